@@ -0,0 +1,301 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// agentPids and agentNames are the processes monitored, by pids and command line prefixes.
+	agentPids  []int
+	agentNames []string
+	// agentInterval is the interval between the scannings of a process.
+	agentInterval time.Duration
+	// agentCheckInterval is the interval of checking the processes and their RSS.
+	agentCheckInterval time.Duration
+	// agentRSSGrowth is the percent of the RSS growth since the last scanning triggering a scanning, 0 disables it.
+	agentRSSGrowth float64
+	// agentDir is the directory of the profiles, and agentKeep is the number of the profiles kept for each process name.
+	agentDir  string
+	agentKeep int
+	// agentUpload is the URL the profiles are uploaded to, no upload if empty.
+	agentUpload string
+	// agentMetricsAddr is the address serving the metrics of the profiles, and agentMetricsTop is
+	// the number of the reference chains exported for each process.
+	agentMetricsAddr string
+	agentMetricsTop  int
+	// agentIncremental is whether to copy only the memory written since the last scanning of a process.
+	agentIncremental bool
+)
+
+func newAgentCommand() *cobra.Command {
+	agentCommand := &cobra.Command{
+		Use:   "agent (--pid <pid> | --name <name>)...",
+		Short: "Scan processes periodically as a daemon.",
+		Long: `Run as a daemon monitoring the processes for leaks, which scans every process periodically,
+or earlier when its RSS grows too much since the last scanning, until goref is interrupted.
+
+The processes are given by pids, or by names like --wait-for of the attach command, i.e. all the
+processes whose command lines start with the name, which are checked every --check-interval, so the
+restarted processes are monitored too. Every process is scanned when it's found.
+
+The profiles are written to --dir, named like <process name>-<time>-<pid>.out, and only the latest
+--keep profiles of each process name are kept. With --upload, every profile is also uploaded by
+an HTTP PUT request to the URL joined with the file name, e.g. a bucket of an object storage.
+The scan flags apply to every scanning, except --out.
+
+With --metrics-addr, the metrics of the last profile of every process are served at /metrics in the
+Prometheus text format, so that the leak growth can be alerted without shipping the profiles:
+  goref_last_scan_timestamp_seconds{process,pid}      time of the last scanning
+  goref_reachable_bytes{process,pid}                  bytes of all the objects reached
+  goref_retained_bytes{process,pid,root,type,path}    bytes referenced through each of the top
+                                                      --metrics-top reference chains by flat bytes
+The metrics require the pprof format with the space sample type.
+
+With --incremental, the memory of a process is copied before every scanning like --snapshot of the
+attach command, but only the pages written since the last scanning are copied again, see grf watch.`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(agentPids) == 0 && len(agentNames) == 0 {
+				return errors.New("you must provide --pid or --name")
+			}
+			if agentInterval <= 0 && agentRSSGrowth <= 0 {
+				return errors.New("either --interval or --rss-growth must be positive")
+			}
+			if agentCheckInterval <= 0 {
+				return errors.New("--check-interval must be positive")
+			}
+			if agentMetricsAddr != "" && (format != "pprof" || !strings.Contains(sampleTypes, "space")) {
+				return errors.New("--metrics-addr requires the pprof format with the space sample type")
+			}
+			return nil
+		},
+		Run: func(_ *cobra.Command, _ []string) {
+			os.Exit(agent())
+		},
+	}
+	addScanFlags(agentCommand)
+	agentCommand.Flags().MarkHidden("out")
+	agentCommand.Flags().IntSliceVar(&agentPids, "pid", nil, "pids of the processes to scan")
+	agentCommand.Flags().StringSliceVar(&agentNames, "name", nil, "scan the processes whose command lines start with the names")
+	agentCommand.Flags().DurationVar(&agentInterval, "interval", 30*time.Minute, "interval between the scannings of a process, 0 means only scanning on the RSS growth")
+	agentCommand.Flags().DurationVar(&agentCheckInterval, "check-interval", time.Minute, "interval of looking for the processes and checking their RSS")
+	agentCommand.Flags().Float64Var(&agentRSSGrowth, "rss-growth", 0, "scan a process once its RSS grows by the percent since the last scanning, like 20; 0 disables it")
+	agentCommand.Flags().StringVar(&agentDir, "dir", "grf-profiles", "directory of the profiles")
+	agentCommand.Flags().IntVar(&agentKeep, "keep", 10, "number of the latest profiles kept for each process name, 0 means keeping all")
+	agentCommand.Flags().StringVar(&agentUpload, "upload", "", "URL to upload the profiles to by HTTP PUT, the file name is joined to its path")
+	agentCommand.Flags().StringVar(&agentMetricsAddr, "metrics-addr", "", "address to serve the Prometheus metrics of the profiles at /metrics, like :9090")
+	agentCommand.Flags().IntVar(&agentMetricsTop, "metrics-top", 10, "number of the top reference chains exported for each process")
+	agentCommand.Flags().BoolVar(&agentIncremental, "incremental", false, "copy the memory to scan, and only copy the pages written since the last scanning again (linux only)")
+	return agentCommand
+}
+
+// agentTarget is the state of a monitored process.
+type agentTarget struct {
+	name     string
+	lastScan time.Time
+	// RSS at the last scanning
+	lastRSS int64
+	// the memory copied by the last scanning, nil if not incremental
+	inc *myproc.IncrementalState
+}
+
+func agent() int {
+	if agentIncremental {
+		snapshot = "copy"
+	}
+	if err := os.MkdirAll(agentDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var metrics *agentMetrics
+	if agentMetricsAddr != "" {
+		metrics = newAgentMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		srv := &http.Server{Addr: agentMetricsAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("serve the metrics: %v\n", err)
+			}
+		}()
+		defer srv.Close()
+	}
+	ticker := time.NewTicker(agentCheckInterval)
+	defer ticker.Stop()
+	targets := make(map[int]*agentTarget)
+	for {
+		pids := agentProcesses()
+		for pid, t := range targets {
+			if !slices.Contains(pids, pid) {
+				log.Printf("process %d (%s) is gone\n", pid, t.name)
+				delete(targets, pid)
+				if metrics != nil {
+					metrics.remove(pid)
+				}
+			}
+		}
+		for _, pid := range pids {
+			if ctx.Err() != nil {
+				return 0
+			}
+			t := targets[pid]
+			if t == nil {
+				t = &agentTarget{name: processName(pid)}
+				if agentIncremental {
+					t.inc = myproc.NewIncrementalState()
+				}
+				targets[pid] = t
+				log.Printf("monitoring process %d (%s)\n", pid, t.name)
+			}
+			rss, _ := processRSS(pid)
+			var reason string
+			switch {
+			case t.lastScan.IsZero():
+				reason = "found"
+			case agentInterval > 0 && time.Since(t.lastScan) >= agentInterval:
+				reason = "interval"
+			case agentRSSGrowth > 0 && t.lastRSS > 0 && float64(rss) >= float64(t.lastRSS)*(1+agentRSSGrowth/100):
+				reason = fmt.Sprintf("RSS grew from %s to %s", myproc.FormatBytes(t.lastRSS), myproc.FormatBytes(rss))
+			default:
+				continue
+			}
+			t.lastScan, t.lastRSS = time.Now(), rss
+			agentScan(pid, t, reason, metrics)
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// agentProcesses returns the pids given and the processes found by the names.
+func agentProcesses() []int {
+	pids := slices.Clone(agentPids)
+	for _, name := range agentNames {
+		found, err := findProcesses(name)
+		if err != nil {
+			log.Printf("find the processes of %s: %v\n", name, err)
+			continue
+		}
+		pids = append(pids, found...)
+	}
+	slices.Sort(pids)
+	return slices.Compact(pids)
+}
+
+// agentScan scans the process to a new profile, updates the metrics if exported, rotates the old profiles
+// and uploads the new one.
+func agentScan(pid int, t *agentTarget, reason string, metrics *agentMetrics) {
+	file := filepath.Join(agentDir, fmt.Sprintf("%s-%s-%d.out", t.name, time.Now().Format("20060102-150405"), pid))
+	log.Printf("scanning process %d (%s), %s\n", pid, t.name, reason)
+	var opts []myproc.Option
+	if t.inc != nil {
+		opts = append(opts, myproc.WithIncremental(t.inc))
+	}
+	if code := execute(pid, "", "", file, conf, opts...); code != 0 {
+		log.Printf("failed to scan process %d\n", pid)
+		os.Remove(file)
+		return
+	}
+	if metrics != nil {
+		if err := metrics.update(pid, t.name, file, agentMetricsTop); err != nil {
+			log.Printf("update the metrics of process %d: %v\n", pid, err)
+		}
+	}
+	if err := rotateProfiles(t.name); err != nil {
+		log.Printf("rotate the profiles: %v\n", err)
+	}
+	if agentUpload != "" {
+		if err := uploadProfile(file); err != nil {
+			log.Printf("upload %s: %v\n", file, err)
+		}
+	}
+}
+
+// rotateProfiles removes the profiles of the process name except the latest ones.
+func rotateProfiles(name string) error {
+	if agentKeep <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(agentDir, name+"-*.out"))
+	if err != nil {
+		return err
+	}
+	// the time in the file names sorts in order, the names with dashes of other processes are excluded
+	files = slices.DeleteFunc(files, func(f string) bool {
+		return strings.Count(strings.TrimPrefix(filepath.Base(f), name+"-"), "-") != 2
+	})
+	slices.Sort(files)
+	for len(files) > agentKeep {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// uploadProfile puts the profile to the upload URL joined with the file name.
+func uploadProfile(file string) error {
+	u, err := url.Parse(agentUpload)
+	if err != nil {
+		return err
+	}
+	u = u.JoinPath(filepath.Base(file))
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	req, err := http.NewRequest(http.MethodPut, u.String(), f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if st, err := f.Stat(); err == nil {
+		req.ContentLength = st.Size()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// chansOutFile is the profile output by the scanning.
+	chansOutFile string
+	// chansMinCap and chansMaxUsage are the thresholds of the suspicious channels.
+	chansMinCap   int64
+	chansMaxUsage float64
+)
+
+func newChansCommand() *cobra.Command {
+	chansCommand := &cobra.Command{
+		Use:   "chans <pid>",
+		Short: "Find the channels with large idle buffers or full buffers.",
+		Long: `Attach to a running process, and report the buffered channels whose buffers are large but
+mostly empty, which waste memory, or full, whose receivers are likely stuck or too slow.
+
+Only the channels with the capacity of at least --min-cap elements are reported. A channel is
+mostly empty if at most --max-usage percent of its buffer is used. The reference profile is also
+output like the attach command.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(chans(pid))
+		},
+	}
+	chansCommand.Flags().StringVarP(&chansOutFile, "out", "o", "grf.chans.out", "output file name of the profile")
+	chansCommand.Flags().Int64Var(&chansMinCap, "min-cap", 64, "report the channels with the capacity of at least N elements")
+	chansCommand.Flags().Float64Var(&chansMaxUsage, "max-usage", 10, "report the channels with at most the percent of the buffer used, besides the full channels")
+	return chansCommand
+}
+
+func chans(pid int) int {
+	var stats []myproc.ChannelStat
+	if code := execute(pid, "", "", chansOutFile, conf, myproc.WithChannelStats(&stats)); code != 0 {
+		return code
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tLEN/CAP\tBUFFER\tTYPE\tPATH")
+	var found int
+	for _, st := range stats {
+		var state string
+		switch usage := 100 * float64(st.Len) / float64(st.Cap); {
+		case st.Cap < chansMinCap:
+			continue
+		case st.Len == st.Cap:
+			state = "full"
+		case usage <= chansMaxUsage:
+			state = "mostly empty"
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\t%s\n", state, st.Len, st.Cap, myproc.FormatBytes(st.BufferSize), st.Type, compactPath(st.Path))
+		found++
+	}
+	if found == 0 {
+		fmt.Printf("no channel of %d or more capacity is full or used %g%% or less\n", chansMinCap, chansMaxUsage)
+		return 0
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// exitBudgetExceeded is the exit code of the check command when any budget is exceeded.
+const exitBudgetExceeded = 2
+
+var (
+	// checkMaxBytes are the budgets like "main.cache=1GiB", and checkRules is the file of more budgets.
+	checkMaxBytes []string
+	checkRules    string
+	// checkOutFile is the profile kept after the check, not kept if empty.
+	checkOutFile string
+)
+
+func newCheckCommand() *cobra.Command {
+	checkCommand := &cobra.Command{
+		Use:   "check (<pid> | <executable> <core>)",
+		Short: "Check the memory budgets of the reference chains, for CI leak gates.",
+		Long: `Scan a running process or a core dump, and exit with 2 if any budget is exceeded, printing the
+offending reference chains, e.g. as a leak regression gate of the integration tests.
+
+A budget is <type-or-path>=<limit>, like --max-bytes 'main.cache=256MiB' or --max-bytes '*main.Session=1GiB'.
+If the key is a reference chain printed by the top command, like 'main.cache -> $mapval. (*main.Item)',
+or a root like 'main.cache', it limits the bytes referenced through the chain. Otherwise the key is
+a type, which limits the bytes of all the objects of the type. The budgets can also be given by
+--rules, a file of one budget per line, where empty lines and lines starting with # are ignored.
+
+The exit code is 0 if all budgets are met, 2 if any is exceeded, and 1 on errors.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(_ *cobra.Command, args []string) {
+			var pid int
+			var exeFile, coreFile string
+			if len(args) == 1 {
+				var err error
+				if pid, err = strconv.Atoi(args[0]); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+					os.Exit(1)
+				}
+			} else {
+				exeFile, coreFile = args[0], args[1]
+			}
+			os.Exit(check(pid, exeFile, coreFile))
+		},
+	}
+	checkCommand.Flags().StringArrayVar(&checkMaxBytes, "max-bytes", nil, "budget like <type-or-path>=<limit>, e.g. main.cache=256MiB, can be repeated")
+	checkCommand.Flags().StringVar(&checkRules, "rules", "", "file of the budgets, one <type-or-path>=<limit> per line")
+	checkCommand.Flags().StringVarP(&checkOutFile, "out", "o", "", "keep the profile to the file for investigating, not kept if empty")
+	return checkCommand
+}
+
+// budget limits the bytes referenced through a reference chain, or of the objects of a type.
+type budget struct {
+	key   string
+	limit int64
+}
+
+// parseBudget parses a budget like "main.cache=256MiB", the key may contain "=".
+func parseBudget(s string) (budget, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return budget{}, fmt.Errorf("invalid budget %q, must be <type-or-path>=<limit>", s)
+	}
+	limit, err := parseSize(s[i+1:])
+	if err != nil {
+		return budget{}, fmt.Errorf("invalid budget %q: %w", s, err)
+	}
+	return budget{key: strings.TrimSpace(s[:i]), limit: limit}, nil
+}
+
+// checkBudgets returns the budgets of --max-bytes and --rules.
+func checkBudgets() ([]budget, error) {
+	lines := checkMaxBytes
+	if checkRules != "" {
+		data, err := os.ReadFile(checkRules)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("you must provide --max-bytes or --rules")
+	}
+	budgets := make([]budget, 0, len(lines))
+	for _, line := range lines {
+		b, err := parseBudget(line)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, nil
+}
+
+func check(pid int, exeFile, coreFile string) int {
+	budgets, err := checkBudgets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	filename := checkOutFile
+	if filename == "" {
+		dir, err := os.MkdirTemp("", "grf")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		filename = filepath.Join(dir, "grf.out")
+	}
+	if code := execute(pid, exeFile, coreFile, filename, conf); code != 0 {
+		return code
+	}
+	p, err := readProfile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	entries, _, err := p.Top(myproc.SampleSpace.ValueType().Type, 0, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	code := 0
+	for _, b := range budgets {
+		used, offending := budgetUsage(entries, b.key)
+		if used <= b.limit {
+			fmt.Printf("ok       %s: %s of %s\n", b.key, myproc.FormatBytes(used), myproc.FormatBytes(b.limit))
+			continue
+		}
+		code = exitBudgetExceeded
+		fmt.Printf("exceeded %s: %s of %s\n", b.key, myproc.FormatBytes(used), myproc.FormatBytes(b.limit))
+		for _, e := range offending {
+			fmt.Printf("    %s  %s\n", myproc.FormatBytes(e.Flat), compactPath(e.Path))
+		}
+	}
+	return code
+}
+
+// maxOffending is the max number of the offending chains printed for a type budget.
+const maxOffending = 5
+
+// budgetUsage returns the bytes used by the key, and the chains using them. The key is a chain if any
+// chain matches it, whose cumulative bytes are used, otherwise a type, whose objects are at the ends of the
+// chains, so the flat bytes of the chains ending with the type are used. The entries are sorted by the cumulative bytes.
+func budgetUsage(entries []profile.TopEntry, key string) (used int64, offending []profile.TopEntry) {
+	for _, e := range entries {
+		if compactPath(e.Path) == key {
+			return e.Cum, []profile.TopEntry{{Path: e.Path, Flat: e.Cum}}
+		}
+	}
+	for _, e := range entries {
+		if e.Flat == 0 || leafType(e.Path) != key {
+			continue
+		}
+		used += e.Flat
+		offending = append(offending, e)
+	}
+	slices.SortStableFunc(offending, func(a, b profile.TopEntry) int {
+		return cmp.Compare(b.Flat, a.Flat)
+	})
+	if len(offending) > maxOffending {
+		offending = offending[:maxOffending]
+	}
+	return used, offending
+}
@@ -15,20 +15,35 @@
 package cmds
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-delve/delve/pkg/config"
 	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/service/debugger"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/cloudwego/goref/pkg/cgroup"
 	myproc "github.com/cloudwego/goref/pkg/proc"
 	"github.com/cloudwego/goref/pkg/version"
 )
 
+// showValuesMaxLen is the max bytes of the string map keys shown by --show-values.
+const showValuesMaxLen = 32
+
 var (
 	// rootCommand is the root of the command tree.
 	rootCommand *cobra.Command
@@ -37,6 +52,83 @@ var (
 	loadConfErr error
 	outFile     string
 
+	// selfMemoryLimit is the memory limit of goref itself, like "2GiB".
+	selfMemoryLimit string
+	// sampleTypes are the sample value types carried by the profile, like "objects,space".
+	sampleTypes string
+	// format is the file format of the output, like "pprof".
+	format string
+	// groupBy is how the objects are aggregated, like "path" or "type".
+	groupBy string
+	// checkMarks is the max examples reported by the mark check, 0 disables the check.
+	checkMarks int
+	// runtimeRoots are the optional runtime roots to scan, like "pool,env".
+	runtimeRoots string
+	// progress is how the progress of the scanning is printed, "bar" or "json", empty means not printed.
+	progress string
+
+	// timeout is the max duration of the scanning, 0 means no limit.
+	timeout time.Duration
+	// parallel is the number of the workers scanning the roots, 0 means GOMAXPROCS.
+	parallel int
+	// includePkgs and excludePkgs filter the roots by their packages.
+	includePkgs, excludePkgs []string
+	// typeRegex filters the reference paths by the types of their nodes.
+	typeRegex string
+	// minBytes and minObjects drop the nodes referencing less.
+	minBytes   string
+	minObjects int64
+	// maxRAM is the max memory of the span masks and the profile kept by goref, like "1GiB".
+	maxRAM string
+	// skipCompatCheck scans the target even if it's known incompatible.
+	skipCompatCheck bool
+	// deterministic makes the output byte-identical for identical heaps.
+	deterministic bool
+	// validateHeap cross-checks the totals of the scanning against the heap of the target.
+	validateHeap bool
+	// largeObjects is the number of the largest heap objects listed after scanning.
+	largeObjects int
+	// maxArrayElems is the number of the array elements named by their indexes in the reference paths.
+	maxArrayElems int64
+	// mapSampleRate is the fraction of the map entries scanned.
+	mapSampleRate float64
+	// sample is the sampling rate of the small heap objects like "1/100", up to sampleMaxSize bytes.
+	sample        string
+	sampleMaxSize string
+	// showValues shows the scalar map keys in the reference paths.
+	showValues bool
+	// depthRulesFile is the file of the depth limits below the types or packages.
+	depthRulesFile string
+	// labels are the labels of the profile like "service=api", and autoLabels adds the detected ones.
+	labels     []string
+	autoLabels bool
+	// debugInfoDirs are the directories of the separate debug files looked up after the configured ones,
+	// and debuginfodURLs are the debuginfod servers to download them from.
+	debugInfoDirs  []string
+	debuginfodURLs []string
+
+	// freezeDuration is the max duration the target is stopped, 0 means no limit.
+	freezeDuration time.Duration
+	// snapshot is how to snapshot the memory to scan and detach the target before scanning,
+	// "copy" or "fork", empty means no snapshot.
+	snapshot string
+	// attachWaitFor is the name prefix of the process to wait for and attach to, polled every
+	// attachWaitForInterval for at most attachWaitForDuration, 0 means no limit.
+	attachWaitFor         string
+	attachWaitForInterval time.Duration
+	attachWaitForDuration time.Duration
+	// attachRetries is the number of the retries of attaching on the transient ptrace errors,
+	// which are apart by attachRetryInterval.
+	attachRetries       int
+	attachRetryInterval time.Duration
+	// attachContainer is the ID or name of the container whose main process is attached to.
+	attachContainer string
+
+	// cpuProfile and memProfile are the files of the CPU and heap profiles of goref itself,
+	// and scanStats prints the time of every phase, the peak RSS and the bytes read from the target.
+	cpuProfile, memProfile string
+	scanStats              bool
+
 	// verbose is whether to log verbose info, like debug logs.
 	verbose bool
 )
@@ -56,42 +148,125 @@ func New(docCall bool) *cobra.Command {
 
 	// 'attach' subcommand.
 	attachCommand := &cobra.Command{
-		Use:   "attach pid [executable]",
+		Use:   "attach [pid[,pid...]] [executable]",
 		Short: "Attach to running process and begin scanning.",
 		Long: `Attach to an already running process and begin scanning its memory.
 
 This command will cause Goref to take control of an already running process and begin scanning object references. 
 You'll have to wait for goref until it outputs 'successfully output to ...', or kill it to terminate scanning.
+
+Several processes, e.g. all the workers of a prefork server, are scanned one by one if their pids are
+separated by commas. A profile is written for each process, like grf.<pid>.out of grf.out, labeled by
+its pid, or with --merge, a profile of all the processes, whose samples are labeled by their pids.
+
+With --wait-for, goref polls until a process whose command line starts with the name appears, and then scans it.
+
+With --container, goref scans the main process of the container, found by the docker API, or by the cgroups of
+the container for the other runtimes like containerd, which need the full ID or an ID prefix. The executable and
+the debug info are read from the root file system of the container, so goref runs on the host like docker.
 `,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				return errors.New("you must provide a PID")
+			if len(args) == 0 && attachWaitFor == "" && attachContainer == "" {
+				return errors.New("you must provide a PID, --wait-for or --container")
+			}
+			if len(args) > 0 && attachWaitFor != "" {
+				return errors.New("--wait-for can not be used with a PID")
+			}
+			if len(args) > 1 && strings.Contains(args[0], ",") {
+				return errors.New("the executable can not be given for several PIDs")
+			}
+			if attachMerge && (len(args) == 0 || !strings.Contains(args[0], ",")) {
+				return errors.New("--merge needs several PIDs")
+			}
+			if attachContainer != "" && (len(args) > 0 || attachWaitFor != "") {
+				return errors.New("--container can not be used with a PID or --wait-for")
+			}
+			if attachWaitFor == "" && (cmd.Flags().Changed("wait-for-interval") || cmd.Flags().Changed("wait-for-duration")) {
+				return errors.New("--wait-for-interval and --wait-for-duration need --wait-for")
+			}
+			if snapshot != "" && freezeDuration > 0 {
+				return errors.New("--snapshot can not be used with --freeze-duration")
+			}
+			if snapshot != "" && snapshot != "copy" && snapshot != "fork" {
+				return fmt.Errorf("unknown snapshot backend %q, must be copy or fork", snapshot)
 			}
 			return nil
 		},
 		Run: attachCmd,
 	}
-	attachCommand.Flags().StringVarP(&outFile, "out", "o", "grf.out", "output file name")
+	addScanFlags(attachCommand)
+	attachCommand.Flags().DurationVar(&freezeDuration, "freeze-duration", 0, "max duration the target is stopped, like 2s; goref copies the memory to scan in time, then resumes the target and scans the copies")
+	attachCommand.Flags().StringVar(&snapshot, "snapshot", "", "snapshot the memory to scan, then detach the target and scan the snapshot; copy (the default of --snapshot) copies all the memory, so the target is only stopped for copying; fork forks the target and scans the frozen child, so the target is hardly stopped (linux/amd64 only)")
+	attachCommand.Flags().Lookup("snapshot").NoOptDefVal = "copy"
+	attachCommand.Flags().StringVar(&attachWaitFor, "wait-for", "", "wait for a process whose command line starts with the name, and attach to it")
+	attachCommand.Flags().DurationVar(&attachWaitForInterval, "wait-for-interval", time.Millisecond, "interval of polling the processes for --wait-for")
+	attachCommand.Flags().DurationVar(&attachWaitForDuration, "wait-for-duration", 0, "max duration of waiting for the process of --wait-for, like 1m, 0 means no limit")
+	addAttachRetryFlags(attachCommand)
+	attachCommand.Flags().BoolVar(&attachMerge, "merge", false, "merge the profiles of the processes given by several pids into one, whose samples are labeled by their pids")
+	attachCommand.Flags().StringVar(&attachContainer, "container", "", "attach to the main process of the container with the ID, ID prefix or name (docker only), reading the executable from the container")
 	rootCommand.AddCommand(attachCommand)
 
 	coreCommand := &cobra.Command{
-		Use:   "core <executable> <core>",
+		Use:   "core (<executable> <core> | --dir <dir>)",
 		Short: "Scan a core dump.",
 		Long: `Scan a core dump (only supports linux and windows core dumps).
 
 The core command will open the specified core file and the associated executable and begin scanning object references.
-You'll have to wait for goref until it outputs 'successfully output to ...', or kill it to terminate scanning.`,
+You'll have to wait for goref until it outputs 'successfully output to ...', or kill it to terminate scanning.
+
+With --dir, every core file in the directory is scanned with its executable, e.g. the dumps collected on OOM,
+by --jobs child processes of goref concurrently. The executable of a core file is the file in the directory
+named like the one recorded in the core file, or the only executable in the directory, or the recorded one if
+it exists. The output of a core file is written to --out-dir like <core>.grf.out, and a summary of the core
+files is printed at last, with the top reference chains summed up over them in pprof format. The logs of the
+child processes, like the reports of --stats, are printed prefixed by the names of the core files.`,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if coreDir != "" {
+				if len(args) > 0 {
+					return errors.New("--dir can not be used with a core file")
+				}
+				return checkScanFlags(cmd, selfProfileFlags, nil, "with --dir, whose core files are scanned by child processes")
+			}
 			if len(args) < 2 {
 				return errors.New("you must provide a core file and an executable")
 			}
 			return nil
 		},
-		Run: coreCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			if coreDir != "" {
+				os.Exit(coreBatch(scanArgs(cmd, coreScanFlags)))
+			}
+			coreCmd(cmd, args)
+		},
 	}
-	coreCommand.Flags().StringVarP(&outFile, "out", "o", "grf.out", "output file name")
+	addScanFlags(coreCommand)
+	coreScanFlags = scanFlagNames(coreCommand)
+	coreCommand.Flags().StringVar(&coreDir, "dir", "", "scan every core file in the directory with its executable")
+	coreCommand.Flags().StringVar(&coreOutDir, "out-dir", "", "directory of the outputs of the core files of --dir, the --dir by default")
+	coreCommand.Flags().IntVar(&coreJobs, "jobs", 2, "number of the core files of --dir scanned concurrently, each by a child process")
+	coreCommand.Flags().IntVar(&coreTop, "top", 10, "number of the reference chains in the summary of --dir, 0 means all")
 	rootCommand.AddCommand(coreCommand)
 
+	rootCommand.AddCommand(newExecCommand())
+	rootCommand.AddCommand(newDiffCommand())
+	rootCommand.AddCommand(newConvertCommand())
+	rootCommand.AddCommand(newTopCommand())
+	rootCommand.AddCommand(newWatchCommand())
+	rootCommand.AddCommand(newGoleakCommand())
+	rootCommand.AddCommand(newChansCommand())
+	rootCommand.AddCommand(newTimersCommand())
+	rootCommand.AddCommand(newGarbageCommand())
+	rootCommand.AddCommand(newWhorefCommand())
+	rootCommand.AddCommand(newAgentCommand())
+	rootCommand.AddCommand(newCheckCommand())
+	rootCommand.AddCommand(newDumpCommand())
+	rootCommand.AddCommand(newServeCommand())
+	rootCommand.AddCommand(newTUICommand())
+	rootCommand.AddCommand(newK8sCommand())
+	serveAgentCommand, connectCommand := newRemoteCommands()
+	rootCommand.AddCommand(serveAgentCommand)
+	rootCommand.AddCommand(connectCommand)
+
 	versionCommand := &cobra.Command{
 		Use:   "version",
 		Short: "Prints version.",
@@ -110,9 +285,109 @@ You'll have to wait for goref until it outputs 'successfully output to ...', or
 	return rootCommand
 }
 
+// addScanFlags adds the flags shared by the scanning commands.
+func addScanFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outFile, "out", "o", "grf.out", "output file name")
+	cmd.Flags().StringVar(&format, "format", "pprof", "output file format, pprof, callgrind, html, folded, speedscope, treemap, json, csv, tsv, or graph and dot for the object graph")
+	cmd.Flags().StringVar(&groupBy, "group-by", "path", "aggregate the objects by their reference paths, by their types like a type histogram, or by the roots and goroutines referencing them, path, type, goroutine or goroutine-site")
+	cmd.Flags().StringVar(&sampleTypes, "sample-types", "objects,space", "sample value types carried by the profile, any of objects,space,entries,waste,retained,weak,stack,self_objects,self_space")
+	cmd.Flags().StringVar(&selfMemoryLimit, "self-memory-limit", "", "memory limit of goref itself, like 2GiB, goref shrinks its caches when approaching it")
+	cmd.Flags().IntVar(&checkMarks, "check-marks", 0, "cross-check the reached objects against the GC marks, and report at most N inconsistent objects of each kind")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "max duration of the scanning, like 10m; the partial profile is output when it expires or goref is interrupted")
+	cmd.Flags().StringVar(&runtimeRoots, "runtime-roots", "", "optional runtime roots to scan, any of pool,env,timers")
+	cmd.Flags().StringVar(&progress, "progress", "", "print the progress of the scanning phases with the objects marked and the ETA to stderr, bar (the default of --progress) redraws a progress bar, json prints JSON lines for the scripts")
+	cmd.Flags().Lookup("progress").NoOptDefVal = "bar"
+	cmd.Flags().IntVar(&parallel, "parallel", 0, "number of the workers reading the heap and scanning the roots in parallel, 0 means GOMAXPROCS; the objects shared by several roots are attributed to the first reaching them, which varies between the runs unless --parallel=1 or --deterministic")
+	cmd.Flags().StringSliceVar(&includePkgs, "include-pkg", nil, "only scan the global variables and the stack frames of the packages as roots, like main,github.com/x/y")
+	cmd.Flags().StringSliceVar(&excludePkgs, "exclude-pkg", nil, "skip the global variables and the stack frames of the packages as roots")
+	cmd.Flags().StringVar(&minBytes, "min-bytes", "", "drop the reference paths referencing less bytes in total, like 1MiB")
+	cmd.Flags().Int64Var(&minObjects, "min-objects", 0, "drop the reference paths referencing less objects in total")
+	cmd.Flags().StringVar(&maxRAM, "max-ram", "", "max memory of the span masks and the profile nodes kept by goref, like 1GiB; they are spilled to temporary files when exceeded, the reference paths excluded, which are always kept in memory")
+	cmd.Flags().StringVar(&typeRegex, "type-regex", "", "only output the reference paths through a variable, field or element whose type name matches the regex")
+	cmd.Flags().BoolVar(&validateHeap, "validate", false, "cross-check the totals against the heap stats of the target, and print the fraction of the heap attributed")
+	cmd.Flags().IntVar(&largeObjects, "large-objects", 0, "list the N largest heap objects with their types and reference paths")
+	cmd.Flags().Int64Var(&maxArrayElems, "max-array-elems", 10, "name the first N elements of the arrays and slices in the reference paths, and collapse the rest like [10+]; 0 names every element")
+	cmd.Flags().Float64Var(&mapSampleRate, "map-sample-rate", 1, "fraction of the map entries scanned by their types, like 0.1; the rest are attributed to the maps by the GC bits only")
+	cmd.Flags().StringVar(&sample, "sample", "", "scan only one of every N heap objects up to --sample-max-size referenced by the large objects like 1/100, chosen by their addresses, and scale the sizes and counts by N, to triage a huge heap quickly")
+	cmd.Flags().StringVar(&sampleMaxSize, "sample-max-size", "", "max size of the heap objects sampled by --sample, like 4KiB, 32KiB by default; the larger objects are always scanned")
+	cmd.Flags().BoolVar(&showValues, "show-values", false, "show the string, integer and boolean map keys in the reference paths like $mapval[\"user:123\"], the strings truncated to 32 bytes; it reads the payloads of the target")
+	cmd.Flags().StringVar(&depthRulesFile, "depth-rules", "", "file of the depth limits of the references below the types or packages, one <type-or-package>=<depth> per line, like google.golang.org/grpc=3")
+	cmd.Flags().StringArrayVar(&labels, "label", nil, "label the profile with <key>=<value>, like service=api, which is written to the comments and every sample of the pprof format; repeatable")
+	cmd.Flags().BoolVar(&autoLabels, "auto-labels", false, "also label the profile with the hostname, the container ID of the target and the build ID of its executable")
+	cmd.Flags().StringArrayVar(&debugInfoDirs, "debug-info-dir", nil, "directory of the separate debug files of the stripped executables, looked up by their build IDs like <dir>/.build-id/ab/cdef....debug or by their names like <dir>/app.debug; repeatable")
+	cmd.Flags().StringSliceVar(&debuginfodURLs, "debuginfod", nil, "debuginfod servers to download the separate debug files from by the build IDs if not found locally, like https://debuginfod.example.com; requires debuginfod-find")
+	cmd.Flags().StringVar(&cpuProfile, "cpuprofile", "", "write the CPU profile of goref itself to the file, e.g. to report a slow scanning")
+	cmd.Flags().StringVar(&memProfile, "memprofile", "", "write the heap profile of goref itself to the file after scanning")
+	// the files are written by this goref, not the one in an ephemeral container or a child process
+	for _, name := range selfProfileFlags {
+		_ = cmd.Flags().SetAnnotation(name, localOnlyAnnotation, []string{"true"})
+	}
+	cmd.Flags().BoolVar(&scanStats, "stats", false, "print the time of attaching and of every scanning phase, the peak RSS of goref and the bytes read from the target")
+	cmd.Flags().BoolVar(&deterministic, "deterministic", false, "make the output byte-identical for identical heaps, e.g. for golden files and diffs; the roots are scanned by one worker, and the strings and the samples are sorted")
+	cmd.Flags().BoolVar(&skipCompatCheck, "skip-compat-check", false, "scan the target even if its go version, GOEXPERIMENTs or architecture are not supported, or the executable does not match the core file")
+}
+
+// addAttachRetryFlags adds the flags of retrying to attach to the command attaching to a process.
+func addAttachRetryFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&attachRetries, "attach-retries", 0, "retry attaching N times on the transient ptrace errors, e.g. when the process is briefly in an uninterruptible state or its threads are exiting")
+	cmd.Flags().DurationVar(&attachRetryInterval, "attach-retry-interval", 100*time.Millisecond, "interval between the retries of --attach-retries")
+}
+
+// localOnlyAnnotation marks the scan flags applying to the goref itself only, which are not passed to another goref.
+const localOnlyAnnotation = "goref_local_only"
+
+// scanFlagNames returns the names of the scan flags of the command except --out and the local-only ones, which are
+// passed to another goref by scanArgs, e.g. in an ephemeral container. It's called right after addScanFlags.
+func scanFlagNames(cmd *cobra.Command) []string {
+	var names []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name != "out" && f.Annotations[localOnlyAnnotation] == nil {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// checkScanFlags returns an error for the first flag of the names set on the command line but not allowed,
+// which is not supported where the scanning runs.
+func checkScanFlags(cmd *cobra.Command, names, allowed []string, where string) error {
+	for _, name := range names {
+		if cmd.Flags().Changed(name) && !slices.Contains(allowed, name) {
+			return fmt.Errorf("--%s is not supported %s", name, where)
+		}
+	}
+	return nil
+}
+
+// scanArgs returns the flags of the names set on the command line as the arguments of another goref.
+func scanArgs(cmd *cobra.Command, names []string) []string {
+	var args []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if !slices.Contains(names, f.Name) {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			for _, v := range sv.GetSlice() {
+				args = append(args, "--"+f.Name+"="+v)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
 func attachCmd(_ *cobra.Command, args []string) {
 	var pid int
 	var exeFile string
+	if len(args) > 0 && strings.Contains(args[0], ",") {
+		pids, err := parsePids(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		os.Exit(attachProcesses(pids))
+	}
 	if len(args) > 0 {
 		var err error
 		pid, err = strconv.Atoi(args[0])
@@ -124,6 +399,18 @@ func attachCmd(_ *cobra.Command, args []string) {
 	if len(args) > 1 {
 		exeFile = args[1]
 	}
+	if attachContainer != "" {
+		c, err := myproc.FindContainer(attachContainer)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "container %.12s: process %d, executable %s\n", c.ID, c.Pid, c.Executable)
+		// the debug info in the container is looked up after the configured ones
+		cconf := *conf
+		cconf.DebugInfoDirectories = append(slices.Clone(conf.DebugInfoDirectories), c.DebugInfoDir)
+		os.Exit(execute(c.Pid, c.Executable, "", outFile, &cconf))
+	}
 	os.Exit(execute(pid, exeFile, "", outFile, conf))
 }
 
@@ -131,7 +418,7 @@ func coreCmd(_ *cobra.Command, args []string) {
 	os.Exit(execute(0, args[0], args[1], outFile, conf))
 }
 
-func execute(attachPid int, exeFile, coreFile, outFile string, conf *config.Config) int {
+func execute(attachPid int, exeFile, coreFile, outFile string, conf *config.Config, extraOpts ...myproc.Option) int {
 	if verbose {
 		if err := logflags.Setup(verbose, "", ""); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -142,29 +429,78 @@ func execute(attachPid int, exeFile, coreFile, outFile string, conf *config.Conf
 	if loadConfErr != nil {
 		logflags.DebuggerLogger().Errorf("%v", loadConfErr)
 	}
+	limitCPU()
+	start := time.Now()
+	opts, err := scanOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	opts = append(opts, extraOpts...)
+	stopProfile, err := startSelfProfile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer stopProfile()
+	if coreFile != "" {
+		opts = append(opts, myproc.WithCoreFile(coreFile))
+	}
 
 	dConf := debugger.Config{
 		AttachPid:             attachPid,
 		Backend:               "default",
 		CoreFile:              coreFile,
-		DebugInfoDirectories:  conf.DebugInfoDirectories,
-		AttachWaitFor:         "",
-		AttachWaitForInterval: 1,
-		AttachWaitForDuration: 0,
+		DebugInfoDirectories:  debugInfoDirectories(conf),
+		AttachWaitFor:         attachWaitFor,
+		AttachWaitForInterval: float64(attachWaitForInterval) / float64(time.Millisecond),
+		AttachWaitForDuration: float64(attachWaitForDuration) / float64(time.Millisecond),
+	}
+	if coreFile != "" && exeFile != "" && !skipCompatCheck {
+		exe, err := myproc.CheckCoreExecutable(coreFile, exeFile, dConf.DebugInfoDirectories)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		if exe != exeFile {
+			fmt.Fprintf(os.Stderr, "the executable %s does not match the core file, scanning with %s of the same build ID\n", exeFile, exe)
+			exeFile = exe
+		}
 	}
 	var args []string
 	if exeFile != "" {
 		args = []string{exeFile}
 	}
-	dbg, err := debugger.New(&dConf, args)
+	dbg, err := newDebugger(&dConf, args)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return 1
 	}
-	t := dbg.Target()
-	if err = myproc.ObjectReference(t, outFile); err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		return 1
+	attach := myproc.PhaseStat{Phase: "attach", Duration: time.Since(start)}
+	if coreFile != "" {
+		attach.Phase = "open core"
+	}
+	if attachWaitFor != "" {
+		fmt.Fprintf(os.Stderr, "attached to process %d\n", dbg.Target().Pid())
+	}
+	var detached bool
+	detach := func() error {
+		detached = true
+		return dbg.Detach(false)
+	}
+	if coreFile == "" {
+		switch {
+		case freezeDuration > 0:
+			opts = append(opts, myproc.WithFreezeDuration(freezeDuration, detach))
+		case snapshot == "copy":
+			opts = append(opts, myproc.WithSnapshot(detach))
+		case snapshot == "fork":
+			opts = append(opts, myproc.WithForkSnapshot(dbg.TargetGroup(), detach))
+		}
+	}
+	code := scanTarget(dbg, coreFile, outFile, attach, opts)
+	if detached {
+		return code
 	}
 	err = dbg.Detach(false)
 	if err != nil {
@@ -172,5 +508,248 @@ func execute(attachPid int, exeFile, coreFile, outFile string, conf *config.Conf
 		return 1
 	}
 
-	return 0
+	return code
+}
+
+// scanTarget scans the target of the debugger to outFile with the options of the scan flags, labeling it by
+// --auto-labels and breaking down its RSS by the mappings, then prints the reports of --stats, --validate and
+// --large-objects. attach is the phase of attaching to the target, launching it or opening the core file.
+func scanTarget(dbg *debugger.Debugger, coreFile, outFile string, attach myproc.PhaseStat, opts []myproc.Option) int {
+	t := dbg.Target()
+	var stats myproc.ScanStats
+	if scanStats {
+		opts = append(opts, myproc.WithScanStats(&stats))
+	}
+	var validation myproc.Validation
+	if validateHeap {
+		opts = append(opts, myproc.WithValidation(&validation))
+	}
+	var large []myproc.LargeObject
+	if largeObjects > 0 {
+		opts = append(opts, myproc.WithLargeObjects(largeObjects, &large))
+	}
+	if autoLabels {
+		pid := t.Pid()
+		if coreFile != "" {
+			pid = 0
+		}
+		// the labels given by --label take precedence
+		opts = append([]myproc.Option{myproc.WithLabels(detectLabels(pid, t.BinInfo().Images[0].Path))}, opts...)
+	}
+	// the mappings break down the RSS in the comments of the profile
+	var mappings []myproc.Mapping
+	var err error
+	if coreFile != "" {
+		mappings, err = myproc.ReadCoreMappings(coreFile)
+	} else {
+		mappings, err = myproc.ReadMappings(t.Pid())
+	}
+	if err == nil {
+		opts = append(opts, myproc.WithMappings(mappings))
+	}
+	ctx, cancel := scanContext()
+	defer cancel()
+	code := 0
+	if err = myproc.ObjectReferenceContext(ctx, t, outFile, opts...); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		code = 1
+	}
+	if scanStats {
+		printScanStats(attach, &stats)
+	}
+	if validateHeap && validation.HeapObjects > 0 {
+		printValidation(&validation)
+	}
+	if len(large) > 0 {
+		if err := printLargeObjects(large); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			code = 1
+		}
+	}
+	return code
+}
+
+// scanContext returns the context of the scanning, which is done when the timeout expires
+// or goref is interrupted, so that goref detaches the target instead of being killed.
+func scanContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// scanOptions converts the scan flags to the scanning options.
+func scanOptions() ([]myproc.Option, error) {
+	memLimit, err := parseSize(selfMemoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid self memory limit: %v", err)
+	}
+	outFormat, err := myproc.ParseFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid format: %v", err)
+	}
+	group, err := myproc.ParseGroupBy(groupBy)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid group by: %v", err)
+	}
+	types, err := myproc.ParseSampleTypes(sampleTypes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sample types: %v", err)
+	}
+	roots, err := myproc.ParseRuntimeRoots(runtimeRoots)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid runtime roots: %v", err)
+	}
+	minSpace, err := parseSize(minBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid min bytes: %v", err)
+	}
+	maxProfileRAM, err := parseSize(maxRAM)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid max ram: %v", err)
+	}
+	if maxArrayElems < 0 {
+		return nil, fmt.Errorf("Invalid max array elems: %d", maxArrayElems)
+	}
+	if mapSampleRate <= 0 || mapSampleRate > 1 {
+		return nil, fmt.Errorf("Invalid map sample rate: %g, must be in (0, 1]", mapSampleRate)
+	}
+	sampleEvery, err := parseSampleRate(sample)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sample: %v", err)
+	}
+	sampleMax, err := parseSize(sampleMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sample max size: %v", err)
+	}
+	var depthRules []myproc.DepthRule
+	if depthRulesFile != "" {
+		if depthRules, err = readDepthRules(depthRulesFile); err != nil {
+			return nil, fmt.Errorf("Invalid depth rules: %v", err)
+		}
+	}
+	var showValuesLen int
+	if showValues {
+		showValuesLen = showValuesMaxLen
+	}
+	profileLabels, err := parseLabels(labels)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid labels: %v", err)
+	}
+	var typeRe *regexp.Regexp
+	if typeRegex != "" {
+		if typeRe, err = regexp.Compile(typeRegex); err != nil {
+			return nil, fmt.Errorf("Invalid type regex: %v", err)
+		}
+	}
+	printer, err := newProgressPrinter(progress, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid progress: %v", err)
+	}
+	var progressFn func(myproc.ScanProgress)
+	if printer != nil {
+		progressFn = printer.print
+	}
+	return []myproc.Option{
+		myproc.WithSelfMemoryLimit(memLimit),
+		myproc.WithFormat(outFormat),
+		myproc.WithGroupBy(group),
+		myproc.WithSampleTypes(types...),
+		myproc.WithRuntimeRoots(roots...),
+		myproc.WithMarkCheck(checkMarks),
+		myproc.WithParallelism(parallel),
+		myproc.WithIncludePackages(includePkgs...),
+		myproc.WithExcludePackages(excludePkgs...),
+		myproc.WithTypeRegex(typeRe),
+		myproc.WithMinReferences(minSpace, minObjects),
+		myproc.WithMaxRAM(maxProfileRAM),
+		myproc.WithCompatCheck(!skipCompatCheck),
+		myproc.WithDeterministic(deterministic),
+		myproc.WithMaxArrayElems(maxArrayElems),
+		myproc.WithMapSampleRate(mapSampleRate),
+		myproc.WithSampling(sampleEvery, sampleMax),
+		myproc.WithDepthRules(depthRules...),
+		myproc.WithShowValues(showValuesLen),
+		myproc.WithLabels(profileLabels),
+		myproc.WithProgress(progressFn),
+	}, nil
+}
+
+// parseSampleRate parses the sampling rate like "1/100" given by --sample, and returns N, 0 if not given.
+func parseSampleRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	one, n, ok := strings.Cut(s, "/")
+	if !ok || strings.TrimSpace(one) != "1" {
+		return 0, fmt.Errorf("%q must be 1/N", s)
+	}
+	every, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+	if err != nil || every <= 0 {
+		return 0, fmt.Errorf("%q must be 1/N with a positive N", s)
+	}
+	return every, nil
+}
+
+// debugInfoDirectories returns the configured directories of the separate debug files with the ones given by
+// --debug-info-dir, and exports the servers given by --debuginfod to debuginfod-find, which delve runs to
+// download the debug file of a stripped executable not found in the directories.
+func debugInfoDirectories(conf *config.Config) []string {
+	if len(debuginfodURLs) > 0 {
+		if _, err := exec.LookPath("debuginfod-find"); err != nil {
+			fmt.Fprintln(os.Stderr, "debuginfod-find is not found, the debug files are not downloaded from the debuginfod servers")
+		}
+		urls := strings.Join(debuginfodURLs, " ")
+		if env := os.Getenv("DEBUGINFOD_URLS"); env != "" {
+			urls += " " + env
+		}
+		os.Setenv("DEBUGINFOD_URLS", urls)
+	}
+	return append(slices.Clone(conf.DebugInfoDirectories), debugInfoDirs...)
+}
+
+// transientAttachErrors are the ptrace errors of attaching that may go away by retrying, e.g. a thread exits
+// while being attached, or the process is briefly busy. The errors are matched by their messages, since the
+// debugger formats them into its own.
+var transientAttachErrors = []error{syscall.ESRCH, syscall.EBUSY, syscall.EAGAIN, syscall.EINTR}
+
+// newDebugger creates the debugger by the config like debugger.New, and retries --attach-retries times
+// if attaching fails by a transient error.
+func newDebugger(dConf *debugger.Config, args []string) (*debugger.Debugger, error) {
+	for i := 0; ; i++ {
+		dbg, err := debugger.New(dConf, args)
+		if err == nil || i >= attachRetries || (dConf.AttachPid == 0 && dConf.AttachWaitFor == "") || !isTransientAttachError(err) {
+			return dbg, err
+		}
+		fmt.Fprintf(os.Stderr, "%v, retrying %d/%d\n", err, i+1, attachRetries)
+		time.Sleep(attachRetryInterval)
+	}
+}
+
+func isTransientAttachError(err error) bool {
+	for _, e := range transientAttachErrors {
+		if errors.Is(err, e) || strings.Contains(err.Error(), e.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitCPU sets GOMAXPROCS to the cgroup CPU quota, so that goref doesn't get
+// throttled when running in the same container as the target.
+func limitCPU() {
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		return
+	}
+	if quota, ok := cgroup.CPUQuota(); ok {
+		if procs := int(math.Ceil(quota)); procs < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(procs)
+		}
+	}
 }
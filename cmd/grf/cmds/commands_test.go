@@ -0,0 +1,52 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestScanFlagNames(t *testing.T) {
+	cmd := &cobra.Command{Use: "scan"}
+	addScanFlags(cmd)
+	names := scanFlagNames(cmd)
+	for _, name := range []string{"out", "cpuprofile", "memprofile"} {
+		if slices.Contains(names, name) {
+			t.Errorf("--%s is passed to another goref", name)
+		}
+	}
+	if !slices.Contains(names, "parallel") {
+		t.Errorf("--parallel is not passed to another goref")
+	}
+}
+
+func TestCoreDirSelfProfile(t *testing.T) {
+	for _, flag := range selfProfileFlags {
+		root := New(false)
+		root.SetOut(io.Discard)
+		root.SetErr(io.Discard)
+		root.SetArgs([]string{"core", "--dir", t.TempDir(), "--" + flag, "self.out"})
+		// the core directory is not scanned, which would exit
+		err := root.Execute()
+		if err == nil || !strings.Contains(err.Error(), "child processes") {
+			t.Errorf("--%s: got %v, want rejected", flag, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// convertTo is the format to convert the profile to.
+	convertTo string
+	// convertOutFile is the output file of the convert command.
+	convertOutFile string
+)
+
+func newConvertCommand() *cobra.Command {
+	convertCommand := &cobra.Command{
+		Use:   "convert [profile]",
+		Short: "Convert a reference profile to another format.",
+		Long: `Convert a profile written by goref in pprof format, grf.out by default, to another output format,
+so a profile captured in production can be rendered locally, e.g. as a flame graph, without scanning the
+target again.
+
+The formats of the object graph, graph and dot, are not supported, since the profile only has the
+reference paths.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			filename := "grf.out"
+			if len(args) > 0 {
+				filename = args[0]
+			}
+			os.Exit(convert(filename))
+		},
+	}
+	convertCommand.Flags().StringVar(&convertTo, "to", "", "output file format, pprof, callgrind, html, folded, speedscope, treemap, json, csv or tsv")
+	convertCommand.Flags().StringVarP(&convertOutFile, "out", "o", "", "output file name, grf.<format> by default")
+	_ = convertCommand.MarkFlagRequired("to")
+	return convertCommand
+}
+
+func convert(filename string) int {
+	format, err := myproc.ParseFormat(convertTo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid format: %v\n", err)
+		return 1
+	}
+	p, err := readProfile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	outFile := convertOutFile
+	if outFile == "" {
+		outFile = "grf." + format.String()
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+	if err = myproc.ConvertProfile(f, p, format); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "successfully output to `%s`\n", outFile)
+	return 0
+}
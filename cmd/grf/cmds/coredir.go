@@ -0,0 +1,325 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+var (
+	// coreDir is the directory of the core files and their executables analyzed together.
+	coreDir string
+	// coreOutDir is the directory of the outputs of the core files, coreDir by default.
+	coreOutDir string
+	// coreJobs is the number of the core files analyzed concurrently.
+	coreJobs int
+	// coreTop is the number of the reference chains in the aggregate summary.
+	coreTop int
+)
+
+// coreScanFlags are the scan flags of the core command passed to the analysis of every core file.
+var coreScanFlags []string
+
+// coreJob is a core file in coreDir with its executable and output.
+type coreJob struct {
+	core, exe, out string
+	// err is why the core file is not analyzed.
+	err error
+	p   *profile.RefProfile
+}
+
+// findCoreJobs returns the core files in the directory with their executables. The executable of a core file is
+// the file in the directory whose build ID is in the core file, or with the same name as the one recorded in the
+// core file, or the only executable in the directory, or the recorded one if it exists, e.g. the dumps of a
+// service on the host.
+func findCoreJobs(dir string) ([]*coreJob, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var cores []string
+	exes := make(map[string]string)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		f, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		switch f.Type {
+		case elf.ET_CORE:
+			cores = append(cores, path)
+		case elf.ET_EXEC, elf.ET_DYN:
+			exes[e.Name()] = path
+		}
+		f.Close()
+	}
+	jobs := make([]*coreJob, 0, len(cores))
+	for _, core := range cores {
+		job := &coreJob{core: core, out: filepath.Join(coreOutDir, filepath.Base(core)+"."+filepath.Base(outFile))}
+		jobs = append(jobs, job)
+		var candidates map[string]string
+		if job.exe, candidates = matchCoreExecutable(core, exes); job.exe != "" {
+			continue
+		}
+		recorded, err := myproc.ReadCoreExecutable(core)
+		if exe, ok := candidates[filepath.Base(recorded)]; ok && err == nil {
+			job.exe = exe
+			continue
+		}
+		if len(candidates) == 1 {
+			for _, exe := range candidates {
+				job.exe = exe
+			}
+			continue
+		}
+		if err == nil {
+			if _, err = os.Stat(recorded); err == nil {
+				job.exe = recorded
+				continue
+			}
+		}
+		job.err = fmt.Errorf("executable not found: %v", err)
+	}
+	return jobs, nil
+}
+
+// matchCoreExecutable returns the executable whose build ID is in the core file, empty if not found, and the
+// candidates of the other ways, i.e. the executables whose build IDs are not compared.
+func matchCoreExecutable(core string, exes map[string]string) (string, map[string]string) {
+	names := make([]string, 0, len(exes))
+	for name := range exes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	candidates := make(map[string]string)
+	for _, name := range names {
+		ok, err := myproc.CoreMatchesExecutable(core, exes[name])
+		if ok {
+			return exes[name], nil
+		}
+		if err != nil {
+			candidates[name] = exes[name]
+		}
+	}
+	return "", candidates
+}
+
+// coreBatch analyzes the core files in coreDir by coreJobs child processes of goref, writes an output for every
+// core file, and prints the summary of the core files and the top reference chains aggregated over them.
+func coreBatch(args []string) int {
+	if coreOutDir == "" {
+		coreOutDir = coreDir
+	}
+	jobs, err := findCoreJobs(coreDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if len(jobs) == 0 {
+		fmt.Fprintf(os.Stderr, "no core file in %s\n", coreDir)
+		return 1
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	var wg sync.WaitGroup
+	var logsMu sync.Mutex
+	sem := make(chan struct{}, max(coreJobs, 1))
+	for _, job := range jobs {
+		if job.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(job *coreJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fmt.Fprintf(os.Stderr, "analyzing %s with %s\n", job.core, job.exe)
+			cmd := exec.Command(self, append([]string{"core", job.exe, job.core, "--out", job.out}, args...)...)
+			// the logs of goref are streamed, e.g. the reports of --stats, --validate and --large-objects
+			logs := &prefixWriter{w: os.Stderr, mu: &logsMu, prefix: filepath.Base(job.core) + ": "}
+			cmd.Stdout, cmd.Stderr = logs, logs
+			err := cmd.Run()
+			logs.Flush()
+			if err != nil {
+				job.err = err
+				return
+			}
+			if format != myproc.FormatPprof.String() {
+				return
+			}
+			if job.p, err = readProfile(job.out); err != nil {
+				job.err = err
+			}
+		}(job)
+	}
+	wg.Wait()
+
+	code := 0
+	for _, job := range jobs {
+		if job.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.core, job.err)
+			code = 1
+		}
+	}
+	if err := printCoreSummary(jobs); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return code
+}
+
+// prefixWriter writes the lines to w prefixed, a line per write under mu, so the lines written by several
+// prefixWriters are not interleaved.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := pw.writeLine(pw.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+}
+
+// Flush writes the last line not terminated by a newline.
+func (pw *prefixWriter) Flush() {
+	if len(pw.buf) > 0 {
+		_ = pw.writeLine(append(pw.buf, '\n'))
+		pw.buf = nil
+	}
+}
+
+func (pw *prefixWriter) writeLine(line []byte) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	_, err := pw.w.Write(append([]byte(pw.prefix), line...))
+	return err
+}
+
+// coreSummaryType returns the index of the space in the samples of the profile, or the first sample type.
+func coreSummaryType(p *profile.RefProfile) int {
+	if i := p.SampleIndex(myproc.SampleSpace.ValueType().Type); i >= 0 {
+		return i
+	}
+	return 0
+}
+
+// printCoreSummary prints the total and the top reference chain of every core file, and the top reference
+// chains summed up over the core files with the number of the core files having them. The profiles are
+// only read back in pprof format.
+func printCoreSummary(jobs []*coreJob) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CORE\tEXECUTABLE\tTOTAL\tOUTPUT\tTOP")
+	type chain struct {
+		path  string
+		value int64
+		cores int
+	}
+	chains := make(map[string]*chain)
+	var unit string
+	for _, job := range jobs {
+		if job.err != nil {
+			fmt.Fprintf(w, "%s\t%s\tfailed\t-\t-\n", filepath.Base(job.core), job.exe)
+			continue
+		}
+		if job.p == nil || len(job.p.SampleTypes) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t-\t%s\t-\n", filepath.Base(job.core), job.exe, job.out)
+			continue
+		}
+		vt := job.p.SampleTypes[coreSummaryType(job.p)]
+		unit = vt.Unit
+		entries, total, err := job.p.Top(vt.Type, 0, false)
+		if err != nil {
+			return err
+		}
+		top := "-"
+		if len(entries) > 0 {
+			top = compactPath(entries[0].Path)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", filepath.Base(job.core), job.exe, formatValue(total, vt.Unit), job.out, top)
+		for _, e := range entries {
+			path := compactPath(e.Path)
+			c := chains[path]
+			if c == nil {
+				c = &chain{path: path}
+				chains[path] = c
+			}
+			c.value += e.Flat
+			c.cores++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(chains) == 0 {
+		return nil
+	}
+	sorted := make([]*chain, 0, len(chains))
+	for _, c := range chains {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].value != sorted[j].value {
+			return sorted[i].value > sorted[j].value
+		}
+		return sorted[i].path < sorted[j].path
+	})
+	if coreTop > 0 && len(sorted) > coreTop {
+		sorted = sorted[:coreTop]
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOTAL\tCORES\tCHAIN")
+	for _, c := range sorted {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", formatValue(c.value, unit), c.cores, c.path)
+	}
+	return w.Flush()
+}
+
+// formatValue formats the sample value of the unit.
+func formatValue(v int64, unit string) string {
+	if unit == "bytes" {
+		return myproc.FormatBytes(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{w: &out, mu: &mu, prefix: "core.1: "}
+	b := &prefixWriter{w: &out, mu: &mu, prefix: "core.2: "}
+	a.Write([]byte("attach 1"))
+	b.Write([]byte("attach 2\nread "))
+	a.Write([]byte("s\ntotal 2s\n"))
+	b.Write([]byte("heap"))
+	a.Flush()
+	b.Flush()
+	want := "core.2: attach 2\ncore.1: attach 1s\ncore.1: total 2s\ncore.2: read heap\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// readDepthRules reads the depth rules from the file of one <type-or-package>=<depth> per line,
+// where empty lines and lines starting with # are ignored.
+func readDepthRules(file string) ([]myproc.DepthRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []myproc.DepthRule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseDepthRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// parseDepthRule parses a depth rule like "*google.golang.org/grpc.ClientConn=3".
+func parseDepthRule(s string) (myproc.DepthRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return myproc.DepthRule{}, fmt.Errorf("invalid depth rule %q, must be <type-or-package>=<depth>", s)
+	}
+	depth, err := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	if err != nil || depth < 0 {
+		return myproc.DepthRule{}, fmt.Errorf("invalid depth rule %q, the depth must be a non-negative integer", s)
+	}
+	return myproc.DepthRule{Key: strings.TrimSpace(s[:i]), Depth: depth}, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// diffOutFile is the output file of the diff command.
+var diffOutFile string
+
+func newDiffCommand() *cobra.Command {
+	diffCommand := &cobra.Command{
+		Use:   "diff <base> <new>",
+		Short: "Compare two reference profiles.",
+		Long: `Compare two reference profiles written by goref, and output the differences in pprof format.
+
+The value of every reference path is the value in the new profile minus the value in the base profile,
+so growing paths are positive and shrinking paths are negative. Only the sample types carried by both
+profiles are compared.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			os.Exit(diff(args[0], args[1]))
+		},
+	}
+	diffCommand.Flags().StringVarP(&diffOutFile, "out", "o", "grf.diff.out", "output file name")
+	return diffCommand
+}
+
+func readProfile(filename string) (*profile.RefProfile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filename, err)
+	}
+	return p, nil
+}
+
+func diff(baseFile, newFile string) int {
+	base, err := readProfile(baseFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	cur, err := readProfile(newFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	f, err := os.Create(diffOutFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+	if err = myproc.DiffProfiles(f, base, cur); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "successfully output to `%s`\n", diffOutFile)
+	return 0
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-delve/delve/pkg/logflags"
+	"github.com/go-delve/delve/service/debugger"
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// dumpCoreFile is the core file written by the dump command.
+	dumpCoreFile string
+	// dumpScan is whether to scan the core file after dumping.
+	dumpScan bool
+)
+
+func newDumpCommand() *cobra.Command {
+	dumpCommand := &cobra.Command{
+		Use:   "dump <pid>",
+		Short: "Write a core dump of a running process, and optionally scan it.",
+		Long: `Attach to a running process, write its core dump, and detach, so the process is only stopped
+for dumping, without gcore. The core dump can be scanned later by the core command with the
+executable, or right after dumping with --scan, which takes the scan flags.
+
+The core dump is written by delve, which the core command reads, but other tools may not.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(dump(pid))
+		},
+	}
+	addScanFlags(dumpCommand)
+	dumpCommand.Flags().StringVarP(&dumpCoreFile, "core", "c", "", "core file to write, core.<pid> by default")
+	dumpCommand.Flags().BoolVar(&dumpScan, "scan", false, "scan the core dump after dumping, like the core command")
+	addAttachRetryFlags(dumpCommand)
+	return dumpCommand
+}
+
+func dump(pid int) int {
+	if verbose {
+		if err := logflags.Setup(verbose, "", ""); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer logflags.Close()
+	}
+	if dumpCoreFile == "" {
+		dumpCoreFile = "core." + strconv.Itoa(pid)
+	}
+	dbg, err := newDebugger(&debugger.Config{
+		AttachPid:            pid,
+		Backend:              "default",
+		DebugInfoDirectories: debugInfoDirectories(conf),
+	}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	exeFile := dbg.Target().BinInfo().Images[0].Path
+	// like /proc/<pid>/exe, which is gone with the process
+	if path, err := filepath.EvalSymlinks(exeFile); err == nil {
+		exeFile = path
+	}
+	err = writeDump(dbg)
+	if derr := dbg.Detach(false); derr != nil {
+		fmt.Fprintf(os.Stderr, "detach failed: %v\n", derr)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Remove(dumpCoreFile)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "core dump written to `%s`, executable %s\n", dumpCoreFile, exeFile)
+	if !dumpScan {
+		return 0
+	}
+	// the debugger of the core file is set up by execute
+	if verbose {
+		logflags.Close()
+	}
+	return execute(0, exeFile, dumpCoreFile, outFile, conf)
+}
+
+// writeDump writes the core dump of the target, reports the progress every second,
+// and cancels the dump if goref is interrupted.
+func writeDump(dbg *debugger.Debugger) error {
+	if err := dbg.DumpStart(dumpCoreFile); err != nil {
+		return err
+	}
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+	for {
+		select {
+		case <-interrupted:
+			dbg.DumpCancel()
+		default:
+		}
+		st := dbg.DumpWait(time.Second)
+		st.Mutex.Lock()
+		done, canceled, dumpErr := !st.Dumping, st.Canceled, st.Err
+		memDone, memTotal := st.MemDone, st.MemTotal
+		st.Mutex.Unlock()
+		if done {
+			if dumpErr != nil {
+				return dumpErr
+			}
+			if canceled {
+				return fmt.Errorf("dump canceled")
+			}
+			return nil
+		}
+		if memTotal > 0 {
+			fmt.Fprintf(os.Stderr, "dumping: %s of %s\n", myproc.FormatBytes(int64(memDone)), myproc.FormatBytes(int64(memTotal)))
+		}
+	}
+}
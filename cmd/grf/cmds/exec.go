@@ -0,0 +1,209 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-delve/delve/pkg/logflags"
+	"github.com/go-delve/delve/pkg/proc"
+	"github.com/go-delve/delve/service/api"
+	"github.com/go-delve/delve/service/debugger"
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// trigger is when to scan the launched process, like "delay:3s", "stdout:READY" or "signal".
+	trigger string
+	// detachAfterScan is whether to leave the launched process running after scanning.
+	detachAfterScan bool
+)
+
+func newExecCommand() *cobra.Command {
+	execCommand := &cobra.Command{
+		Use:   "exec <executable> [args...]",
+		Short: "Launch a binary and scan it on a trigger.",
+		Long: `Launch a binary under the debugger, and scan it when the trigger fires.
+
+The trigger is one of:
+  delay:<duration>  scan after the duration, like delay:3s
+  stdout:<text>     scan when the process writes a line containing the text to stdout, like stdout:READY
+  signal            scan when goref receives SIGUSR1, e.g. kill -USR1 <goref pid>
+
+The process is killed after scanning, unless --detach is set.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			os.Exit(launch(args))
+		},
+	}
+	addScanFlags(execCommand)
+	execCommand.Flags().StringVar(&trigger, "trigger", "delay:3s", "when to scan the process, delay:<duration>, stdout:<text> or signal")
+	execCommand.Flags().BoolVar(&detachAfterScan, "detach", false, "leave the process running after scanning instead of killing it; with a stdout trigger, the process loses its stdout when goref exits")
+	// flags after the executable are passed to the process
+	execCommand.Flags().SetInterspersed(false)
+	return execCommand
+}
+
+// waitTrigger returns a channel which is closed when the trigger fires.
+// For a stdout trigger, stdout is the output of the process, which is copied to the stdout of goref.
+func waitTrigger(stdout io.Reader) (<-chan struct{}, error) {
+	fired := make(chan struct{})
+	kind, arg, _ := strings.Cut(trigger, ":")
+	switch kind {
+	case "delay":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, err
+		}
+		time.AfterFunc(d, func() { close(fired) })
+	case "stdout":
+		if arg == "" {
+			return nil, errors.New("empty stdout trigger text")
+		}
+		go func() {
+			sc := bufio.NewScanner(stdout)
+			done := false
+			for sc.Scan() {
+				fmt.Println(sc.Text())
+				if !done && strings.Contains(sc.Text(), arg) {
+					done = true
+					close(fired)
+				}
+			}
+		}()
+	case "signal":
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGUSR1)
+		go func() {
+			<-ch
+			signal.Stop(ch)
+			close(fired)
+		}()
+	default:
+		return nil, fmt.Errorf("unknown trigger %q", trigger)
+	}
+	return fired, nil
+}
+
+func launch(args []string) int {
+	if verbose {
+		if err := logflags.Setup(verbose, "", ""); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer logflags.Close()
+	}
+	if loadConfErr != nil {
+		logflags.DebuggerLogger().Errorf("%v", loadConfErr)
+	}
+	limitCPU()
+	opts, err := scanOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	stopProfile, err := startSelfProfile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer stopProfile()
+
+	dConf := debugger.Config{
+		Backend:              "default",
+		DebugInfoDirectories: debugInfoDirectories(conf),
+		ExecuteKind:          debugger.ExecutingExistingFile,
+	}
+	var stdout io.Reader
+	if strings.HasPrefix(trigger, "stdout:") {
+		r, w, err := os.Pipe()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer r.Close()
+		dConf.Stdout = proc.OutputRedirect{File: w}
+		stdout = r
+	}
+	fired, err := waitTrigger(stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid trigger: %v\n", err)
+		return 1
+	}
+	start := time.Now()
+	dbg, err := debugger.New(&dConf, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	launched := myproc.PhaseStat{Phase: "launch", Duration: time.Since(start)}
+	if f := dConf.Stdout.File; f != nil {
+		// owned by the process now
+		f.Close()
+	}
+
+	// run the process until the trigger fires
+	go func() {
+		<-fired
+		dbg.Command(&api.DebuggerCommand{Name: api.Halt}, nil, nil)
+	}()
+	state, err := dbg.Command(&api.DebuggerCommand{Name: api.Continue}, nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "process stopped before the trigger: %v\n", err)
+		_ = leaveLaunched(dbg, detachAfterScan)
+		return 1
+	}
+	if state.Exited {
+		fmt.Fprintf(os.Stderr, "process exited before the trigger with status %d\n", state.ExitStatus)
+		_ = dbg.Detach(true)
+		return 1
+	}
+	select {
+	case <-fired:
+	default:
+		fmt.Fprintln(os.Stderr, "process stopped before the trigger, scan it anyway")
+	}
+
+	if code := scanTarget(dbg, "", outFile, launched, opts); code != 0 {
+		_ = leaveLaunched(dbg, detachAfterScan)
+		return code
+	}
+	if err = leaveLaunched(dbg, detachAfterScan); err != nil {
+		fmt.Fprintf(os.Stderr, "detach failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// leaveLaunched kills the launched process, or detaches from it and leaves it running if detach is set.
+// Debugger.Detach always kills the processes it launched, so the target group is detached directly then.
+func leaveLaunched(dbg *debugger.Debugger, detach bool) error {
+	if !detach {
+		return dbg.Detach(true)
+	}
+	dbg.LockTarget()
+	defer dbg.UnlockTarget()
+	return dbg.TargetGroup().Detach(false)
+}
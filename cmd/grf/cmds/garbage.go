@@ -0,0 +1,101 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// garbageOutFile is the profile output by the scanning.
+	garbageOutFile string
+	// garbageByType is whether to break down the garbage by the types in the allocation headers.
+	garbageByType bool
+)
+
+func newGarbageCommand() *cobra.Command {
+	garbageCommand := &cobra.Command{
+		Use:   "garbage <pid>",
+		Short: "Estimate the floating garbage by the size classes.",
+		Long: `Attach to a running process, and report the heap objects which are allocated but unreached by
+the scanning by their size classes, i.e. the floating garbage which is dead but not collected yet.
+It tells a true leak, where most of the heap is reached, from a heap waiting for the next GC.
+
+With --by-type, the garbage is also broken down by the types in the allocation headers since go1.22,
+which only the objects larger than 512 bytes with pointers have.
+
+The reference profile is also output like the attach command.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(garbage(pid))
+		},
+	}
+	garbageCommand.Flags().StringVarP(&garbageOutFile, "out", "o", "grf.garbage.out", "output file name of the profile")
+	garbageCommand.Flags().BoolVar(&garbageByType, "by-type", false, "break down the garbage by the types in the allocation headers")
+	return garbageCommand
+}
+
+func garbage(pid int) int {
+	var stats []myproc.GarbageStat
+	var breakdown myproc.MemoryBreakdown
+	if code := execute(pid, "", "", garbageOutFile, conf, myproc.WithGarbageStats(&stats, garbageByType),
+		myproc.WithMemoryBreakdown(&breakdown)); code != 0 {
+		return code
+	}
+	if len(stats) == 0 {
+		fmt.Println("no garbage is found")
+		return 0
+	}
+
+	var objects, space int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if garbageByType {
+		fmt.Fprintln(w, "SIZE CLASS\tOBJECTS\tSPACE\tTYPE")
+	} else {
+		fmt.Fprintln(w, "SIZE CLASS\tOBJECTS\tSPACE")
+	}
+	for _, st := range stats {
+		class := "large"
+		if st.ElemSize > 0 {
+			class = myproc.FormatBytes(st.ElemSize)
+		}
+		if garbageByType {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", class, st.Objects, myproc.FormatBytes(st.Space), st.Type)
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", class, st.Objects, myproc.FormatBytes(st.Space))
+		}
+		objects += st.Objects
+		space += st.Space
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("garbage: %d objects, %s of the %s heap in use, %s attributed\n", objects, myproc.FormatBytes(space),
+		myproc.FormatBytes(breakdown.Heap), myproc.FormatBytes(breakdown.Attributed))
+	return 0
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// goleakOutFile is the profile of the heap kept alive by each goroutine.
+	goleakOutFile string
+	// goleakMinBlocked and goleakMinRetained are the thresholds of the suspicious goroutines.
+	goleakMinBlocked  time.Duration
+	goleakMinRetained string
+)
+
+func newGoleakCommand() *cobra.Command {
+	goleakCommand := &cobra.Command{
+		Use:   "goleak <pid>",
+		Short: "Find the blocked goroutines holding memory.",
+		Long: `Attach to a running process, and report the goroutines which have been blocked for a long time
+while keeping much heap alive, which are likely leaked.
+
+The blocked duration is recorded by the runtime when a GC finds the goroutine blocked, as the start
+time of the last GC, so it is unknown until the second GC after the goroutine blocks, and is shorter
+than the goroutine is actually blocked. The retained heap of a goroutine is the memory
+which would be freed if the goroutine exited. The profile of the heap kept alive by each goroutine
+is also output, see --group-by goroutine of the attach command.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(goleak(pid))
+		},
+	}
+	goleakCommand.Flags().StringVarP(&goleakOutFile, "out", "o", "grf.goleak.out", "output file name of the profile")
+	goleakCommand.Flags().DurationVar(&goleakMinBlocked, "min-blocked", 10*time.Minute, "report the goroutines blocked for at least the duration")
+	goleakCommand.Flags().StringVar(&goleakMinRetained, "min-retained", "1MiB", "report the goroutines retaining at least the bytes")
+	return goleakCommand
+}
+
+func goleak(pid int) int {
+	minRetained, err := parseSize(goleakMinRetained)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid min retained: %v\n", err)
+		return 1
+	}
+	// the runtime nanotime of the target, the target is stopped during the scanning
+	now, clockOK := monotonicNow()
+	var stats []myproc.GoroutineStat
+	if code := execute(pid, "", "", goleakOutFile, conf, myproc.WithGoroutineStats(&stats)); code != 0 {
+		return code
+	}
+	if !clockOK && goleakMinBlocked > 0 {
+		fmt.Fprintln(os.Stderr, "the blocked durations are unknown on this platform, report the goroutines by the retained heap only")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GOROUTINE\tSTATE\tBLOCKED\tRETAINED\tREACHED\tFUNCTION")
+	var found int
+	for _, st := range stats {
+		var blocked time.Duration
+		if st.WaitSince > 0 && clockOK {
+			blocked = time.Duration(now - st.WaitSince)
+		}
+		if st.Retained < minRetained || (clockOK && blocked < goleakMinBlocked) {
+			continue
+		}
+		state, blockedStr := st.Status, "-"
+		if st.WaitReason != "" {
+			state += " (" + st.WaitReason + ")"
+		}
+		if blocked > 0 {
+			blockedStr = blocked.Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", st.ID, state, blockedStr, myproc.FormatBytes(st.Retained), myproc.FormatBytes(st.Space), st.Name)
+		found++
+	}
+	if found == 0 {
+		fmt.Printf("no goroutine is blocked for %v retaining %s or more\n", goleakMinBlocked, myproc.FormatBytes(minRetained))
+		return 0
+	}
+	if err = w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
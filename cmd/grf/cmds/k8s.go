@@ -0,0 +1,313 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// k8sNamespace and k8sContext select the pod like kubectl, and k8sContainer is the target container in it.
+	k8sNamespace, k8sContext, k8sContainer string
+	// k8sImage is the image of the ephemeral container running goref, and k8sProfile is its security profile.
+	k8sImage, k8sProfile string
+	// k8sKubectl is the kubectl executable.
+	k8sKubectl string
+	// k8sPid is the target process in the PID namespace of the target container.
+	k8sPid int
+	// k8sStartTimeout is the max duration waiting for the ephemeral container to start.
+	k8sStartTimeout time.Duration
+)
+
+// The markers of the profile base64 encoded in the logs of the ephemeral container.
+const (
+	profileBeginMarker = "-----BEGIN GOREF PROFILE-----"
+	profileEndMarker   = "-----END GOREF PROFILE-----"
+	// profileLineLen is the length of the base64 lines, like PEM.
+	profileLineLen = 64
+)
+
+func newK8sCommand() *cobra.Command {
+	k8sCommand := &cobra.Command{
+		Use:   "k8s",
+		Short: "Scan the processes in Kubernetes pods.",
+	}
+
+	attachCommand := &cobra.Command{
+		Use:   "attach <pod>",
+		Short: "Scan a process of a pod by an ephemeral container, and stream the profile back.",
+		Long: `Inject an ephemeral debug container into the pod by kubectl debug, which runs goref from --image
+in the PID namespace of the target container, and scans its main process, i.e. PID 1 there. The profile
+is streamed back by the logs of the ephemeral container and written to --out, so the nodes need not be
+accessible. The scan flags are passed to goref in the ephemeral container, except the ones naming the
+local files or programs like --depth-rules and --debug-info-dir, which are rejected.
+
+The image must have grf in its PATH, and the ephemeral container needs the ptrace capability, which is
+granted by the sysadmin profile of kubectl debug by default. The ephemeral container can not be removed
+from the pod after exiting, which is how Kubernetes works, so its name is unique for every scanning.
+
+If the pod shares the PID namespace among its containers, PID 1 is the pause process, so the target
+process needs to be given by --pid.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			os.Exit(k8sAttach(args[0]))
+		},
+	}
+	addScanFlags(attachCommand)
+	scanFlags := scanFlagNames(attachCommand)
+	attachCommand.Flags().StringVarP(&k8sNamespace, "namespace", "n", "", "namespace of the pod, the one of the kubectl context by default")
+	attachCommand.Flags().StringVar(&k8sContext, "context", "", "kubectl context of the cluster")
+	attachCommand.Flags().StringVarP(&k8sContainer, "container", "c", "", "target container in the pod, the first one by default")
+	attachCommand.Flags().StringVar(&k8sImage, "image", "", "image of the ephemeral container, which has grf in its PATH")
+	attachCommand.Flags().StringVar(&k8sProfile, "profile", "sysadmin", "security profile of the ephemeral container of kubectl debug, which allows ptrace")
+	attachCommand.Flags().StringVar(&k8sKubectl, "kubectl", "kubectl", "kubectl executable")
+	attachCommand.Flags().IntVar(&k8sPid, "pid", 1, "target process in the PID namespace of the target container")
+	attachCommand.Flags().DurationVar(&k8sStartTimeout, "start-timeout", 2*time.Minute, "max duration waiting for the ephemeral container to start, e.g. pulling the image")
+	_ = attachCommand.MarkFlagRequired("image")
+	attachCommand.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		if err := checkScanFlags(cmd, selfProfileFlags, nil, "by k8s attach, which scans in the ephemeral container"); err != nil {
+			return err
+		}
+		if err := checkScanFlags(cmd, scanFlags, k8sScanFlags, "by the ephemeral container, which can not read the local files"); err != nil {
+			return err
+		}
+		k8sScanArgs = scanArgs(cmd, k8sScanFlags)
+		return nil
+	}
+	k8sCommand.AddCommand(attachCommand)
+
+	// the command run in the ephemeral container
+	targetCommand := &cobra.Command{
+		Use:    "target",
+		Short:  "Scan the process and write the profile base64 encoded to stdout.",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			os.Exit(k8sTarget())
+		},
+	}
+	addScanFlags(targetCommand)
+	targetCommand.Flags().IntVar(&k8sPid, "pid", 1, "target process")
+	k8sCommand.AddCommand(targetCommand)
+	return k8sCommand
+}
+
+// k8sScanFlags are the flags passed from the k8s attach command to the ephemeral container. The others name the
+// files or the programs on the local host, like --depth-rules, --debug-info-dir and --debuginfod.
+var k8sScanFlags = []string{
+	"format", "group-by", "sample-types", "self-memory-limit", "check-marks", "timeout", "runtime-roots", "progress",
+	"parallel", "include-pkg", "exclude-pkg", "min-bytes", "min-objects", "max-ram", "type-regex", "validate",
+	"large-objects", "max-array-elems", "map-sample-rate", "sample", "sample-max-size", "show-values", "label",
+	"auto-labels", "stats", "deterministic", "skip-compat-check",
+}
+
+// k8sScanArgs are the scan flags passed to the ephemeral container.
+var k8sScanArgs []string
+
+func k8sAttach(pod string) int {
+	container := k8sContainer
+	if container == "" {
+		out, err := kubectl("get", "pod", pod, "-o", "jsonpath={.spec.containers[0].name}").Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get the containers of pod %s: %v\n", pod, err)
+			return 1
+		}
+		container = string(bytes.TrimSpace(out))
+	}
+	name := "goref-" + strconv.FormatInt(time.Now().Unix(), 10)
+	args := []string{
+		"debug", pod, "--image", k8sImage, "--target", container, "--container", name,
+		"--profile", k8sProfile, "--quiet", "--", "grf", "k8s", "target", "--pid", strconv.Itoa(k8sPid),
+	}
+	args = append(args, k8sScanArgs...)
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	if err := kubectl(args...).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "inject the ephemeral container into pod %s: %v\n", pod, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "scanning process %d of container %s in pod %s by ephemeral container %s\n", k8sPid, container, pod, name)
+	if err := waitEphemeralContainer(pod, name); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	logs := kubectl("logs", "--follow", pod, "--container", name)
+	stdout, err := logs.StdoutPipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if err := logs.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		_ = logs.Process.Kill()
+		return 1
+	}
+	err = decodeProfile(stdout, f, os.Stderr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	_ = logs.Wait()
+	if err != nil {
+		os.Remove(outFile)
+		fmt.Fprintf(os.Stderr, "stream the profile from ephemeral container %s: %v\n", name, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "successfully output to `%s`\n", outFile)
+	return 0
+}
+
+// kubectl returns the kubectl command with the namespace and the context, whose stderr is goref's.
+func kubectl(args ...string) *exec.Cmd {
+	if k8sContext != "" {
+		args = append([]string{"--context", k8sContext}, args...)
+	}
+	if k8sNamespace != "" {
+		args = append([]string{"--namespace", k8sNamespace}, args...)
+	}
+	cmd := exec.Command(k8sKubectl, args...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// waitEphemeralContainer waits until the ephemeral container is running or terminated, so its logs can be followed.
+func waitEphemeralContainer(pod, name string) error {
+	deadline := time.Now().Add(k8sStartTimeout)
+	jsonpath := fmt.Sprintf(`jsonpath={.status.ephemeralContainerStatuses[?(@.name=="%s")].state}`, name)
+	var reason string
+	for time.Now().Before(deadline) {
+		out, err := kubectl("get", "pod", pod, "-o", jsonpath).Output()
+		if err != nil {
+			return fmt.Errorf("get the state of ephemeral container %s: %w", name, err)
+		}
+		var state map[string]struct{ Reason, Message string }
+		if len(bytes.TrimSpace(out)) > 0 {
+			if err := json.Unmarshal(out, &state); err != nil {
+				return fmt.Errorf("parse the state of ephemeral container %s: %w", name, err)
+			}
+		}
+		if _, ok := state["running"]; ok {
+			return nil
+		}
+		if _, ok := state["terminated"]; ok {
+			return nil
+		}
+		if w, ok := state["waiting"]; ok && w.Reason != reason {
+			reason = w.Reason
+			fmt.Fprintf(os.Stderr, "ephemeral container %s is waiting: %s %s\n", name, w.Reason, w.Message)
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("ephemeral container %s not started in %v", name, k8sStartTimeout)
+}
+
+func k8sTarget() int {
+	f, err := os.CreateTemp("", "grf-*.out")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+	if code := execute(k8sPid, "", "", path, conf); code != 0 {
+		return code
+	}
+	f, err = os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+	if err := encodeProfile(f, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+// encodeProfile writes the profile base64 encoded between the markers, a line per write,
+// so the lines are not interleaved with the logs of stderr.
+func encodeProfile(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(data)
+	if _, err := io.WriteString(w, profileBeginMarker+"\n"); err != nil {
+		return err
+	}
+	for len(enc) > 0 {
+		n := min(len(enc), profileLineLen)
+		if _, err := io.WriteString(w, enc[:n]+"\n"); err != nil {
+			return err
+		}
+		enc = enc[n:]
+	}
+	_, err = io.WriteString(w, profileEndMarker+"\n")
+	return err
+}
+
+// decodeProfile decodes the profile between the markers in r to w, and copies the other lines, i.e. the logs
+// of goref in the ephemeral container, to logw. It fails if the end marker is not found.
+func decodeProfile(r io.Reader, w, logw io.Writer) error {
+	sc := bufio.NewScanner(r)
+	var in, done bool
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == profileBeginMarker:
+			in = true
+		case line == profileEndMarker && in:
+			in, done = false, true
+		case in:
+			data, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				return fmt.Errorf("decode the profile: %w", err)
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		default:
+			fmt.Fprintln(logw, sc.Text())
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if !done {
+		return errors.New("no complete profile in the logs")
+	}
+	return nil
+}
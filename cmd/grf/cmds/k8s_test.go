@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestK8sScanFlags(t *testing.T) {
+	var attach *cobra.Command
+	for _, c := range newK8sCommand().Commands() {
+		if c.Name() == "attach" {
+			attach = c
+		}
+	}
+	for _, args := range [][]string{
+		{"--parallel=2", "--depth-rules=/etc/passwd"},
+		{"--debug-info-dir=/usr/lib/debug"},
+		{"--debuginfod=https://debuginfod.example.com"},
+		{"--cpuprofile=cpu.out"},
+		{"--memprofile=mem.out"},
+	} {
+		attach.Flags().VisitAll(func(f *pflag.Flag) { f.Changed = false })
+		if err := attach.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+		err := attach.PreRunE(attach, nil)
+		if err == nil || !strings.Contains(err.Error(), "ephemeral container") {
+			t.Errorf("%v: got %v, want rejected", args, err)
+		}
+	}
+	attach.Flags().VisitAll(func(f *pflag.Flag) { f.Changed = false })
+	if err := attach.ParseFlags([]string{"--parallel=2", "--stats", "--out=local.out"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := attach.PreRunE(attach, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--parallel=2", "--stats=true"}; !slices.Equal(k8sScanArgs, want) {
+		t.Errorf("got %v, want %v", k8sScanArgs, want)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// parseLabels parses the labels like "service=api" given by --label.
+func parseLabels(labels []string) (map[string]string, error) {
+	res := make(map[string]string, len(labels))
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, must be <key>=<value>", l)
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+// detectLabels returns the hostname, and the container ID of the process and the build ID of
+// the executable if found. The process is not examined if pid is 0, e.g. scanning a core file.
+func detectLabels(pid int, exe string) map[string]string {
+	labels := make(map[string]string)
+	if host, err := os.Hostname(); err == nil {
+		labels[myproc.LabelHostname] = host
+	}
+	if pid != 0 {
+		if id, err := myproc.ReadContainerID(pid); err != nil {
+			fmt.Fprintf(os.Stderr, "read the container ID of process %d: %v\n", pid, err)
+		} else if id != "" {
+			labels[myproc.LabelContainerID] = id
+		}
+	}
+	if id, err := myproc.ReadBuildID(exe); err != nil {
+		fmt.Fprintf(os.Stderr, "read the build ID of %s: %v\n", exe, err)
+	} else if id != "" {
+		labels[myproc.LabelBuildID] = id
+	}
+	return labels
+}
@@ -0,0 +1,33 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// printLargeObjects prints the largest heap objects listed by --large-objects.
+func printLargeObjects(objects []myproc.LargeObject) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSIZE\tTYPE\tPATH")
+	for _, obj := range objects {
+		fmt.Fprintf(w, "%#x\t%s\t%s\t%s\n", uint64(obj.Addr), myproc.FormatBytes(obj.Size), obj.Type, compactPath(obj.Path))
+	}
+	return w.Flush()
+}
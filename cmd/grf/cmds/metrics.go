@@ -0,0 +1,131 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// processMetrics are the metrics of the last scanning of a process.
+type processMetrics struct {
+	name     string
+	scanTime time.Time
+	// total bytes of the objects reached
+	total int64
+	// the top reference chains by flat bytes, with their cumulative bytes
+	top []profile.TopEntry
+}
+
+// agentMetrics exports the metrics of the processes monitored by the agent in the Prometheus text format.
+type agentMetrics struct {
+	mu    sync.Mutex
+	procs map[int]*processMetrics
+}
+
+func newAgentMetrics() *agentMetrics {
+	return &agentMetrics{procs: make(map[int]*processMetrics)}
+}
+
+// update reads the profile of the process, and replaces the metrics of the process with the top n chains.
+func (m *agentMetrics) update(pid int, name, filename string, n int) error {
+	p, err := readProfile(filename)
+	if err != nil {
+		return err
+	}
+	top, total, err := p.Top(myproc.SampleSpace.ValueType().Type, n, false)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.procs[pid] = &processMetrics{name: name, scanTime: time.Now(), total: total, top: top}
+	return nil
+}
+
+// remove removes the metrics of the process which is gone.
+func (m *agentMetrics) remove(pid int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.procs, pid)
+}
+
+func (m *agentMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+// write writes the metrics, the values of each metric are grouped under its HELP and TYPE lines.
+func (m *agentMetrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pids := make([]int, 0, len(m.procs))
+	for pid := range m.procs {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	w.WriteString("# HELP goref_last_scan_timestamp_seconds Time of the last scanning of the process.\n")
+	w.WriteString("# TYPE goref_last_scan_timestamp_seconds gauge\n")
+	for _, pid := range pids {
+		p := m.procs[pid]
+		fmt.Fprintf(w, "goref_last_scan_timestamp_seconds{%s} %d\n", processLabels(pid, p), p.scanTime.Unix())
+	}
+	w.WriteString("# HELP goref_reachable_bytes Bytes of the objects reached by the last scanning of the process.\n")
+	w.WriteString("# TYPE goref_reachable_bytes gauge\n")
+	for _, pid := range pids {
+		p := m.procs[pid]
+		fmt.Fprintf(w, "goref_reachable_bytes{%s} %d\n", processLabels(pid, p), p.total)
+	}
+	w.WriteString("# HELP goref_retained_bytes Bytes referenced through the top reference chains of the process, by the flat bytes.\n")
+	w.WriteString("# TYPE goref_retained_bytes gauge\n")
+	for _, pid := range pids {
+		p := m.procs[pid]
+		for _, e := range p.top {
+			fmt.Fprintf(w, "goref_retained_bytes{%s,root=%s,type=%s,path=%s} %d\n", processLabels(pid, p),
+				labelValue(e.Path[0]), labelValue(leafType(e.Path)), labelValue(compactPath(e.Path)), e.Cum)
+		}
+	}
+}
+
+func processLabels(pid int, p *processMetrics) string {
+	return "process=" + labelValue(p.name) + ",pid=" + labelValue(strconv.Itoa(pid))
+}
+
+// leafType returns the type of the last node of the path, like "*main.T" of "next. (*main.T)",
+// empty if unknown, e.g. the path is only a root.
+func leafType(path []string) string {
+	name := path[len(path)-1]
+	if i := strings.LastIndex(name, ". ("); i >= 0 && strings.HasSuffix(name, ")") {
+		return name[i+3 : len(name)-1]
+	}
+	return ""
+}
+
+// labelValue quotes the label value with the escapes of the Prometheus text format.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
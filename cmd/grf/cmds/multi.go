@@ -0,0 +1,117 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// attachMerge is whether to merge the profiles of the processes scanned together into one.
+var attachMerge bool
+
+// parsePids parses the pids separated by commas, like "123,456".
+func parsePids(s string) ([]int, error) {
+	var pids []int
+	for _, f := range strings.Split(s, ",") {
+		pid, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("Invalid pid: %s", f)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// processOutFile returns the output file of a process scanned with others, like grf.123.out of grf.out.
+func processOutFile(out string, pid int) string {
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "." + strconv.Itoa(pid) + ext
+}
+
+// attachProcesses scans the processes one by one, and writes a profile of each process labeled by its pid,
+// or with --merge, a profile of all the processes whose samples are labeled by their pids.
+func attachProcesses(pids []int) int {
+	if !attachMerge {
+		code := 0
+		for _, pid := range pids {
+			fmt.Fprintf(os.Stderr, "scanning process %d\n", pid)
+			labels := map[string]string{myproc.LabelPid: strconv.Itoa(pid)}
+			if c := execute(pid, "", "", processOutFile(outFile, pid), conf, myproc.WithLabels(labels)); c != 0 {
+				code = c
+			}
+		}
+		return code
+	}
+
+	outFormat, err := myproc.ParseFormat(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid format: %v\n", err)
+		return 1
+	}
+	if outFormat == myproc.FormatGraph || outFormat == myproc.FormatDOT {
+		fmt.Fprintf(os.Stderr, "the %s format of the object graph can not be merged\n", outFormat)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "grf-merge-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	// the processes are scanned in pprof format, which is read back to be merged
+	format = myproc.FormatPprof.String()
+	defer func() { format = outFormat.String() }()
+	code := 0
+	var profiles []*profile.RefProfile
+	var labels []map[string]string
+	for _, pid := range pids {
+		fmt.Fprintf(os.Stderr, "scanning process %d\n", pid)
+		path := filepath.Join(dir, strconv.Itoa(pid)+".out")
+		if c := execute(pid, "", "", path, conf); c != 0 {
+			code = c
+			continue
+		}
+		p, err := readProfile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			code = 1
+			continue
+		}
+		profiles = append(profiles, p)
+		labels = append(labels, map[string]string{myproc.LabelPid: strconv.Itoa(pid)})
+	}
+	if len(profiles) == 0 {
+		return code
+	}
+	f, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+	if err = myproc.MergeProfiles(f, profiles, labels, outFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "merged the profiles of %d processes to `%s`\n", len(profiles), outFile)
+	return code
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package cmds
+
+import "errors"
+
+// findProcesses is only supported on linux, where the processes are listed by procfs.
+func findProcesses(name string) ([]int, error) {
+	return nil, errors.New("finding the processes by name is only supported on linux")
+}
+
+// processName returns "pid" since the process names are unknown on the platform.
+func processName(pid int) string {
+	return "pid"
+}
+
+// peakRSS returns false since the peak RSS is unknown on the platform.
+func peakRSS() (int64, bool) {
+	return 0, false
+}
+
+// processRSS returns false since the RSS is unknown on the platform.
+func processRSS(pid int) (int64, bool) {
+	return 0, false
+}
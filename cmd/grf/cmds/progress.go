@@ -0,0 +1,103 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// progressInterval is the min interval between the progress lines, the last one of a phase is always printed.
+const progressInterval = 100 * time.Millisecond
+
+// progressBarWidth is the number of the characters of the progress bar.
+const progressBarWidth = 30
+
+// progressPrinter prints the progress of the scanning, as a progress bar redrawn in place for the terminals,
+// or as JSON lines for the scripts.
+type progressPrinter struct {
+	w    io.Writer
+	json bool
+
+	mu    sync.Mutex
+	phase string
+	last  time.Time
+}
+
+// progressLine is a line of --progress=json.
+type progressLine struct {
+	Phase     string `json:"phase"`
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	Objects   int64  `json:"objects"`
+	Bytes     int64  `json:"bytes"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+	ETAMS     int64  `json:"eta_ms"`
+}
+
+// newProgressPrinter returns the printer of the progress mode, bar or json, nil if the mode is empty.
+func newProgressPrinter(mode string, w io.Writer) (*progressPrinter, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "bar":
+		return &progressPrinter{w: w}, nil
+	case "json":
+		return &progressPrinter{w: w, json: true}, nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q, must be bar or json", mode)
+}
+
+// print prints the progress, which is called by the scanning.
+func (p *progressPrinter) print(pr myproc.ScanProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	last := pr.Done >= pr.Total
+	if pr.Phase == p.phase && !last && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.phase, p.last = pr.Phase, now
+	if p.json {
+		b, _ := json.Marshal(progressLine{
+			Phase: pr.Phase, Done: pr.Done, Total: pr.Total, Objects: pr.Objects, Bytes: pr.Space,
+			ElapsedMS: pr.Elapsed.Milliseconds(), ETAMS: pr.ETA().Milliseconds(),
+		})
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+	filled := progressBarWidth
+	if pr.Total > 0 {
+		filled = progressBarWidth * pr.Done / pr.Total
+	}
+	eta := "eta " + pr.ETA().Round(time.Second).String()
+	if pr.Done == 0 {
+		eta = "eta -"
+	}
+	end := "\r"
+	if last {
+		eta, end = "done", "\n"
+	}
+	// the spaces clear the rest of a longer line drawn before
+	fmt.Fprintf(p.w, "\r%-11s [%s%s] %d/%d, %d objects %s marked, %s elapsed, %s   %s",
+		pr.Phase, strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), pr.Done, pr.Total,
+		pr.Objects, myproc.FormatBytes(pr.Space), pr.Elapsed.Round(time.Millisecond), eta, end)
+}
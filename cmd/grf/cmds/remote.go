@@ -0,0 +1,383 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// agentListen is the address the remote agent listens at, and agentTLSCert and agentTLSKey serve it by TLS.
+	agentListen, agentTLSCert, agentTLSKey string
+	// remoteToken is the bearer token authenticating the requests to the remote agent, no authentication if empty.
+	remoteToken string
+)
+
+// remoteTokenEnv is the environment variable of the token, so it's not in the command lines.
+const remoteTokenEnv = "GOREF_AGENT_TOKEN"
+
+// remoteScanRequest is the body of a scanning request to the remote agent.
+type remoteScanRequest struct {
+	Pid int `json:"pid"`
+	// Args are the scan flags of the scanning like "--format=json".
+	Args []string `json:"args,omitempty"`
+}
+
+// remoteProcess is a process listed by the remote agent.
+type remoteProcess struct {
+	Pid  int    `json:"pid"`
+	Name string `json:"name"`
+	RSS  int64  `json:"rss,omitempty"`
+}
+
+// newRemoteCommands returns the serve-agent and the connect commands, the latter passes its scan flags to the former.
+func newRemoteCommands() (serveAgentCommand, connectCommand *cobra.Command) {
+	connectCommand = &cobra.Command{
+		Use:   "connect <addr> [pid]",
+		Short: "Scan a process by a remote agent and receive the profile.",
+		Long: `Request the agent started by serve-agent at addr, like host:7070 or https://host:7070, to scan the
+process, and write the profile streamed back to --out, so neither goref nor the profiles need to be
+copied from or to the host. The scan flags, --snapshot and --freeze-duration are passed to the agent,
+except those naming the files or the programs on the host, like --depth-rules and --cpuprofile, which
+are rejected. The processes on the host are listed if no pid is given.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				os.Exit(listRemoteProcesses(args[0]))
+			}
+			pid, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[1])
+				os.Exit(1)
+			}
+			os.Exit(connect(args[0], pid, scanArgs(cmd, remoteScanFlags)))
+		},
+	}
+	addScanFlags(connectCommand)
+	connectCommand.Flags().DurationVar(&freezeDuration, "freeze-duration", 0, "max duration the target is stopped, like the attach command")
+	connectCommand.Flags().StringVar(&snapshot, "snapshot", "", "snapshot the memory to scan, copy or fork, like the attach command")
+	connectCommand.Flags().Lookup("snapshot").NoOptDefVal = "copy"
+	// all the scan flags but --out are checked, including the local-only ones, which connect doesn't apply
+	var scanFlags []string
+	connectCommand.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name != "out" {
+			scanFlags = append(scanFlags, f.Name)
+		}
+	})
+	connectCommand.Flags().StringVar(&remoteToken, "token", os.Getenv(remoteTokenEnv), "bearer token of the agent, $"+remoteTokenEnv+" by default")
+	connectCommand.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		return checkScanFlags(cmd, scanFlags, remoteScanFlags, "by the remote agent")
+	}
+
+	serveAgentCommand = &cobra.Command{
+		Use:   "serve-agent",
+		Short: "Serve the scanning of the processes on the host to remote clients.",
+		Long: `Run as an agent next to the processes to scan, which attaches to a process and scans it when
+requested by the connect command, and streams the profile back over HTTP, until goref is interrupted.
+The scannings are run one at a time by goref itself, each in a child process, taking the scan flags
+of the request. The processes on the host are also listed for the clients.
+
+The agent serves http://localhost:7070 by default. When it listens at other interfaces, the requests
+should be authenticated by --token, and served by TLS with --tls-cert and --tls-key, since anyone
+reaching it can read the memory of the processes by the profiles. The API is:
+  GET  /v1/processes    the processes as a JSON array of {"pid", "name", "rss"}
+  POST /v1/scan         scan {"pid", "args"}, where args are the scan flags, and return the profile`,
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			os.Exit(serveAgent())
+		},
+	}
+	serveAgentCommand.Flags().StringVar(&agentListen, "listen", "localhost:7070", "address to listen at, like :7070")
+	serveAgentCommand.Flags().StringVar(&remoteToken, "token", os.Getenv(remoteTokenEnv), "bearer token required from the clients, $"+remoteTokenEnv+" by default, no authentication if empty")
+	serveAgentCommand.Flags().StringVar(&agentTLSCert, "tls-cert", "", "certificate file to serve by TLS, with --tls-key")
+	serveAgentCommand.Flags().StringVar(&agentTLSKey, "tls-key", "", "private key file to serve by TLS, with --tls-cert")
+	return serveAgentCommand, connectCommand
+}
+
+// remoteScanFlags are the flags passed from the connect command to the agent, and the only ones the agent accepts.
+// They only tune the scanning and the profile streamed back. The others name the files or the programs on the
+// host of the agent, like --cpuprofile, --debug-info-dir and --depth-rules, or only print to its stderr.
+var remoteScanFlags = []string{
+	"format", "group-by", "sample-types", "self-memory-limit", "check-marks", "timeout", "runtime-roots",
+	"parallel", "include-pkg", "exclude-pkg", "min-bytes", "min-objects", "max-ram", "type-regex",
+	"max-array-elems", "map-sample-rate", "sample", "sample-max-size", "show-values", "label", "auto-labels",
+	"deterministic", "snapshot", "freeze-duration",
+}
+
+func serveAgent() int {
+	if (agentTLSCert == "") != (agentTLSKey == "") {
+		fmt.Fprintln(os.Stderr, "--tls-cert and --tls-key must be given together")
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	a := &remoteAgent{exe: exe}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/processes", a.authorized(a.processes))
+	mux.HandleFunc("/v1/scan", a.authorized(a.scan))
+	ln, err := net.Listen("tcp", agentListen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if remoteToken == "" && !isLoopback(ln.Addr()) {
+		fmt.Fprintf(os.Stderr, "warning: the agent listens at %s without --token\n", ln.Addr())
+	}
+	srv := &http.Server{Handler: mux}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "serving the agent at %s\n", ln.Addr())
+	if agentTLSCert != "" {
+		err = srv.ServeTLS(ln, agentTLSCert, agentTLSKey)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+// isLoopback reports whether the address only accepts the connections from the host.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// remoteAgent serves the scannings by the child processes of goref, one at a time.
+type remoteAgent struct {
+	exe string
+	mu  sync.Mutex
+}
+
+// authorized checks the bearer token of the requests before the handler.
+func (a *remoteAgent) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if remoteToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(remoteToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (a *remoteAgent) processes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pids, err := findProcesses("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	procs := make([]remoteProcess, 0, len(pids))
+	for _, pid := range pids {
+		p := remoteProcess{Pid: pid, Name: processName(pid)}
+		p.RSS, _ = processRSS(pid)
+		procs = append(procs, p)
+	}
+	slices.SortFunc(procs, func(a, b remoteProcess) int { return a.Pid - b.Pid })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(procs)
+}
+
+func (a *remoteAgent) scan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req remoteScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Pid <= 0 {
+		http.Error(w, "invalid pid "+strconv.Itoa(req.Pid), http.StatusBadRequest)
+		return
+	}
+	// only the allowed scan flags like --name=value, so the request can't choose the files or the programs
+	for _, arg := range req.Args {
+		name, _, ok := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !strings.HasPrefix(arg, "--") || !ok || !slices.Contains(remoteScanFlags, name) {
+			http.Error(w, "invalid scan flag "+arg, http.StatusBadRequest)
+			return
+		}
+	}
+	f, err := os.CreateTemp("", "grf-agent-*.out")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	a.mu.Lock()
+	fmt.Fprintf(os.Stderr, "scanning process %d for %s\n", req.Pid, r.RemoteAddr)
+	args := append([]string{"attach", strconv.Itoa(req.Pid), "--out", path}, req.Args...)
+	cmd := exec.CommandContext(r.Context(), a.exe, args...)
+	// interrupted to detach the target rather than killed if the client goes away
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = time.Minute
+	var stderr bytes.Buffer
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err = cmd.Run()
+	a.mu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("scan process %d: %v\n%s", req.Pid, err, stderr.Bytes()), http.StatusInternalServerError)
+		return
+	}
+	f, err = os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(st.Size(), 10))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.Copy(w, f)
+}
+
+// remoteURL returns the URL of the API path of the agent at addr, which is http if not given.
+func remoteURL(addr, path string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + path
+}
+
+// remoteDo sends the request to the agent with the token, and returns the response if it's OK.
+func remoteDo(req *http.Request) (*http.Response, error) {
+	if remoteToken != "" {
+		req.Header.Set("Authorization", "Bearer "+remoteToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func connect(addr string, pid int, args []string) int {
+	body, err := json.Marshal(remoteScanRequest{Pid: pid, Args: args})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	// the agent detaches the target if the request is canceled by interrupting goref
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, remoteURL(addr, "/v1/scan"), bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	fmt.Fprintf(os.Stderr, "scanning process %d by the agent at %s\n", pid, addr)
+	resp, err := remoteDo(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outFile)
+		fmt.Fprintf(os.Stderr, "receive the profile: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "successfully output to `%s`\n", outFile)
+	return 0
+}
+
+func listRemoteProcesses(addr string) int {
+	req, err := http.NewRequest(http.MethodGet, remoteURL(addr, "/v1/processes"), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	resp, err := remoteDo(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer resp.Body.Close()
+	var procs []remoteProcess
+	if err := json.NewDecoder(resp.Body).Decode(&procs); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tRSS\tNAME")
+	for _, p := range procs {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", p.Pid, myproc.FormatBytes(p.RSS), p.Name)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
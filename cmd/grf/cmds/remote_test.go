@@ -0,0 +1,62 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemoteScanRejectedFlags(t *testing.T) {
+	// the scanning must not start, which would write the file
+	marker := filepath.Join(t.TempDir(), "scanned")
+	exe := filepath.Join(t.TempDir(), "grf")
+	if err := os.WriteFile(exe, []byte("#!/bin/sh\ntouch "+marker+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a := &remoteAgent{exe: exe}
+	for _, arg := range []string{
+		"--cpuprofile=/tmp/clobbered",
+		"--memprofile=/tmp/clobbered",
+		"--debug-info-dir=/",
+		"--debuginfod=https://debuginfod.example.com",
+		"--depth-rules=/etc/passwd",
+		"--out=/tmp/clobbered",
+		"--parallel",
+		"parallel=1",
+	} {
+		body, _ := json.Marshal(remoteScanRequest{Pid: 1, Args: []string{"--parallel=1", arg}})
+		w := httptest.NewRecorder()
+		a.scan(w, httptest.NewRequest(http.MethodPost, "/v1/scan", strings.NewReader(string(body))))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", arg, w.Code, http.StatusBadRequest)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatalf("the scanning is started with a rejected flag")
+	}
+	// the allowed flags only
+	body, _ := json.Marshal(remoteScanRequest{Pid: 1, Args: []string{"--parallel=1", "--format=json", "--label=a=b"}})
+	w := httptest.NewRecorder()
+	a.scan(w, httptest.NewRequest(http.MethodPost, "/v1/scan", strings.NewReader(string(body))))
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("the scanning is not started with the allowed flags, status %d: %s", w.Code, w.Body)
+	}
+}
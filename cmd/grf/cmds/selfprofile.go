@@ -0,0 +1,80 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// selfProfileFlags are the flags profiling goref itself, which are rejected by the commands scanning in another goref.
+var selfProfileFlags = []string{"cpuprofile", "memprofile"}
+
+// startSelfProfile starts the CPU profile of goref itself by --cpuprofile, and returns the function
+// stopping it, which also writes the heap profile of goref by --memprofile.
+func startSelfProfile() (stop func(), err error) {
+	var cpuFile *os.File
+	if cpuProfile != "" {
+		if cpuFile, err = os.Create(cpuProfile); err != nil {
+			return nil, err
+		}
+		if err = pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+		if memProfile != "" {
+			if err := writeHeapProfile(memProfile); err != nil {
+				fmt.Fprintf(os.Stderr, "write the heap profile of goref: %v\n", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// the up-to-date statistics of the live objects
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
+
+// printScanStats prints the time of attaching to the target or opening the core file, and of every scanning
+// phase, the peak RSS of goref and the bytes read from the target for --stats.
+func printScanStats(attach myproc.PhaseStat, stats *myproc.ScanStats) {
+	var total time.Duration
+	for _, ph := range append([]myproc.PhaseStat{attach}, stats.Phases...) {
+		fmt.Fprintf(os.Stderr, "%-16s %v\n", ph.Phase, ph.Duration.Round(time.Millisecond))
+		total += ph.Duration
+	}
+	fmt.Fprintf(os.Stderr, "%-16s %v\n", "total", total.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "read %s from the target in %d reads\n", myproc.FormatBytes(stats.BytesRead), stats.Reads)
+	if rss, ok := peakRSS(); ok {
+		fmt.Fprintf(os.Stderr, "peak RSS of goref %s\n", myproc.FormatBytes(rss))
+	}
+}
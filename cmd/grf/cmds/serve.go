@@ -0,0 +1,77 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// serveAddr is the address the web UI is served at.
+var serveAddr string
+
+func newServeCommand() *cobra.Command {
+	serveCommand := &cobra.Command{
+		Use:   "serve [profile]",
+		Short: "Serve a web UI of a reference profile.",
+		Long: `Serve a web UI of a profile written by goref in pprof format, grf.out by default, until goref
+is interrupted.
+
+Unlike go tool pprof, the UI shows the names of the nodes split into the fields or the variables and
+the types they reference. It has a top view of the nodes by their reference paths, types or fields,
+an expandable reference tree, and a flame graph.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			filename := "grf.out"
+			if len(args) > 0 {
+				filename = args[0]
+			}
+			os.Exit(serve(filename))
+		},
+	}
+	serveCommand.Flags().StringVar(&serveAddr, "http", "localhost:8080", "address to serve the web UI at, like :8080")
+	return serveCommand
+}
+
+func serve(filename string) int {
+	p, err := readProfile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	handler, err := myproc.NewWebHandler(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	ln, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Printf("Serving the web UI of %s at http://%s\n", filename, ln.Addr())
+	if err = http.Serve(ln, handler); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":        0,
+		"0":       0,
+		"512":     512,
+		"100B":    100,
+		"1K":      1 << 10,
+		"1KB":     1 << 10,
+		"1KiB":    1 << 10,
+		"512MiB":  512 << 20,
+		"2G":      2 << 30,
+		"2GB":     2 << 30,
+		"1.5GiB":  3 << 29,
+		"1TiB":    1 << 40,
+		"4t":      4 << 40,
+		"64 mib":  64 << 20,
+		" 2Gi B ": -1,
+		"GiB":     -1,
+		"-1MiB":   -1,
+		"1PiB":    -1,
+		"abc":     -1,
+		"NaN":     -1,
+		"InfGiB":  -1,
+		"1e30B":   -1,
+	} {
+		got, err := parseSize(s)
+		if want < 0 {
+			if err == nil {
+				t.Errorf("%q: got %d, want an error", s, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", s, got, err, want)
+		}
+	}
+}
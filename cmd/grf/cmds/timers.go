@@ -0,0 +1,86 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+var (
+	// timersOutFile is the profile output by the scanning.
+	timersOutFile string
+	// timersMinCount is the threshold of the callbacks reported.
+	timersMinCount int64
+)
+
+func newTimersCommand() *cobra.Command {
+	timersCommand := &cobra.Command{
+		Use:   "timers <pid>",
+		Short: "Summarize the pending timers by their callbacks.",
+		Long: `Attach to a running process, and report the timers in the runtime timer heaps by their
+callbacks, i.e. the functions passed to time.AfterFunc, or time.sendTime of the timers and tickers
+whose channels are waited on. A growing number of the timers of a callback is likely a leak, e.g.
+the timers never stopped.
+
+The heap memory retained by the timers is attributed to the <timers> root and their callbacks in
+the reference profile, which is also output like the attach command.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(timers(pid))
+		},
+	}
+	timersCommand.Flags().StringVarP(&timersOutFile, "out", "o", "grf.timers.out", "output file name of the profile")
+	timersCommand.Flags().Int64Var(&timersMinCount, "min-count", 1, "report the callbacks with at least N timers")
+	return timersCommand
+}
+
+func timers(pid int) int {
+	var stats []myproc.TimerStat
+	if code := execute(pid, "", "", timersOutFile, conf, myproc.WithTimerStats(&stats)); code != 0 {
+		return code
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIMERS\tPERIODIC\tOBJECTS\tREACHED\tCALLBACK")
+	var found int
+	for _, st := range stats {
+		if st.Count < timersMinCount {
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\n", st.Count, st.Periodic, st.Objects, myproc.FormatBytes(st.Space), st.Callback)
+		found++
+	}
+	if found == 0 {
+		fmt.Printf("no callback has %d or more pending timers\n", timersMinCount)
+		return 0
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
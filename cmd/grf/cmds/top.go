@@ -0,0 +1,146 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+var (
+	// topN is the number of the reference chains printed by the top command.
+	topN int
+	// topCum is whether to sort the chains by their cumulative values.
+	topCum bool
+	// topSampleIndex is the sample type to sort by, like "space" or "inuse_space".
+	topSampleIndex string
+	// topPid is the process to scan instead of reading a profile.
+	topPid int
+)
+
+func newTopCommand() *cobra.Command {
+	topCommand := &cobra.Command{
+		Use:   "top [profile]",
+		Short: "Print the top reference chains.",
+		Long: `Print the top reference chains of a profile written by goref, grf.out by default, in a table,
+after the comments of the profile, like the memory breakdown of the target.
+
+With --pid, the process is scanned instead, and the profile is not kept.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			filename := "grf.out"
+			if len(args) > 0 {
+				filename = args[0]
+			}
+			os.Exit(top(filename))
+		},
+	}
+	topCommand.Flags().IntVarP(&topN, "nodes", "n", 10, "number of the reference chains to print, 0 means all")
+	topCommand.Flags().BoolVar(&topCum, "cum", false, "sort the reference chains by their cumulative values instead of their flat values")
+	topCommand.Flags().StringVar(&topSampleIndex, "sample-index", "space", "sample type to sort by, like space or objects")
+	topCommand.Flags().IntVar(&topPid, "pid", 0, "scan the process instead of reading a profile")
+	return topCommand
+}
+
+func top(filename string) int {
+	types, err := myproc.ParseSampleTypes(topSampleIndex)
+	if err != nil || len(types) != 1 {
+		fmt.Fprintf(os.Stderr, "Invalid sample index %q\n", topSampleIndex)
+		return 1
+	}
+	vt := types[0].ValueType()
+	if topPid != 0 {
+		dir, err := os.MkdirTemp("", "grf")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		filename = filepath.Join(dir, "grf.out")
+		if code := execute(topPid, "", "", filename, conf); code != 0 {
+			return code
+		}
+	}
+	p, err := readProfile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	// the memory breakdown of the target
+	for _, c := range p.Comments {
+		fmt.Println(c)
+	}
+	entries, total, err := p.Top(vt.Type, topN, topCum)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if err = printTop(entries, total, vt); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func printTop(entries []profile.TopEntry, total int64, vt profile.ValueType) error {
+	if total == 0 {
+		return errors.New("no samples of " + vt.Type)
+	}
+	value := func(v int64) string {
+		if vt.Unit == "bytes" {
+			return myproc.FormatBytes(v)
+		}
+		return fmt.Sprint(v)
+	}
+	percent := func(v int64) string {
+		return fmt.Sprintf("%.2f%%", float64(v)*100/float64(total))
+	}
+	fmt.Printf("Type: %s, total %s\n", vt.Type, value(total))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "flat\tflat%\tcum\tcum%\t")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t  %s\n", value(e.Flat), percent(e.Flat), value(e.Cum), percent(e.Cum), compactPath(e.Path))
+	}
+	return w.Flush()
+}
+
+// compactPath joins the names of the path, and collapses the runs of a name like "next. (*main.T) x255".
+func compactPath(path []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); {
+		j := i + 1
+		for j < len(path) && path[j] == path[i] {
+			j++
+		}
+		if i > 0 {
+			sb.WriteString(" -> ")
+		}
+		sb.WriteString(path[i])
+		if j-i > 1 {
+			fmt.Fprintf(&sb, " x%d", j-i)
+		}
+		i = j
+	}
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cloudwego/goref/pkg/tui"
+)
+
+func newTUICommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui [profile]",
+		Short: "Explore a reference profile in the terminal.",
+		Long: `Explore the reference tree of a profile written by goref in pprof format, grf.out by default,
+in the terminal, like ncdu for the heap.
+
+The reference chains are expanded and collapsed by the arrow keys, the children are sorted by the
+retained space if the profile carries it, and s switches the sample type to sort by. / searches the
+nodes by their type names, and n goes to the next match. q quits.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			filename := "grf.out"
+			if len(args) > 0 {
+				filename = args[0]
+			}
+			p, err := readProfile(filename)
+			if err == nil {
+				err = tui.Run(p, filename)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		},
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// minAttributedRatio is the fraction of the heap below which the gap is flagged by --validate.
+const minAttributedRatio = 0.8
+
+// printValidation prints the fraction of the heap attributed by the scanning.
+func printValidation(v *myproc.Validation) {
+	fmt.Printf("heap in use %s, allocated %s in %d objects\n", myproc.FormatBytes(v.HeapInuse), myproc.FormatBytes(v.HeapAlloc), v.HeapObjects)
+	fmt.Printf("attributed %s (%.1f%%) in %d objects (%.1f%%)\n",
+		myproc.FormatBytes(v.Space), 100*v.SpaceRatio(), v.Objects, 100*v.ObjectsRatio())
+	if v.SpaceRatio() < minAttributedRatio || v.ObjectsRatio() < minAttributedRatio {
+		fmt.Printf("warning: %s in %d objects are not attributed, which are either garbage not collected yet, "+
+			"or referenced in a way goref misses; scan again after a GC cycle to tell\n",
+			myproc.FormatBytes(v.HeapAlloc-v.Space), v.HeapObjects-v.Objects)
+	}
+}
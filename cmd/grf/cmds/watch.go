@@ -0,0 +1,182 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+var (
+	// watchInterval is the interval between the snapshots, and watchCount is the number of the snapshots.
+	watchInterval time.Duration
+	watchCount    int
+	// watchDir is the directory of the snapshots.
+	watchDir string
+	// watchTop is the number of the growing reference chains reported after every snapshot.
+	watchTop int
+	// watchSampleIndex is the sample type whose growth is reported, like "space" or "objects".
+	watchSampleIndex string
+	// watchCum is whether to report the growth of the cumulative values of the chains.
+	watchCum bool
+	// watchIncremental is whether to copy only the memory written since the last snapshot.
+	watchIncremental bool
+)
+
+func newWatchCommand() *cobra.Command {
+	watchCommand := &cobra.Command{
+		Use:   "watch <pid>",
+		Short: "Take reference snapshots periodically and report the growing chains.",
+		Long: `Attach to a running process every --interval to take --count reference snapshots, and report
+the reference chains growing the fastest after every snapshot since the second one, which automates
+the hunting for leaks by taking the snapshots and diffing them by hand.
+
+The growth rate of a chain, in bytes or objects per minute, is fitted by the least squares over all
+the snapshots taken, so the chains fluctuating with the load don't stand out like in the difference
+of two snapshots. The snapshots are kept in --dir, named like <process name>-<time>-<pid>.out, so they
+can be diffed or viewed later. The scan flags apply to every snapshot, except --out and --format.
+
+With --incremental, the memory of the target is copied before every scanning like --snapshot of the
+attach command, but only the pages written since the last snapshot are copied again, which are known
+by the soft-dirty bits of linux. It cuts the time the target is stopped for a mostly static heap, but
+the copies are kept between the snapshots, which take as much memory as the heap.`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if watchInterval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			if watchCount < 2 {
+				return errors.New("--count must be at least 2")
+			}
+			if format != "pprof" {
+				return errors.New("the snapshots must be in the pprof format")
+			}
+			return nil
+		},
+		Run: func(_ *cobra.Command, args []string) {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[0])
+				os.Exit(1)
+			}
+			os.Exit(watch(pid))
+		},
+	}
+	addScanFlags(watchCommand)
+	watchCommand.Flags().MarkHidden("out")
+	watchCommand.Flags().MarkHidden("format")
+	watchCommand.Flags().DurationVar(&watchInterval, "interval", 5*time.Minute, "interval between the snapshots")
+	watchCommand.Flags().IntVar(&watchCount, "count", 12, "number of the snapshots to take")
+	watchCommand.Flags().StringVar(&watchDir, "dir", "grf-watch", "directory of the snapshots")
+	watchCommand.Flags().IntVarP(&watchTop, "nodes", "n", 10, "number of the growing reference chains to report, 0 means all")
+	watchCommand.Flags().StringVar(&watchSampleIndex, "sample-index", "space", "sample type whose growth is reported, like space or objects")
+	watchCommand.Flags().BoolVar(&watchCum, "cum", false, "report the growth of the cumulative values of the chains instead of their flat values")
+	watchCommand.Flags().BoolVar(&watchIncremental, "incremental", false, "copy the memory to scan, and only copy the pages written since the last snapshot again (linux only)")
+	return watchCommand
+}
+
+func watch(pid int) int {
+	types, err := myproc.ParseSampleTypes(watchSampleIndex)
+	if err != nil || len(types) != 1 {
+		fmt.Fprintf(os.Stderr, "Invalid sample index %q\n", watchSampleIndex)
+		return 1
+	}
+	vt := types[0].ValueType()
+	if err = os.MkdirAll(watchDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var opts []myproc.Option
+	if watchIncremental {
+		snapshot = "copy"
+		opts = append(opts, myproc.WithIncremental(myproc.NewIncrementalState()))
+	}
+	name := processName(pid)
+	var profiles []*profile.RefProfile
+	var times []time.Time
+	next := time.Now()
+	for i := 0; i < watchCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return 0
+			case <-time.After(time.Until(next)):
+			}
+		}
+		now := time.Now()
+		next = now.Add(watchInterval)
+		file := filepath.Join(watchDir, fmt.Sprintf("%s-%s-%d.out", name, now.Format("20060102-150405"), pid))
+		if code := execute(pid, "", "", file, conf, opts...); code != 0 {
+			os.Remove(file)
+			return code
+		}
+		p, err := readProfile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		profiles, times = append(profiles, p), append(times, now)
+		if len(profiles) < 2 {
+			continue
+		}
+		growth, err := profile.Growth(profiles, times, vt.Type, watchTop, watchCum)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		fmt.Printf("\nSnapshot %d/%d at %s, %s growth since %s:\n", i+1, watchCount, now.Format(time.DateTime),
+			vt.Type, times[0].Format(time.DateTime))
+		if err = printGrowth(growth, vt); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+func printGrowth(growth []profile.GrowthEntry, vt profile.ValueType) error {
+	if len(growth) == 0 {
+		fmt.Println("no reference chain is growing")
+		return nil
+	}
+	value := func(v int64) string {
+		if vt.Unit == "bytes" {
+			return myproc.FormatBytes(v)
+		}
+		return fmt.Sprint(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "rate/min\tfirst\tlast\t")
+	for _, g := range growth {
+		fmt.Fprintf(w, "%s\t%s\t%s\t  %s\n", value(int64(g.Rate)), value(g.First), value(g.Last), compactPath(g.Path))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// whorefOutFile is the profile output by the scanning.
+var whorefOutFile string
+
+func newWhorefCommand() *cobra.Command {
+	whorefCommand := &cobra.Command{
+		Use:   "whoref <addr> (<pid> | <executable> <core>)",
+		Short: "Find out who references a heap object.",
+		Long: `Scan a running process or a core dump, and print the reference paths to the heap object
+containing the address, e.g. an address taken from delve or a crash log.
+
+Every path leads from a root to a variable, field or element pointing to the object, through
+the path where the referencing object is first found, so there is one path for every reference.
+The object graph is recorded during the scanning, which takes extra memory.`,
+		Args: cobra.RangeArgs(2, 3),
+		Run: func(_ *cobra.Command, args []string) {
+			addr, err := strconv.ParseUint(args[0], 0, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid address: %s\n", args[0])
+				os.Exit(1)
+			}
+			var pid int
+			var exeFile, coreFile string
+			if len(args) == 2 {
+				if pid, err = strconv.Atoi(args[1]); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", args[1])
+					os.Exit(1)
+				}
+			} else {
+				exeFile, coreFile = args[1], args[2]
+			}
+			os.Exit(whoref(myproc.Address(addr), pid, exeFile, coreFile))
+		},
+	}
+	whorefCommand.Flags().StringVarP(&whorefOutFile, "out", "o", "grf.whoref.out", "output file name of the profile")
+	return whorefCommand
+}
+
+func whoref(addr myproc.Address, pid int, exeFile, coreFile string) int {
+	var r myproc.Referrers
+	if code := execute(pid, exeFile, coreFile, whorefOutFile, conf, myproc.WithReferrers(addr, &r)); code != 0 {
+		return code
+	}
+	fmt.Printf("object %#x, size %s, %d references\n", uint64(r.Base), myproc.FormatBytes(r.Size), len(r.Paths))
+	if !r.Reached {
+		fmt.Println("the object is not reached by the scanning, it's garbage or only referenced by unknown roots")
+		return 0
+	}
+	for _, path := range r.Paths {
+		fmt.Println(compactPath(path))
+	}
+	return 0
+}
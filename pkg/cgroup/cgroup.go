@@ -0,0 +1,94 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cgroup reads the resource limits of the cgroup which goref itself runs in.
+// When goref runs in the same container as the target (e.g. as a sidecar),
+// these limits are shared with the target process.
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Root is the mount point of the cgroup file system.
+var Root = "/sys/fs/cgroup"
+
+// unlimited values larger than this are treated as no limit (cgroup v1 uses a page aligned max int64).
+const maxLimit = 1 << 62
+
+// MemoryLimit returns the memory limit in bytes of the current cgroup.
+func MemoryLimit() (limit int64, ok bool) {
+	// cgroup v2
+	if v, ok := readInt(filepath.Join(Root, "memory.max")); ok {
+		return v, v > 0 && v < maxLimit
+	}
+	// cgroup v1
+	if v, ok := readInt(filepath.Join(Root, "memory", "memory.limit_in_bytes")); ok {
+		return v, v > 0 && v < maxLimit
+	}
+	return 0, false
+}
+
+// MemoryUsage returns the memory usage in bytes of the current cgroup.
+func MemoryUsage() (usage int64, ok bool) {
+	// cgroup v2
+	if v, ok := readInt(filepath.Join(Root, "memory.current")); ok {
+		return v, true
+	}
+	// cgroup v1
+	return readInt(filepath.Join(Root, "memory", "memory.usage_in_bytes"))
+}
+
+// CPUQuota returns the number of CPUs the current cgroup is allowed to use.
+func CPUQuota() (cpus float64, ok bool) {
+	// cgroup v2, "$MAX $PERIOD"
+	if b, err := os.ReadFile(filepath.Join(Root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		quota, err1 := strconv.ParseFloat(fields[0], 64)
+		period, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return quota / period, true
+	}
+	// cgroup v1
+	quota, ok1 := readInt(filepath.Join(Root, "cpu", "cpu.cfs_quota_us"))
+	period, ok2 := readInt(filepath.Join(Root, "cpu", "cpu.cfs_period_us"))
+	if !ok1 || !ok2 || quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return float64(quota) / float64(period), true
+}
+
+func readInt(path string) (int64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, true
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withFiles points Root to a temporary directory with the files, keyed by their paths relative to Root.
+func withFiles(t *testing.T, files map[string]string) {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := Root
+	Root = root
+	t.Cleanup(func() { Root = old })
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		limit int64
+		ok    bool
+	}{
+		{"v2", map[string]string{"memory.max": "1073741824\n"}, 1 << 30, true},
+		{"v2 max", map[string]string{"memory.max": "max\n"}, 0, false},
+		{"v1", map[string]string{"memory/memory.limit_in_bytes": "536870912\n"}, 512 << 20, true},
+		{"v1 unlimited", map[string]string{"memory/memory.limit_in_bytes": "9223372036854771712\n"}, 9223372036854771712, false},
+		{"v2 over v1", map[string]string{"memory.max": "1073741824", "memory/memory.limit_in_bytes": "536870912"}, 1 << 30, true},
+		{"malformed", map[string]string{"memory.max": "1G\n"}, 0, false},
+		{"none", nil, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			withFiles(t, tt.files)
+			if limit, ok := MemoryLimit(); limit != tt.limit || ok != tt.ok {
+				t.Errorf("got %d, %v, want %d, %v", limit, ok, tt.limit, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		usage int64
+		ok    bool
+	}{
+		{"v2", map[string]string{"memory.current": "12345\n"}, 12345, true},
+		{"v1", map[string]string{"memory/memory.usage_in_bytes": "67890\n"}, 67890, true},
+		{"none", nil, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			withFiles(t, tt.files)
+			if usage, ok := MemoryUsage(); usage != tt.usage || ok != tt.ok {
+				t.Errorf("got %d, %v, want %d, %v", usage, ok, tt.usage, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCPUQuota(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		cpus  float64
+		ok    bool
+	}{
+		{"v2", map[string]string{"cpu.max": "200000 100000\n"}, 2, true},
+		{"v2 fraction", map[string]string{"cpu.max": "50000 100000\n"}, 0.5, true},
+		{"v2 max", map[string]string{"cpu.max": "max 100000\n"}, 0, false},
+		{"v2 malformed", map[string]string{"cpu.max": "200000\n"}, 0, false},
+		{"v1", map[string]string{"cpu/cpu.cfs_quota_us": "150000\n", "cpu/cpu.cfs_period_us": "100000\n"}, 1.5, true},
+		{"v1 unlimited", map[string]string{"cpu/cpu.cfs_quota_us": "-1\n", "cpu/cpu.cfs_period_us": "100000\n"}, 0, false},
+		{"v1 no period", map[string]string{"cpu/cpu.cfs_quota_us": "150000\n"}, 0, false},
+		{"none", nil, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			withFiles(t, tt.files)
+			if cpus, ok := CPUQuota(); cpus != tt.cpus || ok != tt.ok {
+				t.Errorf("got %v, %v, want %v, %v", cpus, ok, tt.cpus, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pprof serves the object reference profile of the running process via its HTTP server,
+// like net/http/pprof. The package is typically only imported for the side effect of registering
+// its HTTP handler, the path is /debug/pprof/reference:
+//
+//	import _ "github.com/cloudwego/goref/pkg/pprof"
+//
+// A process can not debug itself, so the handler re-executes the executable of the process as a
+// child, which attaches to the process, scans it and sends the profile back. The child runs the
+// package initializations of the executable before those of this package, so they should have no
+// side effects, e.g. listening on a port. The process is stopped during the scanning.
+//
+// Since the scanning stops the whole process, the handler scans for one request at a time, with a
+// cooldown interval between the scannings, and may require a token. The handler registered on
+// http.DefaultServeMux requires the token in the GOREF_PPROF_TOKEN environment variable if set; use
+// NewHandler to configure another one.
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-delve/delve/service/debugger"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+)
+
+// childEnv marks the process as the child scanning its parent, the value is the pid of the parent.
+const childEnv = "GOREF_PPROF_PARENT"
+
+func init() {
+	if os.Getenv(childEnv) == strconv.Itoa(os.Getppid()) {
+		os.Exit(runChild())
+	}
+	http.Handle("/debug/pprof/reference", NewHandler(WithToken(os.Getenv(tokenEnv))))
+}
+
+// tokenEnv is the token required by the handler registered on http.DefaultServeMux, no token if empty.
+const tokenEnv = "GOREF_PPROF_TOKEN"
+
+var (
+	// only one child can attach to the process at a time
+	scanning sync.Mutex
+	// the end of the last scanning, guarded by scanning
+	lastScan time.Time
+)
+
+// Option configures the handler.
+type Option func(h *Handler)
+
+// WithToken requires the requests to carry the token by the header "Authorization: Bearer <token>".
+// No token is required if token is empty.
+func WithToken(token string) Option {
+	return func(h *Handler) {
+		h.token = token
+	}
+}
+
+// WithCooldown rejects the requests within d after the last scanning of the process ends, so that the
+// process is not stopped too often. The default is DefaultCooldown.
+func WithCooldown(d time.Duration) Option {
+	return func(h *Handler) {
+		h.cooldown = d
+	}
+}
+
+// DefaultCooldown is the default cooldown interval between the scannings.
+const DefaultCooldown = 10 * time.Second
+
+// Handler serves the object reference profile of the process, in the pprof format. It scans the process
+// for one request at a time, and rejects the others with 429 Too Many Requests, so are the requests
+// within the cooldown interval. The scanning is interrupted and the partial profile is returned if the
+// request is canceled.
+type Handler struct {
+	token    string
+	cooldown time.Duration
+}
+
+// NewHandler creates the handler, which may be routed to any path of a mux.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{cooldown: DefaultCooldown}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Reference serves the reference profile like the handler registered on http.DefaultServeMux,
+// without any token.
+func Reference(w http.ResponseWriter, r *http.Request) {
+	NewHandler().ServeHTTP(w, r)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		serveError(w, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+	if !scanning.TryLock() {
+		serveError(w, http.StatusTooManyRequests, "another scanning is in progress")
+		return
+	}
+	defer scanning.Unlock()
+	if wait := h.cooldown - time.Since(lastScan); !lastScan.IsZero() && wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		serveError(w, http.StatusTooManyRequests, fmt.Sprintf("the process was scanned recently, retry after %v", wait.Round(time.Second)))
+		return
+	}
+	data, err := reference(r.Context())
+	lastScan = time.Now()
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="reference"`)
+	w.Write(data)
+}
+
+// authorized returns whether the request carries the token, compared in constant time.
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func serveError(w http.ResponseWriter, status int, txt string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Go-Pprof", "1")
+	w.Header().Del("Content-Disposition")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, txt)
+}
+
+// reference starts the child to scan the process, and reads the profile from the pipe.
+func reference(ctx context.Context) ([]byte, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+	cmd := exec.CommandContext(ctx, exe)
+	cmd.Env = append(os.Environ(), childEnv+"="+strconv.Itoa(os.Getpid()))
+	// the profile is sent by fd 3, the stdout may be written by the package initializations
+	cmd.ExtraFiles = []*os.File{pw}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// never kill the child while it's attached, or the process may be left stopped
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	ready, err := cmd.StdinPipe()
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		return nil, err
+	}
+	// the child attaches after the process allows it to trace, e.g. restricted by the Yama ptrace scope
+	allowTracer(cmd.Process.Pid)
+	ready.Write([]byte{1})
+	ready.Close()
+
+	data, readErr := io.ReadAll(pr)
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("scanning failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return data, nil
+}
+
+// runChild attaches to the parent, scans it and writes the profile to fd 3.
+func runChild() int {
+	var b [1]byte
+	if _, err := os.Stdin.Read(b[:]); err != nil {
+		fmt.Fprintf(os.Stderr, "the parent is not ready: %v\n", err)
+		return 1
+	}
+	out := os.NewFile(3, "profile")
+	dbg, err := debugger.New(&debugger.Config{AttachPid: os.Getppid(), Backend: "default"}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	// the parent is stopped, so it can't read the pipe until detached
+	var buf bytes.Buffer
+	_, scanErr := myproc.Scan(ctx, dbg.Target(), myproc.ScanOptions{Writer: &buf})
+	if err = dbg.Detach(false); err != nil {
+		fmt.Fprintf(os.Stderr, "detach failed: %v\n", err)
+		return 1
+	}
+	if _, err = out.Write(buf.Bytes()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if scanErr != nil && buf.Len() == 0 {
+		fmt.Fprintln(os.Stderr, scanErr.Error())
+		return 1
+	}
+	return 0
+}
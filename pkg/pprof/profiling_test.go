@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pprof
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerLimits(t *testing.T) {
+	// the process was just scanned, so no request reaches the scanning
+	lastScan = time.Now()
+	defer func() { lastScan = time.Time{} }()
+	h := NewHandler(WithToken("secret"), WithCooldown(time.Hour))
+
+	for _, tc := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusTooManyRequests},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/debug/pprof/reference", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("Authorization %q: got status %d, want %d", tc.auth, w.Code, tc.status)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/reference", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	scanning.Lock()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	scanning.Unlock()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "" {
+		t.Errorf("concurrent scanning: got status %d, want %d without Retry-After", w.Code, http.StatusTooManyRequests)
+	}
+}
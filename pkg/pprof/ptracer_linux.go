@@ -0,0 +1,27 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pprof
+
+import "syscall"
+
+// PR_SET_PTRACER of prctl
+const prSetPtracer = 0x59616d61
+
+// allowTracer allows the process pid to trace the current process, which is required if the Yama
+// ptrace scope is 1, since a child can not trace its parent by default. The error is ignored if
+// Yama is not enabled.
+func allowTracer(pid int) {
+	_, _, _ = syscall.RawSyscall(syscall.SYS_PRCTL, prSetPtracer, uintptr(pid), 0)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+const (
+	// userArenaRootName is the pseudo root of the user arena chunks (GOEXPERIMENT=arenas).
+	userArenaRootName = "arena"
+	// userArenaTypeName is the type of the user arena chunks in the type histogram.
+	userArenaTypeName = "<user arena chunk>"
+)
+
+// findUserArenaRoots attributes the in-use user arena chunks to the arena root as a whole, and scans
+// them for the references by their pointer masks, which are written by the runtime for the objects
+// allocated in the chunks. The chunks are not attributed to the references to the objects in them,
+// since the objects have no headers to tell their bounds, and all of them are freed together.
+func (s *ObjRefScope) findUserArenaRoots() {
+	if len(s.userArenas) == 0 {
+		return
+	}
+	idx := (*pprofIndex)(nil).pushHead(s.pb, userArenaRootName)
+	for _, sp := range s.userArenas {
+		s.reached.objects++
+		s.reached.space += sp.elemSize
+		s.pb.addObjects(userArenaTypeName, sp.elemSize, 1)
+		s.record(idx, sp.elemSize, 1)
+		// the pointer mask of the chunk is in its dummy large type with alloc headers
+		s.pinMasks(sp)
+		s.copyGCMask(sp, sp.base)
+		it := s.gcBits(sp, sp.base, sp.elemEnd(sp.base))
+		s.unpinMasks(sp)
+		if it.nextPtr(false) != 0 {
+			s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it, false, 0})
+		}
+	}
+}
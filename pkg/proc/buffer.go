@@ -0,0 +1,61 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// bufferLiveBytes returns the bytes in use of the buffers which keep their grown backing
+// array `buf` for reuse, e.g. a bytes.Buffer after Reset still holds its whole backing array.
+func bufferLiveBytes(x *ReferenceVariable, typ *godwarf.StructType) (live int64, ok bool) {
+	field := func(name string, off int64) int64 {
+		for _, f := range typ.Field {
+			if f.Name == name {
+				v, _ := x.readUint64(x.Addr.Add(f.ByteOffset + off))
+				return int64(v)
+			}
+		}
+		return 0
+	}
+	switch typ.StructName {
+	case "bytes.Buffer":
+		// buf[off:len(buf)] is unread
+		return field("buf", 8) - field("off", 0), true
+	case "bufio.Reader":
+		// buf[r:w] is unread
+		return field("w", 0) - field("r", 0), true
+	case "bufio.Writer":
+		// buf[:n] is unflushed
+		return field("n", 0), true
+	}
+	return 0, false
+}
+
+// recordBufferWaste records the unused bytes of the buffer's backing array, which is
+// referenced by the `buf` field y and newly attributed to it. The bytes beyond the length
+// of the slice y are recorded already, so only the rest is recorded here.
+func (s *ObjRefScope) recordBufferWaste(x *ReferenceVariable, typ *godwarf.StructType, y *ReferenceVariable, idx *pprofIndex) {
+	if y.size == 0 {
+		return
+	}
+	live, ok := bufferLiveBytes(x, typ)
+	if !ok || live < 0 || live > y.size {
+		return
+	}
+	if waste := y.size - live - y.waste; waste > 0 {
+		s.recordValues(idx.pushHead(s.pb, y.Name), &sampleValues{SampleWaste: waste})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// callgrindFunc is a path element in the callgrind format.
+// The self cost is the values recorded at the element itself,
+// and the inclusive cost of a call is the values referenced through the element.
+type callgrindFunc struct {
+	self  sampleValues
+	calls map[uint64]*sampleValues
+}
+
+// callgrindEncoder writes the reference tree in the callgrind format.
+// Path elements with the same name are merged into one function, like pprof does.
+type callgrindEncoder struct{}
+
+func (callgrindEncoder) encode(out io.Writer, b *profileBuilder) error {
+	funcs := make(map[uint64]*callgrindFunc)
+	getFunc := func(idx uint64) *callgrindFunc {
+		fn := funcs[idx]
+		if fn == nil {
+			fn = &callgrindFunc{calls: make(map[uint64]*sampleValues)}
+			funcs[idx] = fn
+		}
+		return fn
+	}
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		if node.isZero() {
+			return
+		}
+		// indexes are from leaf to root
+		getFunc(indexes[0]).self.add(&node.sampleValues)
+		for i := 1; i < len(indexes); i++ {
+			caller := getFunc(indexes[i])
+			incl := caller.calls[indexes[i-1]]
+			if incl == nil {
+				incl = new(sampleValues)
+				caller.calls[indexes[i-1]] = incl
+			}
+			incl.add(&node.sampleValues)
+		}
+	})
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "# callgrind format\nversion: 1\ncreator: goref\nevents:")
+	for _, t := range b.sampleTypes {
+		fmt.Fprintf(w, " %s", sampleTypeInfos[t].typ)
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	named := make(map[uint64]bool)
+	name := func(idx uint64) string {
+		if named[idx] {
+			return fmt.Sprintf("(%d)", idx)
+		}
+		named[idx] = true
+		return fmt.Sprintf("(%d) %s", idx, b.strings[idx])
+	}
+	values := make([]int64, len(b.sampleTypes))
+	cost := func(v *sampleValues) {
+		values, _ = b.selectValues(values, v)
+		fmt.Fprint(w, "0")
+		for _, v := range values {
+			fmt.Fprintf(w, " %d", v)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, idx := range sortedKeys(funcs) {
+		fn := funcs[idx]
+		fmt.Fprintf(w, "fn=%s\n", name(idx))
+		cost(&fn.self)
+		for _, callee := range sortedKeys(fn.calls) {
+			fmt.Fprintf(w, "cfn=%s\ncalls=1 0\n", name(callee))
+			cost(fn.calls[callee])
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func sortedKeys[V any](m map[uint64]V) []uint64 {
+	keys := make([]uint64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlushCallgrind(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatCallgrind, GroupByPath, nil)
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# callgrind format
+version: 1
+creator: goref
+events: inuse_objects inuse_space
+
+fn=(5) main.root
+0 1 16
+cfn=(6) next. (*main.T)
+calls=1 0
+0 2 64
+
+fn=(6)
+0 2 64
+
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected callgrind output:\n%s\nwant:\n%s", got, want)
+	}
+}
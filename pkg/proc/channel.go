@@ -0,0 +1,104 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// ChannelStat is the buffer utilization of a buffered channel.
+type ChannelStat struct {
+	// Path is the reference path from the root to the channel, root first.
+	Path []string
+	// Type is the type of the channel, like "chan *main.T".
+	Type string
+	// Len is the number of the queued elements, and Cap is the capacity of the buffer.
+	Len, Cap int64
+	// BufferSize is the bytes of the buffer, i.e. Cap times the element size.
+	BufferSize int64
+}
+
+// WithChannelStats collects the buffer utilization of the buffered channels reached by the scanning to stats.
+func WithChannelStats(stats *[]ChannelStat) Option {
+	return func(o *options) {
+		o.channelStats = stats
+	}
+}
+
+// channelRef is a buffered channel found by the scanning, the path is resolved after scanning.
+type channelRef struct {
+	idx                  *pprofIndex
+	typ                  string
+	qcount, cap, bufSize int64
+}
+
+// recordChannel records the buffer utilization of the channel x pointing to the hchan at addr.
+func (s *ObjRefScope) recordChannel(x *ReferenceVariable, typ *godwarf.ChanType, addr Address, idx *pprofIndex) {
+	hchan, ok := resolveTypedef(typ.Type.(*godwarf.PtrType).Type).(*godwarf.StructType)
+	if !ok || addr == 0 {
+		return
+	}
+	mem := proc.DereferenceMemory(x.mem)
+	var qcount, dataqsiz uint64
+	for _, field := range hchan.Field {
+		switch field.Name {
+		case "qcount":
+			qcount, _ = readUintRaw(mem, uint64(addr.Add(field.ByteOffset)), 8)
+		case "dataqsiz":
+			dataqsiz, _ = readUintRaw(mem, uint64(addr.Add(field.ByteOffset)), 8)
+		}
+	}
+	if dataqsiz == 0 || qcount > dataqsiz {
+		return
+	}
+	s.addChannel(addr, channelRef{
+		idx: idx, typ: typeName(x.RealType),
+		qcount: int64(qcount), cap: int64(dataqsiz), bufSize: int64(dataqsiz) * typ.ElemType.Size(),
+	})
+}
+
+// addChannel adds the channel found, the shallowest path is kept if it's found through several paths.
+func (s *ObjRefScope) addChannel(addr Address, ch channelRef) {
+	if prev, ok := s.channels[addr]; ok && depthOf(prev.idx) <= depthOf(ch.idx) {
+		return
+	}
+	s.channels[addr] = ch
+}
+
+func depthOf(idx *pprofIndex) int {
+	if idx == nil {
+		return -1
+	}
+	return int(idx.depth)
+}
+
+// channelStats returns the buffer utilization of the channels, sorted by their buffer sizes.
+func (s *ObjRefScope) channelStats() []ChannelStat {
+	stats := make([]ChannelStat, 0, len(s.channels))
+	for _, ch := range s.channels {
+		stats = append(stats, ChannelStat{Path: s.pb.pathNames(ch.idx), Type: ch.typ, Len: ch.qcount, Cap: ch.cap, BufferSize: ch.bufSize})
+	}
+	slices.SortFunc(stats, func(a, b ChannelStat) int {
+		if c := cmp.Compare(b.BufferSize, a.BufferSize); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Path, b.Path)
+	})
+	return stats
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// maxGoVersion is the newest go1 minor version of the targets which goref is tested with,
+// the newer versions are scanned with a warning unless they are known incompatible.
+const maxGoVersion = 24
+
+// goExperiment is a GOEXPERIMENT changing the runtime layouts which the scanning relies on.
+type goExperiment struct {
+	name string
+	// the go1 minor version enabling it by default, 0 if it's never enabled by default
+	defaultSince int
+	// why the targets with it enabled are not supported, with the hint to rebuild, empty if supported
+	unsupported string
+	// the caveat of the scanning with it enabled, if supported
+	caveat string
+}
+
+var goExperiments = []goExperiment{
+	{
+		name: "swissmap", defaultSince: 24,
+		unsupported: "the swiss map layout is not yet supported, rebuild the target with GOEXPERIMENT=noswissmap on go1.24 or go1.25, or with go1.23 or older",
+	},
+	{
+		name: "greenteagc", defaultSince: 26,
+		unsupported: "the green tea GC span layout is not yet supported, rebuild the target with GOEXPERIMENT=nogreenteagc",
+	},
+	{
+		name:   "arenas",
+		caveat: "the user arena chunks are attributed to the arena root as a whole",
+	},
+}
+
+// supportedArchs are the GOARCHes of the targets supported, which are little-endian with 8-byte pointers and loaded by delve.
+const supportedArchs = "amd64, arm64 and ppc64le"
+
+// bigEndianTarget returns the machine of the executable at path if it's big-endian, like EM_S390.
+// Unreadable executables are left to the other checks.
+func bigEndianTarget(path string) (machine string, ok bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	if f.ByteOrder != binary.BigEndian {
+		return "", false
+	}
+	return f.Machine.String(), true
+}
+
+// targetExperiments returns the GOEXPERIMENTs recorded in the build info of the executable,
+// like "noswissmap" or "arenas", which are the differences from the defaults of the go version.
+func targetExperiments(bi *proc.BinaryInfo) ([]string, error) {
+	info, err := buildinfo.ReadFile(bi.Images[0].Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range info.Settings {
+		if s.Key == "GOEXPERIMENT" && s.Value != "" {
+			return strings.Split(s.Value, ","), nil
+		}
+	}
+	return nil, nil
+}
+
+// checkCompat checks the go version, the GOEXPERIMENTs and the architecture of the target against
+// the supported ones before the scanning, so an incompatible target fails with an actionable error
+// rather than a panic or a cryptic error in the middle of reading the heap. The caveats are logged.
+func checkCompat(bi *proc.BinaryInfo, logger Logger) error {
+	var errs []error
+	if bi.Arch.PtrSize() != 8 {
+		errs = append(errs, fmt.Errorf("%s is not supported, goref only supports %s, not the 32-bit GOARCHes like 386 and arm", bi.Arch.Name, supportedArchs))
+	}
+	if machine, ok := bigEndianTarget(bi.Images[0].Path); ok {
+		errs = append(errs, fmt.Errorf("the big-endian %s is not supported, goref only supports %s, not the big-endian GOARCHes like s390x and ppc64", machine, supportedArchs))
+	}
+	l, err := newRuntimeLayout(bi.Producer(), bi.Arch.PtrSize())
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	version := l.version.String()
+	if l.version.IsDevel() {
+		version = "the devel go version"
+	}
+	experiments, err := targetExperiments(bi)
+	if err != nil {
+		logger.Warnf("read the GOEXPERIMENTs of the target error, assume the defaults of %s: %v", version, err)
+	}
+	for _, e := range goExperiments {
+		enabled := slices.Contains(experiments, e.name) ||
+			e.defaultSince > 0 && l.since(e.defaultSince) && !slices.Contains(experiments, "no"+e.name)
+		switch {
+		case !enabled:
+		case e.unsupported != "":
+			errs = append(errs, fmt.Errorf("%s with GOEXPERIMENT=%s is not supported: %s", version, e.name, e.unsupported))
+		case e.caveat != "":
+			logger.Printf("the target is built with GOEXPERIMENT=%s, %s\n", e.name, e.caveat)
+		}
+	}
+	if len(errs) == 0 && (l.version.IsDevel() || l.since(maxGoVersion+1)) {
+		logger.Printf("%s is newer than go1.%d which goref is tested with, the results may be inaccurate\n", version, maxGoVersion)
+	}
+	return errors.Join(errs...)
+}
+
+// checkGCState logs the GC phase of the target if the GC is in progress, which the mark check can't compare with.
+func checkGCState(scope *proc.EvalScope, logger Logger) {
+	tmp, err := scope.EvalExpression("runtime.gcphase", loadSingleValue)
+	if err != nil {
+		logger.Warnf("read the GC phase error: %v", err)
+		return
+	}
+	// _GCoff is 0, otherwise marking or mark termination
+	if phase := toRegion(tmp, scope.BinInfo).Uint(); phase != 0 {
+		logger.Warnf("the GC of the target is in progress (phase %d), the objects allocated during the cycle are reached as usual", phase)
+	}
+}
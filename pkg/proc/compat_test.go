@@ -0,0 +1,90 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// writeELFHeader writes an executable of the ELF header only.
+func writeELFHeader(t *testing.T, order binary.ByteOrder, machine elf.Machine) string {
+	data := byte(elf.ELFDATA2LSB)
+	if order == binary.BigEndian {
+		data = byte(elf.ELFDATA2MSB)
+	}
+	hdr := elf.Header64{
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = data
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), machine.String())
+	if err := os.WriteFile(path, buf.Bytes(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBigEndianTarget(t *testing.T) {
+	if machine, ok := bigEndianTarget(writeELFHeader(t, binary.BigEndian, elf.EM_S390)); !ok || machine != "EM_S390" {
+		t.Errorf("got %q, %v of s390x, want EM_S390 rejected", machine, ok)
+	}
+	if machine, ok := bigEndianTarget(writeELFHeader(t, binary.LittleEndian, elf.EM_X86_64)); ok {
+		t.Errorf("amd64 is rejected as big-endian %s", machine)
+	}
+	if _, ok := bigEndianTarget(filepath.Join(t.TempDir(), "missing")); ok {
+		t.Errorf("a missing executable is rejected as big-endian")
+	}
+}
+
+func TestCheckCompat32Bit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	if minor := testGoMinorVersion(t); minor > maxTestGoMinor {
+		t.Skipf("go1.%d is not supported yet", minor)
+	}
+	exe := filepath.Join(t.TempDir(), "mockleak")
+	cmd := testGoCommand("build", "-o", exe, "./testdata/mockleak")
+	cmd.Dir = testModuleRoot(t)
+	cmd.Env = append(cmd.Env, "GOARCH=386")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build test program mockleak failed: %v\n%s", err, out)
+	}
+	bi := proc.NewBinaryInfo("linux", "386")
+	if err := bi.LoadBinaryInfo(exe, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := checkCompat(bi, testLogger{t})
+	if err == nil || !strings.Contains(err.Error(), "386 is not supported") || !strings.Contains(err.Error(), supportedArchs) {
+		t.Errorf("got %v, want 386 rejected with the supported GOARCHes", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Container is the main process of a container found by FindContainer.
+type Container struct {
+	// ID is the full ID of the container.
+	ID string
+	// Pid is the main process of the container in the PID namespace of goref, e.g. the host.
+	Pid int
+	// Executable is the executable of the process in the root file system of the container, i.e.
+	// under /proc/<pid>/root, and DebugInfoDir is the /usr/lib/debug in it, so the DWARF and the
+	// separate debug info are read from the mount namespace of the container.
+	Executable, DebugInfoDir string
+}
+
+// dockerTimeout is the max duration of a request to the docker API.
+const dockerTimeout = 5 * time.Second
+
+// containerIDPrefixRegex matches the IDs and the ID prefixes of the containers, not their names.
+var containerIDPrefixRegex = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+
+// FindContainer finds the main process of the container by its ID, its ID prefix or its name. It asks the
+// docker API at $DOCKER_HOST or /var/run/docker.sock first, and then looks for the processes in the cgroups
+// of the container, which covers the other runtimes like containerd and CRI-O by the IDs. The main process
+// is the earliest started one, i.e. the init of the container rather than the processes of docker exec.
+// The process is attached to by its PID in the host namespace by ptrace, and its root file system is
+// reached by /proc/<pid>/root rather than entering its mount namespace, which is only allowed for a
+// single-threaded process. It's only supported on linux.
+func FindContainer(id string) (*Container, error) {
+	c, dockerErr := findDockerContainer(dockerSocket(), id)
+	if dockerErr != nil {
+		var cgroupErr error
+		if c, cgroupErr = findCgroupContainer("/proc", id); cgroupErr != nil {
+			return nil, fmt.Errorf("container %s not found by the docker API: %v, nor by the cgroups: %w", id, dockerErr, cgroupErr)
+		}
+	}
+	root := filepath.Join("/proc", strconv.Itoa(c.Pid), "root")
+	c.Executable = filepath.Join("/proc", strconv.Itoa(c.Pid), "exe")
+	// the path in the mount namespace of the container, suffixed by " (deleted)" if replaced
+	if path, err := os.Readlink(c.Executable); err == nil && !strings.HasSuffix(path, " (deleted)") {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			c.Executable = filepath.Join(root, path)
+		}
+	}
+	c.DebugInfoDir = filepath.Join(root, "usr", "lib", "debug")
+	return c, nil
+}
+
+// dockerSocket returns the unix socket of the docker API, empty if $DOCKER_HOST is not a unix socket.
+func dockerSocket() string {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		return "/var/run/docker.sock"
+	}
+	if path, ok := strings.CutPrefix(host, "unix://"); ok {
+		return path
+	}
+	return ""
+}
+
+// dockerContainer is the part of the container inspected by the docker API.
+type dockerContainer struct {
+	ID    string `json:"Id"`
+	State struct {
+		Running bool
+		Pid     int
+	}
+}
+
+// findDockerContainer inspects the container by the docker API at the unix socket.
+func findDockerContainer(socket, id string) (*Container, error) {
+	if socket == "" {
+		return nil, errors.New("DOCKER_HOST is not a unix socket")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		Timeout: dockerTimeout,
+	}
+	resp, err := client.Get("http://docker/containers/" + url.PathEscape(id) + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg struct{ Message string }
+		if json.NewDecoder(resp.Body).Decode(&msg) != nil || msg.Message == "" {
+			msg.Message = resp.Status
+		}
+		return nil, errors.New(msg.Message)
+	}
+	var dc dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&dc); err != nil {
+		return nil, err
+	}
+	if !dc.State.Running || dc.State.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", dc.ID)
+	}
+	return &Container{ID: dc.ID, Pid: dc.State.Pid}, nil
+}
+
+// findCgroupContainer finds the earliest started process of procDir in the cgroups of the container
+// whose ID starts with id, which must be unique.
+func findCgroupContainer(procDir, id string) (*Container, error) {
+	if !containerIDPrefixRegex.MatchString(id) {
+		return nil, errors.New("the containers are found by their IDs only without the docker API")
+	}
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	var c *Container
+	var start uint64
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cgroup, err := os.ReadFile(filepath.Join(procDir, e.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		full := parseContainerID(cgroup)
+		if !strings.HasPrefix(full, id) {
+			continue
+		}
+		if c != nil && c.ID != full {
+			return nil, fmt.Errorf("ambiguous container ID %s, matching %s and %s", id, c.ID, full)
+		}
+		stat, err := os.ReadFile(filepath.Join(procDir, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		st, ok := parseStartTime(stat)
+		if !ok {
+			continue
+		}
+		if c == nil || st < start || st == start && pid < c.Pid {
+			c, start = &Container{ID: full, Pid: pid}, st
+		}
+	}
+	if c == nil {
+		return nil, errors.New("no process is in the container")
+	}
+	return c, nil
+}
+
+// parseStartTime returns the start time of the process in /proc/<pid>/stat, the 22nd field,
+// which is counted after the command name in parentheses since the name may contain spaces.
+func parseStartTime(stat []byte) (uint64, bool) {
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, false
+	}
+	// the fields from the 3rd, i.e. the state
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, false
+	}
+	st, err := strconv.ParseUint(fields[19], 10, 64)
+	return st, err == nil
+}
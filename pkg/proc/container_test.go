@@ -0,0 +1,104 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFindCgroupContainer(t *testing.T) {
+	a, b := strings.Repeat("a", 64), strings.Repeat("ab", 32)
+	dir := t.TempDir()
+	for _, p := range []struct {
+		pid       int
+		cgroup    string
+		startTime int
+	}{
+		{1, "0::/init.scope\n", 1},
+		{100, "0::/system.slice/docker-" + a + ".scope\n", 500},
+		{200, "0::/system.slice/docker-" + a + ".scope\n", 300},
+		{300, "0::/system.slice/docker-" + b + ".scope\n", 400},
+	} {
+		pdir := filepath.Join(dir, strconv.Itoa(p.pid))
+		if err := os.Mkdir(pdir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		stat := strconv.Itoa(p.pid) + " (my (server)) S 1" + strings.Repeat(" 0", 17) + " " + strconv.Itoa(p.startTime) + " 0 0\n"
+		if err := os.WriteFile(filepath.Join(pdir, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pdir, "cgroup"), []byte(p.cgroup), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := findCgroupContainer(dir, "aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != a || c.Pid != 200 {
+		t.Errorf("found container %s pid %d, want %s pid 200", c.ID, c.Pid, a)
+	}
+	if _, err := findCgroupContainer(dir, "a"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("found an ambiguous container, err %v", err)
+	}
+	if _, err := findCgroupContainer(dir, "ffff"); err == nil {
+		t.Error("found a container not running")
+	}
+	if _, err := findCgroupContainer(dir, "my-server"); err == nil {
+		t.Error("found a container by name without the docker API")
+	}
+}
+
+func TestFindDockerContainer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+	id := strings.Repeat("c", 64)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/my-server/json":
+			_, _ = w.Write([]byte(`{"Id":"` + id + `","State":{"Running":true,"Pid":1234}}`))
+		case "/containers/stopped/json":
+			_, _ = w.Write([]byte(`{"Id":"` + id + `","State":{"Running":false,"Pid":0}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such container"}`))
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c, err := findDockerContainer(socket, "my-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != id || c.Pid != 1234 {
+		t.Errorf("found container %s pid %d, want %s pid 1234", c.ID, c.Pid, id)
+	}
+	if _, err := findDockerContainer(socket, "stopped"); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("found a stopped container, err %v", err)
+	}
+	if _, err := findDockerContainer(socket, "missing"); err == nil || err.Error() != "No such container" {
+		t.Errorf("found a missing container, err %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	// ntGNUBuildID is the type of the GNU build ID note named "GNU", and ntGoBuildID is the type of the Go
+	// build ID note named "Go".
+	ntGNUBuildID = 3
+	ntGoBuildID  = 4
+)
+
+// gnuBuildIDRegex matches the GNU build IDs in hex, which name the files in the build-id directories.
+var gnuBuildIDRegex = regexp.MustCompile(`^[0-9a-f]{4,}$`)
+
+// ReadCoreBuildID reads the build ID of the executable of the process dumped in the core file like ReadBuildID,
+// i.e. from the notes of the ELF header in the first page of the text, which is dumped by default. It returns
+// empty if not dumped, or the executable is position independent.
+func ReadCoreBuildID(core string) (string, error) {
+	f, err := elf.Open(core)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		return "", errors.New("not a core file")
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if id := dumpedBuildID(f, prog.Vaddr); id != "" {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// dumpedBuildID returns the build ID of the executable whose ELF header is dumped at addr, empty if not an executable.
+func dumpedBuildID(f *elf.File, addr uint64) string {
+	// the offsets of e_type, e_phoff, e_phentsize and e_phnum, and the size of e_phoff
+	typOff, phOff, phentOff, phnumOff, word := 16, 32, 54, 56, 8
+	if f.Class == elf.ELFCLASS32 {
+		phOff, phentOff, phnumOff, word = 28, 42, 44, 4
+	}
+	hdr, ok := readCoreMemory(f, addr, phnumOff+2)
+	if !ok || !bytes.HasPrefix(hdr, []byte(elf.ELFMAG)) || elf.Type(f.ByteOrder.Uint16(hdr[typOff:])) != elf.ET_EXEC {
+		return ""
+	}
+	readWord := func(b []byte) uint64 {
+		if word == 4 {
+			return uint64(f.ByteOrder.Uint32(b))
+		}
+		return f.ByteOrder.Uint64(b)
+	}
+	phoff := readWord(hdr[phOff:])
+	phentsize, phnum := int(f.ByteOrder.Uint16(hdr[phentOff:])), int(f.ByteOrder.Uint16(hdr[phnumOff:]))
+	phdrs, ok := readCoreMemory(f, addr+phoff, phentsize*phnum)
+	if !ok {
+		return ""
+	}
+	// the offsets of p_vaddr and p_filesz in a program header
+	vaddrOff, fileszOff := 16, 32
+	if word == 4 {
+		vaddrOff, fileszOff = 8, 16
+	}
+	var gnuID, goID []byte
+	for i := 0; i < phnum; i++ {
+		ph := phdrs[i*phentsize:]
+		if elf.ProgType(f.ByteOrder.Uint32(ph)) != elf.PT_NOTE {
+			continue
+		}
+		notes, ok := readCoreMemory(f, readWord(ph[vaddrOff:]), int(readWord(ph[fileszOff:])))
+		if !ok {
+			continue
+		}
+		forEachNote(notes, f.ByteOrder, func(name string, typ uint32, desc []byte) {
+			switch {
+			case name == "GNU" && typ == ntGNUBuildID:
+				gnuID = desc
+			case name == "Go" && typ == ntGoBuildID:
+				goID = desc
+			}
+		})
+	}
+	if gnuID != nil {
+		return hex.EncodeToString(gnuID)
+	}
+	return string(goID)
+}
+
+// CheckCoreExecutable checks the executable against the core file by their build IDs before scanning, since the
+// DWARF of another executable gives garbage. It returns the executable to scan the core file with, which is the
+// executable itself if it matches, or the one with the build ID of the core file found in the build-id
+// directories like /usr/lib/debug/.build-id/ab/cdef..., or next to the executable, e.g. the other releases.
+// It fails if not found. The executable is not checked if either build ID is unknown.
+func CheckCoreExecutable(core, exe string, debugDirs []string) (string, error) {
+	coreID, err := ReadCoreBuildID(core)
+	if err != nil || coreID == "" {
+		return exe, nil
+	}
+	exeID, err := ReadBuildID(exe)
+	if err != nil || exeID == "" || exeID == coreID {
+		return exe, nil
+	}
+	if found := findExecutableByBuildID(coreID, debugDirs, filepath.Dir(exe)); found != "" {
+		return found, nil
+	}
+	return "", fmt.Errorf("the executable %s (build ID %s) does not match the core file %s dumped from build ID %s, "+
+		"give the executable the process ran, or put it in a build-id directory", exe, exeID, core, coreID)
+}
+
+// findExecutableByBuildID returns the executable with the build ID in the build-id directories if it's a GNU build ID,
+// or in the directory, empty if not found.
+func findExecutableByBuildID(id string, debugDirs []string, dir string) string {
+	var candidates []string
+	if gnuBuildIDRegex.MatchString(id) {
+		for _, d := range debugDirs {
+			candidates = append(candidates, filepath.Join(d, id[:2], id[2:]), filepath.Join(d, ".build-id", id[:2], id[2:]))
+		}
+	}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() {
+				candidates = append(candidates, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	for _, path := range candidates {
+		if got, err := ReadBuildID(path); err == nil && got == id {
+			return path
+		}
+	}
+	return ""
+}
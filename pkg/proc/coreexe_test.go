@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindExecutableByBuildID(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	id, err := ReadBuildID(exe)
+	if err != nil || id == "" {
+		t.Skip("no build ID of the test binary")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("not an executable"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := findExecutableByBuildID(id, nil, dir); got != "" {
+		t.Errorf("found %q without the executable", got)
+	}
+	want := filepath.Join(dir, "app")
+	if err := os.Symlink(exe, want); err != nil {
+		t.Skip(err)
+	}
+	if got := findExecutableByBuildID(id, nil, dir); got != want {
+		t.Errorf("findExecutableByBuildID() = %q, want %q", got, want)
+	}
+}
+
+func TestCheckCoreExecutableNotCore(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	// the build ID of the core file is unknown, so the executable is not checked
+	if got, err := CheckCoreExecutable(exe, exe, nil); err != nil || got != exe {
+		t.Errorf("CheckCoreExecutable() = %q, %v, want %q", got, err, exe)
+	}
+}
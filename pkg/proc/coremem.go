@@ -0,0 +1,103 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/elf"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// WithCoreFile tells the target is opened from the core file, whose memory is then read by mapping
+// the file rather than through the debugger, which takes a syscall and a copy per read.
+func WithCoreFile(path string) Option {
+	return func(o *options) {
+		o.coreFile = path
+	}
+}
+
+// coreMemory reads the memory dumped in the core file by mapping it, and the rest, like the read-only
+// sections of the executable, through the debugger.
+type coreMemory struct {
+	mem  proc.MemoryReadWriter
+	data []byte
+	// the dumped segments sorted by their addresses
+	segs []coreSegment
+}
+
+// coreSegment is the part of a PT_LOAD segment of the core file dumped in the file, which is
+// read by the debugger instead of the executable, see the core package of delve.
+type coreSegment struct {
+	addr, end uint64
+	off       uint64
+}
+
+// openCoreMemory maps the core file, whose mapping is released by close.
+func openCoreMemory(path string, mem proc.MemoryReadWriter) (*coreMemory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if ef.Type != elf.ET_CORE {
+		return nil, errors.New("not an ELF core file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := &coreMemory{mem: mem}
+	for _, prog := range ef.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+		if prog.Off+prog.Filesz > uint64(fi.Size()) {
+			return nil, errors.New("the core file is truncated")
+		}
+		m.segs = append(m.segs, coreSegment{addr: prog.Vaddr, end: prog.Vaddr + prog.Filesz, off: prog.Off})
+	}
+	sort.Slice(m.segs, func(i, j int) bool { return m.segs[i].addr < m.segs[j].addr })
+	if m.data, err = mmapFile(f, fi.Size()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *coreMemory) ReadMemory(data []byte, addr uint64) (int, error) {
+	i := sort.Search(len(m.segs), func(i int) bool { return m.segs[i].end > addr })
+	if i < len(m.segs) {
+		seg := m.segs[i]
+		if end := addr + uint64(len(data)); addr >= seg.addr && end >= addr && end <= seg.end {
+			return copy(data, m.data[seg.off+addr-seg.addr:]), nil
+		}
+	}
+	// not dumped, or across the segments
+	return m.mem.ReadMemory(data, addr)
+}
+
+func (m *coreMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return m.mem.WriteMemory(addr, data)
+}
+
+func (m *coreMemory) close() error {
+	return munmapFile(m.data)
+}
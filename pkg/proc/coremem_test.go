@@ -0,0 +1,81 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestCore writes an ELF core file of the PT_LOAD segments at the addresses with the contents.
+func writeTestCore(t *testing.T, segs map[uint64][]byte) string {
+	const ehsize, phentsize = 64, 56
+	var hdr, progs, data bytes.Buffer
+	off := uint64(ehsize + phentsize*len(segs))
+	for addr, content := range segs {
+		binary.Write(&progs, binary.LittleEndian, elf.Prog64{
+			Type: uint32(elf.PT_LOAD), Flags: uint32(elf.PF_R | elf.PF_W), Off: off, Vaddr: addr,
+			Filesz: uint64(len(content)), Memsz: uint64(len(content)) + 4096, Align: 1,
+		})
+		data.Write(content)
+		off += uint64(len(content))
+	}
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+	binary.Write(&hdr, binary.LittleEndian, elf.Header64{
+		Ident: ident, Type: uint16(elf.ET_CORE), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Phoff: ehsize, Ehsize: ehsize, Phentsize: phentsize, Phnum: uint16(len(segs)),
+	})
+	path := filepath.Join(t.TempDir(), "core")
+	if err := os.WriteFile(path, append(append(hdr.Bytes(), progs.Bytes()...), data.Bytes()...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCoreMemory(t *testing.T) {
+	heap := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 512)
+	stack := bytes.Repeat([]byte{9}, 1024)
+	core := writeTestCore(t, map[uint64][]byte{0xc000000000: heap, 0x7ff000: stack})
+	// the executable, which is read for the memory not dumped
+	exe := &fakeMemory{base: 0x400000, data: bytes.Repeat([]byte{0xee}, 4096)}
+	m, err := openCoreMemory(core, exe)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer m.close()
+	for _, c := range []struct {
+		addr uint64
+		size int
+		want []byte
+	}{
+		{0xc000000000, 8, heap[:8]},
+		{0xc000000ff8, 8, heap[4088:]},
+		{0x7ff010, 16, stack[16:32]},
+		{0x400100, 8, exe.data[:8]},
+	} {
+		data := make([]byte, c.size)
+		if n, err := m.ReadMemory(data, c.addr); err != nil || n != c.size || !bytes.Equal(data, c.want) {
+			t.Errorf("read %d bytes at %#x: %v, %d, %v, want %v", c.size, c.addr, data, n, err, c.want)
+		}
+	}
+	// beyond the dumped part of the segment, which is read by the debugger
+	if _, err := m.ReadMemory(make([]byte, 16), 0xc000000ff8); err == nil {
+		t.Errorf("read beyond the dumped segment without an error")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// csvEncoder writes the references as a table with one row per path from the root, with the columns
+// path, depth, type, objects and bytes, followed by the other carried sample types. The path is the names
+// of the nodes joined by ";" like the folded format, and the type is the type of the value referenced by
+// the last node, empty if unknown. The values are recorded at the path itself, excluding the descendants,
+// and the objects and the bytes are 0 if not carried.
+type csvEncoder struct {
+	comma rune
+}
+
+func (e csvEncoder) encode(out io.Writer, b *profileBuilder) error {
+	header := []string{"path", "depth", "type", "objects", "bytes"}
+	// the indexes of the objects and the bytes in the carried values, and the other carried values
+	objects, space := -1, -1
+	var others []int
+	for i, t := range b.sampleTypes {
+		switch t {
+		case SampleObjects:
+			objects = i
+		case SampleSpace:
+			space = i
+		default:
+			header = append(header, sampleTypeInfos[t].typ)
+			others = append(others, i)
+		}
+	}
+	value := func(values []int64, i int) string {
+		if i < 0 {
+			return "0"
+		}
+		return strconv.FormatInt(values[i], 10)
+	}
+	var rows [][]string
+	values := make([]int64, len(b.sampleTypes))
+	names := make([]string, 0, 16)
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		var zero bool
+		values, zero = b.selectValues(values, &node.sampleValues)
+		if zero {
+			return
+		}
+		// indexes are from leaf to root
+		names = names[:0]
+		for i := len(indexes) - 1; i >= 0; i-- {
+			names = append(names, b.strings[indexes[i]])
+		}
+		_, typ := profile.SplitNodeName(b.strings[indexes[0]])
+		row := []string{strings.Join(names, ";"), strconv.Itoa(len(indexes)), typ, value(values, objects), value(values, space)}
+		for _, i := range others {
+			row = append(row, value(values, i))
+		}
+		rows = append(rows, row)
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	w := csv.NewWriter(out)
+	w.Comma = e.comma
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	return w.WriteAll(rows)
+}
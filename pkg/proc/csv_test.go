@@ -0,0 +1,47 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlushCSV(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		want   string
+	}{
+		{FormatCSV, "path,depth,type,objects,bytes,retained_space\n" +
+			"main.root,1,,1,16,80\n" +
+			`"main.root;next. (*main.T, the ""head"")",2,"*main.T, the ""head""",2,64,64` + "\n"},
+		{FormatTSV, "path\tdepth\ttype\tobjects\tbytes\tretained_space\n" +
+			"main.root\t1\t\t1\t16\t80\n" +
+			`"main.root;next. (*main.T, the ""head"")"` + "\t2\t" + `"*main.T, the ""head"""` + "\t2\t64\t64\n"},
+	} {
+		var buf bytes.Buffer
+		pb := newProfileBuilder(&buf, tt.format, GroupByPath, []SampleType{SampleObjects, SampleSpace, SampleRetained})
+		root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+		child := root.pushHead(pb, `next. (*main.T, the "head")`)
+		pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16, SampleRetained: 80})
+		pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64, SampleRetained: 64})
+		if err := pb.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("unexpected %s:\n%s\nwant:\n%s", tt.format, got, tt.want)
+		}
+	}
+}
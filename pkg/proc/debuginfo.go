@@ -0,0 +1,39 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// checkDebugInfo checks the executable of the target has the DWARF which the runtime variables and the types
+// are read from, either in itself or in a separate debug file found by delve, i.e. by the .gnu_debuglink, by
+// the build ID in the debug info directories like /usr/lib/debug/.build-id/ab/cdef....debug, or by debuginfod.
+// Delve only warns about a stripped executable, which then fails with a cryptic error of reading the heap.
+func checkDebugInfo(bi *proc.BinaryInfo) error {
+	if len(bi.Images) == 0 || !bi.Images[0].Stripped() {
+		return nil
+	}
+	image := bi.Images[0]
+	if image.BuildID == "" {
+		return fmt.Errorf("the executable %s is stripped without a build ID to find its separate debug file, "+
+			"give the executable with the debug info", image.Path)
+	}
+	return fmt.Errorf("the executable %s is stripped, and its separate debug file of build ID %s is not found "+
+		"in the debug info directories %v or by debuginfod, give the directory of the debug file or the debuginfod servers",
+		image.Path, image.BuildID, bi.DebugInfoDirectories)
+}
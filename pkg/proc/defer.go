@@ -0,0 +1,87 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "github.com/go-delve/delve/pkg/proc"
+
+const (
+	// deferName is the name of the deferred function value, e.g. a closure, under the deferring function.
+	deferName = "$defer"
+	// panicName is the name of the panic value, under the panicking function.
+	panicName = "$panic"
+)
+
+// findDeferRefs scans the deferred calls and the active panics of the goroutines, i.e. the g._defer and
+// g._panic lists. The closures of the deferred calls are attributed to the functions deferring them, and
+// the panic values to the functions panicking, rather than to runtime.allgs or anonymous sub-objects of
+// the stacks, so it's done before the global variables. The open-coded defers have no records, and their
+// closures are scanned with the stack frames.
+func (s *ObjRefScope) findDeferRefs(t *proc.Target, grs []*goroutineRoot, o *options) {
+	gs, err := s.readGs()
+	if err != nil {
+		s.logger.Warnf("read runtime.allgs err: %v", err)
+		return
+	}
+	defer s.setGoroutineLabels(nil, nil)
+	for _, gr := range grs {
+		g := gs[gr.g.ID]
+		if g == nil || !g.HasField("_defer") {
+			continue
+		}
+		var root *pprofIndex
+		if o.groupBy.byGoroutine() {
+			root = root.pushHead(s.pb, goroutineRootName(gr, o.groupBy))
+		}
+		s.setGoroutineLabels(gr, root)
+		for d := g.Field("_defer").Deref(); d.a != 0 && !s.done(); d = d.Field("link").Deref() {
+			if !d.HasField("fn") || !d.HasField("pc") {
+				break
+			}
+			fn := s.bi.PCToFunc(d.Field("pc").Uintptr())
+			if fn == nil || !o.includes(fn.Name) {
+				continue
+			}
+			f := d.Field("fn")
+			name := fn.Name + "." + deferName + ". (" + f.typ.String() + ")"
+			s.findRef(newReferenceVariable(f.a, name, f.typ, f.mem, nil), root)
+		}
+		if !g.HasField("_panic") {
+			continue
+		}
+		fn := panickingFunc(gr.frames)
+		if fn == "" || !o.includes(fn) {
+			continue
+		}
+		for p := g.Field("_panic").Deref(); p.a != 0 && !s.done(); p = p.Field("link").Deref() {
+			if !p.HasField("arg") {
+				break
+			}
+			arg := p.Field("arg")
+			name := fn + "." + panicName + ". (" + arg.typ.String() + ")"
+			s.findRef(newReferenceVariable(arg.a, name, arg.typ, arg.mem, nil), root)
+		}
+	}
+}
+
+// panickingFunc returns the innermost function of the frames out of the runtime calling runtime.gopanic,
+// like main.worker calling panic directly, or indirectly by runtime.panicIndex, empty if not found.
+func panickingFunc(frames []proc.Stackframe) string {
+	for i := range frames {
+		if fn := frames[i].Current.Fn; fn != nil && fn.Name == "runtime.gopanic" {
+			return blockedFunc(frames[i+1:])
+		}
+	}
+	return blockedFunc(frames)
+}
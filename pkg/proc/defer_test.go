@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+func TestDeferRefs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	out := runTestScenario(t, testScenario{name: "defers", minGoMinor: minTestGoMinor})
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	space := slices.IndexFunc(p.SampleTypes, func(vt profile.ValueType) bool { return vt.Type == "inuse_space" })
+	spaceUnder := func(fn, name string) (total int64) {
+		for _, s := range p.Samples {
+			if slices.ContainsFunc(s.Path, func(n string) bool { return strings.HasPrefix(n, fn+"."+name+".") }) {
+				total += s.Values[space]
+			}
+		}
+		return total
+	}
+	// the 1MB buffers captured by the 3 deferred closures
+	if got := spaceUnder("main.deferrer", deferName); got < 3<<20 {
+		t.Errorf("got %d bytes retained by the deferred closures, want at least %d", got, 3<<20)
+	}
+	// the 1MB buffer of the panic value
+	if got := spaceUnder("main.panicker", panicName); got < 1<<20 {
+		t.Errorf("got %d bytes retained by the panic value, want at least %d", got, 1<<20)
+	}
+}
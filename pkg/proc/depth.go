@@ -0,0 +1,92 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// DepthRule limits the depth of the references below the variables, fields or elements of a type,
+// or of the types of a package, e.g. the internals of the framework types, so they don't exhaust the
+// max depth needed by the application types. The objects deeper than the limit are attributed to the
+// path at the limit, like the max depth.
+type DepthRule struct {
+	// Key is a type name like "*google.golang.org/grpc.ClientConn", or a package like
+	// "google.golang.org/grpc" matching its types and the pointers, slices and arrays of them.
+	Key string
+	// Depth is the max depth of the references below the matching node, 0 means none.
+	Depth int
+}
+
+// WithDepthRules limits the depth of the references below the nodes matching the rules. A type rule
+// takes precedence over a package rule, and the limits of the nested matching nodes only tighten.
+func WithDepthRules(rules ...DepthRule) Option {
+	return func(o *options) {
+		o.depthRules = nil
+		if len(rules) == 0 {
+			return
+		}
+		o.depthRules = make(depthRules, len(rules))
+		for _, r := range rules {
+			o.depthRules[r.Key] = r.Depth
+		}
+	}
+}
+
+// depthRules are the depth limits keyed by the type names and the packages.
+type depthRules map[string]int
+
+// match returns the depth limit of the nodes of the type name, false if not limited.
+func (r depthRules) match(name string) (int, bool) {
+	if depth, ok := r[name]; ok {
+		return depth, true
+	}
+	// the package path may contain dots after the last slash, like gopkg.in/yaml.v3
+	name = elemTypeName(name)
+	for i, slash := strings.LastIndex(name, "."), strings.LastIndex(name, "/"); i > slash; i = strings.LastIndex(name[:i], ".") {
+		if depth, ok := r[name[:i]]; ok {
+			return depth, true
+		}
+	}
+	return 0, false
+}
+
+// limit limits the depth of the path below idx, whose node is of the type typ.
+func (r depthRules) limit(idx *pprofIndex, typ godwarf.Type) {
+	depth, ok := r.match(typeName(typ))
+	if !ok {
+		return
+	}
+	if max := idx.depth + int32(depth); !idx.limited || max < idx.maxDepth {
+		idx.limited, idx.maxDepth = true, max
+	}
+}
+
+// elemTypeName returns the name of the innermost element type of the pointer, slice and array type name,
+// without the type arguments, like "main.T" of "[]*main.T[int]".
+func elemTypeName(name string) string {
+	name = strings.TrimLeft(name, "*[]0123456789")
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// depthExceeded reports whether the references below idx exceed the max depth, or the limit of the depth rules.
+func (s *ObjRefScope) depthExceeded(idx *pprofIndex) bool {
+	return idx != nil && (int(idx.depth) >= s.maxDepth || idx.limited && idx.depth >= idx.maxDepth)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+func TestDepthRules(t *testing.T) {
+	rules := newOptions([]Option{WithDepthRules(
+		DepthRule{Key: "google.golang.org/grpc", Depth: 3},
+		DepthRule{Key: "*google.golang.org/grpc.ClientConn", Depth: 1},
+		DepthRule{Key: "gopkg.in/yaml.v3", Depth: 0},
+	)}).depthRules
+	for name, want := range map[string]int{
+		"*google.golang.org/grpc.ClientConn":       1,
+		"google.golang.org/grpc.ClientConn":        3,
+		"[]*google.golang.org/grpc.Server":         3,
+		"[4]google.golang.org/grpc.Server[int]":    3,
+		"gopkg.in/yaml.v3.Node":                    0,
+		"google.golang.org/grpc/internal.Resolver": -1,
+		"main.T": -1,
+	} {
+		depth, ok := rules.match(name)
+		if !ok {
+			depth = -1
+		}
+		if depth != want {
+			t.Errorf("match(%q) = %d, want %d", name, depth, want)
+		}
+	}
+
+	s := newTestObjRefScope()
+	s.maxDepth = 10
+	idx := &pprofIndex{depth: 2}
+	rules.limit(idx, &godwarf.StructType{CommonType: godwarf.CommonType{Name: "google.golang.org/grpc.Server"}})
+	if !idx.limited || idx.maxDepth != 5 {
+		t.Fatalf("limited = %v, max depth = %d, want 5", idx.limited, idx.maxDepth)
+	}
+	for i := 0; i < 3; i++ {
+		if s.depthExceeded(idx) {
+			t.Fatalf("depth %d exceeded", idx.depth)
+		}
+		idx = idx.pushHead(s.pb, "x")
+	}
+	if !s.depthExceeded(idx) {
+		t.Fatalf("depth %d not exceeded", idx.depth)
+	}
+	// the nested limit only tightens
+	rules.limit(idx, &godwarf.StructType{CommonType: godwarf.CommonType{Name: "google.golang.org/grpc.Server"}})
+	if idx.maxDepth != 5 {
+		t.Fatalf("max depth = %d, want 5", idx.maxDepth)
+	}
+}
@@ -26,7 +26,6 @@ import (
 	"github.com/go-delve/delve/pkg/dwarf/op"
 	"github.com/go-delve/delve/pkg/dwarf/reader"
 	"github.com/go-delve/delve/pkg/goversion"
-	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/pkg/proc"
 )
 
@@ -41,6 +40,8 @@ type myEvalScope struct {
 	proc.EvalScope
 
 	dictAddr uint64 // dictionary address for instantiated generic functions
+
+	logger Logger
 }
 
 func (scope *myEvalScope) Locals(t *proc.Target, g *proc.G, threadID int, mds []proc.ModuleData) ([]*ReferenceVariable, error) {
@@ -101,13 +102,13 @@ func (scope *myEvalScope) simpleLocals(mds []proc.ModuleData) ([]*ReferenceVaria
 		for _, entry := range varEntries {
 			name, _ := entry.Val(dwarf.AttrName).(string)
 			if name == goDictionaryName {
-				dictVar, err := extractVarInfoFromEntry(scope.BinInfo, image(&scope.EvalScope), scope.Regs, scope.Mem, entry.Tree, 0, mds)
+				dictVar, err := extractVarInfoFromEntry(scope.logger, scope.BinInfo, image(&scope.EvalScope), scope.Regs, scope.Mem, entry.Tree, 0, mds)
 				if err != nil {
-					logflags.DebuggerLogger().Errorf("could not load %s variable: %v", name, err)
+					scope.logger.Errorf("could not load %s variable: %v", name, err)
 				} else {
 					scope.dictAddr, err = readUintRaw(dictVar.mem, uint64(dictVar.Addr), int64(scope.BinInfo.Arch.PtrSize()))
 					if err != nil {
-						logflags.DebuggerLogger().Errorf("could not load %s variable: %v", name, err)
+						scope.logger.Errorf("could not load %s variable: %v", name, err)
 					}
 				}
 				break
@@ -131,11 +132,12 @@ func (scope *myEvalScope) simpleLocals(mds []proc.ModuleData) ([]*ReferenceVaria
 				continue
 			}
 		}
-		val, err := extractVarInfoFromEntry(scope.BinInfo, image(&scope.EvalScope), scope.Regs, scope.Mem, entry.Tree, scope.dictAddr, mds)
+		val, err := extractVarInfoFromEntry(scope.logger, scope.BinInfo, image(&scope.EvalScope), scope.Regs, scope.Mem, entry.Tree, scope.dictAddr, mds)
 		if err != nil {
 			// skip variables that we can't parse yet
 			continue
 		}
+		val.declLine, _ = entry.Val(dwarf.AttrDeclLine).(int64)
 		vars = append(vars, val)
 		depth := entry.Depth
 		if entry.Tag == dwarf.TagFormalParameter {
@@ -154,7 +156,7 @@ func (scope *myEvalScope) simpleLocals(mds []proc.ModuleData) ([]*ReferenceVaria
 
 // Extracts the name and type of a variable from a dwarf entry
 // then executes the instructions given in the  DW_AT_location attribute to grab the variable's address
-func extractVarInfoFromEntry(bi *proc.BinaryInfo, image *proc.Image, regs op.DwarfRegisters, mem proc.MemoryReadWriter, entry *godwarf.Tree, dictAddr uint64, mds []proc.ModuleData) (*ReferenceVariable, error) {
+func extractVarInfoFromEntry(logger Logger, bi *proc.BinaryInfo, image *proc.Image, regs op.DwarfRegisters, mem proc.MemoryReadWriter, entry *godwarf.Tree, dictAddr uint64, mds []proc.ModuleData) (*ReferenceVariable, error) {
 	if entry.Tag != dwarf.TagFormalParameter && entry.Tag != dwarf.TagVariable {
 		return nil, fmt.Errorf("invalid entry tag, only supports FormalParameter and Variable, got %s", entry.Tag.String())
 	}
@@ -167,7 +169,7 @@ func extractVarInfoFromEntry(bi *proc.BinaryInfo, image *proc.Image, regs op.Dwa
 	t, err = resolveParametricType(bi, mem, t, dictAddr, mds)
 	if err != nil {
 		// Log the error, keep going with t, which will be the shape type
-		logflags.DebuggerLogger().Warnf("could not resolve parametric type of %s: %v", n, err)
+		logger.Warnf("could not resolve parametric type of %s: %v", n, err)
 	}
 
 	addr, pieces, _, _ := bi.Location(entry, dwarf.AttrLocation, regs.PC(), regs, mem)
@@ -253,3 +255,40 @@ func (v *variablesByDepthAndDeclLine) Swap(i, j int) {
 	v.depths[i], v.depths[j] = v.depths[j], v.depths[i]
 	v.vars[i], v.vars[j] = v.vars[j], v.vars[i]
 }
+
+// globalScope returns a scope to evaluate the package variables, which don't depend on the frame.
+// The scope of the current thread is preferred, then the other threads are tried in case the current
+// thread has no usable frame, e.g. it is in a syscall without any Go frame. A frameless scope is the
+// last resort, which is usable if the package variables can be read through it.
+func globalScope(t *proc.Target, logger Logger) (*proc.EvalScope, error) {
+	var errs []string
+	for _, th := range threadsCurrentFirst(t.CurrentThread(), t.ThreadList()) {
+		scope, err := proc.ThreadScope(t, th)
+		if err == nil {
+			return scope, nil
+		}
+		logger.Warnf("no scope of thread %d: %v", th.ThreadID(), err)
+		errs = append(errs, fmt.Sprintf("thread %d: %v", th.ThreadID(), err))
+	}
+	scope := proc.FrameToScope(t, t.Memory(), nil, 0, proc.Stackframe{})
+	if _, err := scope.EvalExpression("runtime.mheap_", loadSingleValue); err != nil {
+		errs = append(errs, fmt.Sprintf("frameless scope: %v", err))
+		return nil, fmt.Errorf("no usable scope to read the runtime variables: %s", strings.Join(errs, "; "))
+	}
+	logger.Warnf("no thread has a usable scope, use a frameless scope instead")
+	return scope, nil
+}
+
+// threadsCurrentFirst returns the threads with the current one first, which may be nil, e.g. if it has exited.
+func threadsCurrentFirst(cur proc.Thread, list []proc.Thread) []proc.Thread {
+	var ths []proc.Thread
+	if cur != nil {
+		ths = append(ths, cur)
+	}
+	for _, th := range list {
+		if cur == nil || th.ThreadID() != cur.ThreadID() {
+			ths = append(ths, th)
+		}
+	}
+	return ths
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// fakeThread is a proc.Thread of the ID only.
+type fakeThread struct {
+	proc.Thread
+	id int
+}
+
+func (th fakeThread) ThreadID() int { return th.id }
+
+func threadIDs(ths []proc.Thread) []int {
+	var ids []int
+	for _, th := range ths {
+		ids = append(ids, th.ThreadID())
+	}
+	return ids
+}
+
+func TestThreadsCurrentFirst(t *testing.T) {
+	list := []proc.Thread{fakeThread{id: 1}, fakeThread{id: 2}, fakeThread{id: 3}}
+	if got := threadIDs(threadsCurrentFirst(fakeThread{id: 2}, list)); !slices.Equal(got, []int{2, 1, 3}) {
+		t.Errorf("got threads %v, want 2 first", got)
+	}
+	// e.g. the current thread has exited
+	if got := threadIDs(threadsCurrentFirst(nil, list)); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("got threads %v without the current one, want all", got)
+	}
+	if got := threadsCurrentFirst(nil, nil); len(got) != 0 {
+		t.Errorf("got threads %v, want none", threadIDs(got))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// foldedNameReplacer replaces the separators of the folded format in the names.
+var foldedNameReplacer = strings.NewReplacer(";", ",", "\n", " ")
+
+// foldedEncoder writes the references as the collapsed stack lines of flamegraph.pl,
+// one line per path from the root, followed by the values of the carried sample types.
+type foldedEncoder struct{}
+
+func (foldedEncoder) encode(out io.Writer, b *profileBuilder) error {
+	var lines []string
+	values := make([]int64, len(b.sampleTypes))
+	var sb strings.Builder
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		var zero bool
+		values, zero = b.selectValues(values, &node.sampleValues)
+		if zero {
+			return
+		}
+		sb.Reset()
+		// indexes are from leaf to root
+		for i := len(indexes) - 1; i >= 0; i-- {
+			sb.WriteString(foldedNameReplacer.Replace(b.strings[indexes[i]]))
+			if i > 0 {
+				sb.WriteByte(';')
+			}
+		}
+		for _, v := range values {
+			sb.WriteByte(' ')
+			sb.WriteString(strconv.FormatInt(v, 10))
+		}
+		lines = append(lines, sb.String())
+	})
+	sort.Strings(lines)
+
+	w := bufio.NewWriter(out)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlushFolded(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatFolded, GroupByPath, nil)
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	pb.addReference(root.pushHead(pb, "m. map[string;int]").indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 8})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `main.root 1 16
+main.root;m. map[string,int] 1 8
+main.root;next. (*main.T) 2 64
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected folded output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFlushPruned(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatFolded, GroupByPath, nil)
+	pb.minSpace = 64
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	pb.addReference(root.pushHead(pb, "m. (map[string]int)").indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 8})
+	pb.addReference((*pprofIndex)(nil).pushHead(pb, "main.small").indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 32})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// main.root references 88 bytes in total, so it is kept even though itself is small
+	want := `main.root 1 16
+main.root;next. (*main.T) 2 64
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected pruned output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFlushSpilledNodes(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatFolded, GroupByPath, nil)
+	// spill on every new node to the temporary file
+	pb.ram, pb.spilled.pattern = &ramBudget{max: 1}, "goref-nodes-*"
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	if pb.spilled.size == 0 {
+		t.Fatal("the nodes are not spilled")
+	}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if pb.spilled.f != nil {
+		t.Fatal("the spill file is not removed")
+	}
+
+	// the nodes of the same path are merged back
+	want := `main.root 2 32
+main.root;next. (*main.T) 2 64
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected folded output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// WithForkSnapshot forks the target process of grp while it is stopped, then calls resume to resume
+// the target and scans the child instead, which is a copy-on-write copy of the target frozen at the
+// moment. So the target is only stopped for reading the heap metadata, and the copies are shared with
+// the target until it writes to them. The child is killed after the scanning.
+// It's only supported for live processes on linux/amd64.
+func WithForkSnapshot(grp *proc.TargetGroup, resume func() error) Option {
+	return func(o *options) {
+		o.forkGroup, o.resume = grp, resume
+	}
+}
+
+// processMemory reads the memory of a process directly, e.g. the forked child of the target.
+type processMemory struct {
+	pid int
+}
+
+func (m processMemory) ReadMemory(data []byte, addr uint64) (int, error) {
+	return readProcessMemory(m.pid, data, addr)
+}
+
+func (m processMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return 0, errors.New("the forked process is read only")
+}
+
+// forkSnapshot forks the target, resumes it, and switches the scanning to the memory of the child.
+// It returns the function killing the child.
+func (s *ObjRefScope) forkSnapshot(grp *proc.TargetGroup, resume func() error) (release func(), err error) {
+	start := time.Now()
+	child, adopted, err := forkTarget(grp)
+	if err != nil {
+		return nil, fmt.Errorf("fork the target: %w", err)
+	}
+	release = func() {
+		killProcess(child)
+	}
+	if err = resume(); err != nil {
+		release()
+		return nil, err
+	}
+	s.logger.Printf("snapshot: forked the target to process %d in %v\n", child, time.Since(start).Round(time.Millisecond))
+	if !adopted {
+		s.logger.Printf("snapshot: process %d stays a zombie until the target exits, since the target can not give it to its parent\n", child)
+	}
+	mem := newPageCache(processMemory{pid: child})
+	s.mem = mem
+	s.scope.Mem = mem
+	return release, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/go-delve/delve/pkg/dwarf/op"
+	"github.com/go-delve/delve/pkg/dwarf/regnum"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+const (
+	sysClone    = 56
+	sysFork     = 57
+	cloneParent = 0x8000 // CLONE_PARENT
+)
+
+// forkStub is injected into the target to fork it. The parent is single stepped over the syscall only,
+// while the child, whose syscall returns 0, pauses forever without touching its memory:
+//
+//	    syscall
+//	    test rax, rax
+//	    jnz  done
+//	loop:
+//	    mov  eax, SYS_pause
+//	    syscall
+//	    jmp  loop
+//	done:
+//	    int3
+var forkStub = []byte{
+	0x0f, 0x05,
+	0x48, 0x85, 0xc0,
+	0x75, 0x09,
+	0xb8, 0x22, 0x00, 0x00, 0x00,
+	0x0f, 0x05,
+	0xeb, 0xf7,
+	0xcc,
+}
+
+// forkTarget forks the stopped target by injecting a syscall into its current thread, and returns the
+// pid of the child, whose memory is a copy of the target at the moment, including the stacks of all the
+// threads. The child is given to the parent of the target if possible, which reaps it after it's killed,
+// otherwise the child is forked and adopted is false, e.g. the target is the init of a pid namespace.
+func forkTarget(grp *proc.TargetGroup) (pid int, adopted bool, err error) {
+	t := grp.Selected
+	fns := t.BinInfo().LookupFunc()["runtime.main"]
+	if len(fns) == 0 {
+		return 0, false, errors.New("runtime.main is not found")
+	}
+	// the stub overwrites the code of runtime.main temporarily, which is always linked, and no thread runs it
+	addr := fns[0].Entry
+	th := t.CurrentThread()
+	regs, err := th.Registers()
+	if err != nil {
+		return 0, false, err
+	}
+	saved, err := regs.Copy()
+	if err != nil {
+		return 0, false, err
+	}
+	mem := t.Memory()
+	orig := make([]byte, len(forkStub))
+	if _, err = mem.ReadMemory(orig, addr); err != nil {
+		return 0, false, err
+	}
+	if _, err = mem.WriteMemory(addr, forkStub); err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		// the child keeps its own copy of the stub
+		if _, werr := mem.WriteMemory(addr, orig); werr != nil && err == nil {
+			err = werr
+		}
+		if rerr := th.RestoreRegisters(saved); rerr != nil && err == nil {
+			err = rerr
+		}
+		t.ClearCaches()
+	}()
+
+	ret, err := stepSyscall(grp, th, addr, sysClone, cloneParent|uint64(syscall.SIGCHLD))
+	adopted = true
+	if err == nil && ret == -int64(syscall.EINVAL) {
+		ret, err = stepSyscall(grp, th, addr, sysFork, 0)
+		adopted = false
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if ret < 0 {
+		return 0, false, syscall.Errno(-ret)
+	}
+	return int(ret), adopted, nil
+}
+
+// stepSyscall makes the syscall nr with the first argument arg1 by the thread, executing the syscall
+// instruction at pc, and returns the result of the syscall.
+func stepSyscall(grp *proc.TargetGroup, th proc.Thread, pc, nr, arg1 uint64) (int64, error) {
+	for _, r := range []struct{ num, val uint64 }{
+		{regnum.AMD64_Rip, pc},
+		{regnum.AMD64_Rax, nr},
+		{regnum.AMD64_Rdi, arg1},
+		{regnum.AMD64_Rsi, 0},
+		{regnum.AMD64_Rdx, 0},
+		{regnum.AMD64_R10, 0},
+		{regnum.AMD64_R8, 0},
+	} {
+		if err := th.SetReg(r.num, op.DwarfRegisterFromUint64(r.val)); err != nil {
+			return 0, err
+		}
+	}
+	// step the thread itself rather than the goroutine selected
+	if err := grp.Selected.SwitchThread(th.ThreadID()); err != nil {
+		return 0, err
+	}
+	if err := grp.StepInstruction(false); err != nil {
+		return 0, err
+	}
+	regs, err := th.Registers()
+	if err != nil {
+		return 0, err
+	}
+	rs, err := regs.Slice(false)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rs {
+		if r.Name == "Rax" {
+			return int64(r.Reg.Uint64Val), nil
+		}
+	}
+	return 0, errors.New("rax is not found")
+}
+
+// killProcess kills the forked child.
+func killProcess(pid int) {
+	_ = syscall.Kill(pid, syscall.SIGKILL)
+}
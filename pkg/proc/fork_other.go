@@ -0,0 +1,30 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux || !amd64
+
+package proc
+
+import (
+	"errors"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// forkTarget is only supported on linux/amd64.
+func forkTarget(grp *proc.TargetGroup) (pid int, adopted bool, err error) {
+	return 0, false, errors.New("the fork snapshot is only supported on linux/amd64")
+}
+
+func killProcess(pid int) {}
@@ -0,0 +1,108 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is the file format of the output.
+type Format int
+
+const (
+	// FormatPprof is the gzipped pprof protobuf format, which can be viewed by `go tool pprof`.
+	FormatPprof Format = iota
+	// FormatCallgrind is the callgrind format, which can be viewed by KCachegrind.
+	FormatCallgrind
+	// FormatHTML is a self-contained interactive flame graph, which can be viewed by browsers.
+	FormatHTML
+	// FormatFolded is the collapsed stack lines of flamegraph.pl, like "root;field;field count bytes".
+	FormatFolded
+	// FormatSpeedscope is the JSON format of speedscope, which can be viewed by https://www.speedscope.app.
+	FormatSpeedscope
+	// FormatGraph is the object graph as newline delimited JSON, with the roots and the heap objects as
+	// the nodes, and the references between them as the edges. The graph is recorded during the scanning,
+	// which takes extra memory, and the options shaping the reference paths don't apply to it.
+	FormatGraph
+	// FormatDOT is the object graph in the DOT language of Graphviz, see FormatGraph.
+	FormatDOT
+	// FormatTreemap is a squarified treemap in SVG, where the area of a node is its retained space, or its
+	// space if the retained space is not carried, and the children are nested in their parents.
+	FormatTreemap
+	// FormatJSON is the reference tree as nested JSON objects with a versioned schema, like
+	// {"name": "buf", "type": "[]uint8", "count": 1, "size": 1024, "children": [...]}, for jq and log pipelines.
+	FormatJSON
+	// FormatCSV is a table with one row per reference path, with the columns path, depth, type, objects and
+	// bytes, followed by the other carried sample types, for spreadsheets and SQL engines like DuckDB.
+	FormatCSV
+	// FormatTSV is FormatCSV separated by tabs.
+	FormatTSV
+
+	numFormats
+)
+
+var formatNames = [numFormats]string{
+	FormatPprof:      "pprof",
+	FormatCallgrind:  "callgrind",
+	FormatHTML:       "html",
+	FormatFolded:     "folded",
+	FormatSpeedscope: "speedscope",
+	FormatGraph:      "graph",
+	FormatDOT:        "dot",
+	FormatTreemap:    "treemap",
+	FormatJSON:       "json",
+	FormatCSV:        "csv",
+	FormatTSV:        "tsv",
+}
+
+// encoder writes the references collected by a profileBuilder in a file format.
+type encoder interface {
+	encode(w io.Writer, b *profileBuilder) error
+}
+
+var encoders = [numFormats]encoder{
+	FormatPprof:      pprofEncoder{},
+	FormatCallgrind:  callgrindEncoder{},
+	FormatHTML:       htmlEncoder{},
+	FormatFolded:     foldedEncoder{},
+	FormatSpeedscope: speedscopeEncoder{},
+	FormatGraph:      graphEncoder{},
+	FormatDOT:        dotEncoder{},
+	FormatTreemap:    treemapEncoder{},
+	FormatJSON:       jsonEncoder{},
+	FormatCSV:        csvEncoder{comma: ','},
+	FormatTSV:        csvEncoder{comma: '\t'},
+}
+
+// String returns the name of the format, which is used by the command line.
+func (f Format) String() string {
+	if f < 0 || f >= numFormats {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+// ParseFormat parses the format name, like "pprof".
+func ParseFormat(s string) (Format, error) {
+	s = strings.TrimSpace(s)
+	for i, name := range formatNames {
+		if name == s {
+			return Format(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-delve/delve/service/debugger"
+)
+
+// The go toolchain building the test programs can be selected by environment variables,
+// so that the same scenario can be validated against multiple go releases:
+//   - GOREF_TEST_GO: path of the go binary, e.g. /usr/local/go1.22/bin/go
+//   - GOREF_TEST_GOTOOLCHAIN: GOTOOLCHAIN used to build, e.g. go1.22.8
+const (
+	envTestGo          = "GOREF_TEST_GO"
+	envTestGoToolchain = "GOREF_TEST_GOTOOLCHAIN"
+)
+
+// go1.x versions of the executable file supported by goref
+const (
+	minTestGoMinor = 17
+	maxTestGoMinor = 24
+)
+
+// time to wait for the test program to build its heap
+const testProgramSettleTime = 2 * time.Second
+
+type testScenario struct {
+	name       string   // dir name in testdata
+	minGoMinor int      // minimum go1.x version to build the program
+	buildFlags []string // extra flags to build the program
+}
+
+var testScenarios = []testScenario{
+	{name: "alltypes", minGoMinor: minTestGoMinor},
+	{name: "allocheader", minGoMinor: minTestGoMinor},
+	{name: "bufreset", minGoMinor: minTestGoMinor},
+	{name: "closure", minGoMinor: minTestGoMinor},
+	{name: "mockleak", minGoMinor: minTestGoMinor},
+	{name: "sudog", minGoMinor: minTestGoMinor},
+	{name: "defers", minGoMinor: minTestGoMinor},
+	{name: "timers", minGoMinor: minTestGoMinor},
+	// the module declares an older go version, override the language version for range-over-func.
+	{name: "rangeoverfunc", minGoMinor: 23, buildFlags: []string{"-gcflags=-lang=go1.23"}},
+}
+
+// testGoCommand returns a go command using the selected toolchain.
+func testGoCommand(args ...string) *exec.Cmd {
+	goBin := "go"
+	if p := os.Getenv(envTestGo); p != "" {
+		goBin = p
+	}
+	cmd := exec.Command(goBin, args...)
+	cmd.Env = os.Environ()
+	if tc := os.Getenv(envTestGoToolchain); tc != "" {
+		cmd.Env = append(cmd.Env, "GOTOOLCHAIN="+tc)
+	}
+	return cmd
+}
+
+// testGoMinorVersion returns x of the go1.x toolchain building the test programs.
+func testGoMinorVersion(t testing.TB) int {
+	cmd := testGoCommand("env", "GOVERSION")
+	cmd.Dir = testModuleRoot(t)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("get go version failed: %v", err)
+	}
+	ver := strings.TrimPrefix(strings.TrimSpace(string(out)), "go1.")
+	if i := strings.IndexAny(ver, ".-+ "); i >= 0 {
+		ver = ver[:i]
+	}
+	minor, err := strconv.Atoi(ver)
+	if err != nil {
+		t.Fatalf("unknown go version %q", out)
+	}
+	return minor
+}
+
+func testModuleRoot(t testing.TB) string {
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// createTestProgram builds testdata/<name> with the selected toolchain, and returns the executable path.
+func createTestProgram(t testing.TB, name string, buildFlags ...string) string {
+	exe := filepath.Join(t.TempDir(), name)
+	args := append([]string{"build", "-o", exe}, buildFlags...)
+	cmd := testGoCommand(append(args, "./testdata/"+name)...)
+	cmd.Dir = testModuleRoot(t)
+	if testGoMinorVersion(t) >= 24 {
+		// the swiss maps enabled by default since go1.24 are not yet supported, see goExperiments
+		cmd.Env = append(cmd.Env, "GOEXPERIMENT=noswissmap")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build test program %s failed: %v\n%s", name, err, out)
+	}
+	return exe
+}
+
+// startTestProgram starts the executable, and waits for it to build its heap.
+func startTestProgram(t testing.TB, exe string, args ...string) *exec.Cmd {
+	cmd := exec.Command(exe, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start test program failed: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	time.Sleep(testProgramSettleTime)
+	return cmd
+}
+
+// scanTestProgram attaches to the running test program and scans it, returns the output file path.
+func scanTestProgram(t testing.TB, pid int, opts ...Option) string {
+	dbg, err := debugger.New(&debugger.Config{AttachPid: pid, Backend: "default"}, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "not permitted") {
+			t.Skipf("attach is not permitted: %v", err)
+		}
+		t.Fatalf("attach to %d failed: %v", pid, err)
+	}
+	defer dbg.Detach(false)
+	out := filepath.Join(t.TempDir(), "grf.out")
+	if err := ObjectReference(dbg.Target(), out, opts...); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	return out
+}
+
+// runTestScenario builds, starts and scans the scenario, returns the output file path.
+func runTestScenario(t testing.TB, sc testScenario, opts ...Option) string {
+	minor := testGoMinorVersion(t)
+	if minor < sc.minGoMinor || minor > maxTestGoMinor {
+		t.Skipf("scenario %s doesn't support go1.%d", sc.name, minor)
+	}
+	cmd := startTestProgram(t, createTestProgram(t, sc.name, sc.buildFlags...))
+	return scanTestProgram(t, cmd.Process.Pid, opts...)
+}
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	for _, sc := range testScenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			out := runTestScenario(t, sc)
+			if fi, err := os.Stat(out); err != nil || fi.Size() == 0 {
+				t.Fatalf("empty output of scenario %s: %v", sc.name, err)
+			}
+		})
+	}
+}
+
+// testLogger drops the progress messages of the scanning and routes the others to the test log,
+// which would break the lines of the benchmark results otherwise.
+type testLogger struct{ testing.TB }
+
+func (l testLogger) Printf(format string, args ...interface{}) {}
+func (l testLogger) Warnf(format string, args ...interface{})  { l.Logf("warn: "+format, args...) }
+func (l testLogger) Errorf(format string, args ...interface{}) { l.Logf("error: "+format, args...) }
+
+// synthHeaps are the heaps built by the synthheap program for the benchmarks, by their shapes and the number of objects.
+var synthHeaps = []struct {
+	shape string
+	n     int
+}{
+	{"slice", 200000},
+	{"map", 200000},
+	{"list", 200000},
+	{"tree", 200000},
+}
+
+func BenchmarkScan(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skip scenario benchmarks in short mode")
+	}
+	minor := testGoMinorVersion(b)
+	if minor > maxTestGoMinor {
+		b.Skipf("go1.%d is not supported", minor)
+	}
+	exe := createTestProgram(b, "synthheap")
+	for _, h := range synthHeaps {
+		b.Run(fmt.Sprintf("%s=%d", h.shape, h.n), func(b *testing.B) {
+			cmd := startTestProgram(b, exe, h.shape, strconv.Itoa(h.n))
+			var stats ScanStats
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				scanTestProgram(b, cmd.Process.Pid, WithScanStats(&stats), WithLogger(testLogger{b}))
+			}
+			b.ReportMetric(float64(stats.BytesRead), "read-B/op")
+		})
+	}
+}
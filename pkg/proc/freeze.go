@@ -0,0 +1,264 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/elf"
+	"errors"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// copy the heap by chunks, so that the deadline is checked in time for huge spans
+const snapshotChunkSize = 1 << 20
+
+// the chunks are copied in batches of about the bytes, see readProcessRegions
+const snapshotBatchSize = 16 << 20
+
+var errNotInSnapshot = errors.New("memory is not in the snapshot")
+
+type snapshotRegion struct {
+	addr uint64
+	data []byte
+}
+
+type fileRegion struct {
+	addr, size uint64
+	r          io.ReaderAt
+}
+
+// snapshotMemory serves the memory reads from the copies taken while the target was stopped,
+// so that the scanning can go on after the target is resumed. The writable sections of the
+// executable file are copied as a whole, since the runtime keeps many of its structures there,
+// e.g. runtime.mheap_; the read-only sections, e.g. the runtime type data, are read from the file.
+type snapshotMemory struct {
+	regions []snapshotRegion // sorted by addr, not overlapped
+	files   []fileRegion
+
+	// the memory copied from, and the pid of the target to copy from directly, 0 if unknown
+	src proc.MemoryReadWriter
+	pid int
+	// the chunks to copy in the next batch
+	pending      []snapshotRegion
+	pendingBytes int64
+	// the state copying the dirty pages only, nil if all the memory is copied
+	inc *IncrementalState
+
+	// reads of memory not in the snapshot
+	misses atomic.Int64
+}
+
+// newSnapshotMemory creates a snapshot with the writable sections copied from mem, which is the
+// memory of the process pid, returns false if the executable file is not an ELF file. The memory is
+// copied incrementally by inc if not nil.
+func newSnapshotMemory(bi *proc.BinaryInfo, mem proc.MemoryReadWriter, pid int, inc *IncrementalState) (m *snapshotMemory, ok bool) {
+	m = &snapshotMemory{src: mem}
+	if tracedBySelf(pid) {
+		// not a core file or a remote process
+		m.pid = pid
+		if inc != nil && inc.begin(pid) {
+			m.inc = inc
+		}
+	}
+	for _, image := range bi.Images {
+		ef, err := elf.Open(image.Path)
+		if err != nil {
+			// not an ELF file, the read-only sections are unavailable
+			continue
+		}
+		ok = true
+		for _, sec := range ef.Sections {
+			if sec.Flags&elf.SHF_ALLOC == 0 {
+				continue
+			}
+			addr := Address(sec.Addr + image.StaticBase)
+			if sec.Flags&elf.SHF_WRITE != 0 {
+				m.copy(addr, addr.Add(int64(sec.Size)))
+			} else if sec.Type != elf.SHT_NOBITS {
+				m.files = append(m.files, fileRegion{addr: uint64(addr), size: sec.Size, r: sec})
+			}
+		}
+	}
+	return m, ok
+}
+
+// copy copies [start, end) from the source, the memory which is unreadable is skipped.
+// The copies may be delayed to the next batch, until flush is called.
+func (m *snapshotMemory) copy(start, end Address) {
+	for addr := start; addr < end; addr = addr.Add(snapshotChunkSize) {
+		size := end.Sub(addr)
+		if size > snapshotChunkSize {
+			size = snapshotChunkSize
+		}
+		if size <= 0 {
+			continue
+		}
+		var data []byte
+		if m.inc != nil {
+			data = m.inc.buffer(uint64(addr), size)
+		} else {
+			data = make([]byte, size)
+		}
+		m.pending = append(m.pending, snapshotRegion{addr: uint64(addr), data: data})
+		m.pendingBytes += size
+		if m.pendingBytes >= snapshotBatchSize {
+			m.flush()
+		}
+	}
+}
+
+// flush copies the pending chunks, directly from the target process if possible, which takes
+// much less syscalls than reading through the debugger, otherwise one by one from the source.
+func (m *snapshotMemory) flush() {
+	if len(m.pending) == 0 {
+		return
+	}
+	reads, owners := m.pending, []int(nil)
+	if m.inc != nil {
+		reads, owners = m.inc.dirtyRegions(m.pending)
+	}
+	// a chunk fails if any region of it fails
+	failed := make([]bool, len(m.pending))
+	done := readProcessRegions(m.pid, reads)
+	if cm := countedMemory(m.src); cm != nil {
+		for _, r := range reads[:done] {
+			cm.count(len(r.data))
+		}
+	}
+	for i, r := range reads[done:] {
+		if _, err := m.src.ReadMemory(r.data, r.addr); err != nil {
+			if owners != nil {
+				failed[owners[done+i]] = true
+			} else {
+				failed[done+i] = true
+			}
+		}
+	}
+	for i, r := range m.pending {
+		if !failed[i] {
+			m.regions = append(m.regions, r)
+		}
+	}
+	if m.inc != nil {
+		m.inc.record(m.pending, failed)
+	}
+	m.pending, m.pendingBytes = nil, 0
+}
+
+// seal copies the pending chunks, and sorts the regions after all copies.
+func (m *snapshotMemory) seal() {
+	m.flush()
+	sort.Slice(m.regions, func(i, j int) bool { return m.regions[i].addr < m.regions[j].addr })
+}
+
+func (m *snapshotMemory) ReadMemory(data []byte, addr uint64) (int, error) {
+	if n := m.readRegions(data, addr); n == len(data) {
+		return n, nil
+	}
+	for _, f := range m.files {
+		if addr >= f.addr && addr+uint64(len(data)) <= f.addr+f.size {
+			return f.r.ReadAt(data, int64(addr-f.addr))
+		}
+	}
+	m.misses.Add(1)
+	return 0, errNotInSnapshot
+}
+
+// readRegions reads from the contiguous regions starting at addr.
+func (m *snapshotMemory) readRegions(data []byte, addr uint64) (n int) {
+	i := sort.Search(len(m.regions), func(i int) bool { return m.regions[i].addr > addr }) - 1
+	for ; i >= 0 && i < len(m.regions) && n < len(data); i++ {
+		r := &m.regions[i]
+		if addr < r.addr || addr >= r.addr+uint64(len(r.data)) {
+			break
+		}
+		c := copy(data[n:], r.data[addr-r.addr:])
+		n += c
+		addr += uint64(c)
+	}
+	return n
+}
+
+func (m *snapshotMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return 0, errors.New("snapshot memory is read only")
+}
+
+// freeze copies the memory to scan while the target is stopped, and resumes the target.
+// The roots are always copied, then the heap spans are copied until the deadline, or all of
+// them are copied if the deadline is zero. After that, the scanning reads the copies instead of the target.
+func (s *ObjRefScope) freeze(grs []*goroutineRoot, pid int, deadline time.Time, inc *IncrementalState, resume func() error) error {
+	start := time.Now()
+	snap, ok := newSnapshotMemory(s.bi, s.mem, pid, inc)
+	if !ok {
+		for _, seg := range s.data {
+			snap.copy(seg.base, seg.end)
+		}
+		for _, seg := range s.bss {
+			snap.copy(seg.base, seg.end)
+		}
+	}
+	for _, gr := range grs {
+		snap.copy(gr.lo, gr.hi)
+	}
+	snap.flush()
+	var missingSpans int
+	var missingBytes, totalBytes int64
+	for _, sp := range s.spans {
+		totalBytes += sp.spanSize
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			missingSpans++
+			missingBytes += sp.spanSize
+			continue
+		}
+		snap.copy(sp.base, sp.base.Add(sp.spanSize))
+	}
+	snap.seal()
+	if snap.inc != nil {
+		// before resuming the target
+		if err := snap.inc.commit(); err != nil {
+			s.logger.Warnf("incremental: clear the soft-dirty bits err: %v", err)
+		}
+		s.logger.Printf("incremental: reused %d bytes and copied %d bytes\n", snap.inc.reused, snap.inc.copied)
+	} else if inc != nil {
+		s.logger.Printf("incremental: the soft-dirty bits or the page frames of the target are unavailable, all the memory is copied\n")
+	}
+	if err := resume(); err != nil {
+		return err
+	}
+	if deadline.IsZero() {
+		s.logger.Printf("snapshot: copied %d bytes of the heap in %v\n", totalBytes, time.Since(start).Round(time.Millisecond))
+	} else if over := time.Since(deadline); over > 0 {
+		s.logger.Printf("freeze: the target was stopped %v longer than the freeze duration\n", over.Round(time.Millisecond))
+	}
+	if missingSpans > 0 {
+		s.logger.Printf("freeze: %d of %d spans (%d of %d bytes) were not copied in time, objects in them are not scanned\n",
+			missingSpans, len(s.spans), missingBytes, totalBytes)
+	}
+	s.mem = snap
+	s.scope.Mem = snap
+	s.snapshot = snap
+	return nil
+}
+
+// reportSnapshotMisses reports the reads of memory which was not copied during the freeze.
+func (s *ObjRefScope) reportSnapshotMisses() {
+	if s.snapshot != nil && s.snapshot.misses.Load() > 0 {
+		s.logger.Printf("freeze: %d reads of memory which was not copied failed\n", s.snapshot.misses.Load())
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGarbageStats(t *testing.T) {
+	small := &spanInfo{base: 0x1000, elemSize: 16, spanSize: 64, visitMask: make([]uint64, 1), nelems: 4, freeIndex: 2, allocBits: []uint8{0b0100}}
+	// the object 0 is reached, 1 and 2 are allocated but unreached, and 3 is free
+	small.mark(0x1000)
+	large := &spanInfo{base: 0x10000, elemSize: 40 << 10, spanSize: 40 << 10, visitMask: make([]uint64, 80)}
+	s := &ObjRefScope{HeapScope: &HeapScope{spans: []*spanInfo{small, large}}}
+	want := []GarbageStat{
+		{ElemSize: 0, Objects: 1, Space: 40 << 10},
+		{ElemSize: 16, Objects: 2, Space: 32},
+	}
+	if got := s.garbageStats(false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got garbage stats %+v, want %+v", got, want)
+	}
+}
@@ -17,6 +17,7 @@ package proc
 import (
 	"errors"
 	"math/bits"
+	"sync/atomic"
 )
 
 type gcMaskBitIterator struct {
@@ -42,7 +43,7 @@ func (b *gcMaskBitIterator) nextPtr(ack bool) Address {
 	for startOffset < endOffset {
 		ptrIdx := startOffset / 8 / 64
 		i := startOffset / 8 % 64
-		j := int64(bits.TrailingZeros64(b.mask[ptrIdx] >> i))
+		j := int64(bits.TrailingZeros64(atomic.LoadUint64(&b.mask[ptrIdx]) >> i))
 		if j == 64 {
 			// search the next ptr
 			startOffset = (ptrIdx + 1) * 64 * 8
@@ -71,10 +72,32 @@ func (b *gcMaskBitIterator) resetGCMask(addr Address) error {
 	}
 	// TODO: check gc mask
 	offset := addr.Sub(b.maskBase)
-	b.mask[offset/8/64] &= ^(1 << (offset / 8 % 64))
+	clearBits(&b.mask[offset/8/64], 1<<(offset/8%64))
 	return nil
 }
 
+// setBits sets the bits of the mask word, and returns the old word. The mask words are
+// updated atomically, since a word covers several objects, which may be scanned by
+// different workers in parallel.
+func setBits(p *uint64, bits uint64) (old uint64) {
+	for {
+		old = atomic.LoadUint64(p)
+		if old&bits == bits || atomic.CompareAndSwapUint64(p, old, old|bits) {
+			return old
+		}
+	}
+}
+
+// clearBits clears the bits of the mask word atomically.
+func clearBits(p *uint64, bits uint64) {
+	for {
+		old := atomic.LoadUint64(p)
+		if old&bits == 0 || atomic.CompareAndSwapUint64(p, old, old&^bits) {
+			return
+		}
+	}
+}
+
 func newGCBitsIterator(base, end, maskBase Address, ptrMask []uint64) *gcMaskBitIterator {
 	return &gcMaskBitIterator{base: base, end: end, mask: ptrMask, addr: base, maskBase: maskBase}
 }
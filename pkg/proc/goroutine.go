@@ -0,0 +1,234 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// GoroutineStat is the state of a goroutine and the heap it keeps alive.
+type GoroutineStat struct {
+	ID int64
+	// Name is the start function and the function creating the goroutine, like "main.worker created by main.main".
+	Name string
+	// Status is the status of the goroutine, like "waiting".
+	Status string
+	// WaitReason is why the goroutine is waiting, like "chan receive", empty if not waiting.
+	WaitReason string
+	// WaitSince is the runtime nanotime since which the goroutine is blocked, 0 if unknown. When a GC finds
+	// the goroutine blocked, the runtime records the start time of the last GC, so it's unknown until
+	// the second GC after the goroutine blocks, and it's later than the goroutine actually blocks.
+	WaitSince int64
+	// Space is the bytes of the heap objects reached from the stack frames,
+	// and Retained is the bytes which would be freed if the goroutine exited.
+	Space, Retained int64
+}
+
+// WithGoroutineStats collects the states of the goroutines and the heap they keep alive to stats.
+// The objects are aggregated by goroutine and the retained space is computed, see GroupByGoroutine.
+func WithGoroutineStats(stats *[]GoroutineStat) Option {
+	return func(o *options) {
+		o.goroutineStats = stats
+	}
+}
+
+// goroutineStatus are the names of the goroutine statuses of the runtime, see runtime/runtime2.go.
+var goroutineStatus = [...]string{
+	0: "idle",
+	1: "runnable",
+	2: "running",
+	3: "syscall",
+	4: "waiting",
+	6: "dead",
+	8: "copystack",
+	9: "preempted",
+}
+
+// gscanStatus is the bit of the goroutine status set while the GC scans the stack.
+const gscanStatus = 0x1000
+
+func goroutineStatusName(status uint64) string {
+	status &^= gscanStatus
+	if status < uint64(len(goroutineStatus)) && goroutineStatus[status] != "" {
+		return goroutineStatus[status]
+	}
+	return fmt.Sprintf("status(%d)", status)
+}
+
+// waitReason returns the description of the wait reason from runtime.waitReasonStrings.
+func (s *HeapScope) waitReason(reason int64) string {
+	if reason == 0 {
+		return ""
+	}
+	tmp, err := s.scope.EvalExpression("runtime.waitReasonStrings", loadSingleValue)
+	if err != nil {
+		return fmt.Sprintf("waitReason(%d)", reason)
+	}
+	strs := toRegion(tmp, s.bi)
+	if !strs.IsArray() || reason >= strs.ArrayLen() {
+		return fmt.Sprintf("waitReason(%d)", reason)
+	}
+	var str region
+	strs.ArrayIndex(reason, &str)
+	return str.String()
+}
+
+// goroutineState is the state of a goroutine read from runtime.g.
+type goroutineState struct {
+	status     uint64
+	waitReason int64
+	waitSince  int64
+}
+
+// forEachG calls fn with every goroutine in runtime.allgs and its ID.
+func (s *HeapScope) forEachG(fn func(goid int64, g *region)) error {
+	tmp, err := s.scope.EvalExpression("runtime.allgs", loadSingleValue)
+	if err != nil {
+		return err
+	}
+	allgs := toRegion(tmp, s.bi)
+	n := allgs.SliceLen()
+	arr := allgs.Array()
+	var gp region
+	for i := int64(0); i < n; i++ {
+		arr.ArrayIndex(i, &gp)
+		g := gp.Deref()
+		goid := g.Field("goid")
+		if _, ok := goid.typ.(*godwarf.UintType); ok {
+			fn(int64(goid.Uint()), g)
+		} else {
+			fn(goid.Int(), g)
+		}
+	}
+	return nil
+}
+
+// stacksRootName is the root of the goroutine stacks if not grouping by goroutine.
+const stacksRootName = "<goroutine stacks>"
+
+// recordStack records the allocated stack size of the goroutine as SampleStack, to the root of
+// the goroutine if grouping by goroutine, otherwise under stacksRootName by its start function and
+// the function creating it, so the goroutines started by the same go statement are summed up.
+func (s *ObjRefScope) recordStack(gr *goroutineRoot, root *pprofIndex, o *options) {
+	size := int64(gr.hi) - int64(gr.lo)
+	if size <= 0 {
+		return
+	}
+	if root == nil {
+		if gr.startFn != nil && !o.includes(gr.startFn.Name) {
+			return
+		}
+		root = root.pushHead(s.pb, stacksRootName).pushHead(s.pb, goroutineRootName(gr, GroupByGoroutineSite))
+		root.labels = s.labels
+	}
+	s.recordValues(root, &sampleValues{SampleStack: size})
+}
+
+// setGoroutineLabels labels root, if any, and the roots found afterwards with the pprof labels of the
+// goroutine gr, i.e. the labels set by pprof.Do, or stops labeling if gr is nil.
+func (s *ObjRefScope) setGoroutineLabels(gr *goroutineRoot, root *pprofIndex) {
+	s.labels = 0
+	if gr != nil {
+		s.labels = s.pb.labelSetIndex(gr.labels)
+	}
+	if root != nil {
+		root.labels = s.labels
+	}
+}
+
+// goroutineLabels returns the pprof labels of the goroutine, i.e. the runtime/pprof.labelMap pointed by g.labels,
+// nil if not labeled or unreadable.
+func goroutineLabels(g *proc.G) (labels map[string]string) {
+	defer func() {
+		if r := recover(); r != nil {
+			// unknown layout of the label map
+			labels = nil
+		}
+	}()
+	return g.Labels()
+}
+
+// readGs reads the goroutines in runtime.allgs, key: goroutine ID.
+func (s *HeapScope) readGs() (map[int64]*region, error) {
+	gs := make(map[int64]*region)
+	if err := s.forEachG(func(goid int64, g *region) { gs[goid] = g }); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// readGoroutineStates reads the states of the goroutines in runtime.allgs, key: goroutine ID.
+func (s *HeapScope) readGoroutineStates() map[int64]goroutineState {
+	states := make(map[int64]goroutineState)
+	err := s.forEachG(func(goid int64, g *region) {
+		var st goroutineState
+		status := g.Field("atomicstatus")
+		if status.IsStruct() {
+			// atomic.Uint32 since go1.20
+			status = status.Field("value")
+		}
+		st.status = status.Uint()
+		if g.HasField("waitreason") {
+			st.waitReason = int64(g.Field("waitreason").Uint())
+		}
+		st.waitSince = g.Field("waitsince").Int()
+		states[goid] = st
+	})
+	if err != nil {
+		s.logger.Warnf("read runtime.allgs err: %v", err)
+	}
+	return states
+}
+
+// goroutineStats returns the states of the goroutines, and the values recorded at their roots.
+func (s *ObjRefScope) goroutineStats(t *proc.Target, grs []*goroutineRoot) []GoroutineStat {
+	s.pb.loadSpilled()
+	states := s.readGoroutineStates()
+	stats := make([]GoroutineStat, 0, len(grs))
+	for _, gr := range grs {
+		state, ok := states[gr.g.ID]
+		if !ok {
+			state = goroutineState{status: gr.g.Status, waitReason: gr.g.WaitReason, waitSince: gr.g.WaitSince}
+		}
+		st := GoroutineStat{
+			ID:         gr.g.ID,
+			Name:       goroutineRootName(gr, GroupByGoroutineSite),
+			Status:     goroutineStatusName(state.status),
+			WaitReason: s.waitReason(state.waitReason),
+			WaitSince:  state.waitSince,
+		}
+		if gr.root != nil {
+			if node := s.pb.nodes.get([]uint64{gr.root.idx}); node != nil {
+				st.Space, st.Retained = node.sampleValues[SampleSpace], node.sampleValues[SampleRetained]
+			}
+		}
+		stats = append(stats, st)
+	}
+	slices.SortFunc(stats, func(a, b GoroutineStat) int {
+		if c := cmp.Compare(b.Retained, a.Retained); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Space, a.Space); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return stats
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+func TestStackSpace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	out := runTestScenario(t, testScenario{name: "sudog", minGoMinor: minTestGoMinor}, WithSampleTypes(SampleSpace, SampleStack))
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := slices.IndexFunc(p.SampleTypes, func(vt profile.ValueType) bool { return vt.Type == "stack_space" })
+	if stack < 0 {
+		t.Fatalf("no stack_space in sample types %v", p.SampleTypes)
+	}
+	var senders int64
+	for _, s := range p.Samples {
+		if slices.Contains(s.Path, stacksRootName) && slices.ContainsFunc(s.Path, func(name string) bool {
+			return strings.HasSuffix(name, " created by main.main")
+		}) {
+			senders += s.Values[stack]
+		}
+	}
+	// the 3 blocked senders created by main.main, with at least the min stack size of 2KB
+	if senders < 3*2048 {
+		t.Errorf("got %d bytes of the sender stacks, want at least %d", senders, 3*2048)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errNoGraph = errors.New("the object graph is not recorded")
+
+// graphFormat returns whether the format outputs the object graph rather than the reference paths.
+func (f Format) graphFormat() bool {
+	return f == FormatGraph || f == FormatDOT
+}
+
+// nodeName returns the name of a root node, or the type of an object node.
+func (b *profileBuilder) nodeName(nd *retainedNode) string {
+	if nd.root {
+		return b.strings[nd.idx.idx]
+	}
+	return nd.typ
+}
+
+// edgeField returns the variable, field or element of the source node referencing the target
+// by the edge, like "buf" or "items[0]", empty if unknown, e.g. the reference is found by the GC bits.
+func (b *profileBuilder) edgeField(src *retainedNode, via *pprofIndex) string {
+	// the fields of the source are right under the root or where the object is discovered
+	var depth int32
+	if src.idx != nil {
+		depth = src.idx.depth + 1
+	}
+	var names []string
+	for ; via != nil && via.depth >= depth; via = via.prev {
+		name, _, _ := strings.Cut(b.strings[via.idx], ". (")
+		names = append(names, name)
+	}
+	var sb strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		if sb.Len() > 0 && !strings.HasPrefix(names[i], "[") {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(names[i])
+	}
+	return sb.String()
+}
+
+// graphEncoder writes the object graph as newline delimited JSON, one node or edge per line, like
+//
+//	{"node":{"id":1,"root":"main.cache"}}
+//	{"node":{"id":2,"addr":"0xc000012345","type":"main.T","size":32}}
+//	{"edge":{"from":1,"to":2,"field":"items[0]"}}
+//
+// A node is either a root or a heap object, and the edges are the references between them.
+type graphEncoder struct{}
+
+func (graphEncoder) encode(out io.Writer, b *profileBuilder) error {
+	g := b.graph
+	if g == nil {
+		return errNoGraph
+	}
+	type node struct {
+		ID   int32  `json:"id"`
+		Root string `json:"root,omitempty"`
+		Addr string `json:"addr,omitempty"`
+		Type string `json:"type,omitempty"`
+		Size int64  `json:"size,omitempty"`
+	}
+	type edge struct {
+		From  int32  `json:"from"`
+		To    int32  `json:"to"`
+		Field string `json:"field,omitempty"`
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	// keep the type names like "bucket<string,int>" readable
+	enc.SetEscapeHTML(false)
+	for id := int32(1); id < int32(len(g.nodes)); id++ {
+		nd := &g.nodes[id]
+		n := node{ID: id}
+		if nd.root {
+			n.Root = b.nodeName(nd)
+		} else {
+			n.Addr, n.Type, n.Size = "0x"+strconv.FormatUint(uint64(nd.addr), 16), nd.typ, nd.size
+		}
+		if err := enc.Encode(struct {
+			Node node `json:"node"`
+		}{n}); err != nil {
+			return err
+		}
+	}
+	for i := range g.from {
+		if g.from[i] == 0 {
+			// from the super root
+			continue
+		}
+		e := edge{From: g.from[i], To: g.to[i], Field: b.edgeField(&g.nodes[g.from[i]], g.via[i])}
+		if err := enc.Encode(struct {
+			Edge edge `json:"edge"`
+		}{e}); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// dotEncoder writes the object graph in the DOT language of Graphviz, which can also be imported by Gephi.
+type dotEncoder struct{}
+
+func (dotEncoder) encode(out io.Writer, b *profileBuilder) error {
+	g := b.graph
+	if g == nil {
+		return errNoGraph
+	}
+	w := bufio.NewWriter(out)
+	w.WriteString("digraph goref {\n")
+	for id := int32(1); id < int32(len(g.nodes)); id++ {
+		nd := &g.nodes[id]
+		if nd.root {
+			fmt.Fprintf(w, "  n%d [shape=box, label=%s];\n", id, strconv.Quote(b.nodeName(nd)))
+		} else {
+			fmt.Fprintf(w, "  n%d [label=%s, addr=\"%#x\", size=%d];\n", id, strconv.Quote(nd.typ), uint64(nd.addr), nd.size)
+		}
+	}
+	for i := range g.from {
+		if g.from[i] == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  n%d -> n%d", g.from[i], g.to[i])
+		if field := b.edgeField(&g.nodes[g.from[i]], g.via[i]); field != "" {
+			fmt.Fprintf(w, " [label=%s]", strconv.Quote(field))
+		}
+		w.WriteString(";\n")
+	}
+	w.WriteString("}\n")
+	return w.Flush()
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlushGraph(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatGraph, GroupByPath, nil)
+	g := newRetainedGraph()
+	g.export = true
+	pb.graph = g
+	s := &HeapScope{retained: g}
+
+	// main.root.items[1] -> object 1 -> next -> object 2
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	g.src, g.idx = 1, root.pushHead(pb, "items. ([]*main.T)").pushHead(pb, "[1]. (*main.T)")
+	g.nodes = append(g.nodes, retainedNode{root: true, idx: root})
+	g.addEdge(0, 1, nil)
+	s.addRetainedEdge(0x1000, 16, nil)
+	g.src, g.idx = 2, g.idx.pushHead(pb, "next. (*main.T)")
+	s.addRetainedEdge(0x2000, 32, nil)
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"node":{"id":1,"root":"main.root"}}
+{"node":{"id":2,"addr":"0x1000","type":"<unknown>","size":16}}
+{"node":{"id":3,"addr":"0x2000","type":"<unknown>","size":32}}
+{"edge":{"from":1,"to":2,"field":"items[1]"}}
+{"edge":{"from":2,"to":3,"field":"next"}}
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected graph output:\n%s\nwant:\n%s", got, want)
+	}
+}
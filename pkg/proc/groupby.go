@@ -0,0 +1,119 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// GroupBy is how the objects are aggregated in the profile.
+type GroupBy int
+
+const (
+	// GroupByPath aggregates the objects by their reference paths.
+	GroupByPath GroupBy = iota
+	// GroupByType aggregates the objects by their Go types, like a type histogram.
+	// Only the objects and space sample values are reported.
+	GroupByType
+	// GroupByGoroutine aggregates the objects by their roots, where the stack frames of a goroutine
+	// are one root, to report how much heap each goroutine keeps alive.
+	GroupByGoroutine
+	// GroupByGoroutineSite is like GroupByGoroutine, but the goroutines are aggregated by their start functions
+	// and the functions creating them.
+	GroupByGoroutineSite
+
+	numGroupBys
+)
+
+var groupByNames = [numGroupBys]string{
+	GroupByPath:          "path",
+	GroupByType:          "type",
+	GroupByGoroutine:     "goroutine",
+	GroupByGoroutineSite: "goroutine-site",
+}
+
+// String returns the name of the aggregation, which is used by the command line.
+func (g GroupBy) String() string {
+	if g < 0 || g >= numGroupBys {
+		return fmt.Sprintf("GroupBy(%d)", int(g))
+	}
+	return groupByNames[g]
+}
+
+// ParseGroupBy parses the aggregation name, like "type".
+func ParseGroupBy(s string) (GroupBy, error) {
+	s = strings.TrimSpace(s)
+	for i, name := range groupByNames {
+		if name == s {
+			return GroupBy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown group by %q", s)
+}
+
+// byGoroutine returns whether the objects are aggregated by the goroutines.
+func (g GroupBy) byGoroutine() bool {
+	return g == GroupByGoroutine || g == GroupByGoroutineSite
+}
+
+// goroutineRootName returns the name of the root of the goroutine, like
+// "goroutine 18: main.worker created by main.main", or without the goroutine ID if grouping by site.
+func goroutineRootName(gr *goroutineRoot, groupBy GroupBy) string {
+	if groupBy == GroupByGoroutineSite {
+		return gr.site
+	}
+	return fmt.Sprintf("goroutine %d: %s", gr.g.ID, gr.site)
+}
+
+// goroutineSite returns the start function and the function creating the goroutine, like
+// "main.worker created by main.main". The locations are looked up by the line tables which are
+// loaded lazily, so it's called while reading the goroutines rather than by the workers.
+func goroutineSite(gr *goroutineRoot) string {
+	site := "?"
+	if gr.startFn != nil {
+		site = gr.startFn.Name
+	}
+	if fn := gr.g.Go().Fn; fn != nil {
+		site += " created by " + fn.Name
+	}
+	return site
+}
+
+// unknownTypeName is the type of the objects which are found by the GC bits only.
+const unknownTypeName = "<unknown>"
+
+// objectTypeName returns the name which the object of typ is grouped by.
+// Arrays are grouped by their element types regardless of the lengths,
+// since most of them are the backing arrays of slices, strings and channels.
+func objectTypeName(typ godwarf.Type) string {
+	switch t := typ.(type) {
+	case *godwarf.VoidType:
+		return unknownTypeName
+	case *godwarf.ArrayType:
+		return "[]" + t.Type.String()
+	}
+	return typeName(typ)
+}
+
+// typeName returns the name of the type, like "main.T" rather than "struct main.T".
+func typeName(typ godwarf.Type) string {
+	if name := typ.Common().Name; name != "" {
+		return name
+	}
+	return typ.String()
+}
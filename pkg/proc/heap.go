@@ -15,13 +15,17 @@
 package proc
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"go/constant"
-	"math"
 	"math/bits"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-delve/delve/pkg/dwarf/godwarf"
-	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/pkg/proc"
 )
 
@@ -34,17 +38,47 @@ type spanInfo struct {
 
 	spanclass     spanClass // alloc header span
 	largeTypeAddr uint64    // for large type
+
+	// allocation state, objects before freeIndex or with alloc bit set are allocated
+	nelems    int64
+	freeIndex int64
+	allocBits []uint8
+
+	// marks of the last GC if the span is not swept yet, only read for the mark check
+	markBits []uint8
+
+	// whether the span is a user arena chunk (GOEXPERIMENT=arenas), which is scanned as a root
+	// rather than an object, and the objects in it are marked by their addresses
+	userArena bool
+
+	// guards the masks if they may be spilled, see pinMasks
+	masksMu sync.Mutex
+	// whether the masks are used since the clock of maskSpill passed the span
+	used atomic.Bool
+	// the offset of the masks in the spill file plus 1, 0 if never spilled
+	spillOff int64
 }
 
-// marks the pointer, return true of not marked before.
-func (sp *spanInfo) mark(addr Address) bool {
-	offset := addr.Sub(sp.base)
-	if sp.visitMask[offset/8/64]&(1<<(offset/8%64)) != 0 {
+// isFree reports whether the object at base is a free slot, which contains garbage.
+func (sp *spanInfo) isFree(base Address) bool {
+	if sp.allocBits == nil {
 		return false
-	} else {
-		sp.visitMask[offset/8/64] |= 1 << (offset / 8 % 64)
+	}
+	idx := base.Sub(sp.base) / sp.elemSize
+	if idx >= sp.nelems {
 		return true
 	}
+	if idx < sp.freeIndex {
+		return false
+	}
+	return sp.allocBits[idx/8]&(1<<(idx%8)) == 0
+}
+
+// marks the pointer, return true of not marked before.
+func (sp *spanInfo) mark(addr Address) bool {
+	offset := addr.Sub(sp.base)
+	bit := uint64(1) << (offset / 8 % 64)
+	return setBits(&sp.visitMask[offset/8/64], bit)&bit == 0
 }
 
 func (sp *spanInfo) elemEnd(base Address) Address {
@@ -67,13 +101,10 @@ func (s *segment) init(start, end Address, ptrMask []uint64) {
 
 func (s *segment) mark(addr Address) (success bool) {
 	if addr >= s.base && addr < s.end {
+		// shared by the workers
 		offset := addr.Sub(s.base)
-		if s.visitMask[offset/8/64]&(1<<(offset/8%64)) != 0 {
-			return false
-		} else {
-			s.visitMask[offset/8/64] |= 1 << (offset / 8 % 64)
-			return true
-		}
+		bit := uint64(1) << (offset / 8 % 64)
+		return setBits(&s.visitMask[offset/8/64], bit)&bit == 0
 	}
 	return false
 }
@@ -99,6 +130,9 @@ func (ss segments) mark(addr Address) (success bool, seg *segment) {
 type framePointerMask struct {
 	gcMaskBitIterator
 	funcName string
+	// the function and the pc of the frame, nil and 0 if the frames are not unwound
+	fn *proc.Function
+	pc uint64
 }
 
 type stack struct {
@@ -130,8 +164,14 @@ type funcExtra struct {
 	closureStructType *godwarf.StructType // closure struct type only support go 1.23 and later
 }
 
+// check the context every ctxCheckInterval objects, since it takes a lock
+const ctxCheckInterval = 1024
+
 // HeapScope contains the proc info for this round of scanning.
 type HeapScope struct {
+	// widths of the runtime struct fields of the go version
+	layout *runtimeLayout
+
 	// runtime constants
 	pageSize        int64
 	heapArenaBytes  int64
@@ -149,6 +189,10 @@ type HeapScope struct {
 
 	// arena info map
 	arenaInfo []*[]*[]*spanInfo
+	// all in-use spans
+	spans []*spanInfo
+	// the in-use user arena chunks, which are also in spans
+	userArenas []*spanInfo
 
 	finalizers []finalizer
 
@@ -157,22 +201,77 @@ type HeapScope struct {
 	mem   proc.MemoryReadWriter
 	bi    *proc.BinaryInfo
 	scope *proc.EvalScope
-
-	finalMarks []finalMarkParam
+	// the runtime read without DWARF if the executable is stripped, nil otherwise, see scanStripped
+	stripped *strippedRuntime
 
 	funcExtraMap map[*proc.Function]funcExtra
+
+	// runtime types cache, key: type address
+	rtypes map[Address]*runtimeType
+	// the GC layouts of the types in the allocation headers, key: type address
+	gcTypesMu sync.RWMutex
+	gcTypes   map[Address]*gcType
+
+	// watches the memory usage of goref itself
+	guard *memoryGuard
+	// spills the masks of the spans beyond the max ram, nil if not limited
+	masks *maskSpill
+
+	// cross-checks the reached objects against the GC marks, nil if disabled
+	markCheck *markChecker
+
+	// the object graph to compute the retained size, nil if disabled
+	retained *retainedGraph
+
+	// serializes reading types from DWARF and the caches of types,
+	// which are not safe for concurrent use
+	typesMu sync.Mutex
+	// the stack frames whose local variables are not read by function, guarded by typesMu
+	skipped map[string]*SkippedFrame
+	// the shape types of unique.uniqueMap by the type names of their elements, guarded by typesMu
+	uniqueMapTypes map[string]godwarf.Type
+
+	// readHeap stops early once ctx is done
+	canceler
+
+	// the number of the parts of the scanning skipped on panics, see recoverScan
+	panics atomic.Int64
+
+	// bounds of the stack frames to scan, nil if the architecture is unsupported
+	frameBounds frameBoundsFunc
+
+	// reports the progress of the scanning, nil if not requested
+	progress *progressReporter
+
+	// the number of workers reading the heap
+	parallelism int
+
+	logger Logger
 }
 
-func (s *HeapScope) readHeap() error {
-	rdr := s.bi.Images[0].DwarfReader()
-	if rdr == nil {
-		return errors.New("error dwarf reader is nil")
+func (s *HeapScope) readHeap(ctx context.Context) (err error) {
+	// the spans are skipped on panic respectively, but the heap can't be scanned without the rest
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Warnf("%s", debug.Stack())
+			err = fmt.Errorf("read the heap: %w", panicError(r))
+		}
+	}()
+	s.ctx = ctx
+	if s.stripped != nil {
+		s.layout = s.stripped.layout
+	} else {
+		if rdr := s.bi.Images[0].DwarfReader(); rdr == nil {
+			return errors.New("error dwarf reader is nil")
+		}
+		if s.layout, err = newRuntimeLayout(s.bi.Producer(), s.bi.Arch.PtrSize()); err != nil {
+			return err
+		}
 	}
-	tmp, err := s.scope.EvalExpression("runtime.mheap_", loadSingleValue)
+	mheap, err := s.runtimeVariable("runtime.mheap_")
 	if err != nil {
 		return err
 	}
-	mheap := toRegion(tmp, s.bi)
 	// read runtime constants
 	s.pageSize = s.rtConstant("_PageSize")
 	spanInUse := uint8(s.rtConstant("_MSpanInUse"))
@@ -186,8 +285,14 @@ func (s *HeapScope) readHeap() error {
 	s.arenaL1Bits, s.arenaL2Bits = s.rtConstant("arenaL1Bits"), s.rtConstant("arenaL2Bits")
 	s.minSizeForMallocHeader = s.rtConstant("minSizeForMallocHeader")
 
+	s.readMarkState(mheap)
+
 	// start read all spans
 	spans, spanInfos := s.readAllSpans(mheap.Field("allspans").Array(), spanInUse, kindSpecialFinalizer)
+	s.spans = spanInfos
+	if s.done() {
+		return s.ctx.Err()
+	}
 
 	// start read arenas
 	if !s.readArenas(mheap) {
@@ -199,98 +304,251 @@ func (s *HeapScope) readHeap() error {
 	return s.readModuleData()
 }
 
+// canceler checks whether the context of the scanning is done.
+type canceler struct {
+	ctx      context.Context
+	ticks    int
+	canceled bool
+}
+
+// done reports whether ctx is done, ctx is checked every ctxCheckInterval calls.
+func (c *canceler) done() bool {
+	if c.canceled || c.ctx == nil {
+		return c.canceled
+	}
+	if c.ticks++; c.ticks < ctxCheckInterval {
+		return false
+	}
+	c.ticks = 0
+	c.canceled = c.ctx.Err() != nil
+	return c.canceled
+}
+
 func (s *HeapScope) readAllSpans(allspans *region, spanInUse, kindSpecialFinalizer uint8) (spans []*region, spanInfos []*spanInfo) {
-	// read all spans
+	// read all spans, which are independent of each other, then add them in the order of allspans
 	n := allspans.ArrayLen()
-	to := &region{}
-	for i := int64(0); i < n; i++ {
-		allspans.ArrayIndex(i, to)
-		sp := to.Deref()
-		base := Address(sp.Field("startAddr").Uintptr())
-		elemSize := int64(sp.Field("elemsize").Uintptr())
-		spanSize := int64(sp.Field("npages").Uintptr()) * s.pageSize
-		st := sp.Field("state")
-		if st.IsStruct() && st.HasField("s") { // go1.14+
-			st = st.Field("s")
-		}
-		if st.IsStruct() && st.HasField("value") { // go1.20+
-			st = st.Field("value")
-		}
-		if st.Uint8() != spanInUse {
-			continue
-		}
-		maskLen := CeilDivide(spanSize/8, 64)
-		spi := &spanInfo{
-			base: base, elemSize: elemSize, spanSize: spanSize,
-			visitMask: make([]uint64, maskLen), ptrMask: make([]uint64, maskLen),
-		}
-		max := base.Add(spanSize)
-		for addr := base; addr < max; addr = addr.Add(s.pageSize) {
-			s.allocSpan(addr, spi)
+	s.progress.report("spans", 0, int(n))
+	spty, _ := s.runtimeType("runtime.specialfinalizer")
+	read := make([]spanRead, n)
+	var (
+		mu       sync.Mutex // serializes the progress reports
+		finished int
+	)
+	s.parallelRead("spans", int(n), func(i int) bool {
+		to := &region{}
+		allspans.ArrayIndex(int64(i), to)
+		read[i] = s.readSpan(to, spanInUse, kindSpecialFinalizer, spty)
+		mu.Lock()
+		if finished++; finished%spansReportInterval == 0 || finished == int(n) {
+			s.progress.report("spans", finished, int(n))
 		}
-		if err := s.addSpecial(sp, spi, kindSpecialFinalizer); err != nil {
-			logflags.DebuggerLogger().Errorf("%v", err)
+		mu.Unlock()
+		return true
+	})
+	for i := range read {
+		if r := &read[i]; r.spi != nil {
+			s.addSpan(r)
+			// for go 1.22 with allocation header
+			spans = append(spans, r.sp)
+			spanInfos = append(spanInfos, r.spi)
 		}
-		// for go 1.22 with allocation header
-		spans = append(spans, sp)
-		spanInfos = append(spanInfos, spi)
 	}
 	return
 }
 
+// spanRead is an in-use span read by readSpan, which is added to the scope by addSpan.
+type spanRead struct {
+	sp         *region
+	spi        *spanInfo
+	finalizers []finalizer
+}
+
+// readSpan reads the span which spanPtr points to, spi is nil if it's not in use.
+// It only reads the target, so the spans can be read concurrently.
+func (s *HeapScope) readSpan(spanPtr *region, spanInUse, kindSpecialFinalizer uint8, spty godwarf.Type) (r spanRead) {
+	sp := spanPtr.Deref()
+	base := Address(sp.Field("startAddr").Uintptr())
+	elemSize := int64(sp.Field("elemsize").Uintptr())
+	spanSize := int64(sp.Field("npages").Uintptr()) * s.pageSize
+	st := sp.Field("state")
+	if st.IsStruct() && st.HasField("s") { // go1.14+
+		st = st.Field("s")
+	}
+	if st.IsStruct() && st.HasField("value") { // go1.20+
+		st = st.Field("value")
+	}
+	if st.Uint8() != spanInUse {
+		return
+	}
+	spi := &spanInfo{base: base, elemSize: elemSize, spanSize: spanSize}
+	if s.masks == nil {
+		// otherwise allocated when the span is used first
+		maskLen := CeilDivide(spanSize/8, 64)
+		spi.visitMask, spi.ptrMask = make([]uint64, maskLen), make([]uint64, maskLen)
+	}
+	if sp.HasField("isUserArenaChunk") && sp.Field("isUserArenaChunk").Bool() {
+		spc, err := s.layout.uint(sp, "spanclass")
+		if err != nil || spanClass(spc).noscan() {
+			// a freed chunk is set to noscan and faulted, the pointers to it are dangling
+			return
+		}
+		spi.userArena = true
+	}
+	s.readAllocBits(sp, spi)
+	s.readMarkBits(sp, spi)
+	fins, err := s.readFinalizers(sp, spi, kindSpecialFinalizer, spty)
+	if err != nil {
+		s.logger.Errorf("%v", err)
+	}
+	return spanRead{sp: sp, spi: spi, finalizers: fins}
+}
+
+// addSpan adds the span read completely to the scope.
+func (s *HeapScope) addSpan(r *spanRead) {
+	spi := r.spi
+	if spi.userArena {
+		s.userArenas = append(s.userArenas, spi)
+	}
+	s.finalizers = append(s.finalizers, r.finalizers...)
+	max := spi.base.Add(spi.spanSize)
+	for addr := spi.base; addr < max; addr = addr.Add(s.pageSize) {
+		s.allocSpan(addr, spi)
+	}
+}
+
+// readAllocBits reads the allocation state of objects in the span.
+func (s *HeapScope) readAllocBits(sp *region, spi *spanInfo) {
+	if spi.elemSize <= 0 || !sp.HasField("allocBits") || !sp.HasField("nelems") {
+		return
+	}
+	nelems_, err := s.layout.uint(sp, "nelems")
+	if err != nil {
+		s.logger.Warnf("read alloc bits error: %v", err)
+		return
+	}
+	nelems := int64(nelems_)
+	if nelems <= 1 {
+		// large object span
+		return
+	}
+	spi.nelems = nelems
+	freeIndex := "freeindex"
+	if sp.HasField("freeIndexForScan") { // go1.20+
+		freeIndex = "freeIndexForScan"
+	}
+	if !sp.HasField(freeIndex) {
+		return
+	}
+	freeIndex_, err := s.layout.uint(sp, freeIndex)
+	if err != nil {
+		s.logger.Warnf("read alloc bits error: %v", err)
+		return
+	}
+	spi.freeIndex = int64(freeIndex_)
+	if spi.freeIndex >= nelems {
+		// all objects are allocated
+		return
+	}
+	allocBits := sp.Field("allocBits").Address()
+	if allocBits == 0 {
+		return
+	}
+	bits := make([]uint8, CeilDivide(nelems, 8))
+	if _, err := s.mem.ReadMemory(bits, uint64(allocBits)); err != nil {
+		s.logger.Warnf("read alloc bits error: %v", err)
+		return
+	}
+	spi.allocBits = bits
+}
+
 func (s *HeapScope) heapBitsInSpan(elemSize int64) bool {
 	return elemSize <= s.minSizeForMallocHeader
 }
 
 func (s *HeapScope) readTypePointers(spans []*region, spanInfos []*spanInfo) {
-	for i, sp := range spans {
-		spi := spanInfos[i]
-		spc := spanClass(sp.Field("spanclass").Uint8())
+	s.parallelRead("type pointers", len(spans), func(i int) bool {
+		sp, spi := spans[i], spanInfos[i]
+		spc_, err := s.layout.uint(sp, "spanclass")
+		if err != nil {
+			s.logger.Errorf("read span class error: %v", err)
+			return false
+		}
+		spc := spanClass(spc_)
 		spi.spanclass = spc
 		if spc.noscan() {
-			continue
+			return true
 		}
 		if s.heapBitsInSpan(spi.elemSize) {
-			bitmapSize := spi.spanSize / 8 / 8
-			readUint64Array(s.mem, uint64(spi.base.Add(spi.spanSize-bitmapSize)), spi.ptrMask)
-			continue
+			if s.masks == nil {
+				// otherwise read when the span is used first, see initMasks
+				bitmapSize := spi.spanSize / 8 / 8
+				readUint64Array(s.mem, uint64(spi.base.Add(spi.spanSize-bitmapSize)), spi.ptrMask)
+			}
+			return true
 		}
 		// with alloc headers
 		if spc.sizeclass() == 0 {
 			largeTypeAddr := sp.Field("largeType").Address()
 			spi.largeTypeAddr = uint64(largeTypeAddr)
 		}
-	}
+		return true
+	})
 }
 
+// arenaChunkLen is the number of the entries of a level 2 arena table read at once.
+const arenaChunkLen = 4096
+
 func (s *HeapScope) readArenas(mheap *region) (success bool) {
 	arenaSize := s.rtConstant("heapArenaBytes")
 	level1Table := mheap.Field("arenas")
 	level1size := level1Table.ArrayLen()
 	to := &region{}
 	var readBitmapFunc func(heapArena *region, min Address)
+	// the level 2 tables are large and sparse, e.g. 4M entries on linux/amd64, so they are read by chunks,
+	// and the bitmaps are read in parallel. The bitmap of an arena only sets the pointer bits of its own
+	// 64MB, which never share a word of the masks with another arena.
+	type chunk struct {
+		table         *region
+		level1, start int64
+	}
+	var chunks []chunk
 	for level1 := int64(0); level1 < level1size; level1++ {
 		level1Table.ArrayIndex(level1, to)
 		if to.Address() == 0 {
 			continue
 		}
 		level2table := to.Deref()
-		level2size := level2table.ArrayLen()
-		for level2 := int64(0); level2 < level2size; level2++ {
-			level2table.ArrayIndex(level2, to)
-			if to.Address() == 0 {
-				continue
+		if readBitmapFunc == nil {
+			heapArenaType := resolveTypedef(level2table.ArrayElemType()).(*godwarf.PtrType).Type
+			if readBitmapFunc = s.readBitmapFunc(&region{bi: s.bi, typ: resolveTypedef(heapArenaType)}); readBitmapFunc == nil {
+				return false
 			}
-			heapArena := to.Deref()
-			min := Address(arenaSize*(level2+level1*level2size) - s.arenaBaseOffset)
-			if readBitmapFunc == nil {
-				if readBitmapFunc = s.readBitmapFunc(heapArena); readBitmapFunc == nil {
-					return false
-				}
-			}
-			readBitmapFunc(heapArena, min)
+		}
+		for start := int64(0); start < level2table.ArrayLen(); start += arenaChunkLen {
+			chunks = append(chunks, chunk{table: level2table, level1: level1, start: start})
 		}
 	}
+	s.parallelRead("arenas", len(chunks), func(i int) bool {
+		c := chunks[i]
+		level2size := c.table.ArrayLen()
+		to := &region{}
+		c.table.ArrayIndex(c.start, to)
+		ptrs := make([]uint64, min(arenaChunkLen, level2size-c.start))
+		if err := readUint64Array(s.mem, uint64(to.a), ptrs); err != nil {
+			s.logger.Warnf("read heap arenas error: %v", err)
+			return true
+		}
+		for j, ptr := range ptrs {
+			if ptr == 0 {
+				continue
+			}
+			level2 := c.start + int64(j)
+			c.table.ArrayIndex(level2, to)
+			min := Address(arenaSize*(level2+c.level1*level2size) - s.arenaBaseOffset)
+			readBitmapFunc(to.Deref(), min)
+		}
+		return true
+	})
 	return true
 }
 
@@ -316,6 +574,35 @@ func (s *HeapScope) readBitmapFunc(heapArena *region) func(heapArena *region, mi
 	}
 }
 
+// visitObject marks the object at base in the span, and returns the iterator of its pointers from its real
+// base past the allocation header, or nil if it's marked already.
+func (s *HeapScope) visitObject(sp *spanInfo, base Address) *gcMaskBitIterator {
+	s.pinMasks(sp)
+	defer s.unpinMasks(sp)
+	if !sp.mark(base) {
+		return nil
+	}
+	realBase := s.copyGCMask(sp, base)
+	return s.gcBits(sp, realBase, sp.elemEnd(base))
+}
+
+// markSpan marks the address in the span like spanInfo.mark.
+func (s *HeapScope) markSpan(sp *spanInfo, addr Address) bool {
+	s.pinMasks(sp)
+	defer s.unpinMasks(sp)
+	return sp.mark(addr)
+}
+
+// gcBits returns the iterator of the pointers in [base, end) of the span. If the masks may be spilled, the
+// iterator copies the words of the range, which are not used by the others once the object is marked.
+func (s *HeapScope) gcBits(sp *spanInfo, base, end Address) *gcMaskBitIterator {
+	if s.masks == nil {
+		return newGCBitsIterator(base, end, sp.base, sp.ptrMask)
+	}
+	first, last := base.Sub(sp.base)/8/64, min(CeilDivide(end.Sub(sp.base)/8, 64), int64(len(sp.ptrMask)))
+	return newGCBitsIterator(base, end, sp.base.Add(first*8*64), slices.Clone(sp.ptrMask[first:last]))
+}
+
 // base must be the base address of an object in then span
 func (s *HeapScope) copyGCMask(sp *spanInfo, base Address) Address {
 	if !s.enableAllocHeader {
@@ -327,55 +614,69 @@ func (s *HeapScope) copyGCMask(sp *spanInfo, base Address) Address {
 	if s.heapBitsInSpan(sp.elemSize) {
 		return base
 	}
+	typeAddr, realBase := s.headerType(sp, base)
+	s.readType(sp, typeAddr, realBase, sp.elemEnd(base))
+	return realBase
+}
+
+// headerType returns the type recorded by the allocation header of the object at base, and the address
+// where the object data starts. The span must have alloc headers, i.e. neither noscan nor heap bits in span.
+func (s *HeapScope) headerType(sp *spanInfo, base Address) (typeAddr, realBase Address) {
 	if sp.spanclass.sizeclass() != 0 {
 		// alloc type in header
 		typeAddr, _ := readUintRaw(s.mem, uint64(base), 8)
-		s.readType(sp, Address(typeAddr), base.Add(8), sp.elemEnd(base))
-		return base.Add(8)
-	} else {
-		// large type
-		s.readType(sp, Address(sp.largeTypeAddr), base, sp.elemEnd(base))
-		return base
+		return Address(typeAddr), base.Add(8)
 	}
+	// large type
+	return Address(sp.largeTypeAddr), base
 }
 
-func (s *HeapScope) readType(sp *spanInfo, typeAddr, addr, end Address) {
-	var typeSize, ptrBytes int64
-	var gcDataAddr Address
-	mem := cacheMemory(s.mem, uint64(typeAddr), int(gcDataOffset+8))
-	if typeSize_, err := readUintRaw(mem, uint64(typeAddr.Add(sizeOffset)), 8); err != nil || typeSize_ == 0 {
-		return
-	} else {
-		typeSize = int64(typeSize_)
+// allocTypeName returns the name of the object at base by the type in its allocation header, for the objects
+// found by the GC bits only, unknownTypeName if the object has no header. Like objectTypeName, the objects
+// holding more than one element of the type, e.g. the backing arrays of slices, are named like "[]T".
+func (s *HeapScope) allocTypeName(sp *spanInfo, base Address) string {
+	if !s.enableAllocHeader || sp.spanclass.noscan() || s.heapBitsInSpan(sp.elemSize) {
+		return unknownTypeName
 	}
-	if ptrBytes_, err := readUintRaw(mem, uint64(typeAddr.Add(ptrBytesOffset)), 8); err != nil || ptrBytes_ == 0 {
-		return
-	} else {
-		ptrBytes = int64(ptrBytes_)
+	typeAddr, realBase := s.headerType(sp, base)
+	if typeAddr == 0 {
+		return unknownTypeName
+	}
+	rt, err := s.readRuntimeType(typeAddr)
+	if err != nil || rt.size <= 0 || rt.name == "" {
+		return unknownTypeName
+	}
+	if sp.elemEnd(base).Sub(realBase)/rt.size > 1 {
+		return "[]" + rt.name
 	}
-	if gcDataAddr_, err := readUintRaw(mem, uint64(typeAddr.Add(gcDataOffset)), 8); err != nil {
+	return rt.name
+}
+
+// gcType is the GC layout of a runtime type, which is read once for all the objects of the type.
+type gcType struct {
+	size, ptrBytes int64
+	// the pointer bits of an element from its gcdata, a word per 64 pointers up to ptrBytes
+	mask []uint64
+}
+
+// readType copies the pointer bits of the objects of the type at typeAddr in [addr, end) to the span.
+func (s *HeapScope) readType(sp *spanInfo, typeAddr, addr, end Address) {
+	gt := s.gcType(typeAddr)
+	if gt == nil {
 		return
-	} else {
-		gcDataAddr = Address(gcDataAddr_)
-		bLen := int(math.Ceil(float64(ptrBytes)/512)) * 512
-		mem = cacheMemory(s.mem, uint64(gcDataAddr), bLen/64)
 	}
 	elem := addr
 	for {
-		if addr >= elem.Add(ptrBytes) {
+		if addr >= elem.Add(gt.ptrBytes) {
 			// No more ptrs, copy the next element.
 			// Maybe overflow beyond the real object, but doesn't affect the correctness.
-			elem = elem.Add(typeSize)
+			elem = elem.Add(gt.size)
 			addr = elem
 		}
 		if addr >= end {
 			break
 		}
-		mask, err := readUintRaw(mem, uint64(gcDataAddr.Add(addr.Sub(elem)/64)), 8)
-		if err != nil {
-			logflags.DebuggerLogger().Warnf("read gc data addr error: %v", err)
-			break
-		}
+		mask := gt.mask[addr.Sub(elem)/8/64]
 		var headBits int64
 		if addr.Add(8*64) > end {
 			headBits = (end.Sub(addr)) / 8
@@ -384,16 +685,56 @@ func (s *HeapScope) readType(sp *spanInfo, typeAddr, addr, end Address) {
 		offset := addr.Sub(sp.base)
 		idx := offset / 8 / 64
 		bit := offset / 8 % 64
-		sp.ptrMask[idx] |= mask << bit
+		setBits(&sp.ptrMask[idx], mask<<bit)
 		if idx+1 < int64(len(sp.ptrMask)) {
 			// copy remaining mask to next
-			sp.ptrMask[idx+1] |= mask >> (64 - bit)
+			setBits(&sp.ptrMask[idx+1], mask>>(64-bit))
 		}
 		// next
 		addr = addr.Add(8 * 64)
 	}
 }
 
+// gcType returns the GC layout of the type at typeAddr, nil if it has no pointers or can't be read.
+func (s *HeapScope) gcType(typeAddr Address) *gcType {
+	s.gcTypesMu.RLock()
+	gt, ok := s.gcTypes[typeAddr]
+	s.gcTypesMu.RUnlock()
+	if ok {
+		return gt
+	}
+	gt = s.readGCType(typeAddr)
+	s.gcTypesMu.Lock()
+	if s.gcTypes == nil {
+		s.gcTypes = make(map[Address]*gcType)
+	}
+	s.gcTypes[typeAddr] = gt
+	s.gcTypesMu.Unlock()
+	return gt
+}
+
+func (s *HeapScope) readGCType(typeAddr Address) *gcType {
+	mem := cacheMemory(s.mem, uint64(typeAddr), int(gcDataOffset+8))
+	typeSize, err := readUintRaw(mem, uint64(typeAddr.Add(sizeOffset)), 8)
+	if err != nil || typeSize == 0 {
+		return nil
+	}
+	ptrBytes, err := readUintRaw(mem, uint64(typeAddr.Add(ptrBytesOffset)), 8)
+	if err != nil || ptrBytes == 0 {
+		return nil
+	}
+	gcDataAddr, err := readUintRaw(mem, uint64(typeAddr.Add(gcDataOffset)), 8)
+	if err != nil {
+		return nil
+	}
+	gt := &gcType{size: int64(typeSize), ptrBytes: int64(ptrBytes), mask: make([]uint64, CeilDivide(int64(ptrBytes), 8*64))}
+	if err = readUint64Array(s.mem, gcDataAddr, gt.mask); err != nil {
+		s.logger.Warnf("read gc data addr error: %v", err)
+		return nil
+	}
+	return gt
+}
+
 // Read a one-bit bitmap (Go 1.20+), recording the heap pointers.
 func (s *HeapScope) readOneBitBitmap(bitmap *region, min Address) {
 	n := bitmap.ArrayLen()
@@ -476,13 +817,29 @@ func (s *HeapScope) allocSpan(addr Address, sp *spanInfo) {
 	}
 }
 
+// findSpanAndBase finds the in-use span and the object base of addr. Only in-use spans are recorded,
+// so addresses in freed or released pages are rejected. On a live process, the heap may change after
+// the spans were read, so also reject the addresses which don't belong to an allocated object.
 func (s *HeapScope) findSpanAndBase(addr Address) (sp *spanInfo, base Address) {
 	sp = s.spanOf(addr)
 	if sp == nil {
 		return
 	}
+	spanEnd := sp.base.Add(sp.spanSize)
+	if addr < sp.base || addr >= spanEnd || sp.elemSize <= 0 {
+		// the page was reused by another span after snapshotting
+		return nil, 0
+	}
 	offset := addr.Sub(sp.base)
 	base = sp.base.Add(offset / sp.elemSize * sp.elemSize)
+	if base.Add(sp.elemSize) > spanEnd {
+		// in the tail waste of the span, there is no object
+		return nil, 0
+	}
+	if sp.isFree(base) {
+		// a free slot, the pointer is stale
+		return nil, 0
+	}
 	return
 }
 
@@ -491,6 +848,8 @@ func (s *HeapScope) setHeapPtr(a Address) {
 	if sp == nil {
 		return
 	}
+	s.pinMasks(sp)
+	defer s.unpinMasks(sp)
 	offset := a.Sub(sp.base)
 	sp.ptrMask[offset/8/64] |= uint64(1) << (offset / 8 % 64)
 }
@@ -514,11 +873,10 @@ func (s *HeapScope) arenaIndex(p uintptr) uint {
 }
 
 func (s *HeapScope) readModuleData() error {
-	tmp, err := s.scope.EvalExpression("runtime.firstmoduledata", loadSingleValue)
+	firstmoduledata, err := s.runtimeVariable("runtime.firstmoduledata")
 	if err != nil {
 		return err
 	}
-	firstmoduledata := toRegion(tmp, s.bi)
 
 	for md := firstmoduledata; md.a != 0; md = md.Field("next").Deref() {
 		if data := s.parseSegment("data", md); data != nil && data.base != 0 {
@@ -541,7 +899,7 @@ func (s *HeapScope) parseSegment(name string, md *region) *segment {
 	data := make([]byte, int(ptrNum/8))
 	_, err := s.mem.ReadMemory(data, uint64(gcmask))
 	if err != nil {
-		logflags.DebuggerLogger().Errorf("read gc data mask error: %v", err)
+		s.logger.Errorf("read gc data mask error: %v", err)
 	}
 	for i, mask := range data {
 		// convert to 64-bit mask
@@ -551,11 +909,53 @@ func (s *HeapScope) parseSegment(name string, md *region) *segment {
 	return &seg
 }
 
+// frameBoundsFunc returns the memory range [lo, hi) of the frame to scan conservatively.
+type frameBoundsFunc func(fr *proc.Stackframe) (lo, hi Address)
+
+// stackFrameArchs are the architectures supporting the stack root scanning, keyed by GOARCH.
+// Supporting a new architecture only requires describing the bounds of its frames here.
+// The 32-bit architectures like 386 are not supported, since the scanning assumes 8-byte pointers,
+// nor the big-endian ones like ppc64 and s390x, since the memory is read as little-endian, see checkCompat.
+// riscv64 is described ahead of delve, which can not load its executables yet.
+var stackFrameArchs = map[string]frameBoundsFunc{
+	"amd64": frameBaseBounds,
+	"arm64": frameBaseBounds,
+	// the fixed frame area at the bottom of a frame holds the saved link register
+	// and the reserved words of the ABI, which never point to the heap
+	"ppc64le": fixedFrameBounds(32),
+	"riscv64": fixedFrameBounds(8),
+}
+
+// frameBaseBounds returns [SP, frame base), the frame base is the CFA which is
+// right above the locals, arguments spilled by the callee and the saved frame pointer.
+func frameBaseBounds(fr *proc.Stackframe) (lo, hi Address) {
+	return Address(fr.Regs.SP()), Address(fr.Regs.FrameBase)
+}
+
+// fixedFrameBounds returns the bounds of the architecture with the fixed frame area of minFrameSize
+// bytes, i.e. FixedFrameSize of the Go compiler, which is [SP+minFrameSize, frame base).
+func fixedFrameBounds(minFrameSize int64) frameBoundsFunc {
+	return func(fr *proc.Stackframe) (lo, hi Address) {
+		return Address(fr.Regs.SP()).Add(minFrameSize), Address(fr.Regs.FrameBase)
+	}
+}
+
+// initFrameBounds selects how to scan the stack frames for the architecture of the target.
+func (s *HeapScope) initFrameBounds() {
+	arch := s.bi.Arch.Name
+	if s.frameBounds = stackFrameArchs[arch]; s.frameBounds == nil {
+		s.logger.Printf("stack root scanning unsupported on %s; results will miss stack-held memory\n", arch)
+	}
+}
+
 // Support for stackmap greatly couples the underlying implementation of go runtime,
 // which is extremely complex to handle and is not conducive to the maintenance of the project.
 // Therefore, Goref adopts a conservative scanning scheme.
 // NOTE: This may lead to scanning an additional portion of memory.
 func (s *HeapScope) stackPtrMask(start, end Address, frames []proc.Stackframe) []*framePointerMask {
+	if s.frameBounds == nil {
+		return nil
+	}
 	var frPtrMasks []*framePointerMask
 	for i := range frames {
 		pc := frames[i].Regs.PC()
@@ -563,8 +963,7 @@ func (s *HeapScope) stackPtrMask(start, end Address, frames []proc.Stackframe) [
 		if fn == nil {
 			continue
 		}
-		sp := Address(frames[i].Regs.SP())
-		fp := Address(frames[i].Regs.FrameBase)
+		sp, fp := s.frameBounds(&frames[i])
 		if fp <= sp || fp > end || sp < start {
 			// invalid frame pointer
 			continue
@@ -575,38 +974,71 @@ func (s *HeapScope) stackPtrMask(start, end Address, frames []proc.Stackframe) [
 		}
 		frPtrMasks = append(frPtrMasks, &framePointerMask{
 			funcName:          fn.Name,
+			fn:                fn,
+			pc:                pc,
 			gcMaskBitIterator: *newGCBitsIterator(sp, fp, sp, ptrMask),
 		})
 	}
 	return frPtrMasks
 }
 
+// unwoundStackMask returns the mask scanning the stack above the frames conservatively, for the
+// frames not unwound, nil if none or the architecture is unsupported.
+func (s *HeapScope) unwoundStackMask(lo, hi Address, frames []*framePointerMask) *framePointerMask {
+	if s.frameBounds == nil {
+		return nil
+	}
+	start := lo
+	for _, fr := range frames {
+		start = max(start, fr.end)
+	}
+	if start >= hi {
+		return nil
+	}
+	ptrMask := make([]uint64, CeilDivide(hi.Sub(start)/8, 64))
+	for i := range ptrMask {
+		ptrMask[i] = ^uint64(0)
+	}
+	return &framePointerMask{funcName: unwindFailed, gcMaskBitIterator: *newGCBitsIterator(start, hi, start, ptrMask)}
+}
+
 type finalizer struct {
 	p  Address // finalized pointer
 	fn Address // finalizer function, always 8 bytes
 }
 
-func (s *HeapScope) addSpecial(sp *region, spi *spanInfo, kindSpecialFinalizer uint8) error {
+// readFinalizers reads the finalizers of the objects in the span from its special records.
+func (s *HeapScope) readFinalizers(sp *region, spi *spanInfo, kindSpecialFinalizer uint8, spty godwarf.Type) (fins []finalizer, err error) {
 	// Process special records.
-	spty, _ := findType(s.bi, "runtime.specialfinalizer")
 	for special := sp.Field("specials"); special.Address() != 0; special = special.Field("next") {
 		special = special.Deref() // *special to special
-		if special.Field("kind").Uint8() != kindSpecialFinalizer {
+		kind, err := s.layout.uint(special, "kind")
+		if err != nil {
+			return fins, err
+		}
+		if uint8(kind) != kindSpecialFinalizer {
 			// All other specials (just profile records) can't point into the heap.
 			continue
 		}
+		offset, err := s.layout.uint(special, "offset")
+		if err != nil {
+			return fins, err
+		}
 		var fin finalizer
-		p := spi.base.Add(int64(special.Field("offset").Uint16()) / spi.elemSize * spi.elemSize)
+		p := spi.base.Add(int64(offset) / spi.elemSize * spi.elemSize)
 		fin.p = p
 		spf := *special
 		spf.typ = spty
 		fin.fn = spf.Field("fn").a
-		s.finalizers = append(s.finalizers, fin)
+		fins = append(fins, fin)
 	}
-	return nil
+	return fins, nil
 }
 
 func (s *HeapScope) getArenaBaseOffset() int64 {
+	if s.stripped != nil {
+		return s.stripped.constants["arenaBaseOffset"]
+	}
 	x, _ := s.scope.EvalExpression("runtime.arenaBaseOffsetUintptr", loadSingleValue)
 	// arenaBaseOffset changed sign in 1.15. Callers treat this
 	// value as it was specified in 1.14, so we negate it here.
@@ -615,6 +1047,9 @@ func (s *HeapScope) getArenaBaseOffset() int64 {
 }
 
 func (s *HeapScope) rtConstant(name string) int64 {
+	if s.stripped != nil {
+		return s.stripped.constants[name]
+	}
 	x, _ := s.scope.EvalExpression("runtime."+name, loadSingleValue)
 	if x != nil {
 		v, _ := constant.Int64Val(x.Value)
@@ -622,3 +1057,26 @@ func (s *HeapScope) rtConstant(name string) int64 {
 	}
 	return 0
 }
+
+// runtimeVariable returns the package variable of the runtime like "runtime.mheap_".
+func (s *HeapScope) runtimeVariable(name string) (*region, error) {
+	if s.stripped != nil {
+		return s.stripped.variable(s.mem, s.bi, name)
+	}
+	tmp, err := s.scope.EvalExpression(name, loadSingleValue)
+	if err != nil {
+		return nil, err
+	}
+	return toRegion(tmp, s.bi), nil
+}
+
+// runtimeType returns the runtime struct like "runtime.specialfinalizer".
+func (s *HeapScope) runtimeType(name string) (godwarf.Type, error) {
+	if s.stripped != nil {
+		if typ, ok := s.stripped.types[name]; ok {
+			return typ, nil
+		}
+		return nil, fmt.Errorf("%s is not read without DWARF", name)
+	}
+	return findType(s.bi, name)
+}
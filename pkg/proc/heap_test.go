@@ -14,7 +14,14 @@
 
 package proc
 
-import "testing"
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/op"
+	"github.com/go-delve/delve/pkg/proc"
+)
 
 func TestHeapBits(t *testing.T) {
 	hb := newGCBitsIterator(0, 1024, 0, make([]uint64, 2))
@@ -37,3 +44,107 @@ func TestHeapBits(t *testing.T) {
 		}
 	}
 }
+
+func TestReadTypeCached(t *testing.T) {
+	// a 16 bytes type with a pointer at the start, and its gcdata
+	const typeAddr, gcDataAddr = 0x1000, 0x1800
+	fake := &fakeMemory{base: 0x1000, data: make([]byte, 0x1000)}
+	binary.LittleEndian.PutUint64(fake.data[sizeOffset:], 16)
+	binary.LittleEndian.PutUint64(fake.data[ptrBytesOffset:], 8)
+	binary.LittleEndian.PutUint64(fake.data[gcDataOffset:], gcDataAddr)
+	fake.data[gcDataAddr-fake.base] = 1
+	mem := &countingMemory{MemoryReadWriter: fake}
+	s := &HeapScope{mem: mem, logger: getLogger()}
+
+	var reads int64
+	for i := 0; i < 2; i++ {
+		sp := &spanInfo{base: 0x10000, elemSize: 64, spanSize: 8192, ptrMask: make([]uint64, 16)}
+		// an object with a header, whose elements are at 8, 24, 40 and 56
+		s.readType(sp, typeAddr, sp.base.Add(8), sp.base.Add(64))
+		if sp.ptrMask[0] != 0xaa {
+			t.Fatalf("got pointer mask %#x, want 0xaa", sp.ptrMask[0])
+		}
+		if i == 0 {
+			reads = mem.reads.Load()
+		} else if n := mem.reads.Load(); n != reads {
+			t.Fatalf("got %d reads of the type again, want it cached", n-reads)
+		}
+	}
+}
+
+func TestStackFrameArchs(t *testing.T) {
+	const sp, cfa = 0x1000, 0x1100
+	regs := op.NewDwarfRegisters(0, nil, binary.LittleEndian, 0, 1, 2, 3)
+	regs.AddReg(regs.SPRegNum, op.DwarfRegisterFromUint64(sp))
+	regs.FrameBase = cfa
+	fr := &proc.Stackframe{Regs: *regs}
+	for arch, lo := range map[string]Address{
+		"amd64":   sp,
+		"arm64":   sp,
+		"ppc64le": sp + 32,
+		"riscv64": sp + 8,
+	} {
+		bounds := stackFrameArchs[arch]
+		if bounds == nil {
+			t.Fatalf("%s is not supported", arch)
+		}
+		if gotLo, gotHi := bounds(fr); gotLo != lo || gotHi != cfa {
+			t.Errorf("%s: got [%#x, %#x), want [%#x, %#x)", arch, gotLo, gotHi, lo, cfa)
+		}
+	}
+	if stackFrameArchs["386"] != nil {
+		t.Errorf("386 should not be supported with 4-byte pointers")
+	}
+	for _, arch := range []string{"ppc64", "s390x"} {
+		if stackFrameArchs[arch] != nil {
+			t.Errorf("%s should not be supported as big-endian", arch)
+		}
+	}
+}
+
+func BenchmarkNextPtr(b *testing.B) {
+	// the pointer mask of a span of 8KiB, with every word a pointer, or one pointer every 512 bytes
+	for _, bc := range []struct {
+		name string
+		word uint64
+	}{{"dense", ^uint64(0)}, {"sparse", 1}} {
+		b.Run(bc.name, func(b *testing.B) {
+			mask := make([]uint64, 16)
+			for i := range mask {
+				mask[i] = bc.word
+			}
+			var ptrs int
+			for i := 0; i < b.N; i++ {
+				hb := newGCBitsIterator(0x10000, 0x12000, 0x10000, mask)
+				for hb.nextPtr(true) != 0 {
+					ptrs++
+				}
+			}
+			b.ReportMetric(float64(ptrs)/float64(b.N), "ptrs/op")
+		})
+	}
+}
+
+func BenchmarkReadType(b *testing.B) {
+	// a 16 bytes type with a pointer at the start
+	const typeAddr, gcDataAddr = 0x1000, 0x1800
+	mem := &fakeMemory{base: 0x1000, data: make([]byte, 0x1000)}
+	binary.LittleEndian.PutUint64(mem.data[sizeOffset:], 16)
+	binary.LittleEndian.PutUint64(mem.data[ptrBytesOffset:], 8)
+	binary.LittleEndian.PutUint64(mem.data[gcDataOffset:], gcDataAddr)
+	mem.data[gcDataAddr-mem.base] = 1
+	s := &HeapScope{mem: mem, logger: getLogger()}
+
+	// the objects with headers filling a span of 8KiB, each holding the elements of the type
+	for _, elemSize := range []int64{64, 1024, 8192} {
+		b.Run(fmt.Sprintf("elem=%d", elemSize), func(b *testing.B) {
+			sp := &spanInfo{base: 0x10000, elemSize: elemSize, spanSize: 8192, ptrMask: make([]uint64, 16)}
+			b.SetBytes(sp.spanSize)
+			for i := 0; i < b.N; i++ {
+				for base := sp.base; base < sp.base.Add(sp.spanSize); base = base.Add(elemSize) {
+					s.readType(sp, typeAddr, base.Add(8), base.Add(elemSize))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// flameNode is a node of the reference tree rendered by the flame graph,
+// the values are the cumulative values of the carried sample types.
+type flameNode struct {
+	Name     string       `json:"n"`
+	Values   []int64      `json:"v"`
+	Children []*flameNode `json:"c,omitempty"`
+
+	children map[uint64]*flameNode
+}
+
+// flameTree returns the reference tree from the root, the children are sorted by their first value.
+func (b *profileBuilder) flameTree() *flameNode {
+	root := &flameNode{Name: "root", Values: make([]int64, len(b.sampleTypes))}
+	values := make([]int64, len(b.sampleTypes))
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		var zero bool
+		values, zero = b.selectValues(values, &node.sampleValues)
+		if zero {
+			return
+		}
+		// indexes are from leaf to root
+		n := root
+		n.addValues(values)
+		for i := len(indexes) - 1; i >= 0; i-- {
+			child := n.children[indexes[i]]
+			if child == nil {
+				if n.children == nil {
+					n.children = make(map[uint64]*flameNode)
+				}
+				child = &flameNode{Name: b.strings[indexes[i]], Values: make([]int64, len(values))}
+				n.children[indexes[i]] = child
+			}
+			n = child
+			n.addValues(values)
+		}
+	})
+	root.sortChildren()
+	return root
+}
+
+func (n *flameNode) addValues(values []int64) {
+	for i, v := range values {
+		n.Values[i] += v
+	}
+}
+
+func (n *flameNode) sortChildren() {
+	for _, child := range n.children {
+		n.Children = append(n.Children, child)
+		child.sortChildren()
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		ci, cj := n.Children[i], n.Children[j]
+		if ci.Values[0] != cj.Values[0] {
+			return ci.Values[0] > cj.Values[0]
+		}
+		return ci.Name < cj.Name
+	})
+	n.children = nil
+}
+
+// htmlEncoder writes the reference tree as a self-contained interactive flame graph.
+type htmlEncoder struct{}
+
+func (htmlEncoder) encode(out io.Writer, b *profileBuilder) error {
+	type sampleType struct {
+		Type string `json:"type"`
+		Unit string `json:"unit"`
+	}
+	data := struct {
+		SampleTypes []sampleType `json:"sampleTypes"`
+		Root        *flameNode   `json:"root"`
+	}{Root: b.flameTree()}
+	for _, t := range b.sampleTypes {
+		data.SampleTypes = append(data.SampleTypes, sampleType{sampleTypeInfos[t].typ, sampleTypeInfos[t].unit})
+	}
+	w := bufio.NewWriter(out)
+	head, tail, _ := strings.Cut(flameGraphHTML, "{{DATA}}")
+	w.WriteString(head)
+	// json escapes '<' and '>', so the data is safe in the script element
+	if err := json.NewEncoder(w).Encode(&data); err != nil {
+		return err
+	}
+	w.WriteString(tail)
+	return w.Flush()
+}
+
+const flameGraphHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goref</title>
+<style>
+body { font: 12px sans-serif; margin: 8px; }
+#bar { margin-bottom: 8px; }
+#graph { position: relative; }
+.node { position: absolute; height: 17px; overflow: hidden; white-space: nowrap; box-sizing: border-box;
+  border: 1px solid #fff; padding: 1px 3px; cursor: pointer; }
+.node:hover { border-color: #000; }
+#info { margin-top: 8px; height: 16px; font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="bar">
+<select id="type"></select>
+<button id="reset">Reset zoom</button>
+<input id="search" placeholder="Search">
+</div>
+<div id="info"></div>
+<div id="graph"></div>
+<script>
+const data = {{DATA}};
+const graph = document.getElementById("graph"), info = document.getElementById("info");
+const typeSel = document.getElementById("type"), search = document.getElementById("search");
+data.sampleTypes.forEach((t, i) => typeSel.add(new Option(t.type, i)));
+let focus = data.root, parents = new Map();
+(function link(n) { (n.c || []).forEach(c => { parents.set(c, n); link(c); }); })(data.root);
+
+function format(v, unit) {
+  if (unit !== "bytes") return v.toString();
+  const units = ["B", "kB", "MB", "GB", "TB"];
+  let i = 0, x = Math.abs(v);
+  while (x >= 1024 && i < units.length - 1) { x /= 1024; i++; }
+  return (v < 0 ? "-" : "") + (i ? x.toFixed(2) : x) + units[i];
+}
+
+function color(name) {
+  let h = 0;
+  for (let i = 0; i < name.length; i++) h = (h * 31 + name.charCodeAt(i)) >>> 0;
+  return "hsl(" + (h % 60 + 10) + ",80%," + (60 + h % 15) + "%)";
+}
+
+function render() {
+  const t = +typeSel.value, unit = data.sampleTypes[t].unit, total = focus.v[t];
+  const width = graph.clientWidth, term = search.value;
+  const frag = document.createDocumentFragment();
+  let depth = 0, maxDepth = 0;
+  // the ancestors of the focus are drawn in full width
+  const path = [];
+  for (let n = parents.get(focus); n; n = parents.get(n)) path.unshift(n);
+  path.forEach(n => draw(n, 0, width, depth++));
+  (function walk(n, x, w, d) {
+    if (w < 1 || total <= 0) return;
+    draw(n, x, w, d);
+    let cx = x;
+    (n.c || []).forEach(c => {
+      const cw = w * c.v[t] / n.v[t];
+      if (c.v[t] > 0) { walk(c, cx, cw, d + 1); cx += cw; }
+    });
+  })(focus, 0, width, depth);
+  graph.replaceChildren(frag);
+  graph.style.height = (maxDepth + 1) * 17 + "px";
+
+  function draw(n, x, w, d) {
+    maxDepth = Math.max(maxDepth, d);
+    const el = document.createElement("div");
+    el.className = "node";
+    el.style.left = x + "px";
+    el.style.width = w + "px";
+    el.style.top = d * 17 + "px";
+    el.style.background = term && n.n.includes(term) ? "#e0f" : color(n.n);
+    el.textContent = n.n;
+    const desc = n.n + ": " + format(n.v[t], unit) + " (" + (100 * n.v[t] / data.root.v[t]).toFixed(2) + "%)";
+    el.title = desc;
+    el.onmouseover = () => { info.textContent = desc; };
+    el.onclick = () => { focus = n; render(); };
+    frag.appendChild(el);
+  }
+}
+
+typeSel.onchange = render;
+search.oninput = render;
+document.getElementById("reset").onclick = () => { focus = data.root; render(); };
+window.onresize = render;
+render();
+</script>
+</body>
+</html>
+`
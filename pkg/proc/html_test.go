@@ -0,0 +1,39 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFlushHTML(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatHTML, GroupByPath, nil)
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"sampleTypes":[{"type":"inuse_objects","unit":"count"},{"type":"inuse_space","unit":"bytes"}],` +
+		`"root":{"n":"root","v":[3,80],"c":[{"n":"main.root","v":[3,80],"c":[{"n":"next. (*main.T)","v":[2,64]}]}]}}`
+	if got := buf.String(); !strings.Contains(got, "const data = "+want) {
+		t.Fatalf("unexpected flame graph data:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+)
+
+// the bits of the pagemap entries, see Documentation/admin-guide/mm/pagemap.rst of linux
+const (
+	pagemapPresent   = 1 << 63
+	pagemapSoftDirty = 1 << 55
+	pagemapPFN       = 1<<55 - 1
+)
+
+// IncrementalState keeps the memory copied by a scanning in the snapshot mode, see WithSnapshot, for the next
+// scanning of the same process, e.g. the repeated scannings of grf watch. The next scanning only copies the
+// pages written since, known by the soft-dirty bits of linux, and reuses the copies of the others, which cuts
+// the time the target is stopped and the reads of a mostly static heap. The pages are copied again if they are
+// moved, e.g. released and faulted in again, since their page frames are compared too.
+//
+// The copies are kept between the scannings, which take as much memory as the heap. A state must not be used
+// by concurrent scannings. It requires reading the page frames in /proc/<pid>/pagemap, i.e. CAP_SYS_ADMIN,
+// otherwise all the memory is copied every time.
+type IncrementalState struct {
+	pid int
+	// the chunks copied by the last scanning by their addresses
+	chunks map[uint64]*incrementalChunk
+	// whether the soft-dirty bits are cleared after the last scanning copied the chunks
+	tracking bool
+
+	// the state of the scanning copying the memory
+	pagemap *os.File
+	next    map[uint64]*incrementalChunk
+	// the bytes reused and copied by the scanning
+	reused, copied int64
+}
+
+type incrementalChunk struct {
+	data []byte
+	// the pagemap entries of the pages when copied
+	pages []uint64
+	// whether the buffer is taken by the scanning copying the memory
+	taken bool
+}
+
+// NewIncrementalState returns an empty state, with which the first scanning copies all the memory.
+func NewIncrementalState() *IncrementalState {
+	return &IncrementalState{}
+}
+
+// WithIncremental copies the memory incrementally by the state kept across the scannings of the same process.
+// It only applies to a live local process scanned with WithSnapshot or WithFreezeDuration.
+func WithIncremental(st *IncrementalState) Option {
+	return func(o *options) {
+		o.incremental = st
+	}
+}
+
+// begin prepares the state for copying the memory of the process, returns false if the soft-dirty bits or
+// the page frames are unavailable, then the memory is copied as a whole.
+func (st *IncrementalState) begin(pid int) bool {
+	if pid != st.pid {
+		st.pid, st.chunks, st.tracking = pid, nil, false
+	}
+	st.reused, st.copied = 0, 0
+	if !softDirtySupported() {
+		st.chunks, st.tracking = nil, false
+		return false
+	}
+	f, err := openPagemap(pid)
+	if err != nil {
+		st.chunks, st.tracking = nil, false
+		return false
+	}
+	st.pagemap, st.next = f, make(map[uint64]*incrementalChunk)
+	return true
+}
+
+// buffer returns the buffer to copy the chunk at addr into, which is the copy of the last scanning
+// if it's of the same size, so only the dirty pages are copied into it.
+func (st *IncrementalState) buffer(addr uint64, size int64) []byte {
+	if c := st.chunks[addr]; c != nil && !c.taken && int64(len(c.data)) == size {
+		c.taken = true
+		return c.data
+	}
+	return make([]byte, size)
+}
+
+// dirtyRegions returns the regions of the chunks to copy, with the indexes of the chunks they are in,
+// i.e. the dirty pages of the chunks copied by the last scanning, and the other chunks as a whole.
+func (st *IncrementalState) dirtyRegions(chunks []snapshotRegion) (regions []snapshotRegion, owners []int) {
+	pageSize := uint64(os.Getpagesize())
+	for i, r := range chunks {
+		c := st.chunks[r.addr]
+		var pages []uint64
+		if st.tracking && c != nil && len(c.data) > 0 && &c.data[0] == &r.data[0] {
+			pages, _ = readPagemap(st.pagemap, r.addr, uint64(len(r.data)))
+		}
+		if pages == nil || len(pages) != len(c.pages) {
+			regions, owners = append(regions, r), append(owners, i)
+			st.copied += int64(len(r.data))
+			continue
+		}
+		first := r.addr / pageSize
+		end := r.addr + uint64(len(r.data))
+		for j := 0; j < len(pages); {
+			if pageReusable(pages[j], c.pages[j]) {
+				j++
+				continue
+			}
+			// a run of the dirty pages
+			k := j + 1
+			for k < len(pages) && !pageReusable(pages[k], c.pages[k]) {
+				k++
+			}
+			start, stop := max((first+uint64(j))*pageSize, r.addr), min((first+uint64(k))*pageSize, end)
+			regions = append(regions, snapshotRegion{addr: start, data: r.data[start-r.addr : stop-r.addr]})
+			owners = append(owners, i)
+			st.copied += int64(stop - start)
+			j = k
+		}
+	}
+	for _, r := range chunks {
+		st.reused += int64(len(r.data))
+	}
+	st.reused -= st.copied
+	return regions, owners
+}
+
+// pageReusable returns whether the copy of the page is still valid, i.e. the page is not written since
+// the last copy, and is not moved to another page frame, e.g. released and faulted in as the zero page.
+func pageReusable(cur, last uint64) bool {
+	return cur&pagemapPresent != 0 && last&pagemapPresent != 0 && cur&pagemapSoftDirty == 0 &&
+		cur&pagemapPFN != 0 && cur&pagemapPFN == last&pagemapPFN
+}
+
+// record records the chunks copied, except the failed ones, for the next scanning.
+func (st *IncrementalState) record(chunks []snapshotRegion, failed []bool) {
+	for i, r := range chunks {
+		if failed[i] {
+			continue
+		}
+		// after the copy, which may fault the pages in
+		if pages, err := readPagemap(st.pagemap, r.addr, uint64(len(r.data))); err == nil {
+			st.next[r.addr] = &incrementalChunk{data: r.data, pages: pages}
+		}
+	}
+}
+
+// commit keeps the chunks copied by the scanning, and clears the soft-dirty bits of the process, which
+// must be still stopped, so the pages written since are known by the next scanning.
+func (st *IncrementalState) commit() error {
+	st.pagemap.Close()
+	st.chunks, st.next, st.pagemap = st.next, nil, nil
+	if err := clearSoftDirty(st.pid); err != nil {
+		st.chunks, st.tracking = nil, false
+		return err
+	}
+	st.tracking = true
+	return nil
+}
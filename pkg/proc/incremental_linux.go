@@ -0,0 +1,91 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package proc
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// clearRefsSoftDirty clears the soft-dirty bits when written to /proc/<pid>/clear_refs.
+const clearRefsSoftDirty = "4"
+
+func openPagemap(pid int) (*os.File, error) {
+	return os.Open("/proc/" + strconv.Itoa(pid) + "/pagemap")
+}
+
+// readPagemap reads the pagemap entries of the pages of [addr, addr+size).
+func readPagemap(f *os.File, addr, size uint64) ([]uint64, error) {
+	pageSize := uint64(os.Getpagesize())
+	first, last := addr/pageSize, (addr+size-1)/pageSize
+	buf := make([]byte, (last-first+1)*8)
+	if _, err := f.ReadAt(buf, int64(first*8)); err != nil {
+		return nil, err
+	}
+	pages := make([]uint64, len(buf)/8)
+	for i := range pages {
+		pages[i] = binary.LittleEndian.Uint64(buf[i*8:])
+	}
+	return pages, nil
+}
+
+func clearSoftDirty(pid int) error {
+	return os.WriteFile("/proc/"+strconv.Itoa(pid)+"/clear_refs", []byte(clearRefsSoftDirty), 0)
+}
+
+var softDirty struct {
+	once      sync.Once
+	supported bool
+}
+
+// softDirtySupported returns whether the kernel tracks the soft-dirty bits, and the page frames are
+// readable, which is tested on a page of goref itself.
+func softDirtySupported() bool {
+	softDirty.once.Do(func() {
+		page, err := syscall.Mmap(-1, 0, os.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+		if err != nil {
+			return
+		}
+		defer syscall.Munmap(page)
+		f, err := openPagemap(os.Getpid())
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		entry := func() uint64 {
+			pages, err := readPagemap(f, uint64(uintptr(unsafe.Pointer(&page[0]))), 1)
+			if err != nil {
+				return 0
+			}
+			return pages[0]
+		}
+		page[0] = 1
+		if clearSoftDirty(os.Getpid()) != nil {
+			return
+		}
+		clean := entry()
+		page[0] = 2
+		dirty := entry()
+		softDirty.supported = clean&pagemapPresent != 0 && clean&pagemapSoftDirty == 0 && clean&pagemapPFN != 0 &&
+			dirty&pagemapSoftDirty != 0
+	})
+	return softDirty.supported
+}
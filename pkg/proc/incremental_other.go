@@ -0,0 +1,41 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package proc
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoSoftDirty = errors.New("the soft-dirty bits are only supported on linux")
+
+func openPagemap(pid int) (*os.File, error) {
+	return nil, errNoSoftDirty
+}
+
+func readPagemap(f *os.File, addr, size uint64) ([]uint64, error) {
+	return nil, errNoSoftDirty
+}
+
+func clearSoftDirty(pid int) error {
+	return errNoSoftDirty
+}
+
+// softDirtySupported returns false since the soft-dirty bits are only tracked by linux.
+func softDirtySupported() bool {
+	return false
+}
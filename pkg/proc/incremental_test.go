@@ -0,0 +1,82 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package proc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePagemap writes the pagemap entries of the pages from addr to the fake pagemap f.
+func writePagemap(t *testing.T, f *os.File, addr uint64, pages ...uint64) {
+	buf := make([]byte, len(pages)*8)
+	for i, e := range pages {
+		binary.LittleEndian.PutUint64(buf[i*8:], e)
+	}
+	if _, err := f.WriteAt(buf, int64(addr/uint64(os.Getpagesize())*8)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalSnapshot(t *testing.T) {
+	pageSize := uint64(os.Getpagesize())
+	base := 16 * pageSize
+	src := &fakeMemory{base: base, data: bytes.Repeat([]byte{1}, int(4*pageSize))}
+	f, err := os.Create(filepath.Join(t.TempDir(), "pagemap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	copyAll := func(st *IncrementalState) *snapshotMemory {
+		st.pagemap, st.next, st.reused, st.copied = f, make(map[uint64]*incrementalChunk), 0, 0
+		m := &snapshotMemory{src: src, inc: st}
+		m.copy(Address(base), Address(base+4*pageSize))
+		m.seal()
+		st.chunks, st.next, st.tracking = st.next, nil, true
+		return m
+	}
+
+	st := &IncrementalState{pid: 1}
+	writePagemap(t, f, base, pagemapPresent|pagemapSoftDirty|10, pagemapPresent|11, pagemapPresent|12, pagemapPresent|13)
+	copyAll(st)
+	if st.reused != 0 || st.copied != int64(4*pageSize) {
+		t.Fatalf("got %d bytes reused and %d copied by the first snapshot, want all copied", st.reused, st.copied)
+	}
+
+	// the target writes the pages 0 and 3, and page 2 is released and faulted in as another page frame
+	src.data = bytes.Repeat([]byte{2}, int(4*pageSize))
+	writePagemap(t, f, base, pagemapPresent|pagemapSoftDirty|10, pagemapPresent|11, pagemapPresent|20, pagemapPresent|pagemapSoftDirty|13)
+	m := copyAll(st)
+	if st.reused != int64(pageSize) || st.copied != int64(3*pageSize) {
+		t.Fatalf("got %d bytes reused and %d copied, want 1 and 3 pages", st.reused, st.copied)
+	}
+	data := make([]byte, 4*pageSize)
+	if n, err := m.ReadMemory(data, base); err != nil || n != len(data) {
+		t.Fatalf("read the snapshot: %d, %v", n, err)
+	}
+	for i, want := range []byte{2, 1, 2, 2} {
+		if got := data[uint64(i)*pageSize]; got != want {
+			t.Errorf("page %d is %d, want %d", i, got, want)
+		}
+	}
+	if c := st.chunks[base]; c == nil || !bytes.Equal(c.data, data) {
+		t.Fatal("the copies are not kept for the next snapshot")
+	}
+}
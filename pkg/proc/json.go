@@ -0,0 +1,107 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// jsonSchemaVersion is the version of the schema of FormatJSON, which is bumped on incompatible changes,
+// while new fields may be added within a version.
+const jsonSchemaVersion = 1
+
+type jsonProfile struct {
+	Version     int              `json:"version"`
+	SampleTypes []jsonSampleType `json:"sampleTypes"`
+	// the labels of the profile, like the hostname, see WithLabels
+	Labels map[string]string `json:"labels,omitempty"`
+	// the comments of the profile, like the memory breakdown
+	Comments []string  `json:"comments,omitempty"`
+	Root     *jsonNode `json:"root"`
+}
+
+type jsonSampleType struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+// jsonNode is a node of the reference tree, the name of the node is split into the field or the
+// variable, and the type of the referenced value, like "buf" and "[]uint8" of "buf. ([]uint8)".
+type jsonNode struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	// Count and Size are the objects and the bytes referenced through the node, including the descendants,
+	// and SelfCount and SelfSize are those recorded at the node itself. They are 0 if not carried.
+	Count     int64 `json:"count"`
+	Size      int64 `json:"size"`
+	SelfCount int64 `json:"self_count"`
+	SelfSize  int64 `json:"self_size"`
+	// Values are the cumulative values of the other carried sample types, like "retained_space".
+	Values   map[string]int64 `json:"values,omitempty"`
+	Children []*jsonNode      `json:"children,omitempty"`
+}
+
+// jsonEncoder writes the reference tree as nested JSON objects, see jsonProfile.
+type jsonEncoder struct{}
+
+func (jsonEncoder) encode(out io.Writer, b *profileBuilder) error {
+	p := &jsonProfile{Version: jsonSchemaVersion, SampleTypes: []jsonSampleType{}, Comments: b.comments}
+	for _, t := range b.sampleTypes {
+		p.SampleTypes = append(p.SampleTypes, jsonSampleType{sampleTypeInfos[t].typ, sampleTypeInfos[t].unit})
+	}
+	for i := 0; i+1 < len(b.profileLabels); i += 2 {
+		if p.Labels == nil {
+			p.Labels = make(map[string]string)
+		}
+		p.Labels[b.strings[b.profileLabels[i]]] = b.strings[b.profileLabels[i+1]]
+	}
+	p.Root = b.jsonNode(b.flameTree())
+
+	w := bufio.NewWriter(out)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (b *profileBuilder) jsonNode(n *flameNode) *jsonNode {
+	jn := &jsonNode{}
+	jn.Name, jn.Type = profile.SplitNodeName(n.Name)
+	self := append([]int64(nil), n.Values...)
+	for _, c := range n.Children {
+		jn.Children = append(jn.Children, b.jsonNode(c))
+		for i, v := range c.Values {
+			self[i] -= v
+		}
+	}
+	for i, t := range b.sampleTypes {
+		switch t {
+		case SampleObjects:
+			jn.Count, jn.SelfCount = n.Values[i], self[i]
+		case SampleSpace:
+			jn.Size, jn.SelfSize = n.Values[i], self[i]
+		default:
+			if jn.Values == nil {
+				jn.Values = make(map[string]int64)
+			}
+			jn.Values[sampleTypeInfos[t].typ] = n.Values[i]
+		}
+	}
+	return jn
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFlushJSON(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatJSON, GroupByPath, []SampleType{SampleObjects, SampleSpace, SampleRetained})
+	pb.setProfileLabels(map[string]string{"hostname": "host-1"})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16, SampleRetained: 80})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64, SampleRetained: 64})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"version":1,"sampleTypes":[{"type":"inuse_objects","unit":"count"},{"type":"inuse_space","unit":"bytes"},` +
+		`{"type":"retained_space","unit":"bytes"}],"labels":{"hostname":"host-1"},"comments":["label: hostname=host-1"],` +
+		`"root":{"name":"root","count":3,"size":80,"self_count":0,"self_size":0,"values":{"retained_space":144},"children":[` +
+		`{"name":"main.root","count":3,"size":80,"self_count":1,"self_size":16,"values":{"retained_space":144},"children":[` +
+		`{"name":"next","type":"*main.T","count":2,"size":64,"self_count":2,"self_size":64,"values":{"retained_space":64}}]}]}}`
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("unexpected json:\n%s\nwant:\n%s", got, want)
+	}
+}
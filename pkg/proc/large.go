@@ -0,0 +1,130 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// LargeObject is one of the largest heap objects reached by the scanning, see WithLargeObjects.
+type LargeObject struct {
+	Addr Address
+	// Size is the bytes of the object, i.e. its size class or its span for the large objects.
+	Size int64
+	// Type is the type of the object, by its allocation header if it's found by the GC bits only.
+	Type string
+	// Path is the reference path from the root to the object, root first, which is the path the object is
+	// attributed to in the profile, i.e. the first one found by the scanning.
+	Path []string
+}
+
+// WithLargeObjects collects the n largest heap objects reached by the scanning to objects, sorted by the sizes,
+// since a handful of giant buffers are diluted in the profile aggregated by the paths.
+func WithLargeObjects(n int, objects *[]LargeObject) Option {
+	return func(o *options) {
+		o.largeObjectsN = n
+		o.largeObjects = objects
+	}
+}
+
+// largeObjectRef is a large object found by the scanning, the path is resolved after scanning.
+type largeObjectRef struct {
+	addr Address
+	size int64
+	typ  string
+	idx  *pprofIndex
+}
+
+// largeObjectHeap keeps the n largest objects in a min-heap, so the smallest one is replaced first.
+type largeObjectHeap struct {
+	n    int
+	refs []largeObjectRef
+}
+
+func newLargeObjectHeap(n int) *largeObjectHeap {
+	if n <= 0 {
+		return nil
+	}
+	return &largeObjectHeap{n: n}
+}
+
+func (h *largeObjectHeap) Len() int           { return len(h.refs) }
+func (h *largeObjectHeap) Less(i, j int) bool { return h.refs[i].size < h.refs[j].size }
+func (h *largeObjectHeap) Swap(i, j int)      { h.refs[i], h.refs[j] = h.refs[j], h.refs[i] }
+func (h *largeObjectHeap) Push(x any)         { h.refs = append(h.refs, x.(largeObjectRef)) }
+func (h *largeObjectHeap) Pop() any {
+	x := h.refs[len(h.refs)-1]
+	h.refs = h.refs[:len(h.refs)-1]
+	return x
+}
+
+// accepts returns whether an object of the size would be kept, to skip resolving the type of the small ones.
+func (h *largeObjectHeap) accepts(size int64) bool {
+	return len(h.refs) < h.n || size > h.refs[0].size
+}
+
+func (h *largeObjectHeap) add(ref largeObjectRef) {
+	if !h.accepts(ref.size) {
+		return
+	}
+	if len(h.refs) < h.n {
+		heap.Push(h, ref)
+		return
+	}
+	h.refs[0] = ref
+	heap.Fix(h, 0)
+}
+
+// addLargeObject adds the heap object at addr of the size found through idx, typ is nil if it's found by the GC bits only.
+func (s *ObjRefScope) addLargeObject(addr Address, size int64, typ godwarf.Type, idx *pprofIndex) {
+	h := s.largeObjects
+	if h == nil || !h.accepts(size) {
+		return
+	}
+	sp, base := s.findSpanAndBase(addr)
+	if sp == nil {
+		return
+	}
+	name := unknownTypeName
+	if typ != nil {
+		name = objectTypeName(typ)
+	}
+	if name == unknownTypeName {
+		name = s.allocTypeName(sp, base)
+	}
+	h.add(largeObjectRef{addr: base, size: size, typ: name, idx: idx})
+}
+
+// largeObjectList returns the large objects with their paths, sorted by the sizes.
+func (s *ObjRefScope) largeObjectList() []LargeObject {
+	if s.largeObjects == nil {
+		return nil
+	}
+	objects := make([]LargeObject, 0, len(s.largeObjects.refs))
+	for _, ref := range s.largeObjects.refs {
+		objects = append(objects, LargeObject{Addr: ref.addr, Size: ref.size, Type: ref.typ, Path: s.pb.pathNames(ref.idx)})
+	}
+	slices.SortFunc(objects, func(a, b LargeObject) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Addr, b.Addr)
+	})
+	return objects
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLargeObjectHeap(t *testing.T) {
+	h := newLargeObjectHeap(3)
+	for i, size := range []int64{16, 4096, 32, 1 << 20, 8, 64 << 10, 512} {
+		h.add(largeObjectRef{addr: Address(i), size: size})
+	}
+	var sizes []int64
+	for _, ref := range h.refs {
+		sizes = append(sizes, ref.size)
+	}
+	slices.Sort(sizes)
+	if want := []int64{4096, 64 << 10, 1 << 20}; !slices.Equal(sizes, want) {
+		t.Fatalf("got sizes %v, want %v", sizes, want)
+	}
+	if h.accepts(4096) || !h.accepts(4097) {
+		t.Errorf("only the objects larger than the smallest kept one are accepted")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/goversion"
+)
+
+// minLayoutVersion is the oldest go1 minor version whose runtime layouts are known.
+const minLayoutVersion = 17
+
+// ptrWidth is the width of the pointer sized fields, like uintptr.
+const ptrWidth = -1
+
+// fieldWidth is the width in bytes of a runtime struct field since a go1 minor version.
+type fieldWidth struct {
+	since int
+	width int64
+}
+
+// runtimeFieldWidths are the widths of the integer fields of the runtime structs read by the scanning,
+// key: struct and field name, value: the widths sorted by version. The widths of these fields change
+// between go versions, e.g. runtime.special.offset is uint16 before go1.24 and uintptr since then.
+var runtimeFieldWidths = map[string][]fieldWidth{
+	"runtime.special.kind":           {{17, 1}},
+	"runtime.special.offset":         {{17, 2}, {24, ptrWidth}},
+	"runtime.mspan.spanclass":        {{17, 1}},
+	"runtime.mspan.nelems":           {{17, ptrWidth}, {22, 2}},
+	"runtime.mspan.freeindex":        {{17, ptrWidth}, {22, 2}},
+	"runtime.mspan.freeIndexForScan": {{20, ptrWidth}, {22, 2}},
+	"runtime.mspan.sweepgen":         {{17, 4}},
+	"runtime.mheap.sweepgen":         {{17, 4}},
+}
+
+// runtimeLayout checks the runtime struct fields against the widths of the go version of the target,
+// so an unexpected layout is reported as an error rather than a panic in the middle of the scanning.
+type runtimeLayout struct {
+	version goversion.GoVersion
+	ptrSize int64
+}
+
+// newRuntimeLayout returns the runtime layout for the go version in the DWARF producer of the target.
+func newRuntimeLayout(producer string, ptrSize int) (*runtimeLayout, error) {
+	// like "Go cmd/compile go1.23.4; regabi"
+	version, _, _ := strings.Cut(producer, ";")
+	l := &runtimeLayout{version: goversion.ParseProducer(version), ptrSize: int64(ptrSize)}
+	if producer == "" {
+		// assume the latest layouts, which are still checked against DWARF
+		l.version.Major = -1
+	} else if l.version.Major == 0 {
+		return nil, fmt.Errorf("unknown go version of the target, DWARF producer is %q", producer)
+	}
+	if !l.since(minLayoutVersion) {
+		return nil, fmt.Errorf("%s is not supported, the runtime layouts are known since go1.%d", l.version.String(), minLayoutVersion)
+	}
+	return l, nil
+}
+
+// since reports whether the target is built by go1.minor or later, including the prereleases and the devel versions.
+func (l *runtimeLayout) since(minor int) bool {
+	v := l.version
+	return v.IsDevel() || v.Major > 1 || v.Major == 1 && v.Minor >= minor
+}
+
+// width returns the expected width of the field of the runtime struct, like "runtime.special.offset".
+func (l *runtimeLayout) width(field string) (int64, error) {
+	widths, ok := runtimeFieldWidths[field]
+	if !ok {
+		return 0, fmt.Errorf("unknown runtime field %s", field)
+	}
+	var width int64
+	for _, w := range widths {
+		if !l.since(w.since) {
+			break
+		}
+		width = w.width
+	}
+	if width == 0 {
+		return 0, fmt.Errorf("%s is not in %s", field, l.version.String())
+	}
+	if width == ptrWidth {
+		width = l.ptrSize
+	}
+	return width, nil
+}
+
+// uint reads the unsigned integer field of the runtime struct r, whose width in DWARF must be
+// the width of the go version of the target.
+func (l *runtimeLayout) uint(r *region, field string) (uint64, error) {
+	name := r.typ.Common().Name + "." + field
+	want, err := l.width(name)
+	if err != nil {
+		return 0, err
+	}
+	if !r.HasField(field) {
+		return 0, fmt.Errorf("%s is not found in %s", name, l.version.String())
+	}
+	f := r.Field(field)
+	if f.typ.Size() != want {
+		return 0, fmt.Errorf("unexpected layout of %s in %s: %s of %d bytes, expected %d bytes",
+			name, l.version.String(), f.typ.String(), f.typ.Size(), want)
+	}
+	return readUintRaw(f.mem, uint64(f.a), want)
+}
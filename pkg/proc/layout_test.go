@@ -0,0 +1,54 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "testing"
+
+func TestRuntimeLayout(t *testing.T) {
+	if _, err := newRuntimeLayout("Go cmd/compile go1.16.15", 8); err == nil {
+		t.Errorf("go1.16 should not be supported")
+	}
+	for _, tt := range []struct {
+		producer string
+		field    string
+		width    int64
+	}{
+		{"Go cmd/compile go1.17.13", "runtime.special.offset", 2},
+		{"Go cmd/compile go1.23.4; regabi", "runtime.special.offset", 2},
+		{"Go cmd/compile go1.24rc1", "runtime.special.offset", 8},
+		{"Go cmd/compile devel go1.25-abcdef", "runtime.special.offset", 8},
+		{"", "runtime.special.offset", 8},
+		{"Go cmd/compile go1.21.0", "runtime.mspan.nelems", 8},
+		{"Go cmd/compile go1.22.0", "runtime.mspan.nelems", 2},
+		{"Go cmd/compile go1.20.1", "runtime.mspan.freeIndexForScan", 8},
+		{"Go cmd/compile go1.19.1", "runtime.mspan.freeIndexForScan", 0},
+		{"Go cmd/compile go1.22.0", "runtime.mspan.unknown", 0},
+	} {
+		l, err := newRuntimeLayout(tt.producer, 8)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.producer, err)
+		}
+		width, err := l.width(tt.field)
+		if tt.width == 0 {
+			if err == nil {
+				t.Errorf("%q: %s should be unknown, got %d bytes", tt.producer, tt.field, width)
+			}
+			continue
+		}
+		if err != nil || width != tt.width {
+			t.Errorf("%q: %s got %d bytes, %v, want %d bytes", tt.producer, tt.field, width, err, tt.width)
+		}
+	}
+}
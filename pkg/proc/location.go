@@ -0,0 +1,84 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// textMapping is the mapping of the text segment of the executable in the target, so the roots of the
+// stack frames are located by their PCs in the executable, and the pprof tools can disassemble them.
+type textMapping struct {
+	start, limit, offset uint64
+	file, buildID        string
+}
+
+// readTextMapping returns the mapping of the executable text segment of the target, nil if not found.
+func readTextMapping(bi *proc.BinaryInfo) *textMapping {
+	if len(bi.Images) == 0 {
+		return nil
+	}
+	image := bi.Images[0]
+	path := image.Path
+	if strings.HasPrefix(path, "/proc/") && filepath.Base(path) == "exe" {
+		// attached to a process, the pprof tools need the path of the executable
+		if exe, err := os.Readlink(path); err == nil {
+			path = exe
+		}
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			start := prog.Vaddr + image.StaticBase
+			return &textMapping{
+				start:   start,
+				limit:   start + prog.Memsz,
+				offset:  prog.Off,
+				file:    path,
+				buildID: image.BuildID,
+			}
+		}
+	}
+	return nil
+}
+
+// setFrameSource sets the source location of the node of a stack frame root, i.e. the pc of the frame,
+// and the file and line declaring the local variable, or the function if line is 0.
+func (s *ObjRefScope) setFrameSource(name string, fn *proc.Function, pc uint64, line int64) {
+	if fn == nil {
+		return
+	}
+	var file string
+	var entryLine int
+	func() {
+		// the line tables are loaded lazily, which is not safe for concurrent use
+		s.typesMu.Lock()
+		defer s.typesMu.Unlock()
+		file, entryLine = s.bi.EntryLineForFunc(fn)
+	}()
+	if line <= 0 {
+		line = int64(entryLine)
+	}
+	s.pb.setSource(name, sourceLine{pc: pc, file: file, line: line})
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/elf"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+func TestReadTextMapping(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ELF only")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := elf.Open(exe); err != nil || f.Type != elf.ET_EXEC {
+		t.Skip("not a position dependent executable")
+	} else {
+		f.Close()
+	}
+	// the test binary has no DWARF to load
+	bi := &proc.BinaryInfo{Images: []*proc.Image{{Path: exe}}}
+	m := readTextMapping(bi)
+	if m == nil {
+		t.Fatal("text mapping not found")
+	}
+	if pc := uint64(reflect.ValueOf(TestReadTextMapping).Pointer()); pc < m.start || pc >= m.limit {
+		t.Fatalf("pc %#x out of the text mapping [%#x, %#x)", pc, m.start, m.limit)
+	}
+	if m.file != exe {
+		t.Fatalf("got file %s, want %s", m.file, exe)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/go-delve/delve/pkg/logflags"
+)
+
+// Logger receives the messages of goref. Printf is for the messages to the user,
+// like the scanning result, which are always printed by the default logger;
+// Warnf and Errorf are for the diagnostics, which are only printed by the default
+// logger when the delve debugger logger is enabled.
+type Logger interface {
+	Printf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (defaultLogger) Warnf(format string, args ...interface{}) {
+	logflags.DebuggerLogger().Warnf(format, args...)
+}
+
+func (defaultLogger) Errorf(format string, args ...interface{}) {
+	logflags.DebuggerLogger().Errorf(format, args...)
+}
+
+type loggerHolder struct{ Logger }
+
+var globalLogger atomic.Value // loggerHolder
+
+func init() {
+	globalLogger.Store(loggerHolder{defaultLogger{}})
+}
+
+// SetLogger replaces the package level logger, which is used by the scanning
+// without the WithLogger option. A nil logger restores the default one.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = defaultLogger{}
+	}
+	globalLogger.Store(loggerHolder{l})
+}
+
+func getLogger() Logger {
+	return globalLogger.Load().(loggerHolder).Logger
+}
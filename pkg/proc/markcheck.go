@@ -0,0 +1,164 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// markChecker cross-checks the objects reached by goref against the mark state of the runtime:
+//   - for the spans swept after the last GC, the allocated objects are the ones marked by the last GC
+//     and the ones allocated since then;
+//   - for the spans not swept yet, the gcmarkBits are the marks of the last GC.
+//
+// Objects reached by goref but dead to the GC indicate goref follows stale or misinterpreted pointers.
+// Objects live to the GC but unreached by goref indicate goref misses some references, or they have
+// become garbage since the last GC.
+type markChecker struct {
+	// max number of examples to report for every kind of inconsistency
+	examples int
+	// the mark bits are unusable if GC is marking
+	marking bool
+
+	sweepgen uint32
+
+	dead, unreached markInconsistency
+}
+
+type markInconsistency struct {
+	objects, bytes int64
+	examples       []string
+}
+
+func (m *markInconsistency) add(base Address, size int64, limit int) {
+	m.objects++
+	m.bytes += size
+	if len(m.examples) < limit {
+		m.examples = append(m.examples, fmt.Sprintf("%#x(%d bytes)", uint64(base), size))
+	}
+}
+
+func newMarkChecker(examples int) *markChecker {
+	if examples <= 0 {
+		return nil
+	}
+	return &markChecker{examples: examples}
+}
+
+// readMarkState reads the GC phase and the sweep generation of the heap.
+func (s *HeapScope) readMarkState(mheap *region) {
+	c := s.markCheck
+	if c == nil {
+		return
+	}
+	if tmp, err := s.scope.EvalExpression("runtime.gcphase", loadSingleValue); err == nil {
+		// _GCoff is 0, otherwise marking
+		c.marking = toRegion(tmp, s.bi).Uint() != 0
+	}
+	sweepgen, err := s.layout.uint(mheap, "sweepgen")
+	if err != nil {
+		s.logger.Errorf("read sweep generation error, the mark check is disabled: %v", err)
+		s.markCheck = nil
+		return
+	}
+	c.sweepgen = uint32(sweepgen)
+}
+
+// readMarkBits reads the marks of the last GC if the span is not swept yet.
+func (s *HeapScope) readMarkBits(sp *region, spi *spanInfo) {
+	c := s.markCheck
+	if c == nil || c.marking {
+		return
+	}
+	if spi.elemSize <= 0 || !sp.HasField("gcmarkBits") || !sp.HasField("sweepgen") || !sp.HasField("nelems") {
+		return
+	}
+	sweepgen, err := s.layout.uint(sp, "sweepgen")
+	if err != nil {
+		return
+	}
+	sg := uint32(sweepgen)
+	if sg != c.sweepgen-2 && sg != c.sweepgen-1 && sg != c.sweepgen+1 {
+		// swept, the allocation state is the ground truth
+		return
+	}
+	nelems_, err := s.layout.uint(sp, "nelems")
+	if err != nil {
+		return
+	}
+	nelems := int64(nelems_)
+	markBits := sp.Field("gcmarkBits").Address()
+	if nelems <= 0 || markBits == 0 {
+		return
+	}
+	bits := make([]uint8, CeilDivide(nelems, 8))
+	if _, err := s.mem.ReadMemory(bits, uint64(markBits)); err != nil {
+		s.logger.Warnf("read gc mark bits error: %v", err)
+		return
+	}
+	spi.nelems, spi.markBits = nelems, bits
+}
+
+// isLive reports whether the object at base is live to the GC.
+func (sp *spanInfo) isLive(base Address) bool {
+	if sp.markBits == nil {
+		return !sp.isFree(base)
+	}
+	idx := base.Sub(sp.base) / sp.elemSize
+	if idx >= sp.nelems {
+		return false
+	}
+	return sp.markBits[idx/8]&(1<<(idx%8)) != 0
+}
+
+// isVisited reports whether the object at base is reached by goref.
+func (sp *spanInfo) isVisited(base Address) bool {
+	offset := base.Sub(sp.base)
+	return sp.visitMask[offset/8/64]&(1<<(offset/8%64)) != 0
+}
+
+// checkMarks compares the objects of all spans, and reports the inconsistencies.
+func (s *HeapScope) checkMarks() {
+	c := s.markCheck
+	if c == nil {
+		return
+	}
+	if c.marking {
+		s.logger.Printf("mark check: skipped, GC was marking when the process was stopped\n")
+		return
+	}
+	for _, sp := range s.spans {
+		if sp.elemSize <= 0 || sp.userArena {
+			// the user arena chunks are roots rather than objects
+			continue
+		}
+		s.pinMasks(sp)
+		for base := sp.base; base.Add(sp.elemSize) <= sp.base.Add(sp.spanSize); base = base.Add(sp.elemSize) {
+			live, visited := sp.isLive(base), sp.isVisited(base)
+			if visited && !live {
+				c.dead.add(base, sp.elemSize, c.examples)
+			} else if live && !visited {
+				c.unreached.add(base, sp.elemSize, c.examples)
+			}
+		}
+		s.unpinMasks(sp)
+	}
+	s.logger.Printf("mark check: %d objects (%d bytes) reached by goref are dead to the GC, e.g. [%s]\n",
+		c.dead.objects, c.dead.bytes, strings.Join(c.dead.examples, ", "))
+	s.logger.Printf("mark check: %d objects (%d bytes) live to the GC are unreached by goref, "+
+		"some may have become garbage since the last GC, e.g. [%s]\n",
+		c.unreached.objects, c.unreached.bytes, strings.Join(c.unreached.examples, ", "))
+}
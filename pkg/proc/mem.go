@@ -16,6 +16,9 @@
 package proc
 
 import (
+	"container/list"
+	"sync"
+
 	"github.com/go-delve/delve/pkg/proc"
 )
 
@@ -84,3 +87,126 @@ func cacheMemory(mem proc.MemoryReadWriter, addr uint64, size int) proc.MemoryRe
 	}
 	return &memCache{false, addr, make([]byte, size), mem}
 }
+
+const (
+	// the reads of the target are coalesced into the fetches of the aligned blocks of the size,
+	// which are the pages of the target, so a block is either readable or not as a whole
+	pageCacheBlockSize = 4096
+	// max blocks kept by the page cache, i.e. 16MB
+	pageCacheMaxBlocks = 4096
+	// the blocks are sharded by their addresses to reduce the lock contention of the workers
+	pageCacheShards = 16
+	// the reads larger than the size bypass the page cache, which are cached by memCache if needed
+	pageCacheMaxRead = 2 * pageCacheBlockSize
+)
+
+// pageCache coalesces the small reads of the target, like the pointers read one by one, into the fetches
+// of the pages, and keeps the recently read pages in an LRU, since a read through ptrace costs a syscall
+// however small it is. It's only valid while the memory doesn't change, i.e. the target is stopped or a snapshot.
+// It's safe for the concurrent workers.
+type pageCache struct {
+	mem    proc.MemoryReadWriter
+	shards [pageCacheShards]pageCacheShard
+}
+
+type pageCacheShard struct {
+	mu     sync.Mutex
+	blocks map[uint64]*list.Element // of *pageBlock
+	lru    list.List                // the most recently used first
+}
+
+type pageBlock struct {
+	addr uint64
+	data []byte
+}
+
+func newPageCache(mem proc.MemoryReadWriter) *pageCache {
+	c := &pageCache{mem: mem}
+	for i := range c.shards {
+		c.shards[i].blocks = make(map[uint64]*list.Element)
+	}
+	return c
+}
+
+func (c *pageCache) shard(addr uint64) *pageCacheShard {
+	return &c.shards[(addr/pageCacheBlockSize)%pageCacheShards]
+}
+
+func (c *pageCache) ReadMemory(data []byte, addr uint64) (int, error) {
+	if len(data) == 0 || len(data) > pageCacheMaxRead || addr+uint64(len(data)) < addr {
+		return c.mem.ReadMemory(data, addr)
+	}
+	for n := 0; n < len(data); {
+		a := addr + uint64(n)
+		block, ok := c.block(a &^ (pageCacheBlockSize - 1))
+		if !ok {
+			// some of the blocks are unreadable, the read may still succeed if it's readable
+			return c.mem.ReadMemory(data, addr)
+		}
+		n += copy(data[n:], block[a%pageCacheBlockSize:])
+	}
+	return len(data), nil
+}
+
+// block returns the data of the block at addr, which is fetched from the memory if not cached.
+func (c *pageCache) block(addr uint64) ([]byte, bool) {
+	sh := c.shard(addr)
+	sh.mu.Lock()
+	if e, ok := sh.blocks[addr]; ok {
+		sh.lru.MoveToFront(e)
+		sh.mu.Unlock()
+		return e.Value.(*pageBlock).data, true
+	}
+	sh.mu.Unlock()
+
+	// fetched without the lock, a block fetched by several workers at the same time is kept once
+	data := make([]byte, pageCacheBlockSize)
+	if _, err := c.mem.ReadMemory(data, addr); err != nil {
+		return nil, false
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.blocks[addr]; !ok {
+		sh.blocks[addr] = sh.lru.PushFront(&pageBlock{addr: addr, data: data})
+		if sh.lru.Len() > pageCacheMaxBlocks/pageCacheShards {
+			oldest := sh.lru.Back()
+			sh.lru.Remove(oldest)
+			delete(sh.blocks, oldest.Value.(*pageBlock).addr)
+		}
+	}
+	return data, true
+}
+
+// WriteMemory writes the memory, and drops the cached blocks it overlaps.
+func (c *pageCache) WriteMemory(addr uint64, data []byte) (int, error) {
+	for a := addr &^ (pageCacheBlockSize - 1); a < addr+uint64(len(data)); a += pageCacheBlockSize {
+		sh := c.shard(a)
+		sh.mu.Lock()
+		if e, ok := sh.blocks[a]; ok {
+			sh.lru.Remove(e)
+			delete(sh.blocks, a)
+		}
+		sh.mu.Unlock()
+	}
+	return c.mem.WriteMemory(addr, data)
+}
+
+// targetMemory returns the memory to read the target through, and the recorder of the stats counting the reads.
+// The core file is read by mapping it, and the small reads of a process are coalesced by the page cache.
+// The returned function releases the memory after scanning.
+func targetMemory(t *proc.Target, o *options) (proc.MemoryReadWriter, *scanStatsRecorder, func()) {
+	mem, release := t.Memory(), func() {}
+	if o.coreFile != "" {
+		cm, err := openCoreMemory(o.coreFile, mem)
+		if err == nil {
+			mem, release = cm, func() { cm.close() }
+		} else {
+			o.logger.Warnf("read the core file through the debugger: %v", err)
+		}
+	}
+	stats, mem := newScanStatsRecorder(o.scanStats, mem)
+	if o.coreFile == "" {
+		mem = newPageCache(mem)
+	}
+	return mem, stats, release
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPageCache(t *testing.T) {
+	src := &fakeMemory{base: 0x10000, data: make([]byte, 3*pageCacheBlockSize+100)}
+	for i := range src.data {
+		src.data[i] = byte(i * 7)
+	}
+	counting := &countingMemory{MemoryReadWriter: src}
+	c := newPageCache(counting)
+	read := func(addr uint64, size int) {
+		t.Helper()
+		data := make([]byte, size)
+		if n, err := c.ReadMemory(data, addr); err != nil || n != size {
+			t.Fatalf("read %d bytes at %#x: %d, %v", size, addr, n, err)
+		}
+		if want := src.data[addr-src.base:][:size]; !bytes.Equal(data, want) {
+			t.Fatalf("read %d bytes at %#x: got %v, want %v", size, addr, data, want)
+		}
+	}
+
+	// the pointers in a page are fetched once
+	for addr := uint64(0x10000); addr < 0x10000+pageCacheBlockSize; addr += 8 {
+		read(addr, 8)
+	}
+	if n := counting.reads.Load(); n != 1 {
+		t.Errorf("got %d fetches of the pointers in a page, want 1", n)
+	}
+	// a read across two pages fetches the next one
+	read(0x10000+pageCacheBlockSize-4, 8)
+	if n := counting.reads.Load(); n != 2 {
+		t.Errorf("got %d fetches after reading across the pages, want 2", n)
+	}
+	// the last page is partially readable, the read falls back to the memory
+	read(0x10000+3*pageCacheBlockSize, 100)
+	if n := counting.reads.Load(); n != 4 {
+		t.Errorf("got %d fetches after reading the partial page, want 4", n)
+	}
+	// a large read bypasses the cache
+	read(0x10000, pageCacheMaxRead+1)
+	if n := counting.reads.Load(); n != 5 {
+		t.Errorf("got %d fetches after the large read, want 5", n)
+	}
+	if _, err := c.ReadMemory(make([]byte, 8), 0x100); err == nil {
+		t.Errorf("read the unreadable memory without an error")
+	}
+}
+
+func TestPageCacheEviction(t *testing.T) {
+	pages := pageCacheMaxBlocks + pageCacheShards
+	c := newPageCache(&fakeMemory{base: 0, data: make([]byte, pages*pageCacheBlockSize)})
+	data := make([]byte, 8)
+	for i := 0; i < pages; i++ {
+		c.ReadMemory(data, uint64(i*pageCacheBlockSize))
+	}
+	var blocks int
+	for i := range c.shards {
+		blocks += len(c.shards[i].blocks)
+	}
+	if blocks != pageCacheMaxBlocks {
+		t.Errorf("got %d blocks cached, want %d", blocks, pageCacheMaxBlocks)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-delve/delve/pkg/proc"
+
+	"github.com/cloudwego/goref/pkg/cgroup"
+)
+
+const (
+	// check the memory usage every 16K found objects
+	memoryCheckInterval = 1 << 14
+	// back off when the usage reaches 85% of the limit
+	memoryBackoffRatio = 0.85
+	// max size of a single memory cache after backing off
+	lowMemoryCacheThreshold = 64 * 1024 // 64KB
+)
+
+// memoryGuard watches the memory usage of goref, and makes it back off as the usage approaches the limit.
+// When running in the same container as the target, goref shares the cgroup memory limit with the target,
+// so the container usage is also watched to avoid both of them being OOM-killed.
+type memoryGuard struct {
+	selfLimit   int64 // memory limit of goref itself, 0 means no limit
+	cgroupLimit int64 // memory limit of the cgroup goref runs in, 0 means no limit
+
+	// shared by the workers
+	ticks     atomic.Int32
+	lowMemory atomic.Bool
+	mu        sync.Mutex // guards samples
+	samples   []metrics.Sample
+
+	logger Logger
+}
+
+func newMemoryGuard(selfLimit int64, logger Logger) *memoryGuard {
+	g := &memoryGuard{selfLimit: selfLimit, logger: logger}
+	if limit, ok := cgroup.MemoryLimit(); ok {
+		g.cgroupLimit = limit
+	}
+	if selfLimit > 0 {
+		// make the go runtime collect garbage more aggressively near the limit.
+		debug.SetMemoryLimit(selfLimit)
+	}
+	g.samples = []metrics.Sample{{Name: "/memory/classes/total:bytes"}, {Name: "/memory/classes/heap/released:bytes"}}
+	return g
+}
+
+// tick is called for every newly found object.
+func (g *memoryGuard) tick() {
+	if g == nil || g.lowMemory.Load() {
+		return
+	}
+	if g.ticks.Add(1)%memoryCheckInterval != 0 {
+		return
+	}
+	if g.exceeded() && !g.lowMemory.Swap(true) {
+		g.logger.Printf("memory usage approaches the limit, goref shrinks memory caches and the scanning may be slower\n")
+		debug.FreeOSMemory()
+	}
+}
+
+func (g *memoryGuard) exceeded() bool {
+	if g.selfLimit > 0 {
+		g.mu.Lock()
+		metrics.Read(g.samples)
+		used := int64(g.samples[0].Value.Uint64() - g.samples[1].Value.Uint64())
+		g.mu.Unlock()
+		if float64(used) >= float64(g.selfLimit)*memoryBackoffRatio {
+			return true
+		}
+	}
+	if g.cgroupLimit > 0 {
+		if usage, ok := cgroup.MemoryUsage(); ok && float64(usage) >= float64(g.cgroupLimit)*memoryBackoffRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheMemory is like the package level cacheMemory, but avoids caching large
+// memory blocks after backing off.
+func (g *memoryGuard) cacheMemory(mem proc.MemoryReadWriter, addr uint64, size int) proc.MemoryReadWriter {
+	if g != nil && g.lowMemory.Load() && size > lowMemoryCacheThreshold {
+		return mem
+	}
+	return cacheMemory(mem, addr, size)
+}
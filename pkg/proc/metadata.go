@@ -0,0 +1,134 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// The keys of the labels detected by the command line, see WithLabels.
+const (
+	LabelHostname    = "hostname"
+	LabelContainerID = "container_id"
+	LabelBuildID     = "build_id"
+	// LabelPid labels the profiles of the processes scanned together, see MergeProfiles.
+	LabelPid = "pid"
+)
+
+// WithLabels labels the profile with the key-value pairs, like the hostname or the container of the target,
+// which are written to the comments of the profile and the labels of every sample in the pprof format, so
+// the profiles collected across a fleet stay identifiable even after being merged. The pprof labels of
+// the goroutines take precedence over the labels of the same keys. They are the labels of the profile in
+// the json format.
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		if o.labels == nil {
+			o.labels = make(map[string]string)
+		}
+		maps.Copy(o.labels, labels)
+	}
+}
+
+// containerIDRegex matches the container ID in the cgroup paths of the container runtimes,
+// like /docker/<id>, /system.slice/docker-<id>.scope or /kubepods/.../cri-containerd-<id>.scope.
+var containerIDRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// mountContainerIDRegex matches the container ID in the sources of the files mounted by docker,
+// like /var/lib/docker/containers/<id>/hostname, when the cgroup namespace hides the cgroup paths.
+var mountContainerIDRegex = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+// ReadContainerID reads the ID of the container the process runs in from /proc/<pid>/cgroup, or
+// /proc/<pid>/mountinfo with cgroup namespaces, which is only supported on linux. It returns empty if
+// the process is not in a container.
+func ReadContainerID(pid int) (string, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	cgroup, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	if id := parseContainerID(cgroup); id != "" {
+		return id, nil
+	}
+	mountinfo, err := os.ReadFile(filepath.Join(dir, "mountinfo"))
+	if err != nil {
+		return "", err
+	}
+	if m := mountContainerIDRegex.FindSubmatch(mountinfo); m != nil {
+		return string(m[1]), nil
+	}
+	return "", nil
+}
+
+// parseContainerID returns the last container ID in the paths of /proc/<pid>/cgroup, i.e. the innermost one.
+func parseContainerID(cgroup []byte) string {
+	var id []byte
+	for _, line := range bytes.Split(cgroup, []byte("\n")) {
+		if ids := containerIDRegex.FindAll(line, -1); len(ids) > 0 {
+			id = ids[len(ids)-1]
+		}
+	}
+	return string(id)
+}
+
+// ReadBuildID reads the build ID of the ELF executable, i.e. the GNU build ID if linked with one,
+// otherwise the Go build ID. It returns empty if the executable has neither.
+func ReadBuildID(exe string) (string, error) {
+	f, err := elf.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if desc := elfNoteDesc(f, ".note.gnu.build-id"); desc != nil {
+		return hex.EncodeToString(desc), nil
+	}
+	return string(elfNoteDesc(f, ".note.go.buildid")), nil
+}
+
+// elfNoteDesc returns the descriptor of the first note in the section, nil if not found or malformed.
+func elfNoteDesc(f *elf.File, name string) []byte {
+	sec := f.Section(name)
+	if sec == nil {
+		return nil
+	}
+	data, err := sec.Data()
+	if err != nil || len(data) < 12 {
+		return nil
+	}
+	// namesz, descsz and type, followed by the name and the descriptor aligned to 4 bytes
+	namesz, descsz := f.ByteOrder.Uint32(data), f.ByteOrder.Uint32(data[4:])
+	off := 12 + (uint64(namesz)+3)&^3
+	if off+uint64(descsz) > uint64(len(data)) {
+		return nil
+	}
+	return data[off : off+uint64(descsz)]
+}
+
+// labelComments returns the labels of the profile as the lines of the profile comments, sorted by the keys.
+func labelComments(labels map[string]string) []string {
+	comments := make([]string, 0, len(labels))
+	for k, v := range labels {
+		comments = append(comments, "label: "+k+"="+v)
+	}
+	slices.Sort(comments)
+	return comments
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseContainerID(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	tests := []struct {
+		cgroup, want string
+	}{
+		{"0::/system.slice/docker-" + id + ".scope\n", id},
+		{"12:memory:/docker/" + id + "\n11:cpu:/docker/" + id + "\n", id},
+		{"0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" + id + ".scope\n", id},
+		{"0::/user.slice/user-1000.slice/session-2.scope\n", ""},
+		{"0::/\n", ""},
+	}
+	for _, tt := range tests {
+		if got := parseContainerID([]byte(tt.cgroup)); got != tt.want {
+			t.Errorf("parseContainerID(%q) = %q, want %q", tt.cgroup, got, tt.want)
+		}
+	}
+}
+
+func TestReadBuildID(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	id, err := ReadBuildID(exe)
+	if err != nil {
+		t.Skip(err)
+	}
+	if id == "" {
+		t.Fatal("no build ID of the test binary")
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// nodeStripeBits is the log2 of the number of the stripes of a nodeTable.
+const nodeStripeBits = 6
+
+// pathID identifies a path interned by a nodeTable, the stripe of its entry in the low nodeStripeBits
+// and the index in the stripe above. 0 is the empty path, the parent of the roots.
+type pathID uint64
+
+// pathKey is a path by its parent path and the string index of its last name.
+type pathKey struct {
+	parent pathID
+	idx    uint64
+}
+
+type pathEntry struct {
+	pathKey
+	// nil if no values are added to the path itself
+	node *profileNode
+}
+
+type nodeStripe struct {
+	mu      sync.Mutex
+	ids     map[pathKey]pathID
+	entries []pathEntry
+}
+
+// nodeTable is the nodes of the profile by their paths. The paths are interned as a trie, where an entry is
+// a path by its parent and its last name, so a path takes an entry of a fixed size whatever its depth, and the
+// prefixes shared by the paths are stored once. The entries are striped by their keys, each stripe guarded by
+// its own lock, so the shards of a builder scanning in parallel add their nodes to the same table.
+// The paths are never dropped, so a pprofIndex caches the id of its path, see pathOf.
+type nodeTable struct {
+	stripes [1 << nodeStripeBits]nodeStripe
+}
+
+// pathEntryBytes is the estimated bytes taken by an entry of a path.
+const pathEntryBytes = int64(unsafe.Sizeof(pathEntry{})+unsafe.Sizeof(pathKey{})+unsafe.Sizeof(pathID(0))) + 16
+
+func newNodeTable() *nodeTable {
+	t := &nodeTable{}
+	for i := range t.stripes {
+		// entry 0 is never used, so that the ids are not 0
+		t.stripes[i].ids, t.stripes[i].entries = make(map[pathKey]pathID), make([]pathEntry, 1)
+	}
+	return t
+}
+
+// stripeOf returns the stripe of the entry of the path.
+func stripeOf(k pathKey) int {
+	h := (uint64(k.parent)*0x9e3779b97f4a7c15 ^ k.idx) * 0xc2b2ae3d27d4eb4f
+	return int(h >> (64 - nodeStripeBits))
+}
+
+// entry returns the entry of the path, only valid until the stripe grows.
+func (t *nodeTable) entry(id pathID) *pathEntry {
+	return &t.stripes[id&(1<<nodeStripeBits-1)].entries[id>>nodeStripeBits]
+}
+
+// intern returns the path of the name below the parent, and the bytes allocated.
+func (t *nodeTable) intern(parent pathID, idx uint64) (id pathID, bytes int64) {
+	k := pathKey{parent: parent, idx: idx}
+	s := stripeOf(k)
+	st := &t.stripes[s]
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if id, ok := st.ids[k]; ok {
+		return id, 0
+	}
+	id = pathID(len(st.entries))<<nodeStripeBits | pathID(s)
+	st.entries = append(st.entries, pathEntry{pathKey: k})
+	st.ids[k] = id
+	return id, pathEntryBytes
+}
+
+// pathOf returns the path of i, which is interned once and cached by i.
+func (t *nodeTable) pathOf(i *pprofIndex) (id pathID, bytes int64) {
+	// the prefixes not interned yet, from leaf to root
+	var buf [16]*pprofIndex
+	uncached := buf[:0]
+	for ; i != nil; i = i.prev {
+		if id = pathID(i.path.Load()); id != 0 {
+			break
+		}
+		uncached = append(uncached, i)
+	}
+	for k := len(uncached) - 1; k >= 0; k-- {
+		var n int64
+		id, n = t.intern(id, uncached[k].idx)
+		uncached[k].path.Store(uint64(id))
+		bytes += n
+	}
+	return id, bytes
+}
+
+// addTo adds the values with the label set to the node of the path, and returns the bytes allocated.
+func (t *nodeTable) addTo(id pathID, labels uint32, values *sampleValues) (bytes int64) {
+	st := &t.stripes[id&(1<<nodeStripeBits-1)]
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	e := &st.entries[id>>nodeStripeBits]
+	if e.node == nil {
+		e.node = &profileNode{}
+		bytes = nodeOverhead
+	}
+	e.node.add(values)
+	if labels != 0 {
+		bytes += e.node.addLabeled(labels, values)
+	}
+	return bytes
+}
+
+// add adds the values with the label set to the node of the path, and returns the bytes allocated.
+// The indexes are from leaf to root.
+func (t *nodeTable) add(indexes []uint64, labels uint32, values *sampleValues) (bytes int64) {
+	var id pathID
+	for i := len(indexes) - 1; i >= 0; i-- {
+		var n int64
+		id, n = t.intern(id, indexes[i])
+		bytes += n
+	}
+	return bytes + t.addTo(id, labels, values)
+}
+
+// put adds the node to the path, which is merged with the node of the path if any. The indexes are from leaf to root.
+func (t *nodeTable) put(indexes []uint64, n *profileNode) {
+	var id pathID
+	for i := len(indexes) - 1; i >= 0; i-- {
+		id, _ = t.intern(id, indexes[i])
+	}
+	t.merge(id, n)
+}
+
+// get returns the node of the path, nil if not added.
+func (t *nodeTable) get(indexes []uint64) *profileNode {
+	var id pathID
+	for i := len(indexes) - 1; i >= 0; i-- {
+		k := pathKey{parent: id, idx: indexes[i]}
+		st := &t.stripes[stripeOf(k)]
+		st.mu.Lock()
+		var ok bool
+		id, ok = st.ids[k]
+		var node *profileNode
+		if ok && i == 0 {
+			node = st.entries[id>>nodeStripeBits].node
+		}
+		st.mu.Unlock()
+		if !ok || i == 0 {
+			return node
+		}
+	}
+	return nil
+}
+
+// indexes returns the string indexes of the names of the path, from leaf to root.
+func (t *nodeTable) indexes(id pathID) (res []uint64) {
+	for ; id != 0; id = t.entry(id).parent {
+		res = append(res, t.entry(id).idx)
+	}
+	return
+}
+
+// eachID calls fn with the paths having a node, it must not be called concurrently with add.
+func (t *nodeTable) eachID(fn func(id pathID, node *profileNode)) {
+	for s := range t.stripes {
+		entries := t.stripes[s].entries
+		for i := 1; i < len(entries); i++ {
+			if node := entries[i].node; node != nil {
+				fn(pathID(i)<<nodeStripeBits|pathID(s), node)
+			}
+		}
+	}
+}
+
+// each calls fn with the nodes and their paths from leaf to root, it must not be called concurrently with add.
+func (t *nodeTable) each(fn func(indexes []uint64, node *profileNode)) {
+	t.eachID(func(id pathID, node *profileNode) {
+		fn(t.indexes(id), node)
+	})
+}
+
+// len returns the number of the nodes.
+func (t *nodeTable) len() (n int) {
+	t.eachID(func(pathID, *profileNode) { n++ })
+	return n
+}
+
+// remove drops the node of the path, it must not be called concurrently with add.
+func (t *nodeTable) remove(id pathID) {
+	t.entry(id).node = nil
+}
+
+// removeAll drops all the nodes, but keeps the paths interned.
+// It must not be called concurrently with add.
+func (t *nodeTable) removeAll() {
+	for s := range t.stripes {
+		entries := t.stripes[s].entries
+		for i := range entries {
+			entries[i].node = nil
+		}
+	}
+}
+
+// spillChunkBytes is the bytes of the nodes written to a spill file at once.
+const spillChunkBytes = 1 << 20
+
+// spill appends the nodes to the file, each as its path, the values, and the values of its label sets.
+// It must not be called concurrently with add.
+func (t *nodeTable) spill(f *spillFile) (err error) {
+	var buf []byte
+	write := func() {
+		if err == nil && len(buf) > 0 {
+			err = f.writeAt(buf, f.alloc(int64(len(buf))))
+		}
+		buf = buf[:0]
+	}
+	t.eachID(func(id pathID, node *profileNode) {
+		buf = binary.AppendUvarint(buf, uint64(id))
+		buf = appendValues(buf, &node.sampleValues)
+		buf = binary.AppendUvarint(buf, uint64(len(node.labeled)))
+		for labels, v := range node.labeled {
+			buf = binary.AppendUvarint(buf, uint64(labels))
+			buf = appendValues(buf, v)
+		}
+		if len(buf) >= spillChunkBytes {
+			write()
+		}
+	})
+	write()
+	return err
+}
+
+// load merges the nodes spilled to the file to the table.
+func (t *nodeTable) load(f *spillFile) error {
+	r := bufio.NewReader(io.NewSectionReader(f.f, 0, f.size))
+	for {
+		id, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		var node profileNode
+		if err == nil {
+			err = readValues(r, &node.sampleValues)
+		}
+		var n uint64
+		if err == nil {
+			n, err = binary.ReadUvarint(r)
+		}
+		for ; err == nil && n > 0; n-- {
+			var labels uint64
+			v := new(sampleValues)
+			if labels, err = binary.ReadUvarint(r); err == nil {
+				err = readValues(r, v)
+			}
+			if node.labeled == nil {
+				node.labeled = make(map[uint32]*sampleValues)
+			}
+			node.labeled[uint32(labels)] = v
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		t.merge(pathID(id), &node)
+	}
+}
+
+// merge adds the values of n, including the ones of its label sets, to the node of the path.
+func (t *nodeTable) merge(id pathID, n *profileNode) {
+	e := t.entry(id)
+	if e.node == nil {
+		e.node = n
+		return
+	}
+	e.node.add(&n.sampleValues)
+	for labels, v := range n.labeled {
+		e.node.addLabeled(labels, v)
+	}
+}
+
+func appendValues(buf []byte, v *sampleValues) []byte {
+	for _, x := range v {
+		buf = binary.AppendVarint(buf, x)
+	}
+	return buf
+}
+
+func readValues(r io.ByteReader, v *sampleValues) (err error) {
+	for i := range v {
+		if v[i], err = binary.ReadVarint(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestNodeTable(t *testing.T) {
+	pb := newProfileBuilder(io.Discard, FormatFolded, GroupByPath, nil)
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shard := pb.shard()
+			for i := 0; i < 1000; i++ {
+				// the paths share the prefix of the root
+				child := root.pushHead(pb, "next. (*main.T)")
+				shard.addPath(child, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+				shard.addPath(root, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := pb.nodes.len(); n != 2 {
+		t.Fatalf("got %d nodes, want 2", n)
+	}
+	var entries int
+	for i := range pb.nodes.stripes {
+		entries += len(pb.nodes.stripes[i].entries) - 1
+	}
+	if entries != 2 {
+		t.Fatalf("got %d paths interned, want 2", entries)
+	}
+	for _, indexes := range [][]uint64{root.indexes(), root.pushHead(pb, "next. (*main.T)").indexes()} {
+		node := pb.nodes.get(indexes)
+		if node == nil || node.sampleValues[SampleObjects] != 8000 || node.sampleValues[SampleSpace] != 8000*16 {
+			t.Fatalf("got node %+v of path %v, want 8000 objects", node, indexes)
+		}
+	}
+	if node := pb.nodes.get([]uint64{uint64(pb.stringIndex("main.other"))}); node != nil {
+		t.Fatalf("got node %+v of a path not added", node)
+	}
+}
@@ -0,0 +1,464 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Mapping is a memory mapping of the target.
+type Mapping struct {
+	Start, End uint64
+	// Path is the file mapped, or empty for the anonymous mappings. The pseudo paths of the anonymous
+	// mappings, like "[heap]" and "[stack]", are kept.
+	Path string
+	// RSS is the resident bytes of the mapping, or the bytes dumped in a core file.
+	RSS int64
+}
+
+// fileBacked returns whether the mapping is a file, like the executable and the shared libraries.
+func (m *Mapping) fileBacked() bool {
+	return m.Path != "" && !strings.HasPrefix(m.Path, "[")
+}
+
+// ReadMappings reads the mappings of the process from /proc/<pid>/smaps, which is only supported on linux.
+func ReadMappings(pid int) ([]Mapping, error) {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "smaps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSmaps(f)
+}
+
+// parseSmaps parses the mappings in the format of /proc/<pid>/smaps, like:
+//
+//	00400000-0052c000 r-xp 00000000 fd:01 1234    /usr/bin/app
+//	Size:               1200 kB
+//	Rss:                 800 kB
+func parseSmaps(r io.Reader) ([]Mapping, error) {
+	var ms []Mapping
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasSuffix(fields[0], ":") {
+			// a field of the current mapping
+			if fields[0] == "Rss:" && len(ms) > 0 && len(fields) >= 2 {
+				kb, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("malformed smaps line %q", line)
+				}
+				ms[len(ms)-1].RSS = kb << 10
+			}
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok || len(fields) < 5 {
+			return nil, fmt.Errorf("malformed smaps line %q", line)
+		}
+		var m Mapping
+		var err1, err2 error
+		m.Start, err1 = strconv.ParseUint(start, 16, 64)
+		m.End, err2 = strconv.ParseUint(end, 16, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("malformed smaps line %q", line)
+		}
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		ms = append(ms, m)
+	}
+	return ms, sc.Err()
+}
+
+// ntFile is the type of the note of the files mapped in a linux core file.
+const ntFile = 0x46494c45
+
+const (
+	// ntAuxv is the type of the note of the auxiliary vector in a linux core file.
+	ntAuxv = 6
+	// atEntry is the key of the entry point of the executable in the auxiliary vector.
+	atEntry = 9
+)
+
+// ReadCoreExecutable returns the path of the executable of the process dumped in the linux core file, i.e. the
+// file mapped at the entry point in the NT_FILE note, or the first file mapped if the entry point is unknown.
+// It's the path when the process ran, which may be on another host.
+func ReadCoreExecutable(core string) (string, error) {
+	f, err := elf.Open(core)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		return "", errors.New("not a core file")
+	}
+	var notes []byte
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		notes = append(notes, data...)
+	}
+	if path := coreExecutable(notes, f.ByteOrder, f.Class); path != "" {
+		return path, nil
+	}
+	return "", errors.New("no executable recorded in the core file")
+}
+
+// coreExecutable returns the path of the executable in the notes of a core file, empty if not found.
+func coreExecutable(notes []byte, order binary.ByteOrder, class elf.Class) string {
+	files := parseFileNotes(notes, order, class)
+	if len(files) == 0 {
+		return ""
+	}
+	word := 8
+	if class == elf.ELFCLASS32 {
+		word = 4
+	}
+	var entry uint64
+	forEachNote(notes, order, func(_ string, typ uint32, desc []byte) {
+		if typ != ntAuxv {
+			return
+		}
+		// pairs of the key and the value
+		for ; len(desc) >= 2*word; desc = desc[2*word:] {
+			var k, v uint64
+			if word == 4 {
+				k, v = uint64(order.Uint32(desc)), uint64(order.Uint32(desc[4:]))
+			} else {
+				k, v = order.Uint64(desc), order.Uint64(desc[8:])
+			}
+			if k == atEntry {
+				entry = v
+			}
+		}
+	})
+	for _, fm := range files {
+		if entry >= fm.Start && entry < fm.End {
+			return fm.Path
+		}
+	}
+	return files[0].Path
+}
+
+// CoreMatchesExecutable reports whether the core file is dumped from a process of the executable, i.e. the build
+// ID notes of the executable are found in the memory dumped at their addresses, which are in the first page of
+// the text dumped by default, even by delve recording no executable. Only the executables not position
+// independent are matched, whose addresses are fixed. It returns errBuildIDNotDumped if they can't be compared.
+func CoreMatchesExecutable(core, exe string) (bool, error) {
+	ef, err := elf.Open(exe)
+	if err != nil {
+		return false, err
+	}
+	defer ef.Close()
+	if ef.Type != elf.ET_EXEC {
+		return false, errBuildIDNotDumped
+	}
+	cf, err := elf.Open(core)
+	if err != nil {
+		return false, err
+	}
+	defer cf.Close()
+	if cf.Type != elf.ET_CORE {
+		return false, errors.New("not a core file")
+	}
+	var matched bool
+	for _, name := range []string{".note.go.buildid", ".note.gnu.build-id"} {
+		sec := ef.Section(name)
+		if sec == nil {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		dumped, ok := readCoreMemory(cf, sec.Addr, len(data))
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(dumped, data) {
+			return false, nil
+		}
+		matched = true
+	}
+	if !matched {
+		return false, errBuildIDNotDumped
+	}
+	return true, nil
+}
+
+// errBuildIDNotDumped is returned by CoreMatchesExecutable if the build ID of the executable is not in the core file.
+var errBuildIDNotDumped = errors.New("the build ID of the executable is not dumped in the core file")
+
+// readCoreMemory reads the n bytes at addr dumped in the core file, false if not dumped.
+func readCoreMemory(f *elf.File, addr uint64, n int) ([]byte, bool) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr+uint64(n) > prog.Vaddr+prog.Filesz {
+			continue
+		}
+		buf := make([]byte, n)
+		if _, err := prog.ReadAt(buf, int64(addr-prog.Vaddr)); err != nil {
+			return nil, false
+		}
+		return buf, true
+	}
+	return nil, false
+}
+
+// ReadCoreMappings reads the mappings dumped in the linux core file, the RSS of a mapping is the bytes
+// dumped, and the files mapped are read from the NT_FILE note.
+func ReadCoreMappings(core string) ([]Mapping, error) {
+	f, err := elf.Open(core)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		return nil, errors.New("not a core file")
+	}
+	var files []Mapping
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, parseFileNotes(data, f.ByteOrder, f.Class)...)
+	}
+	var ms []Mapping
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		m := Mapping{Start: prog.Vaddr, End: prog.Vaddr + prog.Memsz, RSS: int64(prog.Filesz)}
+		for _, fm := range files {
+			if m.Start >= fm.Start && m.Start < fm.End {
+				m.Path = fm.Path
+				break
+			}
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// parseFileNotes returns the files mapped in the NT_FILE notes of the data of a PT_NOTE segment.
+func parseFileNotes(data []byte, order binary.ByteOrder, class elf.Class) []Mapping {
+	var files []Mapping
+	forEachNote(data, order, func(_ string, typ uint32, desc []byte) {
+		if typ == ntFile {
+			files = append(files, parseFileNote(desc, order, class)...)
+		}
+	})
+	return files
+}
+
+// forEachNote calls f with the name, the type and the desc of every note in the data of a PT_NOTE segment.
+func forEachNote(data []byte, order binary.ByteOrder, f func(name string, typ uint32, desc []byte)) {
+	align4 := func(n uint32) int { return int((n + 3) &^ 3) }
+	for len(data) >= 12 {
+		namesz, descsz, typ := order.Uint32(data), order.Uint32(data[4:]), order.Uint32(data[8:])
+		data = data[12:]
+		if align4(namesz) > len(data) {
+			break
+		}
+		name := string(bytes.TrimRight(data[:namesz], "\x00"))
+		data = data[align4(namesz):]
+		if align4(descsz) > len(data) {
+			break
+		}
+		desc := data[:descsz]
+		data = data[align4(descsz):]
+		f(name, typ, desc)
+	}
+}
+
+// parseFileNote parses the desc of a NT_FILE note, which is the count of the files, the page size,
+// the start, end and offset of every file, and then their NUL-terminated paths.
+func parseFileNote(desc []byte, order binary.ByteOrder, class elf.Class) []Mapping {
+	word := 8
+	if class == elf.ELFCLASS32 {
+		word = 4
+	}
+	read := func() (uint64, bool) {
+		if len(desc) < word {
+			return 0, false
+		}
+		var v uint64
+		if word == 4 {
+			v = uint64(order.Uint32(desc))
+		} else {
+			v = order.Uint64(desc)
+		}
+		desc = desc[word:]
+		return v, true
+	}
+	count, ok1 := read()
+	_, ok2 := read()
+	if !ok1 || !ok2 || count > uint64(len(desc)/(3*word)) {
+		return nil
+	}
+	files := make([]Mapping, count)
+	for i := range files {
+		files[i].Start, _ = read()
+		files[i].End, _ = read()
+		_, _ = read()
+	}
+	for i := range files {
+		path, rest, ok := bytes.Cut(desc, []byte{0})
+		if !ok {
+			return files[:i]
+		}
+		files[i].Path = string(path)
+		desc = rest
+	}
+	return files
+}
+
+// MemoryBreakdown is the breakdown of the memory of the target, the RSS and the sizes of the Go runtime.
+type MemoryBreakdown struct {
+	// RSS is the resident bytes of all mappings, 0 if the mappings are unknown.
+	RSS int64
+	// Heap is the bytes of the in-use heap spans, and Attributed is the bytes of them reached by the scanning.
+	Heap, Attributed int64
+	// Stacks is the bytes of the goroutine stacks.
+	Stacks int64
+	// Metadata is the bytes of the runtime metadata, like the spans, the GC and the profiling structures.
+	Metadata int64
+	// Mmap is the resident bytes of the anonymous mappings beyond the Go runtime, i.e. the memory allocated
+	// by cgo and the mmaps. It's estimated as if the Go runtime is all resident, 0 if the mappings are unknown.
+	Mmap int64
+	// Binary is the resident bytes of the files mapped, like the executable and the shared libraries.
+	Binary int64
+}
+
+// WithMappings provides the memory mappings of the target, like ReadMappings or ReadCoreMappings,
+// to break down the RSS in the memory breakdown, which is written to the comments of the profile.
+func WithMappings(mappings []Mapping) Option {
+	return func(o *options) {
+		o.mappings = mappings
+	}
+}
+
+// WithMemoryBreakdown collects the memory breakdown of the target to b after scanning.
+func WithMemoryBreakdown(b *MemoryBreakdown) Option {
+	return func(o *options) {
+		o.memoryBreakdown = b
+	}
+}
+
+// runtimeMetadataStats are the runtime stats of the metadata allocated from the OS, like runtime.MemStats.MSpanSys,
+// MCacheSys, BuckHashSys, GCSys and OtherSys, gcMiscSys is named gc_sys before go1.16.
+var runtimeMetadataStats = []string{"mspan_sys", "mcache_sys", "buckhash_sys", "gcMiscSys", "gc_sys", "other_sys"}
+
+// memoryBreakdown breaks down the memory of the target by the runtime stats, the goroutine stacks and the mappings.
+func (s *ObjRefScope) memoryBreakdown(grs []*goroutineRoot, mappings []Mapping) *MemoryBreakdown {
+	var v Validation
+	s.validate(&v)
+	b := &MemoryBreakdown{Heap: v.HeapInuse, Attributed: v.Space}
+	for _, gr := range grs {
+		if gr.hi > gr.lo {
+			b.Stacks += int64(gr.hi - gr.lo)
+		}
+	}
+	if tmp, err := s.scope.EvalExpression("runtime.memstats", loadSingleValue); err == nil {
+		memstats := toRegion(tmp, s.bi)
+		if memstats.HasField("stacks_sys") {
+			// the stacks not from the heap, like the system stacks on some platforms
+			if r := memstats.Field("stacks_sys"); isUint(r.typ) {
+				b.Stacks += int64(r.Uint())
+			}
+		}
+		for _, name := range runtimeMetadataStats {
+			if !memstats.HasField(name) {
+				continue
+			}
+			if r := memstats.Field(name); isUint(r.typ) {
+				b.Metadata += int64(r.Uint())
+			}
+		}
+	} else {
+		s.logger.Warnf("read runtime.memstats err: %v", err)
+	}
+	if len(mappings) == 0 {
+		return b
+	}
+	var anon int64
+	for i := range mappings {
+		m := &mappings[i]
+		b.RSS += m.RSS
+		if m.fileBacked() {
+			b.Binary += m.RSS
+		} else {
+			anon += m.RSS
+		}
+	}
+	if mmap := anon - b.Heap - b.Stacks - b.Metadata; mmap > 0 {
+		b.Mmap = mmap
+	}
+	return b
+}
+
+// comments returns the breakdown as the lines of the profile comments.
+func (b *MemoryBreakdown) comments() []string {
+	var lines []string
+	if b.RSS > 0 {
+		lines = append(lines, "rss: "+FormatBytes(b.RSS))
+	}
+	lines = append(lines,
+		fmt.Sprintf("go heap: %s, %s attributed", FormatBytes(b.Heap), FormatBytes(b.Attributed)),
+		"goroutine stacks: "+FormatBytes(b.Stacks),
+		"runtime metadata: "+FormatBytes(b.Metadata),
+	)
+	if b.RSS > 0 {
+		lines = append(lines, "cgo/mmap: "+FormatBytes(b.Mmap), "binary mappings: "+FormatBytes(b.Binary))
+	}
+	return lines
+}
+
+// FormatBytes formats the size in bytes with a binary unit, like 1.50MB.
+func FormatBytes(v int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	x, i := float64(v), 0
+	for (x >= 1024 || x <= -1024) && i < len(units)-1 {
+		x /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", v, units[0])
+	}
+	return fmt.Sprintf("%.2f%s", x, units[i])
+}
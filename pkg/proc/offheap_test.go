@@ -0,0 +1,83 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSmaps(t *testing.T) {
+	const smaps = `00400000-0052c000 r-xp 00000000 fd:01 1234                               /usr/bin/app
+Size:               1200 kB
+Rss:                 800 kB
+c000000000-c004000000 rw-p 00000000 00:00 0 
+Size:              65536 kB
+Rss:                4096 kB
+7ffd1000-7ffd2000 rw-p 00000000 00:00 0                                  [stack]
+Rss:                   4 kB
+VmFlags: rd wr mr mw me gd ac
+`
+	ms, err := parseSmaps(strings.NewReader(smaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mapping{
+		{Start: 0x400000, End: 0x52c000, Path: "/usr/bin/app", RSS: 800 << 10},
+		{Start: 0xc000000000, End: 0xc004000000, RSS: 4096 << 10},
+		{Start: 0x7ffd1000, End: 0x7ffd2000, Path: "[stack]", RSS: 4 << 10},
+	}
+	if !reflect.DeepEqual(ms, want) {
+		t.Fatalf("got mappings %+v, want %+v", ms, want)
+	}
+	if !ms[0].fileBacked() || ms[1].fileBacked() || ms[2].fileBacked() {
+		t.Errorf("only the executable is file backed")
+	}
+}
+
+func TestCoreExecutable(t *testing.T) {
+	words := func(vs ...uint64) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint64(b, v)
+		}
+		return b
+	}
+	note := func(typ uint32, desc []byte) []byte {
+		b := binary.LittleEndian.AppendUint32(nil, 5)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(desc)))
+		b = binary.LittleEndian.AppendUint32(b, typ)
+		b = append(b, "CORE\x00\x00\x00\x00"...)
+		b = append(b, desc...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	files := note(ntFile, append(words(2, 4096, 0x7f0000, 0x7f1000, 0, 0x400000, 0x500000, 0), "/lib/ld.so\x00/app/server\x00"...))
+	auxv := note(ntAuxv, words(6, 4096, atEntry, 0x401000, 0, 0))
+	if got := coreExecutable(append(files, auxv...), binary.LittleEndian, elf.ELFCLASS64); got != "/app/server" {
+		t.Errorf("got executable %q at the entry point, want /app/server", got)
+	}
+	if got := coreExecutable(files, binary.LittleEndian, elf.ELFCLASS64); got != "/lib/ld.so" {
+		t.Errorf("got executable %q without the entry point, want the first file /lib/ld.so", got)
+	}
+	if got := coreExecutable(auxv, binary.LittleEndian, elf.ELFCLASS64); got != "" {
+		t.Errorf("got executable %q without files", got)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// Option configures the object reference scanning.
+type Option func(o *options)
+
+type options struct {
+	// memory limit of goref itself, 0 means no limit
+	selfMemoryLimit int64
+
+	// sample types carried by the profile
+	sampleTypes []SampleType
+
+	// file format of the output
+	format Format
+
+	// how the objects are aggregated in the profile
+	groupBy GroupBy
+
+	// optional roots scanned besides goroutine stacks and global variables
+	runtimeRoots []RuntimeRoot
+
+	// max examples reported by the mark check, 0 disables the check
+	markCheckExamples int
+
+	// max duration the target is stopped, 0 means stopped during the whole scanning
+	freezeDuration time.Duration
+	// whether to copy all the memory to scan and resume the target, before the scanning
+	snapshot bool
+	// the memory copied by the last scanning, which is reused by the freeze or the snapshot
+	incremental *IncrementalState
+	// the target to fork and scan the child, see WithForkSnapshot
+	forkGroup *proc.TargetGroup
+	// resumes the target after the freeze, the snapshot or the fork
+	resume func() error
+
+	// max depth of the reference paths
+	maxDepth int
+	// the depth limits below the nodes of the matching types or packages, maybe nil
+	depthRules depthRules
+	// the array elements from maxArrayElems on are collapsed to one node, 0 means no collapsing
+	maxArrayElems int64
+	// the fraction of the map entries scanned, in (0, 1]
+	mapSampleRate float64
+	// only one of every sampleEvery heap objects up to sampleMaxSize bytes is scanned, no sampling if <= 1
+	sampleEvery, sampleMaxSize int64
+	// the max bytes of the map keys shown in the node names, 0 means not shown
+	showValues int
+	// packages whose variables are scanned as roots, all packages if empty
+	includePackages []string
+	// reports the progress of the scanning, maybe nil
+	progress func(ScanProgress)
+	// number of the workers scanning in parallel
+	parallelism int
+	// the roots of the packages are not scanned
+	excludePackages []string
+	// only the reference paths with a node of the matching type are recorded
+	typeRegex *regexp.Regexp
+	// the nodes referencing less bytes or objects are not output
+	minBytes, minObjects int64
+	// collects the goroutine stats after scanning, maybe nil
+	goroutineStats *[]GoroutineStat
+	// collects the buffer utilization of the channels after scanning, maybe nil
+	channelStats *[]ChannelStat
+	// collects the largest heap objects after scanning, maybe nil
+	largeObjects  *[]LargeObject
+	largeObjectsN int
+	// collects the timers by their callbacks after scanning, maybe nil
+	timerStats *[]TimerStat
+	// collects the allocated objects unreached by the scanning, maybe nil, and whether by their types
+	garbageStats  *[]GarbageStat
+	garbageByType bool
+	// the reverse reference query of the heap object containing the address, maybe nil
+	referrersAddr Address
+	referrers     *Referrers
+	// max bytes of the masks of the spans and the profile kept in memory, 0 means no limit
+	maxRAM int64
+	// whether the output is byte-identical for identical heaps, see WithDeterministic
+	deterministic bool
+	// whether the incompatible target is still scanned, see WithCompatCheck
+	skipCompatCheck bool
+	// cross-checks the totals against the heap of the target, maybe nil
+	validation *Validation
+	// the memory mappings of the target to break down the RSS, maybe nil
+	mappings []Mapping
+	// collects the memory breakdown after scanning, maybe nil
+	memoryBreakdown *MemoryBreakdown
+	// the labels of the profile, like the hostname, maybe nil
+	labels map[string]string
+	// collects the statistics of the scanning, maybe nil
+	scanStats *ScanStats
+	// the core file the target is opened from, empty if it's a process
+	coreFile string
+
+	// logger of the scanning, the package level logger if not specified
+	logger Logger
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{maxArrayElems: defaultMaxArrayElems, mapSampleRate: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		o.logger = getLogger()
+	}
+	if o.maxDepth <= 0 {
+		o.maxDepth = defaultMaxRefDepth
+	}
+	if o.maxArrayElems < 0 {
+		o.maxArrayElems = defaultMaxArrayElems
+	}
+	if o.mapSampleRate <= 0 || o.mapSampleRate > 1 {
+		o.mapSampleRate = 1
+	}
+	if o.sampleMaxSize <= 0 {
+		o.sampleMaxSize = maxSmallSize
+	}
+	if o.parallelism <= 0 {
+		o.parallelism = runtime.GOMAXPROCS(0)
+	}
+	return o
+}
+
+// WithSelfMemoryLimit limits the memory used by goref itself. Goref will shrink its
+// memory caches when the usage approaches the limit. Without the option, goref only
+// backs off when the cgroup it runs in approaches the cgroup memory limit.
+func WithSelfMemoryLimit(bytes int64) Option {
+	return func(o *options) {
+		o.selfMemoryLimit = bytes
+	}
+}
+
+// WithSampleTypes selects the sample value types which the profile carries,
+// DefaultSampleTypes are carried if not specified.
+func WithSampleTypes(types ...SampleType) Option {
+	return func(o *options) {
+		o.sampleTypes = types
+	}
+}
+
+// WithFormat selects the file format of the output, FormatPprof is used if not specified.
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithGroupBy selects how the objects are aggregated in the profile, GroupByPath is used if not specified.
+func WithGroupBy(g GroupBy) Option {
+	return func(o *options) {
+		o.groupBy = g
+	}
+}
+
+// WithRuntimeRoots enables scanning the optional runtime roots.
+func WithRuntimeRoots(roots ...RuntimeRoot) Option {
+	return func(o *options) {
+		o.runtimeRoots = roots
+	}
+}
+
+// WithLogger routes the messages of the scanning to the logger instead of the package level logger.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithMarkCheck cross-checks the objects reached by goref against the mark state of the GC,
+// and reports the inconsistent objects with at most `examples` examples for each kind.
+// It is a diagnostic to validate the correctness of the scanning.
+func WithMarkCheck(examples int) Option {
+	return func(o *options) {
+		o.markCheckExamples = examples
+	}
+}
+
+// WithFreezeDuration bounds how long the target is stopped. Goref copies the memory to scan
+// within d, prioritizing the roots and the heap metadata, then calls resume to resume the
+// target and finishes the scanning against the copies. The heap spans which are not copied
+// in time are reported and not scanned. Note the copies take as much memory as the heap.
+func WithFreezeDuration(d time.Duration, resume func() error) Option {
+	return func(o *options) {
+		o.freezeDuration, o.resume = d, resume
+	}
+}
+
+// WithSnapshot copies all the memory to scan while the target is stopped, including the heap spans,
+// the goroutine stacks and the data segments, then calls resume to resume the target and scans the copies,
+// so that the target is only stopped for copying. Note the copies take as much memory as the heap.
+// On linux, the memory of a live process is copied by process_vm_readv in batches.
+func WithSnapshot(resume func() error) Option {
+	return func(o *options) {
+		o.snapshot, o.resume = true, resume
+	}
+}
+
+// WithParallelism reads the heap and scans the roots by n workers in parallel, GOMAXPROCS workers are used if not specified.
+// The scanning is sequential if the retained space is carried by the profile. With more than one worker, the objects
+// shared by several roots are attributed to the root reaching them first, which varies between the runs, see WithDeterministic.
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		o.parallelism = n
+	}
+}
+
+// WithExcludePackages skips the global variables and the stack frames of the packages as roots.
+func WithExcludePackages(pkgs ...string) Option {
+	return func(o *options) {
+		o.excludePackages = pkgs
+	}
+}
+
+// WithTypeRegex only records the reference paths with a variable, field or element of a type
+// whose name matches re, e.g. `^\*main\.T$`, and the objects referenced through it.
+func WithTypeRegex(re *regexp.Regexp) Option {
+	return func(o *options) {
+		o.typeRegex = re
+	}
+}
+
+// WithMinReferences drops the nodes of the reference tree which reference less than minBytes bytes
+// or minObjects objects in total, to shrink the output of huge heaps. The values referenced through
+// the dropped nodes are not output either.
+func WithMinReferences(minBytes, minObjects int64) Option {
+	return func(o *options) {
+		o.minBytes, o.minObjects = minBytes, minObjects
+	}
+}
+
+// WithMaxRAM bounds the memory taken by the intermediate state of the scanning to about bytes, i.e. the visit
+// masks and the pointer masks of the heap spans, which are 1/32 of the heap, and the nodes of the profile being
+// built. When it's exceeded, the masks of the spans least recently used are spilled to a temporary file, and read
+// back when used again. The nodes are written to the output as samples in advance with FormatPprof, whose tools
+// merge the samples of the same path, or spilled to a temporary file and merged back for the output otherwise,
+// like when WithMinReferences needs the whole profile to prune the nodes. The interned paths the nodes refer to
+// are neither counted nor spilled, they're kept in memory until the output.
+func WithMaxRAM(bytes int64) Option {
+	return func(o *options) {
+		o.maxRAM = bytes
+	}
+}
+
+// WithDeterministic makes the output byte-identical for identical heaps, like the same core file scanned twice,
+// for the golden files and the diffs. The roots are scanned by one worker, so the objects referenced by several
+// roots are attributed in the same order, and the string table, the label sets and the samples are sorted before
+// encoding. The samples are not written to the output in advance by WithMaxRAM then, but spilled to a temporary
+// file. The object graph of FormatGraph and FormatDOT is output in the order of the scanning.
+func WithDeterministic(enabled bool) Option {
+	return func(o *options) {
+		o.deterministic = enabled
+	}
+}
+
+// WithCompatCheck sets whether the scanning fails on the target whose go version, GOEXPERIMENTs or
+// architecture are known unsupported, enabled by default. If disabled, the incompatibility is only logged,
+// and the scanning may panic or report wrong results.
+func WithCompatCheck(enabled bool) Option {
+	return func(o *options) {
+		o.skipCompatCheck = !enabled
+	}
+}
+
+// WithMaxArrayElems names the first n elements of an array or a slice by their indexes in the reference paths,
+// and collapses the rest elements to one node like "[10+]", 10 is used if not specified, and 0 means every
+// element is named, which is precise but may blow up the profile with huge arrays.
+func WithMaxArrayElems(n int64) Option {
+	return func(o *options) {
+		o.maxArrayElems = n
+	}
+}
+
+// WithMapSampleRate scans the fraction of the entries of every map by their types, like 0.1 for one of every
+// 10 entries, for the speed with huge maps. The other entries are still attributed to the maps, but found
+// by the GC bits only, i.e. without the paths of their keys and values. All entries are scanned if not specified.
+func WithMapSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.mapSampleRate = rate
+	}
+}
+
+// WithSampling scans only one of every n heap objects up to maxSize bytes referenced by the large objects or
+// variables, like the elements of the big slices and maps, chosen by the hashes of their addresses, and scales
+// the sizes and counts of the sampled ones by n, like the heap profiles sampled by the runtime, so that a huge heap
+// is triaged in seconds. The objects found below a sampled object are scanned and scaled with it rather than
+// sampled again, and the ones only reachable through the skipped objects are not found. 32KiB, the max size of
+// the size classes, is used if maxSize is not positive, and no objects are skipped if n <= 1. The totals, the type
+// histogram and the retained sizes are estimates then, and the mark check and the garbage stats are disabled.
+func WithSampling(n, maxSize int64) Option {
+	return func(o *options) {
+		o.sampleEvery, o.sampleMaxSize = n, maxSize
+	}
+}
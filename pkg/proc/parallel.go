@@ -0,0 +1,147 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// parallel runs the jobs of a scanning phase by n workers. Every worker scans with its own
+// states and its own shard of the profile, which are merged into s after all the jobs are done,
+// except the nodes of the profile, which are added to the table shared by the shards.
+// The objects are marked atomically, so every object is scanned by the worker marking it first.
+func (s *ObjRefScope) parallel(n int, phase string, jobs int, fn func(w *ObjRefScope, i int)) {
+	if jobs > 0 {
+		s.progress.report(phase, 0, jobs)
+	}
+	if n <= 1 || jobs <= 1 {
+		for i := 0; i < jobs && !s.done(); i++ {
+			s.runReportedJob(phase, i, fn)
+			s.progress.report(phase, i+1, jobs)
+		}
+		return
+	}
+	if n > jobs {
+		n = jobs
+	}
+	var (
+		next     atomic.Int64
+		mu       sync.Mutex // serializes the progress reports
+		finished int
+		wg       sync.WaitGroup
+	)
+	workers := make([]*ObjRefScope, n)
+	for k := range workers {
+		w := &ObjRefScope{
+			HeapScope:  s.HeapScope,
+			pb:         s.pb.shard(),
+			maxDepth:   s.maxDepth,
+			typeRegex:  s.typeRegex,
+			depthRules: s.depthRules,
+			snapshot:   s.snapshot,
+			canceler:   canceler{ctx: s.ctx},
+
+			maxArrayElems:  s.maxArrayElems,
+			mapSampleEvery: s.mapSampleEvery,
+			showValues:     s.showValues,
+			sampleEvery:    s.sampleEvery,
+			sampleMaxSize:  s.sampleMaxSize,
+		}
+		if s.channels != nil {
+			w.channels = make(map[Address]channelRef)
+		}
+		if s.largeObjects != nil {
+			w.largeObjects = newLargeObjectHeap(s.largeObjects.n)
+		}
+		workers[k] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !w.done() {
+				i := int(next.Add(1)) - 1
+				if i >= jobs {
+					return
+				}
+				w.runReportedJob(phase, i, fn)
+				mu.Lock()
+				finished++
+				s.progress.report(phase, finished, jobs)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, w := range workers {
+		s.finalMarks = append(s.finalMarks, w.finalMarks...)
+		for addr, ch := range w.channels {
+			s.addChannel(addr, ch)
+		}
+		if w.largeObjects != nil {
+			for _, ref := range w.largeObjects.refs {
+				s.largeObjects.add(ref)
+			}
+		}
+		s.reached.objects += w.reached.objects
+		s.reached.space += w.reached.space
+		s.canceled = s.canceled || w.canceled
+	}
+}
+
+// runReportedJob runs a job like runJob, and adds the objects it marks to the progress.
+func (s *ObjRefScope) runReportedJob(phase string, i int, fn func(w *ObjRefScope, i int)) {
+	objects, space := s.reached.objects, s.reached.space
+	s.runJob(phase, i, fn)
+	s.progress.marked(s.reached.objects-objects, s.reached.space-space)
+}
+
+// parallelRead runs the jobs of reading the heap by s.parallelism workers, until fn returns false.
+// Unlike parallel, the workers share the scope, so a job must only write the states of its own.
+func (s *HeapScope) parallelRead(phase string, jobs int, fn func(i int) bool) {
+	var (
+		next atomic.Int64
+		stop atomic.Bool
+		wg   sync.WaitGroup
+	)
+	work := func(c *canceler) {
+		for !stop.Load() && !c.done() {
+			i := int(next.Add(1)) - 1
+			if i >= jobs {
+				return
+			}
+			if !s.readJob(phase, i, fn) {
+				stop.Store(true)
+			}
+		}
+	}
+	n := min(s.parallelism, jobs)
+	if n <= 1 {
+		work(&s.canceler)
+		return
+	}
+	cancelers := make([]canceler, n)
+	for k := range cancelers {
+		cancelers[k].ctx = s.ctx
+		wg.Add(1)
+		go func(c *canceler) {
+			defer wg.Done()
+			work(c)
+		}(&cancelers[k])
+	}
+	wg.Wait()
+	for _, c := range cancelers {
+		s.canceled = s.canceled || c.canceled
+	}
+}
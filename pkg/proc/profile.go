@@ -0,0 +1,182 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// DiffProfiles writes the differences of the sample values per reference path, cur minus base,
+// to w in pprof format. Only the sample types carried by both profiles are compared.
+func DiffProfiles(w io.Writer, base, cur *profile.RefProfile) error {
+	var types []SampleType
+	var baseIdx, curIdx []int
+	for i, vt := range cur.SampleTypes {
+		t, err := ParseSampleTypes(vt.Type)
+		if err != nil {
+			return fmt.Errorf("unsupported sample type %q", vt.Type)
+		}
+		for j, bvt := range base.SampleTypes {
+			if bvt.Type == vt.Type {
+				types = append(types, t[0])
+				curIdx, baseIdx = append(curIdx, i), append(baseIdx, j)
+				break
+			}
+		}
+	}
+	if len(types) == 0 {
+		return errors.New("no common sample type to compare")
+	}
+
+	pb := newProfileBuilder(w, FormatPprof, GroupByPath, types)
+	for _, s := range base.Samples {
+		pb.addSample(s, baseIdx, -1)
+	}
+	for _, s := range cur.Samples {
+		pb.addSample(s, curIdx, 1)
+	}
+	return pb.flush()
+}
+
+// ConvertProfile writes the profile to w in the format, e.g. to render a profile captured in production
+// in pprof format as a flame graph locally without scanning again. The comments, the labels and the source
+// locations are kept as far as the format carries them. The object graph formats are not supported, since
+// the profile only has the reference paths.
+func ConvertProfile(w io.Writer, p *profile.RefProfile, format Format) error {
+	if format < 0 || format >= numFormats {
+		return fmt.Errorf("unknown format %v", format)
+	}
+	if format.graphFormat() {
+		return fmt.Errorf("the %s format of the object graph can not be converted from a profile", format)
+	}
+	types := make([]SampleType, 0, len(p.SampleTypes))
+	idx := make([]int, 0, len(p.SampleTypes))
+	for i, vt := range p.SampleTypes {
+		t, err := ParseSampleTypes(vt.Type)
+		if err != nil {
+			return fmt.Errorf("unsupported sample type %q", vt.Type)
+		}
+		types, idx = append(types, t[0]), append(idx, i)
+	}
+	if len(types) == 0 {
+		return errors.New("no sample type in the profile")
+	}
+
+	pb := newProfileBuilder(w, format, GroupByPath, types)
+	pb.comments = p.Comments
+	for name, src := range p.Sources {
+		pb.setSource(name, sourceLine{file: src.File, line: src.Line})
+	}
+	for _, s := range p.Samples {
+		pb.addSample(s, idx, 1)
+	}
+	return pb.flush()
+}
+
+// MergeProfiles writes the profiles merged into one to w in the format, e.g. the profiles of all the workers
+// of a prefork server, so they can be compared in one view. The samples of each profile are labeled by
+// its labels, like the pid of the process, which take precedence over the labels of the samples, and
+// its comments are prefixed by them. Only the sample types carried by all the profiles are merged.
+func MergeProfiles(w io.Writer, profiles []*profile.RefProfile, labels []map[string]string, format Format) error {
+	if format < 0 || format >= numFormats {
+		return fmt.Errorf("unknown format %v", format)
+	}
+	if format.graphFormat() {
+		return fmt.Errorf("the %s format of the object graph can not be merged from profiles", format)
+	}
+	if len(profiles) == 0 {
+		return errors.New("no profile to merge")
+	}
+	var types []SampleType
+	idx := make([][]int, len(profiles))
+	for _, vt := range profiles[0].SampleTypes {
+		t, err := ParseSampleTypes(vt.Type)
+		if err != nil {
+			return fmt.Errorf("unsupported sample type %q", vt.Type)
+		}
+		pidx := make([]int, len(profiles))
+		common := true
+		for i, p := range profiles {
+			if pidx[i] = p.SampleIndex(vt.Type); pidx[i] < 0 {
+				common = false
+				break
+			}
+		}
+		if common {
+			types = append(types, t[0])
+			for i := range profiles {
+				idx[i] = append(idx[i], pidx[i])
+			}
+		}
+	}
+	if len(types) == 0 {
+		return errors.New("no common sample type to merge")
+	}
+
+	pb := newProfileBuilder(w, format, GroupByPath, types)
+	for i, p := range profiles {
+		prefix := formatLabels(labels[i])
+		for _, c := range p.Comments {
+			pb.comments = append(pb.comments, prefix+": "+c)
+		}
+		// the first location of a name is kept
+		for name, src := range p.Sources {
+			pb.setSource(name, sourceLine{file: src.File, line: src.Line})
+		}
+		for _, s := range p.Samples {
+			ls := *s
+			ls.Labels = maps.Clone(s.Labels)
+			if ls.Labels == nil {
+				ls.Labels = make(map[string]string, len(labels[i]))
+			}
+			maps.Copy(ls.Labels, labels[i])
+			pb.addSample(&ls, idx[i], 1)
+		}
+	}
+	return pb.flush()
+}
+
+// formatLabels formats the labels like "pid=123,service=api", sorted by the keys.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// addSample adds the values of the sample read from a profile, indexed by idx like the sample types of b,
+// multiplied by sign.
+func (b *profileBuilder) addSample(s *profile.Sample, idx []int, sign int64) {
+	var values sampleValues
+	for i, t := range b.sampleTypes {
+		values[t] = sign * s.Values[idx[i]]
+	}
+	var pi *pprofIndex
+	for i := len(s.Path) - 1; i >= 0; i-- {
+		pi = pi.pushHead(b, s.Path[i])
+	}
+	if pi != nil {
+		b.addReference(pi.indexes(), b.labelSetIndex(s.Labels), &values)
+	}
+}
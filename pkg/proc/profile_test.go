@@ -0,0 +1,384 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+func writeTestProfile(t *testing.T, refs map[string]sampleValues) *profile.RefProfile {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	for path, values := range refs {
+		var pi *pprofIndex
+		for _, name := range strings.Split(path, ";") {
+			pi = pi.pushHead(pb, name)
+		}
+		values := values
+		pb.addReference(pi.indexes(), 0, &values)
+	}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// dumpProfile returns the samples in text, sorted by the paths from the root to the leaf.
+func dumpProfile(p *profile.RefProfile) string {
+	var lines []string
+	for _, s := range p.Samples {
+		path := make([]string, len(s.Path))
+		for i, name := range s.Path {
+			path[len(path)-1-i] = name
+		}
+		lines = append(lines, fmt.Sprintf("%s %v", strings.Join(path, ";"), s.Values))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffProfiles(t *testing.T) {
+	base := writeTestProfile(t, map[string]sampleValues{
+		"main.a":               {SampleObjects: 1, SampleSpace: 16},
+		"main.a;next. *main.T": {SampleObjects: 2, SampleSpace: 64},
+		"main.gone":            {SampleObjects: 1, SampleSpace: 8},
+	})
+	if got, want := dumpProfile(base), "main.a [1 16]\nmain.a;next. *main.T [2 64]\nmain.gone [1 8]"; got != want {
+		t.Fatalf("unexpected profile read:\n%s\nwant:\n%s", got, want)
+	}
+	cur := writeTestProfile(t, map[string]sampleValues{
+		"main.a":               {SampleObjects: 1, SampleSpace: 16},
+		"main.a;next. *main.T": {SampleObjects: 5, SampleSpace: 160},
+		"main.new":             {SampleObjects: 1, SampleSpace: 32},
+	})
+
+	var buf bytes.Buffer
+	if err := DiffProfiles(&buf, base, cur); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "main.a;next. *main.T [3 96]\nmain.gone [-1 -8]\nmain.new [1 32]"
+	if got := dumpProfile(diff); got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeProfiles(t *testing.T) {
+	a := writeTestProfile(t, map[string]sampleValues{
+		"main.a":               {SampleObjects: 1, SampleSpace: 16},
+		"main.a;next. *main.T": {SampleObjects: 2, SampleSpace: 64},
+	})
+	a.Comments = []string{"rss: 1.00MB"}
+	b := writeTestProfile(t, map[string]sampleValues{
+		"main.a":   {SampleObjects: 3, SampleSpace: 48},
+		"main.new": {SampleObjects: 1, SampleSpace: 32},
+	})
+
+	var buf bytes.Buffer
+	labels := []map[string]string{{LabelPid: "100"}, {LabelPid: "200"}}
+	if err := MergeProfiles(&buf, []*profile.RefProfile{a, b}, labels, FormatPprof); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, s := range p.Samples {
+		path := make([]string, len(s.Path))
+		for i, name := range s.Path {
+			path[len(path)-1-i] = name
+		}
+		lines = append(lines, fmt.Sprintf("%s %v %s", strings.Join(path, ";"), s.Values, s.Labels[LabelPid]))
+	}
+	sort.Strings(lines)
+	want := "main.a [1 16] 100\nmain.a [3 48] 200\nmain.a;next. *main.T [2 64] 100\nmain.new [1 32] 200"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected merged profile:\n%s\nwant:\n%s", got, want)
+	}
+	if want := []string{"pid=100: rss: 1.00MB"}; !reflect.DeepEqual(p.Comments, want) {
+		t.Fatalf("got comments %q, want %q", p.Comments, want)
+	}
+}
+
+func TestFlushSpilled(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	// spill on every new node
+	pb.ram, pb.spillOutput = &ramBudget{max: 1}, true
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	if pb.zw == nil {
+		t.Fatal("the samples are not spilled")
+	}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the samples of the same path are written separately
+	want := "main.root [1 16]\nmain.root [1 16]\nmain.root;next. (*main.T) [2 64]"
+	if got := dumpProfile(p); got != want {
+		t.Fatalf("unexpected spilled profile:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProfileComments(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	pb.addReference((*pprofIndex)(nil).pushHead(pb, "main.root").indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.comments = []string{"rss: 1.00MB", "go heap: 512.00kB, 16B attributed"}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Comments, pb.comments) {
+		t.Fatalf("got comments %q, want %q", p.Comments, pb.comments)
+	}
+	// the comments are not locations
+	if got, want := dumpProfile(p), "main.root [1 16]"; got != want {
+		t.Fatalf("unexpected profile:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProfileSources(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	pb.setSource("main.worker.buf", sourceLine{file: "/src/main.go", line: 12})
+	pb.setSource("main.worker.buf", sourceLine{file: "/src/other.go", line: 13})
+	pb.setSource("main.worker", sourceLine{file: "/src/main.go", line: 10})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.worker.buf")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(root.pushHead(pb, "$sliceelem. (*main.T)").indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 8})
+	pb.comments = []string{"rss: 1.00MB"}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]profile.SourceLine{
+		"main.worker.buf": {File: "/src/main.go", Line: 12},
+		"main.worker":     {File: "/src/main.go", Line: 10},
+	}
+	if !reflect.DeepEqual(p.Sources, want) {
+		t.Fatalf("got sources %v, want %v", p.Sources, want)
+	}
+	if !reflect.DeepEqual(p.Comments, pb.comments) {
+		t.Fatalf("got comments %q, want %q", p.Comments, pb.comments)
+	}
+	// the file names are not locations
+	if got, want := dumpProfile(p), "main.worker.buf [1 16]\nmain.worker.buf;$sliceelem. (*main.T) [1 8]"; got != want {
+		t.Fatalf("unexpected profile:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProfileLabels(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	shard := pb.shard()
+	tenant := map[string]string{"handler": "/upload", "tenant": "a"}
+	labels := shard.labelSetIndex(tenant)
+	if got := pb.labelSetIndex(map[string]string{"tenant": "a", "handler": "/upload"}); got != labels {
+		t.Fatalf("got label set %d, want %d", got, labels)
+	}
+	if got := pb.labelSetIndex(nil); got != 0 {
+		t.Fatalf("got label set %d for no labels, want 0", got)
+	}
+	root := (*pprofIndex)(nil).pushHead(pb, "main.worker.buf")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	shard.addReference(root.indexes(), labels, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	shard.addReference(root.indexes(), pb.labelSetIndex(map[string]string{"tenant": "b"}), &sampleValues{SampleObjects: 1, SampleSpace: 8})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, s := range p.Samples {
+		lines = append(lines, fmt.Sprintf("%v %v", s.Labels, s.Values))
+	}
+	sort.Strings(lines)
+	want := "map[] [1 16]\nmap[handler:/upload tenant:a] [2 64]\nmap[tenant:b] [1 8]"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected labeled samples:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProfileLabelsOfProfile(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+	pb.setProfileLabels(map[string]string{"hostname": "host-1", "tenant": "none"})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.worker.buf")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(root.indexes(), pb.labelSetIndex(map[string]string{"tenant": "a"}), &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"label: hostname=host-1", "label: tenant=none"}; !reflect.DeepEqual(p.Comments, want) {
+		t.Fatalf("got comments %q, want %q", p.Comments, want)
+	}
+	var lines []string
+	for _, s := range p.Samples {
+		lines = append(lines, fmt.Sprintf("%v %v", s.Labels, s.Values))
+	}
+	sort.Strings(lines)
+	// the labels of the goroutines take precedence
+	want := "map[hostname:host-1 tenant:a] [2 64]\nmap[hostname:host-1 tenant:none] [1 16]"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected labeled samples:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConvertProfile(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, []SampleType{SampleObjects, SampleSpace, SampleRetained})
+	pb.setSource("main.worker.buf", sourceLine{file: "/src/main.go", line: 12})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.worker.buf")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16, SampleRetained: 80})
+	pb.addReference(root.pushHead(pb, "$sliceelem. (*main.T)").indexes(), pb.labelSetIndex(map[string]string{"tenant": "a"}),
+		&sampleValues{SampleObjects: 2, SampleSpace: 64, SampleRetained: 64})
+	pb.comments = []string{"rss: 1.00MB"}
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err = ConvertProfile(&buf, p, FormatPprof); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(converted.SampleTypes, p.SampleTypes) || !reflect.DeepEqual(converted.Comments, p.Comments) ||
+		!reflect.DeepEqual(converted.Sources, p.Sources) || dumpProfile(converted) != dumpProfile(p) {
+		t.Fatalf("unexpected converted profile %+v, want %+v", converted, p)
+	}
+	var labeled int
+	for _, s := range converted.Samples {
+		if s.Labels["tenant"] == "a" {
+			labeled++
+		}
+	}
+	if labeled != 1 {
+		t.Fatalf("got %d samples labeled, want 1", labeled)
+	}
+
+	buf.Reset()
+	if err = ConvertProfile(&buf, p, FormatFolded); err != nil {
+		t.Fatal(err)
+	}
+	want := "main.worker.buf 1 16 80\nmain.worker.buf;$sliceelem. (*main.T) 2 64 64\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected folded:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err = ConvertProfile(&buf, p, FormatDOT); err == nil {
+		t.Fatal("expect an error for the object graph")
+	}
+}
+
+func TestDeterministicProfile(t *testing.T) {
+	type ref struct {
+		path   string
+		labels map[string]string
+		values sampleValues
+	}
+	refs := []ref{
+		{"main.a", nil, sampleValues{SampleObjects: 1, SampleSpace: 16}},
+		{"main.a;next. *main.T", map[string]string{"tenant": "a"}, sampleValues{SampleObjects: 2, SampleSpace: 64}},
+		{"main.a;next. *main.T", map[string]string{"tenant": "b"}, sampleValues{SampleObjects: 1, SampleSpace: 32}},
+		{"main.b;m. map[string]int", map[string]string{"handler": "/upload"}, sampleValues{SampleObjects: 1, SampleSpace: 8}},
+		{"main.c", nil, sampleValues{SampleObjects: 3, SampleSpace: 48}},
+	}
+	write := func(refs []ref) []byte {
+		var buf bytes.Buffer
+		pb := newProfileBuilder(&buf, FormatPprof, GroupByPath, nil)
+		pb.deterministic = true
+		pb.setProfileLabels(map[string]string{"hostname": "host-1"})
+		for _, r := range refs {
+			var pi *pprofIndex
+			for _, name := range strings.Split(r.path, ";") {
+				pi = pi.pushHead(pb, name)
+			}
+			values := r.values
+			pb.addReference(pi.indexes(), pb.labelSetIndex(r.labels), &values)
+		}
+		if err := pb.flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	want := write(refs)
+	// the names and the label sets are found in the reverse order
+	reversed := slices.Clone(refs)
+	slices.Reverse(reversed)
+	if got := write(reversed); !bytes.Equal(got, want) {
+		t.Fatal("got different profiles of the same references")
+	}
+
+	p, err := profile.Parse(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, s := range p.Samples {
+		path := make([]string, len(s.Path))
+		for i, name := range s.Path {
+			path[len(path)-1-i] = name
+		}
+		lines = append(lines, fmt.Sprintf("%s %v %s", strings.Join(path, ";"), s.Values, s.Labels["tenant"]))
+	}
+	// the samples are in the order of their paths
+	wantSamples := "main.a [1 16] \nmain.a;next. *main.T [2 64] a\nmain.a;next. *main.T [1 32] b\nmain.b;m. map[string]int [1 8] \nmain.c [3 48] "
+	if got := strings.Join(lines, "\n"); got != wantSamples {
+		t.Fatalf("unexpected samples:\n%s\nwant:\n%s", got, wantSamples)
+	}
+}
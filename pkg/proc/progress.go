@@ -0,0 +1,78 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// spansReportInterval is the number of the spans read between the progress reports.
+const spansReportInterval = 1024
+
+// ETA estimates the remaining time of the phase by its rate so far, 0 if unknown or done.
+func (p ScanProgress) ETA() time.Duration {
+	if p.Done <= 0 || p.Done >= p.Total {
+		return 0
+	}
+	return time.Duration(float64(p.Elapsed) * float64(p.Total-p.Done) / float64(p.Done))
+}
+
+// progressReporter reports the progress of the scanning phases with the heap objects marked so far.
+// A nil progressReporter reports nothing.
+type progressReporter struct {
+	fn func(ScanProgress)
+
+	mu    sync.Mutex // serializes the reports
+	phase string
+	start time.Time
+
+	objects, space atomic.Int64
+}
+
+func newProgressReporter(fn func(ScanProgress)) *progressReporter {
+	if fn == nil {
+		return nil
+	}
+	return &progressReporter{fn: fn}
+}
+
+// marked adds the heap objects marked by a job.
+func (r *progressReporter) marked(objects, space int64) {
+	if r == nil {
+		return
+	}
+	r.objects.Add(objects)
+	r.space.Add(space)
+}
+
+// report reports that done of the total jobs of the phase are done, the phase starts by its first report
+// with no jobs done.
+func (r *progressReporter) report(phase string, done, total int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if phase != r.phase {
+		r.phase, r.start = phase, now
+	}
+	r.fn(ScanProgress{
+		Phase: phase, Done: done, Total: total,
+		Objects: r.objects.Load(), Space: r.space.Load(), Elapsed: now.Sub(r.start),
+	})
+}
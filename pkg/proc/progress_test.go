@@ -0,0 +1,66 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	for _, n := range []int{1, 4} {
+		var reports []ScanProgress
+		s := &ObjRefScope{
+			HeapScope: &HeapScope{logger: newOptions(nil).logger, progress: newProgressReporter(func(p ScanProgress) {
+				reports = append(reports, p)
+			})},
+			pb:       newProfileBuilder(io.Discard, FormatPprof, GroupByPath, nil),
+			canceler: canceler{ctx: context.Background()},
+		}
+		s.parallel(n, "test", 8, func(w *ObjRefScope, i int) {
+			w.reached.objects++
+			w.reached.space += 16
+		})
+		if len(reports) != 9 {
+			t.Fatalf("got %d reports by %d workers, want 9", len(reports), n)
+		}
+		for i, p := range reports {
+			if p.Phase != "test" || p.Done != i || p.Total != 8 {
+				t.Errorf("got the report %+v by %d workers, want %d/8 done", p, n, i)
+			}
+		}
+		if last := reports[8]; last.Objects != 8 || last.Space != 128 {
+			t.Errorf("got %d objects of %d bytes marked by %d workers, want 8 of 128 bytes", last.Objects, last.Space, n)
+		}
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	for _, c := range []struct {
+		p   ScanProgress
+		eta time.Duration
+	}{
+		{ScanProgress{Done: 0, Total: 4, Elapsed: time.Second}, 0},
+		{ScanProgress{Done: 1, Total: 4, Elapsed: time.Second}, 3 * time.Second},
+		{ScanProgress{Done: 3, Total: 4, Elapsed: 3 * time.Second}, time.Second},
+		{ScanProgress{Done: 4, Total: 4, Elapsed: time.Second}, 0},
+	} {
+		if eta := c.p.ETA(); eta != c.eta {
+			t.Errorf("got the ETA %v of %+v, want %v", eta, c.p, c.eta)
+		}
+	}
+}
@@ -10,8 +10,13 @@
 package proc
 
 import (
+	"cmp"
 	"compress/gzip"
 	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 // A protobuf is a simple protocol buffer encoder.
@@ -177,8 +182,8 @@ const (
 	tagSample_Label    = 3 // repeated Label
 
 	// message Label
-	// tagLabel_Key = 1 // int64 (string table index)
-	// tagLabel_Str = 2 // int64 (string table index)
+	tagLabel_Key = 1 // int64 (string table index)
+	tagLabel_Str = 2 // int64 (string table index)
 	// tagLabel_Num = 3 // int64
 
 	// message Mapping
@@ -214,37 +219,121 @@ const (
 // A profileBuilder writes a profile incrementally from a
 // stream of profile samples delivered by the runtime.
 type profileBuilder struct {
-	w  io.Writer
-	zw *gzip.Writer
+	w io.Writer
 
-	pb        protobuf
+	pb protobuf
+	// the string table and the label sets, shared with the shards
+	mu        sync.RWMutex
 	strings   []string
 	stringMap map[string]int
+	// the string indexes of the label keys and values in pairs, by the index of the label set minus 1,
+	// see labelSetIndex
+	labelSets   [][]uint64
+	labelSetMap map[string]uint32
+	// the label set of every sample, like the hostname, see setProfileLabels
+	profileLabels []uint64
+	// the builder owning the string table if b is a shard
+	parent *profileBuilder
+
+	// sample types carried by the profile
+	sampleTypes []SampleType
+	// writes the output in the file format
+	enc encoder
+	// how the objects are aggregated
+	groupBy GroupBy
+	// index of the first string used by the locations
+	locStart int
+
+	// the nodes by their paths, shared with the shards
+	nodes *nodeTable
+
+	// the nodes referencing less bytes or objects are dropped, see prune
+	minSpace, minObjects int64
+	// the object graph output by the graph formats, nil if not recorded
+	graph *retainedGraph
+	// the comments of the profile, like the memory breakdown, only written in the pprof and json formats
+	comments []string
+	// the source locations of the root nodes by their string indexes, nil if not written, see setSource
+	sources map[uint64]sourceLine
+	// the mapping of the executable text containing the pcs of the sources, nil if unknown
+	text *textMapping
+
+	// whether the strings, the label sets and the samples are sorted before encoding, see sortStrings
+	deterministic bool
+
+	// the memory cap of the nodes shared with the masks of the spans, nil if no cap, see spill
+	ram *ramBudget
+	// whether the nodes are spilled to the output as samples, or to a temporary file otherwise
+	spillOutput bool
+	// excludes adding the nodes by the shards while spilling
+	spillMu sync.RWMutex
+	// the gzip stream of the samples spilled to the output, and the file of the nodes spilled otherwise
+	zw      *gzip.Writer
+	spilled spillFile
+	// the error of spilling
+	spillErr error
+}
 
-	// key: indexes, val: *profileNode
-	nodes map[string]*profileNode
+// sourceLine is the source location of a node in the profile.
+type sourceLine struct {
+	// the pc in the executable, 0 if unknown
+	pc   uint64
+	file string
+	line int64
 }
 
 type profileNode struct {
-	count int64
-	size  int64
+	sampleValues
+	// the parts of the values by the label sets, nil if none is labeled
+	labeled map[uint32]*sampleValues
 }
 
+// labeledOverhead is the estimated bytes taken by the values of a label set in a node.
+const labeledOverhead = int64(unsafe.Sizeof(sampleValues{})) + 16
+
+// addLabeled adds the values to the part of the label set, and returns the bytes allocated.
+func (n *profileNode) addLabeled(labels uint32, values *sampleValues) (bytes int64) {
+	if n.labeled == nil {
+		n.labeled = make(map[uint32]*sampleValues)
+	}
+	v := n.labeled[labels]
+	if v == nil {
+		v = new(sampleValues)
+		n.labeled[labels] = v
+		bytes = labeledOverhead
+	}
+	v.add(values)
+	return bytes
+}
+
+// nodeOverhead is the estimated bytes taken by a node besides its key.
+const nodeOverhead = int64(unsafe.Sizeof(profileNode{})) + 64
+
 // newProfileBuilder returns a new profileBuilder.
 // CPU profiling data obtained from the runtime can be added
 // by calling b.addCPUData, and then the eventual profile
 // can be obtained by calling b.finish.
-func newProfileBuilder(w io.Writer) *profileBuilder {
-	zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+func newProfileBuilder(w io.Writer, format Format, groupBy GroupBy, sampleTypes []SampleType) *profileBuilder {
+	if len(sampleTypes) == 0 {
+		sampleTypes = DefaultSampleTypes
+	}
 	b := &profileBuilder{
-		w:         w,
-		zw:        zw,
-		strings:   []string{""},
-		stringMap: map[string]int{"": 0},
-		nodes:     make(map[string]*profileNode),
-	}
-	b.pbValueType(tagProfile_SampleType, "inuse_objects", "count")
-	b.pbValueType(tagProfile_SampleType, "inuse_space", "bytes")
+		w:           w,
+		strings:     []string{""},
+		stringMap:   map[string]int{"": 0},
+		sampleTypes: sampleTypes,
+		enc:         encoders[format],
+		groupBy:     groupBy,
+		nodes:       newNodeTable(),
+	}
+	if format == FormatPprof {
+		b.sources = make(map[uint64]sourceLine)
+	}
+	for _, t := range sampleTypes {
+		info := sampleTypeInfos[t]
+		b.pbValueType(tagProfile_SampleType, info.typ, info.unit)
+	}
+	b.locStart = len(b.strings)
 	return b
 }
 
@@ -267,7 +356,18 @@ func (b *profileBuilder) pbValueType(tag int, typ, unit string) {
 // stringIndex adds s to the string table if not already present
 // and returns the index of s in the string table.
 func (b *profileBuilder) stringIndex(s string) int64 {
+	if b.parent != nil {
+		return b.parent.stringIndex(s)
+	}
+	b.mu.RLock()
 	id, ok := b.stringMap[s]
+	b.mu.RUnlock()
+	if ok {
+		return int64(id)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, ok = b.stringMap[s]
 	if !ok {
 		id = len(b.strings)
 		b.strings = append(b.strings, s)
@@ -276,25 +376,294 @@ func (b *profileBuilder) stringIndex(s string) int64 {
 	return int64(id)
 }
 
-func (b *profileBuilder) addReference(indexes []uint64, count, bytes int64) {
-	k := uint64s2str(indexes)
-	var node *profileNode
-	if node = b.nodes[k]; node == nil {
-		node = &profileNode{}
-		b.nodes[k] = node
+// setSource sets the source location of the node named name, e.g. the declaration of a local variable,
+// so the pprof tools show the file and line of the root. The first location set is kept, e.g. the pc
+// of the first goroutine found running the function.
+func (b *profileBuilder) setSource(name string, src sourceLine) {
+	if b.parent != nil {
+		b.parent.setSource(name, src)
+		return
+	}
+	if b.sources == nil || src.file == "" {
+		return
+	}
+	idx := uint64(b.stringIndex(name))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.sources[idx]; !ok {
+		b.sources[idx] = src
+	}
+}
+
+// labelSetIndex returns the index of the label set, like the pprof labels of a goroutine, 0 if labels is empty.
+func (b *profileBuilder) labelSetIndex(labels map[string]string) uint32 {
+	if b.parent != nil {
+		return b.parent.labelSetIndex(labels)
+	}
+	if len(labels) == 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	set := make([]uint64, 0, 2*len(keys))
+	for _, k := range keys {
+		set = append(set, uint64(b.stringIndex(k)), uint64(b.stringIndex(labels[k])))
+	}
+	k := uint64s2str(set)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, ok := b.labelSetMap[k]
+	if !ok {
+		if b.labelSetMap == nil {
+			b.labelSetMap = make(map[string]uint32)
+		}
+		b.labelSets = append(b.labelSets, set)
+		id = uint32(len(b.labelSets))
+		b.labelSetMap[k] = id
+	}
+	return id
+}
+
+// setProfileLabels labels every sample with the labels, and writes them to the comments.
+func (b *profileBuilder) setProfileLabels(labels map[string]string) {
+	b.comments = append(b.comments, labelComments(labels)...)
+	if id := b.labelSetIndex(labels); id != 0 {
+		b.profileLabels = b.labelSets[id-1]
+	}
+}
+
+// shard returns a builder for a worker scanning in parallel, which shares the string table
+// and the nodes with b.
+func (b *profileBuilder) shard() *profileBuilder {
+	return &profileBuilder{parent: b, groupBy: b.groupBy, nodes: b.nodes}
+}
+
+// spill bounds the memory taken by the nodes. If spillOutput, the builder writes its nodes to the output
+// as samples in advance, which is only supported by the pprof format, since the pprof tools merge the
+// samples of the same path. Otherwise, the nodes are written to a temporary file, and merged back for
+// the output by loadSpilled, when the masks of the spans are dropped.
+func (b *profileBuilder) spill() {
+	b.spillMu.Lock()
+	defer b.spillMu.Unlock()
+	if b.spillErr != nil || !b.ram.nodesOver() {
+		// failed, or spilled by another shard
+		return
+	}
+	if b.spillOutput {
+		b.flushReference()
+		if b.zw == nil {
+			b.zw, _ = gzip.NewWriterLevel(b.w, gzip.BestSpeed)
+		}
+		if _, b.spillErr = b.zw.Write(b.pb.data); b.spillErr != nil {
+			return
+		}
+		b.pb.data = b.pb.data[:0]
+	} else if b.spillErr = b.nodes.spill(&b.spilled); b.spillErr != nil {
+		return
+	}
+	b.nodes.removeAll()
+	b.ram.nodes.Store(0)
+}
+
+// limitRAM caps the memory of the nodes by the budget shared with the masks of the spans, nil if no cap.
+func (b *profileBuilder) limitRAM(ram *ramBudget, o *options) {
+	if ram == nil {
+		return
+	}
+	b.ram = ram
+	b.spillOutput = o.format == FormatPprof && o.minBytes <= 0 && o.minObjects <= 0 && o.goroutineStats == nil && !o.deterministic
+	b.spilled.pattern = "goref-nodes-*"
+}
+
+// loadSpilled merges the nodes spilled to the temporary file back once the scanning is done.
+func (b *profileBuilder) loadSpilled() {
+	if b.spilled.size == 0 || b.spillErr != nil {
+		return
+	}
+	b.spillErr = b.nodes.load(&b.spilled)
+	b.spilled.close()
+}
+
+// addReference adds the values referenced by the path with the label set, unless grouping by type.
+// The values are added to the root of the path if grouping by goroutine.
+func (b *profileBuilder) addReference(indexes []uint64, labels uint32, values *sampleValues) {
+	switch {
+	case b.groupBy == GroupByPath:
+		b.addNode(indexes, labels, values)
+	case b.groupBy.byGoroutine() && len(indexes) > 0:
+		// indexes are from leaf to root
+		b.addNode(indexes[len(indexes)-1:], labels, values)
+	}
+}
+
+// addObjects adds the objects of the type to the type histogram if grouping by type.
+func (b *profileBuilder) addObjects(typeName string, size, count int64) {
+	if b.groupBy != GroupByType || count == 0 {
+		return
+	}
+	b.addNode([]uint64{uint64(b.stringIndex(typeName))}, 0, &sampleValues{SampleObjects: count, SampleSpace: size})
+}
+
+func (b *profileBuilder) addNode(indexes []uint64, labels uint32, values *sampleValues) {
+	r := b.lockNodes()
+	r.unlockNodes(b.nodes.add(indexes, labels, values))
+}
+
+// addPath adds the values referenced by the path like addReference, but the path is interned by
+// the table only once, see nodeTable.pathOf.
+func (b *profileBuilder) addPath(i *pprofIndex, values *sampleValues) {
+	if i == nil {
+		return
+	}
+	labels := i.labels
+	switch {
+	case b.groupBy == GroupByPath:
+	case b.groupBy.byGoroutine():
+		for i.prev != nil {
+			i = i.prev
+		}
+	default:
+		return
+	}
+	r := b.lockNodes()
+	id, bytes := b.nodes.pathOf(i)
+	r.unlockNodes(bytes + b.nodes.addTo(id, labels, values))
+}
+
+// lockNodes excludes spilling while adding the nodes, and returns the builder owning the nodes.
+func (b *profileBuilder) lockNodes() *profileBuilder {
+	r := b
+	if b.parent != nil {
+		r = b.parent
+	}
+	if r.ram != nil {
+		r.spillMu.RLock()
+	}
+	return r
+}
+
+// unlockNodes counts the bytes allocated by adding the nodes, and spills them if they take too much.
+func (b *profileBuilder) unlockNodes(bytes int64) {
+	if b.ram == nil {
+		return
+	}
+	b.ram.nodes.Add(bytes)
+	b.spillMu.RUnlock()
+	if b.ram.nodesOver() {
+		b.spill()
 	}
-	node.count += count
-	node.size += bytes
 }
 
+// flushReference writes the nodes as samples, the values of a node are split into a sample per label set,
+// and a sample of the rest not labeled.
+// If deterministic, the samples are written in the order of their paths from the root and their label sets.
 func (b *profileBuilder) flushReference() {
-	for k, node := range b.nodes {
-		indexes := str2uint64s(k)
-		start := b.pb.startMessage()
-		b.pb.int64s(tagSample_Value, []int64{node.count, node.size})
-		b.pb.uint64s(tagSample_Location, indexes)
-		b.pb.endMessage(tagProfile_Sample, start)
+	values := make([]int64, len(b.sampleTypes))
+	write := func(indexes []uint64, node *profileNode) {
+		rest := node.sampleValues
+		if !b.deterministic {
+			for labels, v := range node.labeled {
+				rest.sub(v)
+				b.pbSample(values, indexes, labels, v)
+			}
+		} else if len(node.labeled) > 0 {
+			labels := make([]uint32, 0, len(node.labeled))
+			for l := range node.labeled {
+				labels = append(labels, l)
+			}
+			slices.Sort(labels)
+			for _, l := range labels {
+				rest.sub(node.labeled[l])
+				b.pbSample(values, indexes, l, node.labeled[l])
+			}
+		}
+		b.pbSample(values, indexes, 0, &rest)
+	}
+	if !b.deterministic {
+		b.nodes.each(write)
+		return
+	}
+	type sample struct {
+		indexes []uint64
+		node    *profileNode
+	}
+	var samples []sample
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		samples = append(samples, sample{indexes, node})
+	})
+	slices.SortFunc(samples, func(x, y sample) int { return comparePaths(x.indexes, y.indexes) })
+	for _, smp := range samples {
+		write(smp.indexes, smp.node)
+	}
+}
+
+// comparePaths compares the paths by their string indexes from the roots, the indexes are from leaf to root.
+func comparePaths(x, y []uint64) int {
+	for i, j := len(x)-1, len(y)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := cmp.Compare(x[i], y[j]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(x), len(y))
+}
+
+// pbSample encodes a Sample message of the values with the label set to b.pb, unless the values are zero.
+func (b *profileBuilder) pbSample(buf []int64, indexes []uint64, labels uint32, values *sampleValues) {
+	buf, zero := b.selectValues(buf, values)
+	if zero {
+		return
+	}
+	start := b.pb.startMessage()
+	b.pb.int64s(tagSample_Value, buf)
+	b.pb.uint64s(tagSample_Location, indexes)
+	var set []uint64
+	if labels != 0 {
+		b.mu.RLock()
+		set = b.labelSets[labels-1]
+		b.mu.RUnlock()
+	}
+	for i := 0; i < len(b.profileLabels); i += 2 {
+		if !hasLabelKey(set, b.profileLabels[i]) {
+			b.pbLabel(b.profileLabels[i], b.profileLabels[i+1])
+		}
+	}
+	for i := 0; i < len(set); i += 2 {
+		b.pbLabel(set[i], set[i+1])
+	}
+	b.pb.endMessage(tagProfile_Sample, start)
+}
+
+// pbLabel encodes a Label message of a sample to b.pb.
+func (b *profileBuilder) pbLabel(key, str uint64) {
+	start := b.pb.startMessage()
+	b.pb.uint64(tagLabel_Key, key)
+	b.pb.uint64(tagLabel_Str, str)
+	b.pb.endMessage(tagSample_Label, start)
+}
+
+// hasLabelKey reports whether the label set has the key, both string indexes.
+func hasLabelKey(set []uint64, key uint64) bool {
+	for i := 0; i < len(set); i += 2 {
+		if set[i] == key {
+			return true
+		}
 	}
+	return false
+}
+
+// selectValues fills the values of the carried sample types to dst.
+func (b *profileBuilder) selectValues(dst []int64, values *sampleValues) (_ []int64, zero bool) {
+	zero = true
+	for i, t := range b.sampleTypes {
+		dst[i] = values[t]
+		if dst[i] != 0 {
+			zero = false
+		}
+	}
+	return dst, zero
 }
 
 func (b *profileBuilder) pbMapping(tag int, id, base, limit, offset uint64, file, buildID string, hasFuncs bool) {
@@ -309,32 +678,188 @@ func (b *profileBuilder) pbMapping(tag int, id, base, limit, offset uint64, file
 	b.pb.endMessage(tag, start)
 }
 
-func (b *profileBuilder) flush() {
+func (b *profileBuilder) flush() error {
+	b.loadSpilled()
+	if b.spillErr != nil {
+		return b.spillErr
+	}
+	b.prune()
+	if b.deterministic {
+		b.sortStrings()
+	}
+	return b.enc.encode(b.w, b)
+}
+
+// sortStrings sorts the strings added after the sample types, i.e. the names of the nodes and the labels, and
+// renumbers the label sets in the order of their labels, so that the indexes don't depend on the order the
+// names are found in, e.g. by the workers or by the map iterations. The nodes are added again by the new indexes.
+// The object graph refers to the names by the old indexes, and is output in the order of the scanning instead.
+func (b *profileBuilder) sortStrings() {
+	if b.graph != nil {
+		return
+	}
+	names := slices.Clone(b.strings[b.locStart:])
+	slices.Sort(names)
+	// key: old index, val: new index
+	remap := make([]uint64, len(b.strings))
+	for i := range remap[:b.locStart] {
+		remap[i] = uint64(i)
+	}
+	for i, name := range names {
+		id := b.locStart + i
+		remap[b.stringMap[name]] = uint64(id)
+		b.stringMap[name] = id
+	}
+	copy(b.strings[b.locStart:], names)
+	remapped := func(indexes []uint64) []uint64 {
+		res := make([]uint64, len(indexes))
+		for i, idx := range indexes {
+			res[i] = remap[idx]
+		}
+		return res
+	}
+
+	// the keys of a label set are in the order of their names, so are the new indexes
+	sets := make([][]uint64, len(b.labelSets))
+	order := make([]int, len(b.labelSets))
+	for i, set := range b.labelSets {
+		sets[i], order[i] = remapped(set), i
+	}
+	slices.SortFunc(order, func(x, y int) int { return slices.Compare(sets[x], sets[y]) })
+	// key: old label set, val: new label set
+	relabel := make([]uint32, len(sets)+1)
+	b.labelSetMap = make(map[string]uint32, len(sets))
+	for i, old := range order {
+		b.labelSets[i] = sets[old]
+		relabel[old+1] = uint32(i + 1)
+		b.labelSetMap[uint64s2str(sets[old])] = uint32(i + 1)
+	}
+	if b.profileLabels != nil {
+		b.profileLabels = remapped(b.profileLabels)
+	}
+	if b.sources != nil {
+		sources := make(map[uint64]sourceLine, len(b.sources))
+		for idx, src := range b.sources {
+			sources[remap[idx]] = src
+		}
+		b.sources = sources
+	}
+
+	nodes := newNodeTable()
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		if node.labeled != nil {
+			labeled := make(map[uint32]*sampleValues, len(node.labeled))
+			for labels, v := range node.labeled {
+				labeled[relabel[labels]] = v
+			}
+			node.labeled = labeled
+		}
+		nodes.put(remapped(indexes), node)
+	})
+	b.nodes = nodes
+}
+
+// prune drops the nodes referencing less than minSpace bytes or minObjects objects, including
+// the values referenced through them. The ancestors of a kept node are kept, since they reference more.
+func (b *profileBuilder) prune() {
+	if b.minSpace <= 0 && b.minObjects <= 0 {
+		return
+	}
+	// key: a path from the root, val: the values referenced through the path
+	cum := make(map[pathID]*sampleValues)
+	b.nodes.eachID(func(id pathID, node *profileNode) {
+		// the values are referenced through the ancestors
+		for ; id != 0; id = b.nodes.entry(id).parent {
+			v := cum[id]
+			if v == nil {
+				v = new(sampleValues)
+				cum[id] = v
+			}
+			v.add(&node.sampleValues)
+		}
+	})
+	b.nodes.eachID(func(id pathID, _ *profileNode) {
+		if v := cum[id]; v[SampleSpace] < b.minSpace || v[SampleObjects] < b.minObjects {
+			b.nodes.remove(id)
+		}
+	})
+}
+
+// textMappingID is the id of the mapping of the executable text, see textMapping.
+const textMappingID = 2
+
+// pprofEncoder writes the references in the gzipped pprof protobuf format.
+type pprofEncoder struct{}
+
+func (pprofEncoder) encode(w io.Writer, b *profileBuilder) error {
 	b.flushReference()
-	for i := uint64(5); i < uint64(len(b.strings)); i++ {
+	locEnd := uint64(len(b.strings))
+	// just avoid error msg from pprof tool
+	b.pbMapping(tagProfile_Mapping, uint64(1), uint64(0), uint64(0xff), 0, "-", "", false)
+	if t := b.text; t != nil {
+		// the names of the functions are kept rather than symbolized by the pprof tools
+		b.pbMapping(tagProfile_Mapping, textMappingID, t.start, t.limit, t.offset, t.file, t.buildID, true)
+	}
+	// the file names and the comments are appended to the string table after the locations
+	var files []string
+	fileIndexes := make(map[string]int64)
+	for i := uint64(b.locStart); i < locEnd; i++ {
+		src, ok := b.sources[i]
+		var file int64
+		if ok {
+			if file, ok = fileIndexes[src.file]; !ok {
+				file = int64(len(b.strings) + len(files))
+				fileIndexes[src.file] = file
+				files = append(files, src.file)
+			}
+		}
 		// write location
 		start := b.pb.startMessage()
 		b.pb.uint64Opt(tagLocation_ID, i)
-		b.pbLine(tagLocation_Line, i, 0)
+		if t := b.text; t != nil && src.pc >= t.start && src.pc < t.limit {
+			b.pb.uint64Opt(tagLocation_MappingID, textMappingID)
+			b.pb.uint64Opt(tagLocation_Address, src.pc)
+		}
+		b.pbLine(tagLocation_Line, i, src.line)
 		b.pb.endMessage(tagProfile_Location, start)
 
 		// write function
 		start = b.pb.startMessage()
 		b.pb.uint64Opt(tagFunction_ID, i)
 		b.pb.int64Opt(tagFunction_Name, int64(i))
+		b.pb.int64Opt(tagFunction_Filename, file)
+		b.pb.int64Opt(tagFunction_StartLine, src.line)
 		b.pb.endMessage(tagProfile_Function, start)
 	}
-	// just avoid error msg from pprof tool
-	b.pbMapping(tagProfile_Mapping, uint64(1), uint64(0), uint64(0xff), 0, "-", "", false)
+	for i := range b.comments {
+		b.pb.int64(tagProfile_Comment, int64(len(b.strings)+len(files)+i))
+	}
 	b.pb.strings(tagProfile_StringTable, b.strings)
-	b.zw.Write(b.pb.data)
-	b.zw.Close()
+	b.pb.strings(tagProfile_StringTable, files)
+	b.pb.strings(tagProfile_StringTable, b.comments)
+	zw := b.zw
+	if zw == nil {
+		zw, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+	}
+	if _, err := zw.Write(b.pb.data); err != nil {
+		return err
+	}
+	return zw.Close()
 }
 
 type pprofIndex struct {
 	idx   uint64
 	prev  *pprofIndex
-	depth int
+	depth int32
+	// the path interned by the nodes of the builder, 0 if not interned yet, see nodeTable.pathOf
+	path atomic.Uint64
+	// the label set of the goroutine referencing the path, 0 if none, see labelSetIndex
+	labels uint32
+	// whether the path has a node matching the type regex, see ObjRefScope.typeRegex
+	matched bool
+	// whether the path below is limited to maxDepth by the depth rules, see depthRules
+	limited  bool
+	maxDepth int32
 }
 
 func (i *pprofIndex) pushHead(pb *profileBuilder, name string) *pprofIndex {
@@ -350,10 +875,24 @@ func (i *pprofIndex) pushHead(pb *profileBuilder, name string) *pprofIndex {
 		pi.depth = 0
 	} else {
 		pi.depth = i.depth + 1
+		pi.matched = i.matched
+		pi.limited, pi.maxDepth = i.limited, i.maxDepth
+		pi.labels = i.labels
 	}
 	return pi
 }
 
+// pathNames returns the names of the path, root first.
+func (b *profileBuilder) pathNames(i *pprofIndex) []string {
+	// indexes are from leaf to root
+	indexes := i.indexes()
+	names := make([]string, len(indexes))
+	for k, index := range indexes {
+		names[len(indexes)-1-k] = b.strings[index]
+	}
+	return names
+}
+
 func (i *pprofIndex) indexes() (res []uint64) {
 	tmp := i
 	for tmp != nil {
@@ -0,0 +1,55 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// recoverScan recovers from a panic in a part of the scanning, like reading a span or scanning
+// the roots of a goroutine, e.g. on a malformed runtime struct. The part is skipped, and the rest
+// is still scanned, so the profile built so far is output. It must be deferred directly.
+func (s *HeapScope) recoverScan(phase string, item int) {
+	if r := recover(); r != nil {
+		s.panics.Add(1)
+		s.logger.Errorf("%s: skip #%d on panic: %v", phase, item, r)
+		s.logger.Warnf("%s", debug.Stack())
+	}
+}
+
+// safely runs the phase of the scanning, which is skipped on panic.
+func (s *HeapScope) safely(phase string, fn func()) {
+	defer s.recoverScan(phase, 0)
+	fn()
+}
+
+// runJob runs the i-th job of the phase, which is skipped on panic.
+func (s *ObjRefScope) runJob(phase string, i int, fn func(w *ObjRefScope, i int)) {
+	defer s.recoverScan(phase, i)
+	fn(s, i)
+}
+
+// readJob runs the i-th job of reading the heap, which is skipped on panic.
+func (s *HeapScope) readJob(phase string, i int, fn func(i int) bool) (ok bool) {
+	ok = true
+	defer s.recoverScan(phase, i)
+	return fn(i)
+}
+
+// panicError converts the recovered value to an error.
+func panicError(r any) error {
+	return fmt.Errorf("panic: %v", r)
+}
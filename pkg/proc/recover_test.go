@@ -0,0 +1,74 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecoverScan(t *testing.T) {
+	o := newOptions(nil)
+	s := &ObjRefScope{HeapScope: &HeapScope{logger: o.logger}, canceler: canceler{ctx: context.Background()}}
+	var scanned []int
+	s.parallel(1, "test", 4, func(_ *ObjRefScope, i int) {
+		if i == 1 {
+			var r *region
+			r.Uint16() // nil dereference like a malformed runtime struct
+		}
+		scanned = append(scanned, i)
+	})
+	if len(scanned) != 3 || scanned[1] != 2 {
+		t.Fatalf("got the jobs %v scanned, want [0 2 3]", scanned)
+	}
+	if n := s.panics.Load(); n != 1 {
+		t.Fatalf("got %d panics, want 1", n)
+	}
+}
+
+func TestParallelRead(t *testing.T) {
+	o := newOptions(nil)
+	for _, n := range []int{1, 4} {
+		s := &HeapScope{logger: o.logger, parallelism: n, canceler: canceler{ctx: context.Background()}}
+		read := make([]bool, 100)
+		s.parallelRead("test", len(read), func(i int) bool {
+			if i == 1 {
+				var r *region
+				r.Uint16()
+			}
+			read[i] = true
+			return true
+		})
+		for i, ok := range read {
+			if ok == (i == 1) {
+				t.Fatalf("got job %d read %v with %d workers", i, ok, n)
+			}
+		}
+		if got := s.panics.Load(); got != 1 {
+			t.Fatalf("got %d panics with %d workers, want 1", got, n)
+		}
+	}
+
+	// stops once a job fails
+	s := &HeapScope{logger: o.logger, parallelism: 1, canceler: canceler{ctx: context.Background()}}
+	var jobs []int
+	s.parallelRead("test", 10, func(i int) bool {
+		jobs = append(jobs, i)
+		return i < 2
+	})
+	if len(jobs) != 3 {
+		t.Fatalf("got the jobs %v run, want [0 1 2]", jobs)
+	}
+}
@@ -15,9 +15,9 @@
 package proc
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"reflect"
 	"regexp"
@@ -25,12 +25,12 @@ import (
 
 	"github.com/go-delve/delve/pkg/dwarf/godwarf"
 	"github.com/go-delve/delve/pkg/dwarf/reader"
-	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/pkg/proc"
 )
 
 const (
-	maxRefDepth           = 256
+	defaultMaxRefDepth    = 256
+	defaultMaxArrayElems  = 10
 	disableDwarfSearching = false
 )
 
@@ -41,10 +41,106 @@ type ObjRefScope struct {
 
 	// maybe nil
 	g *stack
+	// the label set of the goroutine being scanned, which labels the roots found, see setGoroutineLabels
+	labels uint32
+
+	// max depth of the reference paths
+	maxDepth int
+	// the array elements from maxArrayElems on are collapsed to one node, 0 means no collapsing
+	maxArrayElems int64
+	// only one of every mapSampleEvery map entries is scanned, the others are left to the final marks
+	mapSampleEvery int64
+	// the max bytes of the map keys shown in the node names, 0 means not shown
+	showValues int
+	// only one of every sampleEvery heap objects up to sampleMaxSize bytes is scanned, see sampleWeight
+	sampleEvery, sampleMaxSize int64
+	// only the paths with a node of the matching type are recorded, maybe nil
+	typeRegex *regexp.Regexp
+	// the depth limits below the nodes of the matching types, maybe nil
+	depthRules depthRules
+
+	// the states of a worker scanning in parallel, see parallel
+	finalMarks []finalMarkParam
+	reached    struct{ objects, space int64 }
+	// the weight of the objects found next, which is more than 1 below a sampled object, and whether they're
+	// sampled, i.e. referenced by a large object or variable, see sampleWeight
+	weight   int64
+	sampling bool
+	canceler
+	// the buffered channels found by their addresses, nil if not collected
+	channels map[Address]channelRef
+	// the largest heap objects found, nil if not collected
+	largeObjects *largeObjectHeap
+	// the timers found by their callbacks, nil if the timers root is not scanned
+	timers map[string]*TimerStat
+
+	// memory copied during the freeze, nil if not frozen
+	snapshot *snapshotMemory
+	// the stack of the variables being scanned by findRef
+	frames []refFrame
+	// the struct types classified by their names, see classifyStruct
+	structClasses map[*godwarf.StructType]structClass
+}
+
+// goroutineRoot is a goroutine whose stack frames are scanned as roots.
+type goroutineRoot struct {
+	g        *proc.G
+	lo, hi   Address
+	threadID int
+	frames   []proc.Stackframe
+	// the error unwinding the frames, or the frames are truncated
+	framesErr error
+	// the pprof labels of the goroutine, nil if not labeled
+	labels map[string]string
+	// the start function of the goroutine, maybe nil, and the site of it, see goroutineSite
+	startFn *proc.Function
+	site    string
+	// the root of the references from the stack frames if grouping by goroutine
+	root *pprofIndex
+}
+
+// currentThreadID returns the ID of the current thread, or of another thread if it's nil like globalScope,
+// or 0 if there is no thread.
+func currentThreadID(cur proc.Thread, list []proc.Thread) int {
+	if ths := threadsCurrentFirst(cur, list); len(ths) > 0 {
+		return ths[0].ThreadID()
+	}
+	return 0
+}
+
+// readGoroutines reads all goroutines and their stack frames through the target.
+func readGoroutines(t *proc.Target) []*goroutineRoot {
+	var grs []*goroutineRoot
+	threadID := currentThreadID(t.CurrentThread(), t.ThreadList())
+	gs, _, _ := proc.GoroutinesInfo(t, 0, 0)
+	for _, g := range gs {
+		lo, hi := getStack(g)
+		if g.Thread != nil {
+			threadID = g.Thread.ThreadID()
+		}
+		sf, err := proc.GoroutineStacktrace(t, g, maxStackFrames, 0)
+		if err == nil && len(sf) > maxStackFrames {
+			err = fmt.Errorf("more than %d frames", maxStackFrames)
+		}
+		gr := &goroutineRoot{g: g, lo: Address(lo), hi: Address(hi), threadID: threadID, frames: sf, framesErr: err,
+			labels: goroutineLabels(g), startFn: g.StartLoc(t).Fn,
+		}
+		gr.site = goroutineSite(gr)
+		grs = append(grs, gr)
+	}
+	return grs
 }
 
 func (s *ObjRefScope) findObject(addr Address, typ godwarf.Type, mem proc.MemoryReadWriter) (v *ReferenceVariable) {
 	sp, base := s.findSpanAndBase(addr)
+	if sp != nil && sp.userArena {
+		// in a user arena chunk, which is attributed to the arena root as a whole,
+		// so the object is only scanned for the references, like in the data segments
+		if addr.Add(typ.Size()) > sp.elemEnd(base) || !s.markSpan(sp, addr) {
+			return
+		}
+		return newReferenceVariable(addr, "", resolveTypedef(typ), mem, nil)
+	}
 	if sp == nil {
 		// not in heap
 		var end Address
@@ -68,34 +164,113 @@ func (s *ObjRefScope) findObject(addr Address, typ godwarf.Type, mem proc.Memory
 		v = newReferenceVariable(addr, "", resolveTypedef(typ), mem, nil)
 		return
 	}
+	weight := s.sampleWeight(base, sp.elemSize)
+	if weight == 0 {
+		return // not sampled
+	}
+	s.addRetainedEdge(base, sp.elemSize*weight, typ)
 	// Find mark bit
-	if !sp.mark(base) {
+	hb := s.visitObject(sp, base)
+	if hb == nil {
 		return // already found
 	}
-	realBase := s.copyGCMask(sp, base)
+	s.guard.tick()
+	s.reached.objects += weight
+	s.reached.space += sp.elemSize * weight
+	typ = resolveTypedef(typ)
+	if name := objectTypeName(typ); name != unknownTypeName {
+		s.pb.addObjects(name, sp.elemSize*weight, weight)
+	} else {
+		// e.g. referenced by an unsafe.Pointer
+		s.addUntypedObject(sp, base, weight)
+	}
 
 	// heap bits searching
-	hb := newGCBitsIterator(realBase, sp.elemEnd(base), sp.base, sp.ptrMask)
 	if hb.nextPtr(false) != 0 {
 		// has pointer, cache mem
-		mem = cacheMemory(mem, uint64(base), int(sp.elemSize))
+		mem = s.guard.cacheMemory(mem, uint64(base), int(sp.elemSize))
 	}
-	v = newReferenceVariableWithSizeAndCount(addr, "", resolveTypedef(typ), mem, hb, sp.elemSize, 1)
+	v = newReferenceVariableWithSizeAndCount(addr, "", typ, mem, hb, sp.elemSize*weight, weight)
+	v.weight = weight
 	return
 }
 
-func (s *HeapScope) markObject(addr Address, mem proc.MemoryReadWriter) (size, count int64) {
+// sampleWeight returns the number of the objects the heap object at base of size bytes stands for, or 0 if
+// it's not scanned. The objects up to sampleMaxSize bytes referenced by a large object or variable, like the
+// elements of a big slice or map, are sampled, one of every sampleEvery chosen by the hash of its address, so
+// that it's chosen or not wherever it's reached, even by another worker. The objects referenced by the small
+// ones, like the nodes of a list, are not, so that a whole structure doesn't depend on a single sample.
+// A sampled object weighs sampleEvery, and so do the objects scanned below it, which are not sampled again.
+func (s *ObjRefScope) sampleWeight(base Address, size int64) int64 {
+	if s.weight > 1 {
+		return s.weight
+	}
+	if !s.sampling || size > s.sampleMaxSize {
+		return 1
+	}
+	if (uint64(base)*0x9e3779b97f4a7c15>>32)%uint64(s.sampleEvery) != 0 {
+		return 0
+	}
+	return s.sampleEvery
+}
+
+// samplesBelow returns whether the objects referenced by an object or a variable of size bytes with the weight
+// are sampled.
+func (s *ObjRefScope) samplesBelow(weight, size int64) bool {
+	return s.sampleEvery > 1 && weight <= 1 && size > s.sampleMaxSize
+}
+
+// enterSampled sets the weight and the sampling of the objects found next, and returns the function restoring
+// the previous ones.
+func (s *ObjRefScope) enterSampled(weight int64, sampling bool) func() {
+	prevWeight, prevSampling := s.weight, s.sampling
+	s.weight, s.sampling = weight, sampling
+	return func() {
+		s.weight, s.sampling = prevWeight, prevSampling
+	}
+}
+
+// addUntypedObject adds the object at base without the DWARF type, e.g. found by the GC bits only,
+// to the type histogram and the object graph, named by the type in its allocation header if any.
+func (s *ObjRefScope) addUntypedObject(sp *spanInfo, base Address, weight int64) {
+	if g := s.retained; s.pb.groupBy != GroupByType && (g == nil || !g.export) {
+		return
+	}
+	name := s.allocTypeName(sp, base)
+	s.pb.addObjects(name, sp.elemSize*weight, weight)
+	s.setRetainedType(base, name)
+}
+
+// markObject marks the object at addr and the objects reached by its GC bits, and returns their bytes and count,
+// and the bytes and count of the object itself, which are 0 if it's not found or already marked.
+func (s *ObjRefScope) markObject(addr Address, mem proc.MemoryReadWriter, idx *pprofIndex) (size, count, selfSize, selfCount int64) {
 	sp, base := s.findSpanAndBase(addr)
-	if sp == nil {
-		return // not found
+	if sp == nil || sp.userArena || s.done() {
+		return // not found, scanned by the arena root, or canceled
+	}
+	weight := s.sampleWeight(base, sp.elemSize)
+	if weight == 0 {
+		return // not sampled
 	}
+	s.addRetainedEdge(base, sp.elemSize*weight, nil)
 	// Find mark bit
-	if !sp.mark(base) {
+	hb := s.visitObject(sp, base)
+	if hb == nil {
 		return // already found
 	}
-	realBase := s.copyGCMask(sp, base)
-	size, count = sp.elemSize, 1
-	hb := newGCBitsIterator(realBase, sp.elemEnd(base), sp.base, sp.ptrMask)
+	s.guard.tick()
+	s.reached.objects += weight
+	s.reached.space += sp.elemSize * weight
+	size, count = sp.elemSize*weight, weight
+	selfSize, selfCount = size, count
+	s.addUntypedObject(sp, base, weight)
+	s.addLargeObject(base, sp.elemSize, nil, idx)
+	if s.retained != nil {
+		defer s.enterRetained(base, s.retained.idx)()
+	}
+	if s.sampleEvery > 1 {
+		defer s.enterSampled(weight, s.samplesBelow(weight, sp.elemSize))()
+	}
 	var cmem proc.MemoryReadWriter
 	for {
 		ptr := hb.nextPtr(true)
@@ -103,281 +278,663 @@ func (s *HeapScope) markObject(addr Address, mem proc.MemoryReadWriter) (size, c
 			break
 		}
 		if cmem == nil {
-			cmem = cacheMemory(mem, uint64(ptr), int(hb.end.Sub(ptr)))
+			cmem = s.guard.cacheMemory(mem, uint64(ptr), int(hb.end.Sub(ptr)))
 		}
 		nptr, err := readUintRaw(cmem, uint64(ptr), int64(s.bi.Arch.PtrSize()))
 		if err != nil {
 			continue
 		}
-		size_, count_ := s.markObject(Address(nptr), cmem)
+		size_, count_, _, _ := s.markObject(Address(nptr), cmem, idx)
 		size += size_
 		count += count_
 	}
 	return
 }
 
+// record records the objects referenced by idx directly, e.g. the storage of a table.
 func (s *ObjRefScope) record(idx *pprofIndex, size, count int64) {
 	if size == 0 && count == 0 {
 		return
 	}
-	s.pb.addReference(idx.indexes(), count, size)
+	s.recordValues(idx, &sampleValues{SampleObjects: count, SampleSpace: size, SampleSelfObjects: count, SampleSelfSpace: size})
+}
+
+// recordNode records the objects referenced by the node x, including the ones folded into x.
+func (s *ObjRefScope) recordNode(idx *pprofIndex, x *ReferenceVariable) {
+	if x.size == 0 && x.count == 0 {
+		return
+	}
+	s.recordValues(idx, &sampleValues{
+		SampleObjects: x.count, SampleSpace: x.size,
+		SampleSelfObjects: x.selfCount, SampleSelfSpace: x.selfSize,
+	})
+}
+
+func (s *ObjRefScope) recordValues(idx *pprofIndex, values *sampleValues) {
+	if s.typeRegex != nil && !idx.matched {
+		return
+	}
+	s.pb.addPath(idx, values)
 }
 
 type finalMarkParam struct {
 	idx *pprofIndex
 	hb  *gcMaskBitIterator
+	// whether hb is the memory of the root itself, like a stack frame, rather than of an object
+	// folded into the path, so the objects hb points to are referenced by the path directly
+	direct bool
+	// the weight of the object of hb, see sampleWeight, 0 for a root
+	weight int64
 }
 
-func (s *ObjRefScope) finalMark(idx *pprofIndex, hb *gcMaskBitIterator) {
+func (s *ObjRefScope) finalMark(idx *pprofIndex, hb *gcMaskBitIterator, direct bool, weight int64) {
+	defer s.enterSampled(weight, s.samplesBelow(weight, hb.end.Sub(hb.base)))()
 	var ptr Address
-	var size, count int64
+	var values sampleValues
 	var cmem proc.MemoryReadWriter
-	for {
+	for !s.done() {
 		ptr = hb.nextPtr(true)
 		if ptr == 0 {
 			break
 		}
 		if cmem == nil {
-			cmem = cacheMemory(s.mem, uint64(ptr), int(hb.end.Sub(ptr)))
+			cmem = s.guard.cacheMemory(s.mem, uint64(ptr), int(hb.end.Sub(ptr)))
+			if g := s.retained; g != nil {
+				defer s.enterRetained(ptr, idx)()
+				// The stack frames and the data segments are also scanned as variables,
+				// the references which are found already are not counted twice.
+				g.weak = g.nodes[g.src].root
+			}
 		}
 		ptr, err := readUintRaw(cmem, uint64(ptr), int64(s.bi.Arch.PtrSize()))
 		if err != nil {
 			continue
 		}
-		size_, count_ := s.markObject(Address(ptr), cmem)
-		size += size_
-		count += count_
+		size, count, selfSize, selfCount := s.markObject(Address(ptr), cmem, idx)
+		values[SampleSpace] += size
+		values[SampleObjects] += count
+		if direct {
+			values[SampleSelfSpace] += selfSize
+			values[SampleSelfObjects] += selfCount
+		}
 	}
-	s.record(idx, size, count)
+	if !values.isZero() {
+		s.recordValues(idx, &values)
+	}
+}
+
+// refKind is how the children of a refFrame are generated, by the type of its variable.
+type refKind uint8
+
+const (
+	refNone      refKind = iota // no children to scan
+	refPtr                      // the object pointed to
+	refChan                     // the buffer of the channel, the hchan is flattened at once
+	refString                   // the bytes of the string
+	refSlice                    // the backing array of the slice
+	refInterface                // the object in the interface
+	refFunc                     // the closure of the func value
+	refFinalized                // the object with a finalizer
+	refMap                      // the keys and the values of the map
+	refStruct                   // the fields of the struct
+	refArray                    // the elements of the array
+)
+
+// refFrame is a variable being scanned by findRef. Its children are generated one by one by nextChild, each
+// scanned completely before the next one is generated, so the order is the same as the depth-first recursion.
+// The frames are values on the stack of the scope with the cursors of the children, so that a frame costs
+// no allocation.
+type refFrame struct {
+	x    *ReferenceVariable
+	idx  *pprofIndex
+	kind refKind
+	// the weight and the sampling of the objects found below x, see sampleWeight
+	weight   int64
+	sampling bool
+	// whether the rest of the children are skipped
+	done bool
+	// the next field or element and the number of them, or 1 once the only child is generated
+	i, n int64
+	// the struct type of the fields, or the type of the elements
+	st   *godwarf.StructType
+	elem godwarf.Type
+	// the child being scanned, and its own node if it's not flattened into x, see interfaceObject
+	y    *ReferenceVariable
+	yidx *pprofIndex
+	// the bytes of the slice beyond its length
+	waste int64
+	// nil until the buckets of the map are found
+	m *mapCursor
+	// the source of the retained references before x, restored once x is done
+	saved retainedCursor
+	err   error
+}
+
+// mapCursor is the cursor of the entries of a map being scanned by findRef.
+type mapCursor struct {
+	it      *mapIterator
+	entries int64
+	// whether the value of the current entry is the next child, and the preview of its key
+	val     bool
+	preview string
 }
 
 // findRef finds sub refs of x, and records them to pprof buffer.
-func (s *ObjRefScope) findRef(x *ReferenceVariable, idx *pprofIndex) (err error) {
+// The references are traversed depth-first with an explicit stack of frames rather than recursively,
+// so that deep structures like long linked lists don't grow the goroutine stack. The stack is shared
+// by the nested calls, e.g. from findWeakRef, each of which only pops its own frames.
+func (s *ObjRefScope) findRef(x *ReferenceVariable, idx *pprofIndex) error {
+	defer s.enterSampled(s.weight, s.sampling)()
+	base := len(s.frames)
+	if err := s.enterRef(x, idx); len(s.frames) == base {
+		return err
+	}
+	for {
+		// frames may move by the pushes, so only referenced by pointers in between
+		top := len(s.frames) - 1
+		f := &s.frames[top]
+		s.weight, s.sampling = f.weight, f.sampling
+		if y, yidx, ok := s.nextChild(f); ok {
+			if err := s.enterRef(y, yidx); len(s.frames) == top+1 {
+				f = &s.frames[top]
+				f.done = s.childDone(f, err)
+			}
+			continue
+		}
+		s.leaveRef(f)
+		err := f.err
+		s.frames[top] = refFrame{}
+		s.frames = s.frames[:top]
+		if top == base {
+			return err
+		}
+		f = &s.frames[top-1]
+		f.done = s.childDone(f, err)
+	}
+}
+
+// enterRef starts scanning x, and pushes its frame, or returns the error if x is not to be scanned.
+func (s *ObjRefScope) enterRef(x *ReferenceVariable, idx *pprofIndex) error {
+	if s.done() {
+		return s.ctx.Err()
+	}
+	f := refFrame{x: x, weight: s.weight, sampling: s.sampling}
+	if x.weight > 0 {
+		// a heap object, whose size is its own yet
+		f.weight, f.sampling = x.weight, s.samplesBelow(x.weight, x.size/x.weight)
+	} else if !f.sampling && s.sampleEvery > 1 {
+		f.sampling = s.samplesBelow(f.weight, x.RealType.Size())
+	}
 	if x.Name != "" {
-		if idx != nil && idx.depth >= maxRefDepth {
-			// No scan for depth >= maxRefDepth, as it could lead to uncontrollable reference chain depths.
+		if s.depthExceeded(idx) {
+			// No scan for depth >= maxDepth, as it could lead to uncontrollable reference chain depths.
 			// No need to worry about memory not being able to be recorded, as the parent object will be finally scanned.
-			return
+			return nil
 		}
 		// For array elem / map kv / struct field type, record them.
 		idx = idx.pushHead(s.pb, x.Name)
-		defer func() { s.record(idx, x.size, x.count) }()
-	} else {
-		// For newly found heap objects, check if all pointers have been scanned by the DWARF searching.
-		defer func() {
-			if x.hb.nextPtr(false) != 0 {
+		if idx.prev == nil {
+			idx.labels = s.labels
+		}
+		if s.typeRegex != nil && !idx.matched {
+			idx.matched = s.typeRegex.MatchString(typeName(x.RealType))
+		}
+		if s.depthRules != nil {
+			s.depthRules.limit(idx, x.RealType)
+		}
+	}
+	f.idx = idx
+	if s.retained != nil {
+		f.saved = s.retained.retainedCursor
+		s.setRetained(x.Addr, idx)
+	}
+	if x.Name == "" && x.hb != nil {
+		s.addLargeObject(x.Addr, x.size/max(x.weight, 1), x.RealType, idx)
+	}
+	s.weight, s.sampling = f.weight, f.sampling
+	s.refChildren(&f)
+	s.frames = append(s.frames, f)
+	return nil
+}
+
+// leaveRef finishes scanning the variable of the frame once all its children are done.
+func (s *ObjRefScope) leaveRef(f *refFrame) {
+	x := f.x
+	if m := f.m; m != nil {
+		// avoid missing memory
+		for _, obj := range m.it.objects {
+			if obj.hb.nextPtr(false) != 0 {
 				// still has pointer, add to the finalMarks
-				s.finalMarks = append(s.finalMarks, finalMarkParam{idx, x.hb})
+				s.finalMarks = append(s.finalMarks, finalMarkParam{f.idx, obj.hb, false, max(obj.weight, f.weight)})
 			}
-		}()
+		}
+		if m.entries > 0 {
+			s.recordValues(f.idx, &sampleValues{SampleEntries: m.entries})
+		}
+		// the buckets are the storage of the map itself
+		x.fold(m.it.size, m.it.count, m.it.size, m.it.count)
+	}
+	// For newly found heap objects, check if all pointers have been scanned by the DWARF searching.
+	if x.Name == "" && x.hb.nextPtr(false) != 0 {
+		// still has pointer, add to the finalMarks
+		s.finalMarks = append(s.finalMarks, finalMarkParam{f.idx, x.hb, false, f.weight})
 	}
+	if s.retained != nil {
+		s.retained.retainedCursor = f.saved
+	}
+	if x.Name != "" {
+		s.recordNode(f.idx, x)
+	}
+}
+
+// refChildren sets the kind of the children of the frame by the type of its variable.
+func (s *ObjRefScope) refChildren(f *refFrame) {
+	x, idx := f.x, f.idx
 	switch typ := x.RealType.(type) {
 	case *godwarf.PtrType:
-		var ptrval uint64
-		ptrval, err = x.readPointer(x.Addr)
-		if err != nil {
+		f.kind = refPtr
+	case *godwarf.ChanType:
+		f.kind = refChan
+	case *godwarf.MapType:
+		f.kind = refMap
+	case *godwarf.StringType:
+		f.kind = refString
+	case *godwarf.SliceType:
+		f.kind = refSlice
+	case *godwarf.InterfaceType:
+		f.kind = refInterface
+	case *godwarf.StructType:
+		c := s.classifyStruct(typ)
+		if c.weak {
+			s.findWeakRef(x, typ, idx)
 			return
 		}
-		if y := s.findObject(Address(ptrval), resolveTypedef(typ.Type), proc.DereferenceMemory(x.mem)); y != nil {
-			_ = s.findRef(y, idx)
-			// flatten reference
-			x.size += y.size
-			x.count += y.count
+		if c.hashTrieMap != "" && s.findHashTrieMapRef(x, typ, c.hashTrieMap, idx) {
+			return
 		}
-	case *godwarf.ChanType:
-		var ptrval uint64
-		ptrval, err = x.readPointer(x.Addr)
-		if err != nil {
+		f.kind, f.st = refStruct, c.typ
+		f.n = int64(len(f.st.Field))
+	case *godwarf.ArrayType:
+		eType := resolveTypedef(typ.Type)
+		if !hasPtrType(eType) {
 			return
 		}
-		if y := s.findObject(Address(ptrval), resolveTypedef(typ.Type.(*godwarf.PtrType).Type), proc.DereferenceMemory(x.mem)); y != nil {
-			x.size += y.size
-			x.count += y.count
+		if s.depthExceeded(idx) {
+			// all elements will be skipped by the depth limit, leave them to the final mark.
+			return
+		}
+		f.kind, f.elem, f.n = refArray, eType, s.arrayScanCount(x, typ.Count, eType.Size())
+	case *godwarf.FuncType:
+		f.kind = refFunc
+	case *finalizePtrType:
+		f.kind = refFinalized
+	default:
+	}
+}
 
-			structType, ok := y.RealType.(*godwarf.StructType)
-			if !ok {
-				return
-			}
-			var zptrval, chanLen uint64
-			for _, field := range structType.Field {
-				switch field.Name {
-				case "buf":
-					zptrval, err = y.readPointer(y.Addr.Add(field.ByteOffset))
-					if err != nil {
-						return
-					}
-				case "dataqsiz":
-					chanLen, _ = y.readUint64(y.Addr.Add(field.ByteOffset))
-				}
-			}
-			if z := s.findObject(Address(zptrval), fakeArrayType(chanLen, typ.ElemType), y.mem); z != nil {
-				_ = s.findRef(z, idx)
-				x.size += z.size
-				x.count += z.count
-			}
+// nextChild returns the next child of the frame and its node, or false if there is no more.
+func (s *ObjRefScope) nextChild(f *refFrame) (*ReferenceVariable, *pprofIndex, bool) {
+	x := f.x
+	switch f.kind {
+	case refNone:
+		return nil, nil, false
+	case refStruct:
+		if f.done || f.i >= f.n {
+			return nil, nil, false
 		}
-	case *godwarf.MapType:
-		var ptrval uint64
-		ptrval, err = x.readPointer(x.Addr)
-		if err != nil {
-			return
+		field := f.st.Field[f.i]
+		f.i++
+		fieldAddr := x.Addr.Add(field.ByteOffset)
+		y := newReferenceVariable(fieldAddr, field.Name+". ("+field.Type.String()+")", resolveTypedef(field.Type), x.mem, x.hb)
+		if f.st.StructName == "sync.Pool" {
+			y = s.pooledVariable(x, f.st, field, y)
 		}
-		if y := s.findObject(Address(ptrval), resolveTypedef(typ.Type.(*godwarf.PtrType).Type), proc.DereferenceMemory(x.mem)); y != nil {
-			var it *mapIterator
-			it, err = s.toMapIterator(y)
-			if err != nil {
-				// logflags.DebuggerLogger().Errorf("toMapIterator failed: %v", err)
-				return
-			}
-			for s.next(it) {
-				// find key ref
-				if key := it.key(); key != nil {
-					key.Name = "$mapkey. (" + key.RealType.String() + ")"
-					if err := s.findRef(key, idx); errors.Is(err, errOutOfRange) {
-						continue
-					}
-				}
-				// find val ref
-				if val := it.value(); val != nil {
-					val.Name = "$mapval. (" + val.RealType.String() + ")"
-					if err := s.findRef(val, idx); errors.Is(err, errOutOfRange) {
-						continue
-					}
-				}
-			}
-			// avoid missing memory
-			for _, obj := range it.objects {
-				if obj.hb.nextPtr(false) != 0 {
-					// still has pointer, add to the finalMarks
-					s.finalMarks = append(s.finalMarks, finalMarkParam{idx, obj.hb})
-				}
-			}
-			x.size += it.size
-			x.count += it.count
+		f.y = y
+		return y, f.idx, true
+	case refArray:
+		if f.done || f.i >= f.n {
+			return nil, nil, false
 		}
-	case *godwarf.StringType:
-		var strAddr, strLen uint64
-		strAddr, strLen, err = readStringInfo(x)
+		elemAddr := x.Addr.Add(f.i * f.elem.Size())
+		f.y = newReferenceVariable(elemAddr, s.arrayElemName(f.i)+". ("+f.elem.String()+")", f.elem, x.mem, x.hb)
+		f.i++
+		return f.y, f.idx, true
+	case refMap:
+		f.y = s.nextMapEntry(f)
+		return f.y, f.idx, f.y != nil
+	}
+	if f.i > 0 {
+		// the only child is generated
+		return nil, nil, false
+	}
+	f.i = 1
+	var y *ReferenceVariable
+	switch f.kind {
+	case refPtr:
+		ptrval, err := x.readPointer(x.Addr)
 		if err != nil {
-			return
+			f.err = err
+			return nil, nil, false
 		}
-		if y := s.findObject(Address(strAddr), fakeArrayType(strLen, &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "byte", ReflectKind: reflect.Uint8}, BitSize: 8, BitOffset: 0}}), proc.DereferenceMemory(x.mem)); y != nil {
-			_ = s.findRef(y, idx)
-			x.size += y.size
-			x.count += y.count
+		y = s.findObject(Address(ptrval), resolveTypedef(x.RealType.(*godwarf.PtrType).Type), proc.DereferenceMemory(x.mem))
+	case refChan:
+		y = s.chanBuffer(f)
+	case refString:
+		strAddr, strLen, err := readStringInfo(x)
+		if err != nil {
+			f.err = err
+			return nil, nil, false
 		}
-	case *godwarf.SliceType:
-		var base, cap_ uint64
-		for _, f := range typ.Field {
-			switch f.Name {
-			case "array":
-				base, err = x.readPointer(x.Addr.Add(f.ByteOffset))
-				if err != nil {
-					return
-				}
-			case "cap":
-				cap_, _ = x.readUint64(x.Addr.Add(f.ByteOffset))
-			}
+		y = s.findObject(Address(strAddr), fakeArrayType(strLen, &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "byte", ReflectKind: reflect.Uint8}, BitSize: 8, BitOffset: 0}}), proc.DereferenceMemory(x.mem))
+	case refSlice:
+		y = s.sliceArray(f)
+	case refInterface:
+		y = s.interfaceObject(f)
+	case refFunc:
+		y = s.closureObject(f)
+	case refFinalized:
+		y = s.findObject(x.Addr, new(godwarf.VoidType), x.mem)
+	}
+	if y == nil {
+		return nil, nil, false
+	}
+	f.y = y
+	if f.yidx != nil {
+		return y, f.yidx, true
+	}
+	return y, f.idx, true
+}
+
+// childDone is called with the result of scanning the child of the frame, and returns whether to skip
+// the rest of the children.
+func (s *ObjRefScope) childDone(f *refFrame, err error) (skip bool) {
+	x, y := f.x, f.y
+	f.y = nil
+	switch f.kind {
+	case refStruct:
+		if f.err = err; errors.Is(err, errOutOfRange) {
+			return true
 		}
-		if y := s.findObject(Address(base), fakeArrayType(cap_, typ.ElemType), proc.DereferenceMemory(x.mem)); y != nil {
-			_ = s.findRef(y, idx)
-			x.size += y.size
-			x.count += y.count
+		if f.st.Field[f.i-1].Name == "buf" {
+			s.recordBufferWaste(x, f.st, y, f.idx)
 		}
-	case *godwarf.InterfaceType:
-		_type, data := s.readInterface(x)
-		if data == nil {
-			return
+	case refArray:
+		f.err = err
+		return errors.Is(err, errOutOfRange)
+	case refMap:
+		if errors.Is(err, errOutOfRange) {
+			// skip the value of the key
+			f.m.val = false
 		}
-		var ptrval uint64
-		ptrval, err = data.readPointer(data.Addr)
-		if err != nil || ptrval == 0 {
-			return
+	case refInterface:
+		if f.yidx != nil {
+			s.recordNode(f.yidx, y)
+			return false
 		}
-		var ityp godwarf.Type
-		if _type != nil {
-			var rtyp godwarf.Type
-			var kind int64
-			rtyp, kind, err = proc.RuntimeTypeToDIE(_type, uint64(data.Addr), s.mds)
-			if err == nil {
-				if kind&kindDirectIface == 0 {
-					if _, isptr := resolveTypedef(rtyp).(*godwarf.PtrType); !isptr {
-						rtyp = pointerTo(rtyp, s.bi.Arch)
-					}
-				}
-				if ptrType, isPtr := resolveTypedef(rtyp).(*godwarf.PtrType); isPtr {
-					ityp = resolveTypedef(ptrType.Type)
-				}
+		// flatten reference
+		x.flatten(y)
+	case refSlice:
+		x.flatten(y)
+		if f.waste > 0 {
+			// the elements beyond the length are allocated but unused, e.g. by over-grown append buffers,
+			// bounded by the allocation of the backing array itself, not the objects found through it
+			if x.waste = min(f.waste, y.selfSize); x.waste > 0 {
+				s.recordValues(f.idx, &sampleValues{SampleWaste: x.waste})
 			}
 		}
-		if ityp == nil {
-			ityp = new(godwarf.VoidType)
-		}
-		if y := s.findObject(Address(ptrval), ityp, proc.DereferenceMemory(x.mem)); y != nil {
-			_ = s.findRef(y, idx)
-			x.size += y.size
-			x.count += y.count
+	default:
+		// flatten reference
+		x.flatten(y)
+	}
+	return false
+}
+
+// chanBuffer returns the buffer of the channel variable of the frame, after its hchan is flattened into it.
+func (s *ObjRefScope) chanBuffer(f *refFrame) *ReferenceVariable {
+	x, typ := f.x, f.x.RealType.(*godwarf.ChanType)
+	ptrval, err := x.readPointer(x.Addr)
+	if err != nil {
+		f.err = err
+		return nil
+	}
+	if s.channels != nil {
+		// the channel may be reached through other paths first
+		s.recordChannel(x, typ, Address(ptrval), f.idx)
+	}
+	y := s.findObject(Address(ptrval), resolveTypedef(typ.Type.(*godwarf.PtrType).Type), proc.DereferenceMemory(x.mem))
+	if y == nil {
+		return nil
+	}
+	x.flatten(y)
+	if s.retained != nil {
+		// the buffer is referenced by the hchan, until the source of x is restored
+		s.setRetained(y.Addr, f.idx)
+	}
+
+	structType, ok := y.RealType.(*godwarf.StructType)
+	if !ok {
+		return nil
+	}
+	var zptrval, chanLen uint64
+	for _, field := range structType.Field {
+		switch field.Name {
+		case "buf":
+			zptrval, err = y.readPointer(y.Addr.Add(field.ByteOffset))
+			if err != nil {
+				f.err = err
+				return nil
+			}
+		case "dataqsiz":
+			chanLen, _ = y.readUint64(y.Addr.Add(field.ByteOffset))
 		}
-	case *godwarf.StructType:
-		typ = s.specialStructTypes(typ)
-		for _, field := range typ.Field {
-			fieldAddr := x.Addr.Add(field.ByteOffset)
-			y := newReferenceVariable(fieldAddr, field.Name+". ("+field.Type.String()+")", resolveTypedef(field.Type), x.mem, x.hb)
-			if err = s.findRef(y, idx); errors.Is(err, errOutOfRange) {
-				break
+	}
+	return s.findObject(Address(zptrval), fakeArrayType(chanLen, typ.ElemType), y.mem)
+}
+
+// sliceArray returns the backing array of the slice variable of the frame.
+func (s *ObjRefScope) sliceArray(f *refFrame) *ReferenceVariable {
+	x, typ := f.x, f.x.RealType.(*godwarf.SliceType)
+	var base, len_, cap_ uint64
+	for _, field := range typ.Field {
+		switch field.Name {
+		case "array":
+			var err error
+			base, err = x.readPointer(x.Addr.Add(field.ByteOffset))
+			if err != nil {
+				f.err = err
+				return nil
 			}
+		case "len":
+			len_, _ = x.readUint64(x.Addr.Add(field.ByteOffset))
+		case "cap":
+			cap_, _ = x.readUint64(x.Addr.Add(field.ByteOffset))
 		}
-	case *godwarf.ArrayType:
-		eType := resolveTypedef(typ.Type)
-		if !hasPtrType(eType) {
-			return
+	}
+	if len_ < cap_ {
+		f.waste = int64(cap_-len_) * typ.ElemType.Size()
+	}
+	return s.findObject(Address(base), fakeArrayType(cap_, typ.ElemType), proc.DereferenceMemory(x.mem))
+}
+
+// closureObject returns the closure of the func variable of the frame, typed by the closure struct of the func.
+func (s *ObjRefScope) closureObject(f *refFrame) *ReferenceVariable {
+	x := f.x
+	closureAddr, err := x.readPointer(x.Addr)
+	if err != nil || closureAddr == 0 {
+		f.err = err
+		return nil
+	}
+	var cst godwarf.Type
+	var funcAddr uint64
+	funcAddr, f.err = readUintRaw(proc.DereferenceMemory(x.mem), closureAddr, int64(s.bi.Arch.PtrSize()))
+	if f.err == nil && funcAddr != 0 {
+		if fn := s.bi.PCToFunc(funcAddr); fn != nil {
+			cst = s.closureStructType(fn)
 		}
-		for i := int64(0); i < typ.Count; i++ {
-			elemAddr := x.Addr.Add(i * eType.Size())
-			// collapse 10+ elements by default
-			name := "[10+]"
-			if i < 10 {
-				name = "[" + strconv.Itoa(int(i)) + "]"
-			}
-			y := newReferenceVariable(elemAddr, name+". ("+eType.String()+")", eType, x.mem, x.hb)
-			if err = s.findRef(y, idx); errors.Is(err, errOutOfRange) {
-				break
-			}
+	}
+	if cst == nil {
+		cst = new(godwarf.VoidType)
+	}
+	return s.findObject(Address(closureAddr), cst, proc.DereferenceMemory(x.mem))
+}
+
+// nextMapEntry returns the key or the value of the next entry of the map variable of the frame,
+// each value generated after its key is scanned, or nil if there is no more.
+func (s *ObjRefScope) nextMapEntry(f *refFrame) *ReferenceVariable {
+	m := f.m
+	if m == nil {
+		if f.i > 0 {
+			// no buckets
+			return nil
 		}
-	case *godwarf.FuncType:
-		var closureAddr uint64
-		closureAddr, err = x.readPointer(x.Addr)
-		if err != nil || closureAddr == 0 {
-			return
+		f.i = 1
+		x := f.x
+		ptrval, err := x.readPointer(x.Addr)
+		if err != nil {
+			f.err = err
+			return nil
+		}
+		y := s.findObject(Address(ptrval), resolveTypedef(x.RealType.(*godwarf.MapType).Type.(*godwarf.PtrType).Type), proc.DereferenceMemory(x.mem))
+		if y == nil {
+			return nil
 		}
-		var cst godwarf.Type
-		var funcAddr uint64
-		funcAddr, err = readUintRaw(proc.DereferenceMemory(x.mem), closureAddr, int64(s.bi.Arch.PtrSize()))
-		if err == nil && funcAddr != 0 {
-			if fn := s.bi.PCToFunc(funcAddr); fn != nil {
-				cst = s.closureStructType(fn)
+		if s.retained != nil {
+			// the buckets are referenced by the hmap, until the source of x is restored
+			s.setRetained(y.Addr, f.idx)
+		}
+		it, err := s.toMapIterator(y)
+		if err != nil {
+			// s.logger.Errorf("toMapIterator failed: %v", err)
+			f.err = err
+			return nil
+		}
+		m = &mapCursor{it: it}
+		f.m = m
+	}
+	for {
+		if m.val {
+			// find val ref
+			m.val = false
+			if v := m.it.value(); v != nil {
+				v.Name = "$mapval" + m.preview + ". (" + v.RealType.String() + ")"
+				return v
 			}
 		}
-		if cst == nil {
-			cst = new(godwarf.VoidType)
+		if !s.next(m.it) {
+			return nil
 		}
-		if closure := s.findObject(Address(closureAddr), cst, proc.DereferenceMemory(x.mem)); closure != nil {
-			_ = s.findRef(closure, idx)
-			x.size += closure.size
-			x.count += closure.count
+		m.entries++
+		if s.mapSampleEvery > 1 && (m.entries-1)%s.mapSampleEvery != 0 {
+			// not sampled, the pointers of the entry are found by the final marks
+			continue
 		}
-	case *finalizePtrType:
-		if y := s.findObject(x.Addr, new(godwarf.VoidType), x.mem); y != nil {
-			_ = s.findRef(y, idx)
-			x.size += y.size
-			x.count += y.count
+		m.preview, m.val = "", true
+		// find key ref
+		if key := m.it.key(); key != nil {
+			if s.showValues > 0 {
+				m.preview = keyPreview(key, s.showValues)
+			}
+			key.Name = "$mapkey" + m.preview + ". (" + key.RealType.String() + ")"
+			return key
 		}
-	default:
 	}
-	return
+}
+
+// interfaceObject returns the object the interface variable of the frame points to, and sets its own
+// node to the frame if the object has no DWARF type.
+func (s *ObjRefScope) interfaceObject(f *refFrame) *ReferenceVariable {
+	x, idx := f.x, f.idx
+	_type, data := s.readInterface(x)
+	if data == nil {
+		return nil
+	}
+	ptrval, err := data.readPointer(data.Addr)
+	if err != nil || ptrval == 0 {
+		f.err = err
+		return nil
+	}
+	var ityp godwarf.Type
+	var typeAddr uint64
+	if _type != nil {
+		var rtyp godwarf.Type
+		var kind int64
+		func() {
+			s.typesMu.Lock()
+			defer s.typesMu.Unlock()
+			rtyp, kind, f.err = proc.RuntimeTypeToDIE(_type, uint64(data.Addr), s.mds)
+		}()
+		if f.err == nil {
+			if kind&kindDirectIface == 0 {
+				if _, isptr := resolveTypedef(rtyp).(*godwarf.PtrType); !isptr {
+					rtyp = pointerTo(rtyp, s.bi.Arch)
+				}
+			}
+			if ptrType, isPtr := resolveTypedef(rtyp).(*godwarf.PtrType); isPtr {
+				ityp = resolveTypedef(ptrType.Type)
+			}
+		} else {
+			// no DIE for the runtime type, e.g. types created by reflect.
+			typeAddr, _ = readUintRaw(getVariableMem(_type), _type.Addr, int64(s.bi.Arch.PtrSize()))
+		}
+	}
+	if ityp == nil {
+		ityp = new(godwarf.VoidType)
+	}
+	y := s.findObject(Address(ptrval), ityp, proc.DereferenceMemory(x.mem))
+	if y != nil && typeAddr != 0 {
+		f.yidx = s.runtimeTypeIndex(y, Address(typeAddr), idx)
+	}
+	return y
+}
+
+// runtimeTypeIndex returns the node of an object which has no DWARF type, e.g. created by reflect.New/reflect.MakeSlice
+// with a type from reflect.StructOf/reflect.SliceOf. The object is scanned conservatively by its heap bits,
+// which come from the runtime type's GC data with allocation headers, and attributed to the runtime type name.
+func (s *ObjRefScope) runtimeTypeIndex(y *ReferenceVariable, typeAddr Address, idx *pprofIndex) *pprofIndex {
+	name := "unknown"
+	if rt, err := s.readRuntimeType(typeAddr); err == nil {
+		name = rt.name
+	} else {
+		s.logger.Warnf("read runtime type %#x error: %v", typeAddr, err)
+	}
+	idx = idx.pushHead(s.pb, "$rtype. ("+name+")")
+	if m := uniqueMapRegex.FindStringSubmatch(name); m != nil {
+		// only the shape of the generic type is in DWARF
+		if typ := s.uniqueMapType(m[1]); typ != nil {
+			y.RealType = typ
+		}
+	}
+	return idx
+}
+
+// arrayScanCount returns the number of array elements that can be scanned.
+// Arrays produced by unsafe conversions, e.g. (*[2112313131]Request)(unsafe.Pointer(p)),
+// may declare far more elements than the underlying object holds, so the count is
+// bounded by the heap bits range of the object to avoid spinning for billions of iterations.
+func (s *ObjRefScope) arrayScanCount(x *ReferenceVariable, count, elemSize int64) int64 {
+	if x.hb == nil || elemSize <= 0 {
+		return count
+	}
+	if x.Addr >= x.hb.end {
+		return 0
+	}
+	if n := CeilDivide(x.hb.end.Sub(x.Addr), elemSize); n < count {
+		return n
+	}
+	return count
+}
+
+// arrayElemName returns the name of the i-th array element, like "[3]", or "[10+]" for the collapsed ones.
+func (s *ObjRefScope) arrayElemName(i int64) string {
+	if s.maxArrayElems > 0 && i >= s.maxArrayElems {
+		return "[" + strconv.FormatInt(s.maxArrayElems, 10) + "+]"
+	}
+	return "[" + strconv.FormatInt(i, 10) + "]"
 }
 
 func (s *ObjRefScope) closureStructType(fn *proc.Function) *godwarf.StructType {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
 	var fe funcExtra
 	if fe = s.funcExtraMap[fn]; fe.closureStructType != nil {
 		return fe.closureStructType
@@ -422,6 +979,36 @@ func (s *ObjRefScope) closureStructType(fn *proc.Function) *godwarf.StructType {
 	return st
 }
 
+// structClass is how a struct type is scanned, which depends on its name only.
+type structClass struct {
+	// a weak pointer, see findWeakRef
+	weak bool
+	// the type arguments of a hash-trie map, "" if not one, see findHashTrieMapRef
+	hashTrieMap string
+	// the fields to scan, see specialStructTypes
+	typ *godwarf.StructType
+}
+
+// classifyStruct returns the class of st, which is matched by the names once for every type,
+// as the regexes are too expensive to match for every struct variable.
+func (s *ObjRefScope) classifyStruct(st *godwarf.StructType) structClass {
+	if c, ok := s.structClasses[st]; ok {
+		return c
+	}
+	c := structClass{weak: weakPointerRegex.MatchString(st.StructName)}
+	if !c.weak {
+		c.typ = s.specialStructTypes(st)
+	}
+	if m := hashTrieMapRegex.FindStringSubmatch(st.StructName); m != nil {
+		c.hashTrieMap = m[1]
+	}
+	if s.structClasses == nil {
+		s.structClasses = make(map[*godwarf.StructType]structClass)
+	}
+	s.structClasses[st] = c
+	return c
+}
+
 var atomicPointerRegex = regexp.MustCompile(`^sync/atomic\.Pointer\[.*\]$`)
 
 func (s *ObjRefScope) specialStructTypes(st *godwarf.StructType) *godwarf.StructType {
@@ -460,116 +1047,25 @@ var loadSingleValue = proc.LoadConfig{}
 
 // ObjectReference scanning goroutine stack and global vars to search all heap objects they reference,
 // and outputs the reference relationship to the filename with pprof format.
-func ObjectReference(t *proc.Target, filename string) error {
-	scope, err := proc.ThreadScope(t, t.CurrentThread())
-	if err != nil {
-		return err
-	}
-
-	heapScope := &HeapScope{mem: t.Memory(), bi: t.BinInfo(), scope: scope, funcExtraMap: make(map[*proc.Function]funcExtra)}
-	err = heapScope.readHeap()
-	if err != nil {
-		return err
-	}
+func ObjectReference(t *proc.Target, filename string, opts ...Option) error {
+	return ObjectReferenceContext(context.Background(), t, filename, opts...)
+}
 
+// ObjectReferenceContext is like ObjectReference, but stops scanning once ctx is done,
+// and still outputs the partial profile.
+func ObjectReferenceContext(ctx context.Context, t *proc.Target, filename string, opts ...Option) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-
-	s := &ObjRefScope{
-		HeapScope: heapScope,
-		pb:        newProfileBuilder(f),
-	}
-
-	mds, err := proc.LoadModuleData(t.BinInfo(), t.Memory())
+	defer f.Close()
+	res, err := Scan(ctx, t, ScanOptions{Writer: f, Options: opts})
 	if err != nil {
-		return err
-	}
-	s.mds = mds
-
-	// Global variables
-	pvs, _ := scope.PackageVariables(loadSingleValue)
-	for _, pv := range pvs {
-		if pv.Addr == 0 || disableDwarfSearching {
-			continue
+		if res != nil {
+			newOptions(opts).logger.Printf("partial profile output to `%s`\n", filename)
 		}
-		s.findRef(newReferenceVariable(Address(pv.Addr), pv.Name, pv.RealType, t.Memory(), nil), nil)
-	}
-
-	// Local variables
-	threadID := t.CurrentThread().ThreadID()
-	grs, _, _ := proc.GoroutinesInfo(t, 0, 0)
-	for _, gr := range grs {
-		s.g = &stack{}
-		lo, hi := getStack(gr)
-		if gr.Thread != nil {
-			threadID = gr.Thread.ThreadID()
-		}
-		sf, _ := proc.GoroutineStacktrace(t, gr, 1024, 0)
-		s.g.init(Address(lo), Address(hi), s.stackPtrMask(Address(lo), Address(hi), sf))
-		if len(sf) > 0 {
-			for i := range sf {
-				ms := myEvalScope{EvalScope: *proc.FrameToScope(t, t.Memory(), gr, threadID, sf[i:]...)}
-				locals, err := ms.Locals(t, gr, threadID, mds)
-				if err != nil {
-					logflags.DebuggerLogger().Warnf("local variables err: %v", err)
-					continue
-				}
-				for _, l := range locals {
-					if l.Addr == 0 || disableDwarfSearching {
-						continue
-					}
-					if l.Name[0] == '&' {
-						// escaped variables
-						l.Name = l.Name[1:]
-					}
-					l.Name = sf[i].Current.Fn.Name + "." + l.Name
-					s.findRef(l, nil)
-				}
-			}
-		}
-		// scan root gc bits in case dwarf searching failure
-		for _, fr := range s.g.frames {
-			it := &(fr.gcMaskBitIterator)
-			if it.nextPtr(false) != 0 {
-				// add to the finalMarks
-				idx := (*pprofIndex)(nil).pushHead(s.pb, fr.funcName)
-				s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it})
-			}
-		}
-	}
-	s.g = nil
-
-	// final mark segment root bits
-	for i, seg := range s.bss {
-		it := &(seg.gcMaskBitIterator)
-		if it.nextPtr(false) != 0 {
-			idx := (*pprofIndex)(nil).pushHead(s.pb, fmt.Sprintf("bss segment[%d]", i))
-			s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it})
-		}
-	}
-	for i, seg := range s.data {
-		it := &(seg.gcMaskBitIterator)
-		if it.nextPtr(false) != 0 {
-			idx := (*pprofIndex)(nil).pushHead(s.pb, fmt.Sprintf("data segment[%d]", i))
-			s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it})
-		}
-	}
-
-	// Finalizers
-	for _, fin := range heapScope.finalizers {
-		// scan object
-		s.findRef(newReferenceVariable(fin.p, "finalized", new(finalizePtrType), s.mem, nil), nil)
-		// scan finalizer
-		s.findRef(newReferenceVariable(fin.fn, "finalizer", new(godwarf.FuncType), s.mem, nil), nil)
-	}
-
-	for _, param := range s.finalMarks {
-		s.finalMark(param.idx, param.hb)
+		return err
 	}
-
-	s.pb.flush()
-	log.Printf("successfully output to `%s`\n", filename)
+	newOptions(opts).logger.Printf("successfully output to `%s`\n", filename)
 	return nil
 }
@@ -0,0 +1,271 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// fakeMemory is a proc.MemoryReadWriter backed by a byte slice starting at base.
+type fakeMemory struct {
+	base uint64
+	data []byte
+}
+
+func (m *fakeMemory) ReadMemory(data []byte, addr uint64) (int, error) {
+	if addr < m.base || addr+uint64(len(data)) > m.base+uint64(len(m.data)) {
+		return 0, errors.New("fake memory: out of range")
+	}
+	return copy(data, m.data[addr-m.base:]), nil
+}
+
+func (m *fakeMemory) WriteMemory(addr uint64, data []byte) (int, error) {
+	return 0, errors.New("fake memory: read only")
+}
+
+func newTestObjRefScope() *ObjRefScope {
+	return &ObjRefScope{
+		HeapScope: &HeapScope{
+			pageSize:       8192,
+			heapArenaBytes: 64 << 20,
+			pagesPerArena:  (64 << 20) / 8192,
+			funcExtraMap:   make(map[*proc.Function]funcExtra),
+			logger:         getLogger(),
+		},
+		pb:            newProfileBuilder(io.Discard, FormatPprof, GroupByPath, nil),
+		maxDepth:      defaultMaxRefDepth,
+		maxArrayElems: defaultMaxArrayElems,
+	}
+}
+
+func TestFindRefHugeFakeArray(t *testing.T) {
+	const objSize = 64
+	mem := &fakeMemory{base: 0x1000, data: make([]byte, objSize)}
+	ptrType := &godwarf.PtrType{
+		CommonType: godwarf.CommonType{ByteSize: 8, Name: "*int64", ReflectKind: reflect.Ptr},
+		Type:       &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "int64", ReflectKind: reflect.Int64}}},
+	}
+	// e.g. (*[2112313131]*int64)(unsafe.Pointer(p)) pointing to a 64 bytes object.
+	arrType := fakeArrayType(2112313131, ptrType)
+	ptrMask := []uint64{^uint64(0)}
+
+	for _, depth := range []int{0, defaultMaxRefDepth} {
+		s := newTestObjRefScope()
+		hb := newGCBitsIterator(Address(mem.base), Address(mem.base+objSize), Address(mem.base), append([]uint64(nil), ptrMask...))
+		x := newReferenceVariable(Address(mem.base), "", arrType, mem, hb)
+		idx := &pprofIndex{depth: int32(depth)}
+
+		done := make(chan struct{})
+		go func() {
+			_ = s.findRef(x, idx)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("findRef on a fake huge array does not return in time, depth %d", depth)
+		}
+	}
+}
+
+func TestFindRefDeepNesting(t *testing.T) {
+	// a struct nested a million levels deep, which a recursive scan would need gigabytes of stack for.
+	const levels = 1 << 20
+	var typ godwarf.Type = &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "int64", ReflectKind: reflect.Int64}}}
+	for i := 0; i < levels; i++ {
+		typ = &godwarf.StructType{
+			CommonType: godwarf.CommonType{ByteSize: 8, Name: "main.T", ReflectKind: reflect.Struct},
+			StructName: "main.T",
+			Kind:       "struct",
+			Field:      []*godwarf.StructField{{Name: "next", Type: typ}},
+		}
+	}
+	mem := &fakeMemory{base: 0x1000, data: make([]byte, 8)}
+	s := newTestObjRefScope()
+	s.maxDepth = levels + 1
+	defer debug.SetMaxStack(debug.SetMaxStack(64 << 20))
+	if err := s.findRef(newReferenceVariable(Address(mem.base), "main.t", typ, mem, nil), nil); err != nil {
+		t.Fatalf("findRef error: %v", err)
+	}
+}
+
+func BenchmarkFindRef(b *testing.B) {
+	int64Type := &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "int64", ReflectKind: reflect.Int64}}}
+	ptrType := &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*int64", ReflectKind: reflect.Ptr}, Type: int64Type}
+	byteType := &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "uint8", ReflectKind: reflect.Uint8}}}
+	field := func(name string, off int64, typ godwarf.Type) *godwarf.StructField {
+		return &godwarf.StructField{Name: name, ByteOffset: off, Type: typ}
+	}
+	sliceType := &godwarf.SliceType{
+		StructType: godwarf.StructType{
+			CommonType: godwarf.CommonType{ByteSize: 24, Name: "[]uint8", ReflectKind: reflect.Slice},
+			StructName: "[]uint8",
+			Kind:       "struct",
+			Field:      []*godwarf.StructField{field("array", 0, &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*uint8", ReflectKind: reflect.Ptr}, Type: byteType}), field("len", 8, int64Type), field("cap", 16, int64Type)},
+		},
+		ElemType: byteType,
+	}
+	stringType := &godwarf.StringType{
+		StructType: godwarf.StructType{
+			CommonType: godwarf.CommonType{ByteSize: 16, Name: "string", ReflectKind: reflect.String},
+			StructName: "string",
+			Kind:       "struct",
+			Field:      []*godwarf.StructField{field("str", 0, ptrType), field("len", 8, int64Type)},
+		},
+	}
+	innerType := &godwarf.StructType{
+		CommonType: godwarf.CommonType{ByteSize: 40, Name: "main.inner", ReflectKind: reflect.Struct},
+		StructName: "main.inner",
+		Kind:       "struct",
+		Field:      []*godwarf.StructField{field("p", 0, ptrType), field("q", 8, fakeArrayType(4, ptrType))},
+	}
+	// a large array of structs with pointers of the common kinds, all nil
+	elemType := &godwarf.StructType{
+		CommonType: godwarf.CommonType{ByteSize: 96, Name: "main.T", ReflectKind: reflect.Struct},
+		StructName: "main.T",
+		Kind:       "struct",
+		Field: []*godwarf.StructField{
+			field("next", 0, ptrType), field("name", 8, stringType), field("buf", 24, sliceType),
+			field("inner", 48, innerType), field("n", 88, int64Type),
+		},
+	}
+	const count = 1 << 12
+	arrType := fakeArrayType(count, elemType)
+	mem := &fakeMemory{base: 0x1000, data: make([]byte, count*elemType.Size())}
+	s := newTestObjRefScope()
+	s.maxArrayElems = 0
+	x := newReferenceVariable(Address(mem.base), "main.ts", arrType, mem, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.size, x.count = 0, 0
+		if err := s.findRef(x, nil); err != nil {
+			b.Fatalf("findRef error: %v", err)
+		}
+	}
+}
+
+func TestArrayElemName(t *testing.T) {
+	s := newTestObjRefScope()
+	for i, want := range map[int64]string{0: "[0]", 9: "[9]", 10: "[10+]", 1000: "[10+]"} {
+		if got := s.arrayElemName(i); got != want {
+			t.Errorf("got name %q of element %d, want %q", got, i, want)
+		}
+	}
+	// no collapsing
+	s.maxArrayElems = 0
+	if got := s.arrayElemName(1000); got != "[1000]" {
+		t.Errorf("got name %q of element 1000 without collapsing, want %q", got, "[1000]")
+	}
+}
+
+func TestFlattenSelf(t *testing.T) {
+	// x -> y -> z, where y and z are heap objects without their own nodes
+	x := newReferenceVariable(0x1000, "main.pp", nil, nil, nil)
+	y := newReferenceVariableWithSizeAndCount(0x2000, "", nil, nil, nil, 8, 1)
+	z := newReferenceVariableWithSizeAndCount(0x3000, "", nil, nil, nil, 1024, 1)
+	y.flatten(z)
+	x.flatten(y)
+	if x.size != 1032 || x.count != 2 {
+		t.Fatalf("got size %d and count %d, want 1032 and 2", x.size, x.count)
+	}
+	// only y is referenced by x directly
+	if x.selfSize != 8 || x.selfCount != 1 {
+		t.Fatalf("got self size %d and count %d, want 8 and 1", x.selfSize, x.selfCount)
+	}
+}
+
+func TestSliceWasteBound(t *testing.T) {
+	s := newTestObjRefScope()
+	x := newReferenceVariable(0x1000, "main.s", nil, nil, nil)
+	// a backing array of 64 bytes referencing 1024 bytes of sub-objects, with a bogus capacity
+	y := newReferenceVariableWithSizeAndCount(0x2000, "", nil, nil, nil, 64, 1)
+	y.flatten(newReferenceVariableWithSizeAndCount(0x3000, "", nil, nil, nil, 1024, 1))
+	f := &refFrame{x: x, idx: &pprofIndex{}, kind: refSlice, y: y, waste: 4096}
+	s.childDone(f, nil)
+	if x.waste != 64 {
+		t.Errorf("got waste %d, want 64 of the backing array", x.waste)
+	}
+	// the array not in the heap, e.g. a global one, is not allocated by the slice
+	x = newReferenceVariable(0x1000, "main.s", nil, nil, nil)
+	y = newReferenceVariable(0x4000, "", nil, nil, nil)
+	y.flatten(newReferenceVariableWithSizeAndCount(0x3000, "", nil, nil, nil, 1024, 1))
+	s.childDone(&refFrame{x: x, idx: &pprofIndex{}, kind: refSlice, y: y, waste: 4096}, nil)
+	if x.waste != 0 {
+		t.Errorf("got waste %d of a global array, want 0", x.waste)
+	}
+}
+
+func TestCurrentThreadID(t *testing.T) {
+	list := []proc.Thread{fakeThread{id: 1}, fakeThread{id: 2}}
+	if id := currentThreadID(fakeThread{id: 2}, list); id != 2 {
+		t.Errorf("got thread %d, want the current thread 2", id)
+	}
+	if id := currentThreadID(nil, list); id != 1 {
+		t.Errorf("got thread %d without the current thread, want 1", id)
+	}
+	if id := currentThreadID(nil, nil); id != 0 {
+		t.Errorf("got thread %d without any thread, want 0", id)
+	}
+}
+
+func TestSampleWeight(t *testing.T) {
+	s := newTestObjRefScope()
+	if w := s.sampleWeight(0xc000010000, 16); w != 1 {
+		t.Fatalf("weight without sampling: %d", w)
+	}
+	s.sampleEvery, s.sampleMaxSize = 10, maxSmallSize
+	if w := s.sampleWeight(0xc000010000, 16); w != 1 {
+		t.Fatalf("weight of an object referenced by a small one: %d", w)
+	}
+	if s.samplesBelow(1, 16) || !s.samplesBelow(1, maxSmallSize+1) || s.samplesBelow(10, maxSmallSize+1) {
+		t.Fatal("only the objects referenced by the large unsampled ones should be sampled")
+	}
+	s.sampling = true
+	var sampled int64
+	for addr := Address(0xc000010000); addr < 0xc000010000+16*100000; addr += 16 {
+		switch w := s.sampleWeight(addr, 16); w {
+		case 10:
+			sampled++
+			if s.sampleWeight(addr, 16) != 10 {
+				t.Fatalf("object %x is sampled inconsistently", addr)
+			}
+		case 0:
+		default:
+			t.Fatalf("unexpected weight %d", w)
+		}
+	}
+	if sampled < 9000 || sampled > 11000 {
+		t.Fatalf("%d of 100000 objects sampled, expected about 10000", sampled)
+	}
+	if w := s.sampleWeight(0xc000010000, maxSmallSize+1); w != 1 {
+		t.Fatalf("weight of a large object: %d", w)
+	}
+	// the objects below a sampled object are scanned with its weight
+	s.weight = 10
+	for addr := Address(0xc000010000); addr < 0xc000010000+16*100; addr += 16 {
+		if w := s.sampleWeight(addr, 16); w != 10 {
+			t.Fatalf("weight below a sampled object: %d", w)
+		}
+	}
+}
@@ -86,6 +86,18 @@ func (r *region) Deref() *region {
 	}
 }
 
+// Uint returns the unsigned integer value stored in r, whatever its size is.
+// It is used for runtime fields whose width changes between go versions.
+func (r *region) Uint() uint64 {
+	switch t := r.typ.(type) {
+	case *godwarf.UintType:
+		i, _ := readUintRaw(r.mem, uint64(r.a), t.Size())
+		return i
+	default:
+		panic("not an unsigned integer: " + t.String())
+	}
+}
+
 // Uint64 returns the uint64 value stored in r.
 // r must have type uint64.
 func (r *region) Uint64() uint64 {
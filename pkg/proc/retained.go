@@ -0,0 +1,281 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "github.com/go-delve/delve/pkg/dwarf/godwarf"
+
+// retainedGraph records the object graph discovered by the scanning, to compute the retained size,
+// i.e. the memory which would be freed if a root were dropped. A virtual super root references all
+// roots, and an object is retained by a root if the root dominates it in the graph. The graph also
+// serves the reverse reference query of WithReferrers and the graph formats.
+//
+// Every object is attributed to the profile node where it is discovered, so the retained space of
+// a root is the sum of its subtree, and the objects shared by several roots are retained by none.
+type retainedGraph struct {
+	// node 0 is the super root, followed by the roots and the objects in the order of discovery
+	nodes []retainedNode
+	// from and to are the edges
+	from, to []int32
+
+	objects map[Address]int32
+	roots   map[uint64]int32 // key: string index of the root name
+
+	retainedCursor
+
+	// the object queried by WithReferrers, 0 if none, and the sources referencing it
+	target    Address
+	referrers []*pprofIndex
+
+	// whether to record the addresses and the types of the nodes and the paths of the edges,
+	// which are output by the graph formats
+	export bool
+	via    []*pprofIndex
+}
+
+// retainedCursor is the source of the references found next, saved and restored around the
+// variables scanned, see enterRetained.
+type retainedCursor struct {
+	// the source of the references found next, and the profile node of the objects found next
+	src int32
+	idx *pprofIndex
+	// whether to ignore the references to the objects found already, see finalMark
+	weak bool
+}
+
+type retainedNode struct {
+	size int64
+	root bool
+	// where the object is discovered, or the root
+	idx *pprofIndex
+
+	// only recorded if exporting the graph
+	addr Address
+	typ  string
+}
+
+func newRetainedGraph() *retainedGraph {
+	return &retainedGraph{
+		nodes:   make([]retainedNode, 1),
+		objects: make(map[Address]int32),
+		roots:   make(map[uint64]int32),
+	}
+}
+
+// enterRetained sets the source of the references found next to the object at addr,
+// or to the root of idx if addr is not in the heap. It returns a function to restore the source.
+func (s *HeapScope) enterRetained(addr Address, idx *pprofIndex) func() {
+	g := s.retained
+	saved := g.retainedCursor
+	s.setRetained(addr, idx)
+	return func() { g.retainedCursor = saved }
+}
+
+// setRetained sets the source of the references found next like enterRetained, without restoring it.
+func (s *HeapScope) setRetained(addr Address, idx *pprofIndex) {
+	s.retained.retainedCursor = retainedCursor{src: s.retainedSource(addr, idx), idx: idx}
+}
+
+func (s *HeapScope) retainedSource(addr Address, idx *pprofIndex) int32 {
+	g := s.retained
+	if sp, base := s.findSpanAndBase(addr); sp != nil {
+		if id, ok := g.objects[base]; ok {
+			return id
+		}
+	}
+	if idx == nil {
+		return 0
+	}
+	for idx.prev != nil {
+		idx = idx.prev
+	}
+	id, ok := g.roots[idx.idx]
+	if !ok {
+		id = int32(len(g.nodes))
+		g.nodes = append(g.nodes, retainedNode{root: true, idx: idx})
+		g.roots[idx.idx] = id
+		g.addEdge(0, id, nil)
+	}
+	return id
+}
+
+// addRetainedEdge adds the reference from the current source to the object at base,
+// typ is the type of the object, maybe nil if unknown.
+func (s *HeapScope) addRetainedEdge(base Address, size int64, typ godwarf.Type) {
+	g := s.retained
+	if g == nil {
+		return
+	}
+	id, ok := g.objects[base]
+	if ok && g.weak {
+		return
+	}
+	g.addReferrer(base)
+	if !ok {
+		id = int32(len(g.nodes))
+		node := retainedNode{size: size, idx: g.idx}
+		if g.export {
+			node.addr, node.typ = base, unknownTypeName
+			if typ != nil {
+				node.typ = objectTypeName(resolveTypedef(typ))
+			}
+		}
+		g.nodes = append(g.nodes, node)
+		g.objects[base] = id
+	}
+	g.addEdge(g.src, id, g.idx)
+}
+
+// setRetainedType sets the type name of the object node at base, which is found by the GC bits only.
+func (s *HeapScope) setRetainedType(base Address, name string) {
+	g := s.retained
+	if g == nil || !g.export {
+		return
+	}
+	if id, ok := g.objects[base]; ok {
+		g.nodes[id].typ = name
+	}
+}
+
+func (g *retainedGraph) addEdge(from, to int32, via *pprofIndex) {
+	g.from, g.to = append(g.from, from), append(g.to, to)
+	if g.export {
+		g.via = append(g.via, via)
+	}
+}
+
+// dominators returns the immediate dominators of the nodes, and -1 for unreachable nodes,
+// by "A Simple, Fast Dominance Algorithm" of Cooper, Harvey and Kennedy.
+func (g *retainedGraph) dominators() []int32 {
+	n := len(g.nodes)
+	succ, succStart := csr(n, g.from, g.to)
+	pred, predStart := csr(n, g.to, g.from)
+
+	// number the nodes in post order by an iterative DFS, since the graph may be very deep
+	order := make([]int32, n) // post order number
+	for i := range order {
+		order[i] = -1
+	}
+	var rpo []int32
+	visited := make([]bool, n)
+	type frame struct{ v, next int32 }
+	stack := []frame{{0, succStart[0]}}
+	visited[0] = true
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next < succStart[f.v+1] {
+			w := succ[f.next]
+			f.next++
+			if !visited[w] {
+				visited[w] = true
+				stack = append(stack, frame{w, succStart[w]})
+			}
+			continue
+		}
+		order[f.v] = int32(len(rpo))
+		rpo = append(rpo, f.v)
+		stack = stack[:len(stack)-1]
+	}
+	for i, j := 0, len(rpo)-1; i < j; i, j = i+1, j-1 {
+		rpo[i], rpo[j] = rpo[j], rpo[i]
+	}
+
+	idom := make([]int32, n)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int32) int32 {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, v := range rpo[1:] {
+			d := int32(-1)
+			for _, p := range pred[predStart[v]:predStart[v+1]] {
+				if idom[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[v] {
+				idom[v], changed = d, true
+			}
+		}
+	}
+	return idom
+}
+
+// csr returns the adjacency lists of the edges in the compressed sparse row format,
+// the list of node v is adj[start[v]:start[v+1]].
+func csr(n int, from, to []int32) (adj, start []int32) {
+	start = make([]int32, n+1)
+	for _, v := range from {
+		start[v+1]++
+	}
+	for i := 1; i <= n; i++ {
+		start[i] += start[i-1]
+	}
+	adj = make([]int32, len(from))
+	pos := append([]int32(nil), start[:n]...)
+	for i, v := range from {
+		adj[pos[v]] = to[i]
+		pos[v]++
+	}
+	return adj, start
+}
+
+// recordRetained computes the dominators, and records the objects retained by the roots.
+func (s *ObjRefScope) recordRetained() {
+	g := s.retained
+	if g == nil {
+		return
+	}
+	idom := g.dominators()
+	// domRoot is the root dominating the node, 0 if none. The dominators
+	// are closer to the super root, so they are resolved first in the node order.
+	domRoot := make([]int32, len(g.nodes))
+	retained := make(map[*pprofIndex]int64)
+	for v := int32(1); v < int32(len(g.nodes)); v++ {
+		switch d := idom[v]; {
+		case d < 0:
+			continue
+		case d == 0:
+			// a root, or an object shared by the roots
+			if g.nodes[v].root {
+				domRoot[v] = v
+			}
+		default:
+			domRoot[v] = domRoot[d]
+		}
+		if nd := &g.nodes[v]; domRoot[v] != 0 && !nd.root {
+			retained[nd.idx] += nd.size
+		}
+	}
+	for idx, size := range retained {
+		s.recordValues(idx, &sampleValues{SampleRetained: size})
+	}
+}
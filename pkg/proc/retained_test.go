@@ -0,0 +1,35 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRetainedDominators(t *testing.T) {
+	g := newRetainedGraph()
+	// roots 1 and 2; 3 is only referenced by 1; 4 is shared by 1 and 2,
+	// and references 5; 5 and 6 reference each other from 3.
+	g.nodes = append(g.nodes, make([]retainedNode, 6)...)
+	for _, e := range [][2]int32{{0, 1}, {0, 2}, {1, 3}, {1, 4}, {2, 4}, {4, 5}, {3, 6}, {6, 5}, {5, 6}} {
+		g.from, g.to = append(g.from, e[0]), append(g.to, e[1])
+	}
+	idom := g.dominators()
+	want := []int32{0, 0, 0, 1, 0, 0, 0}
+	if !slices.Equal(idom, want) {
+		t.Fatalf("got idom %v, want %v", idom, want)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// RuntimeRoot is an optional root which is held by the runtime or the standard library
+// instead of user variables. Runtime roots are scanned before global variables,
+// so the memory they retain is attributed to their own subtrees.
+type RuntimeRoot int
+
+const (
+	// RootSyncPool is the objects cached in the per-P local and victim caches of all sync.Pools.
+	RootSyncPool RuntimeRoot = iota
+	// RootArgsEnv is the argv and environment slices retained by the runtime and the syscall package.
+	RootArgsEnv
+	// RootTimers is the timers in the timer heaps of all Ps, attributed to their callbacks.
+	RootTimers
+
+	numRuntimeRoots
+)
+
+var runtimeRootInfos = [numRuntimeRoots]struct {
+	name, node string
+}{
+	RootSyncPool: {"pool", "<sync.Pool>"},
+	RootArgsEnv:  {"env", "<process args/env>"},
+	RootTimers:   {"timers", "<timers>"},
+}
+
+// String returns the short name of the runtime root, which is used by the command line.
+func (r RuntimeRoot) String() string {
+	if r < 0 || r >= numRuntimeRoots {
+		return fmt.Sprintf("RuntimeRoot(%d)", int(r))
+	}
+	return runtimeRootInfos[r].name
+}
+
+// ParseRuntimeRoots parses a comma separated runtime root list, like "pool,env".
+func ParseRuntimeRoots(s string) ([]RuntimeRoot, error) {
+	var roots []RuntimeRoot
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		r := RuntimeRoot(-1)
+		for i := range runtimeRootInfos {
+			if runtimeRootInfos[i].name == name {
+				r = RuntimeRoot(i)
+				break
+			}
+		}
+		if r < 0 {
+			return nil, fmt.Errorf("unknown runtime root %q", name)
+		}
+		for _, prev := range roots {
+			if prev == r {
+				return nil, fmt.Errorf("duplicated runtime root %q", name)
+			}
+		}
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+func (s *ObjRefScope) findRuntimeRoots(roots []RuntimeRoot) {
+	for _, r := range roots {
+		var err error
+		switch r {
+		case RootSyncPool:
+			err = s.findSyncPoolRoots(runtimeRootInfos[r].node)
+		case RootArgsEnv:
+			err = s.findArgsEnvRoots(runtimeRootInfos[r].node)
+		case RootTimers:
+			err = s.findTimerRoots(runtimeRootInfos[r].node)
+		}
+		if err != nil {
+			s.logger.Warnf("scan runtime root %s err: %v", r, err)
+		}
+	}
+}
+
+// findSyncPoolRoots scans the local and victim caches of all pools.
+// Pools are registered to sync.allPools once they are used after a GC,
+// and moved to sync.oldPools with their victim caches by the next GC.
+func (s *ObjRefScope) findSyncPoolRoots(name string) error {
+	poolLocalType, err := findType(s.bi, "sync.poolLocal")
+	if err != nil {
+		// sync.Pool is not used by the program
+		return nil
+	}
+	seen := make(map[Address]struct{})
+	for _, pools := range []string{"sync.allPools", "sync.oldPools"} {
+		tmp, err := s.scope.EvalExpression(pools, loadSingleValue)
+		if err != nil {
+			return err
+		}
+		ps := toRegion(tmp, s.bi)
+		for i, n := int64(0), ps.SliceLen(); i < n; i++ {
+			p := ps.SliceIndex(i).Deref()
+			if p.a == 0 {
+				continue
+			}
+			if _, ok := seen[p.a]; ok {
+				continue
+			}
+			seen[p.a] = struct{}{}
+			// local and victim are unsafe.Pointer to [P]poolLocal
+			for _, f := range []string{"local", "victim"} {
+				size := p.Field(f + "Size").Uintptr()
+				if size == 0 {
+					continue
+				}
+				typ := pointerTo(fakeArrayType(size, poolLocalType), s.bi.Arch)
+				s.findRef(newReferenceVariable(p.Field(f).a, name, typ, s.mem, nil), nil)
+			}
+		}
+	}
+	return nil
+}
+
+// registryVariables are the global variables registering the variables of the program, which are
+// scanned after the other global variables, e.g. sync.allPools references all the used sync.Pools.
+var registryVariables = map[string]bool{
+	"sync.allPools": true,
+	"sync.oldPools": true,
+}
+
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// pooledName is the path of the objects cached in a sync.Pool, under the path of the pool.
+const pooledName = "(pooled)"
+
+// pooledVariable returns the variable of the field of the sync.Pool x, which is y unless the field
+// is the local or victim cache, an unsafe.Pointer to [size]poolLocal, which is typed to attribute
+// the cached objects to the pool under pooledName rather than to the opaque runtime structures.
+func (s *ObjRefScope) pooledVariable(x *ReferenceVariable, typ *godwarf.StructType, field *godwarf.StructField, y *ReferenceVariable) *ReferenceVariable {
+	if field.Name != "local" && field.Name != "victim" {
+		return y
+	}
+	var size uint64
+	for _, f := range typ.Field {
+		if f.Name == field.Name+"Size" {
+			size, _ = x.readUint64(x.Addr.Add(f.ByteOffset))
+		}
+	}
+	if size == 0 {
+		return y
+	}
+	poolLocalType, err := func() (godwarf.Type, error) {
+		s.typesMu.Lock()
+		defer s.typesMu.Unlock()
+		return findType(s.bi, "sync.poolLocal")
+	}()
+	if err != nil {
+		return y
+	}
+	return newReferenceVariable(y.Addr, pooledName, pointerTo(fakeArrayType(size, poolLocalType), s.bi.Arch), y.mem, y.hb)
+}
+
+// findArgsEnvRoots scans the argv and environment slices. The strings passed by the kernel
+// live on the initial process stack, so only the slices and the variables set by os.Setenv
+// are in the heap.
+func (s *ObjRefScope) findArgsEnvRoots(name string) error {
+	for _, expr := range []string{"runtime.argslice", "runtime.envs", "syscall.envs"} {
+		v, err := s.scope.EvalExpression(expr, loadSingleValue)
+		if err != nil {
+			// syscall.envs doesn't exist if syscall is not linked
+			continue
+		}
+		s.findRef(newReferenceVariable(Address(v.Addr), name, v.RealType, s.mem, nil), nil)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// maxTypeNameLen limits the length of a runtime type name to read.
+const maxTypeNameLen = 1024
+
+// runtimeType is the information read from a runtime type (abi.Type).
+type runtimeType struct {
+	size     int64
+	ptrBytes int64
+	kind     uint8
+	name     string
+}
+
+var errTypeNotInModule = errors.New("runtime type is not in any module data")
+
+// readRuntimeType reads the runtime type at typeAddr, it doesn't rely on DWARF,
+// so it also works for types created by reflect (reflect.StructOf, reflect.SliceOf, etc.).
+func (s *HeapScope) readRuntimeType(typeAddr Address) (*runtimeType, error) {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	return s.readRuntimeTypeDepth(typeAddr, 0)
+}
+
+func (s *HeapScope) readRuntimeTypeDepth(typeAddr Address, depth int) (*runtimeType, error) {
+	if rt, ok := s.rtypes[typeAddr]; ok {
+		return rt, nil
+	}
+	mem := cacheMemory(s.mem, uint64(typeAddr), int(strOffset+4))
+	size, err := readUintRaw(mem, uint64(typeAddr.Add(sizeOffset)), 8)
+	if err != nil {
+		return nil, err
+	}
+	ptrBytes, err := readUintRaw(mem, uint64(typeAddr.Add(ptrBytesOffset)), 8)
+	if err != nil {
+		return nil, err
+	}
+	tflag, err := readUintRaw(mem, uint64(typeAddr.Add(tflagOffset)), 1)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := readUintRaw(mem, uint64(typeAddr.Add(kindOffset)), 1)
+	if err != nil {
+		return nil, err
+	}
+	str, err := readIntRaw(mem, uint64(typeAddr.Add(strOffset)), 4)
+	if err != nil {
+		return nil, err
+	}
+	rt := &runtimeType{size: int64(size), ptrBytes: int64(ptrBytes), kind: uint8(kind)}
+	rt.name, err = s.resolveTypeName(typeAddr, str)
+	if errors.Is(err, errTypeNotInModule) {
+		// Types created by reflect are allocated in the heap, and their names are
+		// registered in runtime.reflectOffs, name them by their kind and element types instead.
+		rt.name, err = s.reflectTypeName(typeAddr, rt.kind, depth), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if TFlag(tflag)&tflagExtraStar != 0 && len(rt.name) > 0 && rt.name[0] == '*' {
+		rt.name = rt.name[1:]
+	}
+	if s.rtypes == nil {
+		s.rtypes = make(map[Address]*runtimeType)
+	}
+	s.rtypes[typeAddr] = rt
+	return rt, nil
+}
+
+// resolveTypeName reads the name at nameOff of the module which contains the type.
+// The name is encoded as: 1 byte flags, varint length, name bytes (go1.17+).
+func (s *HeapScope) resolveTypeName(typeAddr Address, nameOff int64) (string, error) {
+	for i := range s.mds {
+		types, etypes := getModuleDataTypes(&s.mds[i])
+		if uint64(typeAddr) < types || uint64(typeAddr) >= etypes {
+			continue
+		}
+		nameAddr := Address(types).Add(nameOff)
+		var hdr [1 + binary.MaxVarintLen16]byte
+		if _, err := s.mem.ReadMemory(hdr[:], uint64(nameAddr)); err != nil {
+			return "", err
+		}
+		n, l := binary.Uvarint(hdr[1:])
+		if l <= 0 || n > maxTypeNameLen {
+			return "", fmt.Errorf("invalid runtime type name at %#x", nameAddr)
+		}
+		b := make([]byte, n)
+		if _, err := s.mem.ReadMemory(b, uint64(nameAddr.Add(int64(1+l)))); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return "", errTypeNotInModule
+}
+
+// max depth of element types to resolve for types created by reflect
+const maxReflectTypeDepth = 8
+
+// reflectTypeName composes the name of a type created by reflect by its kind,
+// e.g. "[]*struct {...}" for reflect.SliceOf(reflect.PointerTo(reflect.StructOf(...))).
+func (s *HeapScope) reflectTypeName(typeAddr Address, kind uint8, depth int) string {
+	k := reflect.Kind(kind & kindMask)
+	var prefix string
+	switch k {
+	case reflect.Ptr:
+		prefix = "*"
+	case reflect.Slice:
+		prefix = "[]"
+	case reflect.Chan:
+		prefix = "chan "
+	case reflect.Array:
+		// array type: {Type; Elem *Type; Slice *Type; Len uintptr}
+		n, err := readUintRaw(s.mem, uint64(typeAddr.Add(typeStructSize+16)), 8)
+		if err != nil {
+			return k.String()
+		}
+		prefix = "[" + strconv.FormatUint(n, 10) + "]"
+	case reflect.Struct:
+		return "struct {...}"
+	default:
+		return k.String()
+	}
+	// ptr, slice, chan and array types have an element type right after the abi.Type.
+	elemAddr, err := readUintRaw(s.mem, uint64(typeAddr.Add(typeStructSize)), 8)
+	if err != nil || elemAddr == 0 || depth >= maxReflectTypeDepth {
+		return prefix + "?"
+	}
+	elem, err := s.readRuntimeTypeDepth(Address(elemAddr), depth+1)
+	if err != nil {
+		return prefix + "?"
+	}
+	return prefix + elem.name
+}
@@ -51,14 +51,24 @@ type Type struct {
 	PtrToThis   TypeOff
 }
 
-var sizeOffset, ptrBytesOffset, gcDataOffset int64
+// TFlagExtraStar means the name in the str field has an extraneous '*' prefix.
+const tflagExtraStar TFlag = 1 << 1
+
+var sizeOffset, ptrBytesOffset, gcDataOffset, tflagOffset, kindOffset, strOffset, typeStructSize int64
 
 func init() {
 	rtype := reflect.TypeOf(Type{})
+	typeStructSize = int64(rtype.Size())
 	sf, _ := rtype.FieldByName("Size_")
 	sizeOffset = int64(sf.Offset)
 	sf, _ = rtype.FieldByName("PtrBytes")
 	ptrBytesOffset = int64(sf.Offset)
 	sf, _ = rtype.FieldByName("GCData")
 	gcDataOffset = int64(sf.Offset)
+	sf, _ = rtype.FieldByName("TFlag")
+	tflagOffset = int64(sf.Offset)
+	sf, _ = rtype.FieldByName("Kind_")
+	kindOffset = int64(sf.Offset)
+	sf, _ = rtype.FieldByName("Str")
+	strOffset = int64(sf.Offset)
 }
@@ -0,0 +1,138 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// SampleType is a sample value type which the profile can carry.
+type SampleType int
+
+const (
+	// SampleObjects is the count of the referenced objects.
+	SampleObjects SampleType = iota
+	// SampleSpace is the bytes of the referenced objects.
+	SampleSpace
+	// SampleEntries is the count of the referenced map entries.
+	SampleEntries
+	// SampleWaste is the unused bytes of the referenced buffers.
+	SampleWaste
+	// SampleRetained is the bytes of the objects which would be freed if the root were dropped.
+	SampleRetained
+	// SampleWeak is the bytes of the objects referenced by weak pointers, which are not counted as referenced.
+	SampleWeak
+	// SampleStack is the bytes of the goroutine stacks, recorded by the goroutines rather than the paths.
+	SampleStack
+	// SampleSelfObjects is the count of the objects referenced by the path directly, excluding the objects
+	// reached through them which have no path of their own, e.g. by the GC bits only, which are folded
+	// into SampleObjects of the path.
+	SampleSelfObjects
+	// SampleSelfSpace is the bytes of the objects counted by SampleSelfObjects.
+	SampleSelfSpace
+
+	numSampleTypes
+)
+
+var sampleTypeInfos = [numSampleTypes]struct {
+	name, typ, unit string
+}{
+	SampleObjects:     {"objects", "inuse_objects", "count"},
+	SampleSpace:       {"space", "inuse_space", "bytes"},
+	SampleEntries:     {"entries", "map_entries", "count"},
+	SampleWaste:       {"waste", "waste_space", "bytes"},
+	SampleRetained:    {"retained", "retained_space", "bytes"},
+	SampleWeak:        {"weak", "weak_space", "bytes"},
+	SampleStack:       {"stack", "stack_space", "bytes"},
+	SampleSelfObjects: {"self_objects", "self_objects", "count"},
+	SampleSelfSpace:   {"self_space", "self_space", "bytes"},
+}
+
+// DefaultSampleTypes are the sample types carried by default.
+var DefaultSampleTypes = []SampleType{SampleObjects, SampleSpace}
+
+// String returns the short name of the sample type, which is used by the command line.
+func (t SampleType) String() string {
+	if t < 0 || t >= numSampleTypes {
+		return fmt.Sprintf("SampleType(%d)", int(t))
+	}
+	return sampleTypeInfos[t].name
+}
+
+// ValueType returns the type and unit of the sample type in the profile, like "inuse_space" in bytes.
+func (t SampleType) ValueType() profile.ValueType {
+	if t < 0 || t >= numSampleTypes {
+		return profile.ValueType{}
+	}
+	return profile.ValueType{Type: sampleTypeInfos[t].typ, Unit: sampleTypeInfos[t].unit}
+}
+
+// ParseSampleTypes parses a comma separated sample type list, like "objects,space,entries".
+func ParseSampleTypes(s string) ([]SampleType, error) {
+	var types []SampleType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		t := SampleType(-1)
+		for i := range sampleTypeInfos {
+			if sampleTypeInfos[i].name == name || sampleTypeInfos[i].typ == name {
+				t = SampleType(i)
+				break
+			}
+		}
+		if t < 0 {
+			return nil, fmt.Errorf("unknown sample type %q", name)
+		}
+		for _, prev := range types {
+			if prev == t {
+				return nil, fmt.Errorf("duplicated sample type %q", name)
+			}
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no sample type in %q", s)
+	}
+	return types, nil
+}
+
+// sampleValues are the values of all sample types, indexed by SampleType.
+type sampleValues [numSampleTypes]int64
+
+func (v *sampleValues) add(o *sampleValues) {
+	for i := range v {
+		v[i] += o[i]
+	}
+}
+
+func (v *sampleValues) sub(o *sampleValues) {
+	for i := range v {
+		v[i] -= o[i]
+	}
+}
+
+func (v *sampleValues) isZero() bool {
+	for i := range v {
+		if v[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
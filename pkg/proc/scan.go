@@ -0,0 +1,494 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// ScanOptions configures Scan, so that goref can be embedded in other tools.
+// The zero values of the fields mean the defaults.
+type ScanOptions struct {
+	// MaxDepth is the max depth of the reference paths, the deeper objects are attributed
+	// to the path at the max depth. 256 is used if not specified.
+	MaxDepth int
+	// DepthRules limit the depth below the nodes of the types or packages, see WithDepthRules.
+	DepthRules []DepthRule
+	// IncludePackages restricts the roots to the global variables and the stack frames
+	// of these packages, like "main" or "github.com/cloudwego/kitex/server".
+	// The data segments and the stack frames which are not covered by the variables
+	// of the packages are not scanned.
+	IncludePackages []string
+	// GroupBy is how the objects are aggregated in the profile.
+	GroupBy GroupBy
+	// Writer is where the profile is written, the profile is discarded if nil.
+	Writer io.Writer
+	// ProgressFn is called with the progress of the scanning if not nil.
+	ProgressFn func(ScanProgress)
+	// Labels label the profile, like the hostname of the target, see WithLabels.
+	Labels map[string]string
+
+	// Options are the other options, e.g. WithFormat and WithSampleTypes.
+	Options []Option
+}
+
+// ScanProgress is the progress of a scanning phase.
+type ScanProgress struct {
+	// Phase is one of "spans", "globals", "goroutines", "finalizers" and "final marks".
+	Phase string
+	// Done and Total are the number of the spans read or the roots scanned, and to read or scan in the phase.
+	Done, Total int
+	// Objects and Space are the count and the bytes of the heap objects marked so far in all the phases.
+	Objects, Space int64
+	// Elapsed is the duration since the phase started.
+	Elapsed time.Duration
+}
+
+// Result is the summary of a scanning.
+type Result struct {
+	// Objects and Space are the count and the bytes of the heap objects reached by the scanning.
+	Objects, Space int64
+	// SkippedFrames are the stack frames whose local variables are not read, the most first,
+	// which tells how complete the attribution of the profile is.
+	SkippedFrames []SkippedFrame
+	// Panics is the number of the parts of the scanning skipped on panics, like the spans
+	// or the goroutines whose runtime structs are malformed, see the logs for the details.
+	Panics int64
+}
+
+// WithMaxDepth limits the depth of the reference paths, 256 is used if not specified.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithIncludePackages restricts the roots to the global variables and the stack frames of the packages.
+func WithIncludePackages(pkgs ...string) Option {
+	return func(o *options) {
+		o.includePackages = pkgs
+	}
+}
+
+// WithProgress reports the progress of the scanning to fn.
+func WithProgress(fn func(ScanProgress)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// Scan scans goroutine stacks and global variables of the stopped target to search all
+// heap objects they reference, and writes the reference relationship to opts.Writer.
+// If ctx is done during the scanning, Scan stops early and still writes the partial profile,
+// then returns the partial result with an error wrapping ctx.Err().
+func Scan(ctx context.Context, t *proc.Target, opts ScanOptions) (*Result, error) {
+	all := append([]Option(nil), opts.Options...)
+	if opts.MaxDepth > 0 {
+		all = append(all, WithMaxDepth(opts.MaxDepth))
+	}
+	if len(opts.DepthRules) > 0 {
+		all = append(all, WithDepthRules(opts.DepthRules...))
+	}
+	if len(opts.IncludePackages) > 0 {
+		all = append(all, WithIncludePackages(opts.IncludePackages...))
+	}
+	if opts.GroupBy != GroupByPath {
+		all = append(all, WithGroupBy(opts.GroupBy))
+	}
+	if opts.ProgressFn != nil {
+		all = append(all, WithProgress(opts.ProgressFn))
+	}
+	if len(opts.Labels) > 0 {
+		all = append(all, WithLabels(opts.Labels))
+	}
+	w := opts.Writer
+	if w == nil {
+		w = io.Discard
+	}
+	return scan(ctx, t, w, newOptions(all))
+}
+
+// packageOf returns the package of a symbol name, like "main" of "main.(*T).M".
+func packageOf(name string) string {
+	pathend := strings.LastIndex(name, "/")
+	if pathend < 0 {
+		pathend = 0
+	}
+	if i := strings.Index(name[pathend:], "."); i != -1 {
+		return name[:pathend+i]
+	}
+	return ""
+}
+
+func (o *options) includes(name string) bool {
+	pkg := packageOf(name)
+	return (len(o.includePackages) == 0 || slices.Contains(o.includePackages, pkg)) &&
+		!slices.Contains(o.excludePackages, pkg)
+}
+
+// samplingComments returns the comments of the profile telling how the objects are sampled, nil if not sampled.
+func (o *options) samplingComments() []string {
+	if o.sampleEvery <= 1 {
+		return nil
+	}
+	return []string{fmt.Sprintf("sampled: one of every %d heap objects up to %s referenced by the large ones is scanned, the sizes and counts are scaled by %d",
+		o.sampleEvery, FormatBytes(o.sampleMaxSize), o.sampleEvery)}
+}
+
+// findGoroutineRef scans the local variables in the stack frames of the goroutine.
+func (s *ObjRefScope) findGoroutineRef(t *proc.Target, gr *goroutineRoot, o *options) {
+	sf := gr.frames
+	s.g = &stack{}
+	defer func() { s.g = nil }()
+	type frameLocals struct {
+		fn     *proc.Function
+		pc     uint64
+		locals []*ReferenceVariable
+	}
+	// the root of the references from the goroutine if grouping by goroutine,
+	// otherwise every local variable is a root
+	var root *pprofIndex
+	if o.groupBy.byGoroutine() {
+		root = root.pushHead(s.pb, goroutineRootName(gr, o.groupBy))
+		gr.root = root
+	}
+	s.setGoroutineLabels(gr, root)
+	defer s.setGoroutineLabels(nil, nil)
+	s.recordStack(gr, root, o)
+	var frames []frameLocals
+	func() {
+		s.typesMu.Lock()
+		defer s.typesMu.Unlock()
+		masks := s.stackPtrMask(gr.lo, gr.hi, sf)
+		if gr.framesErr != nil {
+			s.skipFrame(unwindFailed, gr.framesErr)
+			if m := s.unwoundStackMask(gr.lo, gr.hi, masks); m != nil {
+				masks = append(masks, m)
+			}
+		}
+		s.g.init(gr.lo, gr.hi, masks)
+		for i := range sf {
+			if !o.includes(sf[i].Current.Fn.Name) {
+				continue
+			}
+			locals, err := s.frameLocals(t, gr, sf[i:])
+			if err != nil {
+				// the frame is still scanned by its mask
+				s.skipFrame(sf[i].Current.Fn.Name, err)
+				continue
+			}
+			frames = append(frames, frameLocals{sf[i].Current.Fn, sf[i].Call.PC, locals})
+		}
+	}()
+
+	for _, fr := range frames {
+		for _, l := range fr.locals {
+			if l.Addr == 0 || disableDwarfSearching {
+				continue
+			}
+			if l.Name[0] == '&' {
+				// escaped variables
+				l.Name = l.Name[1:]
+			}
+			l.Name = fr.fn.Name + "." + l.Name
+			s.setFrameSource(l.Name, fr.fn, fr.pc, l.declLine)
+			s.findRef(l, root)
+		}
+	}
+	// scan root gc bits in case dwarf searching failure
+	for _, fr := range s.g.frames {
+		it := &(fr.gcMaskBitIterator)
+		if it.nextPtr(false) != 0 && o.includes(fr.funcName) {
+			s.setFrameSource(fr.funcName, fr.fn, fr.pc, 0)
+			// add to the finalMarks
+			idx := root.pushHead(s.pb, fr.funcName)
+			idx.labels = s.labels
+			s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it, true, 0})
+		}
+	}
+}
+
+// frameLocals reads the local variables of the top frame of sf, a panic on the malformed
+// DWARF or memory is returned as an error, so the frame is skipped like the unreadable ones.
+func (s *ObjRefScope) frameLocals(t *proc.Target, gr *goroutineRoot, sf []proc.Stackframe) (locals []*ReferenceVariable, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	ms := myEvalScope{EvalScope: *proc.FrameToScope(t, s.mem, gr.g, gr.threadID, sf...), logger: s.logger}
+	return ms.Locals(t, gr.g, gr.threadID, s.mds)
+}
+
+func scan(ctx context.Context, t *proc.Target, w io.Writer, o *options) (*Result, error) {
+	var deadline time.Time
+	if o.freezeDuration > 0 {
+		deadline = time.Now().Add(o.freezeDuration)
+	}
+	if o.goroutineStats != nil {
+		o.groupBy = GroupByGoroutine
+		if len(o.sampleTypes) == 0 {
+			o.sampleTypes = DefaultSampleTypes
+		}
+		if !slices.Contains(o.sampleTypes, SampleRetained) {
+			o.sampleTypes = append(slices.Clip(o.sampleTypes), SampleRetained)
+		}
+	}
+	if err := checkDebugInfo(t.BinInfo()); err != nil {
+		return scanStripped(ctx, t, w, o, err)
+	}
+	if err := checkCompat(t.BinInfo(), o.logger); err != nil {
+		if !o.skipCompatCheck {
+			return nil, err
+		}
+		o.logger.Errorf("the target is incompatible, scan it anyway: %v", err)
+	}
+	scope, err := globalScope(t, o.logger)
+	if err != nil {
+		return nil, err
+	}
+	checkGCState(scope, o.logger)
+
+	if o.sampleEvery > 1 && o.markCheckExamples > 0 {
+		o.logger.Warnf("the mark check is disabled in the sampling mode")
+		o.markCheckExamples = 0
+	}
+	mem, stats, release := targetMemory(t, o)
+	defer release()
+	defer stats.end()
+	stats.begin("read heap")
+	ram := newRAMBudget(o.maxRAM)
+	heapScope := &HeapScope{
+		mem: mem, bi: t.BinInfo(), scope: scope, funcExtraMap: make(map[*proc.Function]funcExtra),
+		guard: newMemoryGuard(o.selfMemoryLimit, o.logger), logger: o.logger, markCheck: newMarkChecker(o.markCheckExamples),
+		progress: newProgressReporter(o.progress), parallelism: o.parallelism, masks: newMaskSpill(ram, o.logger),
+	}
+	defer heapScope.masks.close()
+	retainedSpace := slices.Contains(o.sampleTypes, SampleRetained)
+	if retainedSpace || o.referrers != nil || o.format.graphFormat() {
+		heapScope.retained = newRetainedGraph()
+		heapScope.retained.export = o.format.graphFormat()
+	}
+	err = heapScope.readHeap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if o.referrers != nil {
+		if err = heapScope.setReferrersTarget(o.referrersAddr, o.referrers); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &ObjRefScope{
+		HeapScope:  heapScope,
+		pb:         newProfileBuilder(w, o.format, o.groupBy, o.sampleTypes),
+		maxDepth:   o.maxDepth,
+		typeRegex:  o.typeRegex,
+		depthRules: o.depthRules,
+		canceler:   canceler{ctx: ctx},
+
+		maxArrayElems:  o.maxArrayElems,
+		mapSampleEvery: int64(math.Round(1 / o.mapSampleRate)),
+		showValues:     o.showValues,
+		sampleEvery:    o.sampleEvery,
+		sampleMaxSize:  o.sampleMaxSize,
+	}
+	s.pb.comments = append(s.pb.comments, o.samplingComments()...)
+	if o.channelStats != nil {
+		s.channels = make(map[Address]channelRef)
+	}
+	if o.largeObjects != nil {
+		s.largeObjects = newLargeObjectHeap(o.largeObjectsN)
+	}
+	s.pb.minSpace, s.pb.minObjects = o.minBytes, o.minObjects
+	s.pb.deterministic = o.deterministic
+	if o.format == FormatPprof {
+		s.pb.text = readTextMapping(t.BinInfo())
+	}
+	if o.format == FormatPprof || o.format == FormatJSON {
+		s.pb.setProfileLabels(o.labels)
+	}
+	if o.format.graphFormat() {
+		s.pb.graph = heapScope.retained
+	}
+	s.pb.limitRAM(ram, o)
+
+	mds, err := proc.LoadModuleData(t.BinInfo(), mem)
+	if err != nil {
+		return nil, err
+	}
+	s.mds = mds
+
+	// read the roots through the target
+	stats.begin("read roots")
+	pvs, _ := scope.PackageVariables(loadSingleValue)
+	grs := readGoroutines(t)
+	switch {
+	case o.forkGroup != nil:
+		release, err := s.forkSnapshot(o.forkGroup, o.resume)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	case o.freezeDuration > 0 || o.snapshot:
+		if err = s.freeze(grs, t.Pid(), deadline, o.incremental, o.resume); err != nil {
+			return nil, err
+		}
+	}
+	if o.incremental != nil && o.freezeDuration <= 0 && !o.snapshot {
+		o.logger.Warnf("the incremental state only applies to the freeze or the copy snapshot")
+	}
+
+	// Runtime roots, before global variables which may also reference them
+	stats.begin("runtime roots")
+	runtimeRoots := o.runtimeRoots
+	if o.timerStats != nil && !slices.Contains(runtimeRoots, RootTimers) {
+		runtimeRoots = append(slices.Clip(runtimeRoots), RootTimers)
+	}
+	s.findRuntimeRoots(runtimeRoots)
+	// the values pending in the blocked channel operations, the deferred closures and the panic values,
+	// before runtime.allgs referencing them
+	if !disableDwarfSearching {
+		s.safely("sudogs", func() { s.findSudogRefs(t, grs, o) })
+		s.safely("defers", func() { s.findDeferRefs(t, grs, o) })
+	}
+
+	workers := o.parallelism
+	if s.retained != nil || o.deterministic {
+		// the object graph is recorded in the order of the scanning, and the objects referenced
+		// by several roots are attributed to the first scanned
+		workers = 1
+	}
+
+	// Global variables, the registries last, so the objects are attributed to the registered variables
+	slices.SortStableFunc(pvs, func(a, b *proc.Variable) int {
+		return cmpBool(registryVariables[a.Name], registryVariables[b.Name])
+	})
+	stats.begin("globals")
+	s.parallel(workers, "globals", len(pvs), func(w *ObjRefScope, i int) {
+		pv := pvs[i]
+		if pv.Addr != 0 && !disableDwarfSearching && o.includes(pv.Name) {
+			w.findRef(newReferenceVariable(Address(pv.Addr), pv.Name, pv.RealType, w.mem, nil), nil)
+		}
+	})
+
+	// Local variables
+	stats.begin("goroutines")
+	s.initFrameBounds()
+	s.parallel(workers, "goroutines", len(grs), func(w *ObjRefScope, i int) {
+		w.findGoroutineRef(t, grs[i], o)
+	})
+
+	// final mark segment root bits, unless the roots are restricted to some packages
+	if len(o.includePackages) == 0 {
+		for i, seg := range s.bss {
+			it := &(seg.gcMaskBitIterator)
+			if it.nextPtr(false) != 0 {
+				idx := (*pprofIndex)(nil).pushHead(s.pb, fmt.Sprintf("bss segment[%d]", i))
+				s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it, true, 0})
+			}
+		}
+		for i, seg := range s.data {
+			it := &(seg.gcMaskBitIterator)
+			if it.nextPtr(false) != 0 {
+				idx := (*pprofIndex)(nil).pushHead(s.pb, fmt.Sprintf("data segment[%d]", i))
+				s.finalMarks = append(s.finalMarks, finalMarkParam{idx, it, true, 0})
+			}
+		}
+		s.findUserArenaRoots()
+	}
+
+	// Finalizers
+	stats.begin("finalizers")
+	s.parallel(workers, "finalizers", len(heapScope.finalizers), func(w *ObjRefScope, i int) {
+		fin := heapScope.finalizers[i]
+		// scan object
+		w.findRef(newReferenceVariable(fin.p, "finalized", new(finalizePtrType), w.mem, nil), nil)
+		// scan finalizer
+		w.findRef(newReferenceVariable(fin.fn, "finalizer", new(godwarf.FuncType), w.mem, nil), nil)
+	})
+
+	stats.begin("final marks")
+	finalMarks := s.finalMarks
+	s.parallel(workers, "final marks", len(finalMarks), func(w *ObjRefScope, i int) {
+		w.finalMark(finalMarks[i].idx, finalMarks[i].hb, finalMarks[i].direct, finalMarks[i].weight)
+	})
+	stats.begin("post-processing")
+	if !s.canceled {
+		// meaningless for a partial scanning
+		if retainedSpace {
+			s.safely("retained size", s.recordRetained)
+		}
+		s.safely("mark check", s.checkMarks)
+	}
+	if o.referrers != nil {
+		s.referrers(o.referrers)
+	}
+	s.reportSnapshotMisses()
+	if o.goroutineStats != nil {
+		*o.goroutineStats = s.goroutineStats(t, grs)
+	}
+	if o.channelStats != nil {
+		*o.channelStats = s.channelStats()
+	}
+	if o.largeObjects != nil {
+		*o.largeObjects = s.largeObjectList()
+	}
+	if o.timerStats != nil {
+		*o.timerStats = s.timerStats()
+	}
+	if o.garbageStats != nil {
+		if s.canceled || len(o.includePackages) > 0 || len(o.excludePackages) > 0 || o.sampleEvery > 1 {
+			o.logger.Warnf("the garbage stats are not collected for a partial or sampled scanning")
+		} else {
+			*o.garbageStats = s.garbageStats(o.garbageByType)
+		}
+	}
+	if o.validation != nil {
+		s.safely("validation", func() { s.validate(o.validation) })
+	}
+	s.safely("memory breakdown", func() {
+		b := s.memoryBreakdown(grs, o.mappings)
+		s.pb.comments = append(s.pb.comments, b.comments()...)
+		if o.memoryBreakdown != nil {
+			*o.memoryBreakdown = *b
+		}
+	})
+
+	stats.begin("output")
+	// the masks are not used any more, drop them before merging the spilled nodes
+	heapScope.masks.close()
+	if err = s.pb.flush(); err != nil {
+		return nil, err
+	}
+	res := &Result{Objects: s.reached.objects, Space: s.reached.space, SkippedFrames: s.skippedFrames(), Panics: s.panics.Load()}
+	s.reportSkippedFrames(res.SkippedFrames)
+	if s.canceled {
+		return res, fmt.Errorf("scanning is interrupted, the profile is partial: %w", ctx.Err())
+	}
+	if res.Panics > 0 {
+		return res, fmt.Errorf("%d parts of the scanning are skipped on panics, the profile is partial", res.Panics)
+	}
+	return res, nil
+}
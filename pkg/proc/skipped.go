@@ -0,0 +1,93 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxStackFrames is the max number of the stack frames unwound for a goroutine.
+const maxStackFrames = 1024
+
+// unwindFailed is the function of the skipped frames which are not unwound, e.g. the unwinding
+// failed halfway or the stack is too deep.
+const unwindFailed = "<unwinding failed>"
+
+// maxSkippedReported is the max number of the functions of the skipped frames logged.
+const maxSkippedReported = 10
+
+// SkippedFrame is the stack frames of a function whose local variables are not read, e.g. for the
+// DWARF errors. The frames are still scanned conservatively, so the objects referenced by them are
+// reached but attributed to the function rather than the variables.
+type SkippedFrame struct {
+	// Function is the function of the frames, or "<unwinding failed>" for the frames not unwound,
+	// whose Count is the number of the goroutines.
+	Function string
+	Count    int
+	// Err is the error of the first frame skipped.
+	Err string
+}
+
+// skipFrame records the frame of fn skipped for err, the caller holds typesMu.
+func (s *HeapScope) skipFrame(fn string, err error) {
+	if s.skipped == nil {
+		s.skipped = make(map[string]*SkippedFrame)
+	}
+	sf := s.skipped[fn]
+	if sf == nil {
+		sf = &SkippedFrame{Function: fn, Err: err.Error()}
+		s.skipped[fn] = sf
+	}
+	sf.Count++
+}
+
+// skippedFrames returns the skipped frames, the most first.
+func (s *HeapScope) skippedFrames() []SkippedFrame {
+	frames := make([]SkippedFrame, 0, len(s.skipped))
+	for _, sf := range s.skipped {
+		frames = append(frames, *sf)
+	}
+	slices.SortFunc(frames, func(a, b SkippedFrame) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Function, b.Function)
+	})
+	return frames
+}
+
+// reportSkippedFrames logs a summary of the skipped frames.
+func (s *HeapScope) reportSkippedFrames(frames []SkippedFrame) {
+	if len(frames) == 0 {
+		return
+	}
+	var total int
+	for _, sf := range frames {
+		total += sf.Count
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "skipped the local variables of %d frames of %d functions, which are scanned conservatively instead:", total, len(frames))
+	for i, sf := range frames {
+		if i == maxSkippedReported {
+			fmt.Fprintf(&sb, "\n  ... and %d more functions", len(frames)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n  %6d  %s: %s", sf.Count, sf.Function, sf.Err)
+	}
+	s.logger.Printf("%s\n", sb.String())
+}
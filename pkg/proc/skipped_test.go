@@ -0,0 +1,51 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSkippedFrames(t *testing.T) {
+	s := &HeapScope{frameBounds: frameBaseBounds}
+	errCtx := errors.New("unable to find function context")
+	s.skipFrame("main.a", errCtx)
+	s.skipFrame("main.b", errors.New("bad frame"))
+	s.skipFrame("main.b", errCtx)
+	s.skipFrame(unwindFailed, errors.New("more than 1024 frames"))
+	want := []SkippedFrame{
+		{Function: "main.b", Count: 2, Err: "bad frame"},
+		{Function: unwindFailed, Count: 1, Err: "more than 1024 frames"},
+		{Function: "main.a", Count: 1, Err: "unable to find function context"},
+	}
+	if got := s.skippedFrames(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// the stack above the unwound frames is scanned conservatively
+	frames := []*framePointerMask{{gcMaskBitIterator: *newGCBitsIterator(0x1000, 0x1100, 0x1000, []uint64{^uint64(0)})}}
+	m := s.unwoundStackMask(0x1000, 0x2000, frames)
+	if m == nil || m.base != 0x1100 || m.end != 0x2000 || m.funcName != unwindFailed {
+		t.Fatalf("unexpected mask of the frames not unwound: %+v", m)
+	}
+	if ptr := m.nextPtr(false); ptr != 0x1100 {
+		t.Fatalf("got the first pointer %#x, want 0x1100", ptr)
+	}
+	if m = s.unwoundStackMask(0x1000, 0x1100, frames); m != nil {
+		t.Fatalf("got a mask for the stack fully unwound: %+v", m)
+	}
+}
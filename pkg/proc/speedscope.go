@@ -0,0 +1,107 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeFile struct {
+	Schema   string              `json:"$schema"`
+	Shared   speedscopeShared    `json:"shared"`
+	Profiles []speedscopeProfile `json:"profiles"`
+	Name     string              `json:"name"`
+	Exporter string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// speedscopeEncoder writes the references in the JSON format of speedscope, with a sampled
+// profile per carried sample type, whose samples are the paths from the root weighted by the values.
+type speedscopeEncoder struct{}
+
+func (speedscopeEncoder) encode(out io.Writer, b *profileBuilder) error {
+	type path struct {
+		indexes []uint64
+		node    *profileNode
+	}
+	var paths []path
+	b.nodes.each(func(indexes []uint64, node *profileNode) {
+		paths = append(paths, path{indexes, node})
+	})
+	sort.Slice(paths, func(i, j int) bool { return uint64s2str(paths[i].indexes) < uint64s2str(paths[j].indexes) })
+
+	f := &speedscopeFile{Schema: speedscopeSchema, Name: "goref", Exporter: "goref"}
+	frames := make(map[uint64]int) // key: string index, val: frame index
+	for _, t := range b.sampleTypes {
+		info := sampleTypeInfos[t]
+		p := speedscopeProfile{Type: "sampled", Name: info.typ, Unit: "none", Samples: [][]int{}, Weights: []int64{}}
+		if info.unit == "bytes" {
+			p.Unit = "bytes"
+		}
+		for _, pt := range paths {
+			v := pt.node.sampleValues[t]
+			if v == 0 {
+				continue
+			}
+			// indexes are from leaf to root, while the samples are from root to leaf
+			indexes := pt.indexes
+			sample := make([]int, len(indexes))
+			for i, idx := range indexes {
+				frame, ok := frames[idx]
+				if !ok {
+					frame = len(f.Shared.Frames)
+					f.Shared.Frames = append(f.Shared.Frames, speedscopeFrame{Name: b.strings[idx]})
+					frames[idx] = frame
+				}
+				sample[len(indexes)-1-i] = frame
+			}
+			p.Samples = append(p.Samples, sample)
+			p.Weights = append(p.Weights, v)
+			p.EndValue += v
+		}
+		f.Profiles = append(f.Profiles, p)
+	}
+	if f.Shared.Frames == nil {
+		f.Shared.Frames = []speedscopeFrame{}
+	}
+
+	w := bufio.NewWriter(out)
+	if err := json.NewEncoder(w).Encode(f); err != nil {
+		return err
+	}
+	return w.Flush()
+}
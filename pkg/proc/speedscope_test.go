@@ -0,0 +1,41 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlushSpeedscope(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatSpeedscope, GroupByPath, []SampleType{SampleSpace})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"$schema":"https://www.speedscope.app/file-format-schema.json",` +
+		`"shared":{"frames":[{"name":"main.root"},{"name":"next. (*main.T)"}]},` +
+		`"profiles":[{"type":"sampled","name":"inuse_space","unit":"bytes","startValue":0,"endValue":80,"samples":[[0],[0,1]],"weights":[16,64]}],` +
+		`"name":"goref","exporter":"goref"}
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected speedscope output:\n%s\nwant:\n%s", got, want)
+	}
+}
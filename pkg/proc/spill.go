@@ -0,0 +1,226 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ramBudget is the memory cap of the intermediate state of the scanning given by WithMaxRAM, which is shared
+// by the masks of the spans and the nodes of the profile. When both of them take more than max, each spills
+// itself, but keeps at least half of max, so that one of them doesn't spill all the time for the other.
+type ramBudget struct {
+	max          int64
+	masks, nodes atomic.Int64
+}
+
+// newRAMBudget returns the budget of max bytes, nil if max is not positive.
+func newRAMBudget(max int64) *ramBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &ramBudget{max: max}
+}
+
+// masksOver returns the bytes of the masks to spill.
+func (b *ramBudget) masksOver() int64 {
+	return b.masks.Load() - max(b.max-b.nodes.Load(), b.max/2)
+}
+
+// nodesOver reports whether the nodes are to spill.
+func (b *ramBudget) nodesOver() bool {
+	return b.nodes.Load() > max(b.max-b.masks.Load(), b.max/2)
+}
+
+// spillFile is a temporary file the intermediate state is spilled to, which is created on the first write,
+// and removed by close.
+type spillFile struct {
+	pattern string
+	f       *os.File
+	size    int64
+}
+
+// alloc returns the offset of n bytes allocated at the end of the file.
+func (f *spillFile) alloc(n int64) int64 {
+	off := f.size
+	f.size += n
+	return off
+}
+
+func (f *spillFile) writeAt(p []byte, off int64) (err error) {
+	if f.f == nil {
+		if f.f, err = os.CreateTemp("", f.pattern); err != nil {
+			return err
+		}
+	}
+	_, err = f.f.WriteAt(p, off)
+	return err
+}
+
+func (f *spillFile) readAt(p []byte, off int64) error {
+	_, err := f.f.ReadAt(p, off)
+	return err
+}
+
+func (f *spillFile) close() {
+	if f.f != nil {
+		f.f.Close()
+		os.Remove(f.f.Name())
+		f.f = nil
+	}
+	f.size = 0
+}
+
+// maskSpill bounds the memory taken by the visit masks and the pointer masks of the spans, which are 1/32
+// of the heap, by spilling the masks of the spans least recently used to a temporary file. The masks of
+// a span are allocated when it's used first, and a span is pinned while its masks are accessed, which
+// are loaded back if spilled, see pinMasks. The spans are spilled in the order of a clock, where a span
+// used since the clock passed it last time is kept for another round.
+type maskSpill struct {
+	ram *ramBudget
+
+	// guards the fields below
+	mu sync.Mutex
+	// the spans whose masks are in memory, and the hand of the clock
+	resident []*spanInfo
+	hand     int
+	file     spillFile
+	// the error of spilling, after which the masks are kept in memory
+	err error
+
+	logger Logger
+}
+
+// newMaskSpill returns the spill of the masks within the budget, nil if ram is nil.
+func newMaskSpill(ram *ramBudget, logger Logger) *maskSpill {
+	if ram == nil {
+		return nil
+	}
+	return &maskSpill{ram: ram, file: spillFile{pattern: "goref-masks-*"}, logger: logger}
+}
+
+// load allocates the masks of the span pinned, and reads them back if spilled. It returns false if the span
+// is used first, whose masks are to be initialized.
+func (m *maskSpill) load(sp *spanInfo) (spilled bool) {
+	maskLen := CeilDivide(sp.spanSize/8, 64)
+	sp.visitMask, sp.ptrMask = make([]uint64, maskLen), make([]uint64, maskLen)
+	if spilled = sp.spillOff > 0; spilled {
+		off := sp.spillOff - 1
+		err := m.file.readAt(uint64sBytes(sp.visitMask), off)
+		if err == nil {
+			err = m.file.readAt(uint64sBytes(sp.ptrMask), off+maskLen*8)
+		}
+		if err != nil {
+			m.logger.Errorf("read the spilled masks of span %x error: %v", sp.base, err)
+		}
+	}
+	m.mu.Lock()
+	m.resident = append(m.resident, sp)
+	m.mu.Unlock()
+	m.ram.masks.Add(2 * maskLen * 8)
+	return spilled
+}
+
+// evict spills the masks of the spans not pinned until they fit in the budget.
+func (m *maskSpill) evict() {
+	if m.ram.masksOver() <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// every span is passed at most twice, the second time with its use cleared by the first
+	for n := 2 * len(m.resident); n > 0 && m.err == nil && m.ram.masksOver() > 0; n-- {
+		if m.hand >= len(m.resident) {
+			m.hand = 0
+		}
+		sp := m.resident[m.hand]
+		if sp.used.Swap(false) || !sp.masksMu.TryLock() {
+			// used recently, or pinned now
+			m.hand++
+			continue
+		}
+		m.spill(sp)
+		sp.masksMu.Unlock()
+		if m.err != nil {
+			m.logger.Errorf("spill the masks of the spans error, the max ram is exceeded: %v", m.err)
+			return
+		}
+		last := len(m.resident) - 1
+		m.resident[m.hand], m.resident[last] = m.resident[last], nil
+		m.resident = m.resident[:last]
+	}
+}
+
+// spill writes the masks of the span to the file, and drops them from memory.
+func (m *maskSpill) spill(sp *spanInfo) {
+	maskLen := int64(len(sp.visitMask))
+	if sp.spillOff == 0 {
+		// the masks of a span have the same size each time
+		sp.spillOff = m.file.alloc(2*maskLen*8) + 1
+	}
+	off := sp.spillOff - 1
+	if m.err = m.file.writeAt(uint64sBytes(sp.visitMask), off); m.err != nil {
+		return
+	}
+	if m.err = m.file.writeAt(uint64sBytes(sp.ptrMask), off+maskLen*8); m.err != nil {
+		return
+	}
+	sp.visitMask, sp.ptrMask = nil, nil
+	m.ram.masks.Add(-2 * maskLen * 8)
+}
+
+// close drops the masks, and removes the file. It's called once the masks are not used any more.
+func (m *maskSpill) close() {
+	if m == nil {
+		return
+	}
+	for _, sp := range m.resident {
+		sp.visitMask, sp.ptrMask = nil, nil
+	}
+	m.resident = nil
+	m.ram.masks.Store(0)
+	m.file.close()
+}
+
+// pinMasks pins the masks of the span while they're accessed if they may be spilled, until unpinMasks.
+func (s *HeapScope) pinMasks(sp *spanInfo) {
+	if s.masks == nil {
+		return
+	}
+	sp.masksMu.Lock()
+	sp.used.Store(true)
+	if sp.visitMask == nil && !s.masks.load(sp) {
+		s.initMasks(sp)
+	}
+}
+
+func (s *HeapScope) unpinMasks(sp *spanInfo) {
+	if s.masks == nil {
+		return
+	}
+	sp.masksMu.Unlock()
+	s.masks.evict()
+}
+
+// initMasks fills the pointer mask of the span used first if its masks may be spilled, which is read in
+// advance by readTypePointers otherwise.
+func (s *HeapScope) initMasks(sp *spanInfo) {
+	if s.enableAllocHeader && !sp.spanclass.noscan() && s.heapBitsInSpan(sp.elemSize) {
+		bitmapSize := sp.spanSize / 8 / 8
+		readUint64Array(s.mem, uint64(sp.base.Add(sp.spanSize-bitmapSize)), sp.ptrMask)
+	}
+}
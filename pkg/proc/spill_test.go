@@ -0,0 +1,52 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"testing"
+)
+
+func TestMaskSpill(t *testing.T) {
+	// the masks of a span of 8KiB take 256 bytes, so only one span fits in the budget
+	s := &HeapScope{masks: newMaskSpill(newRAMBudget(300), getLogger())}
+	defer s.masks.close()
+	spans := make([]*spanInfo, 4)
+	for i := range spans {
+		spans[i] = &spanInfo{base: Address(0x1000000 + i*8192), elemSize: 16, spanSize: 8192}
+	}
+	for round := 0; round < 2; round++ {
+		for i, sp := range spans {
+			s.pinMasks(sp)
+			if sp.visitMask[1] != uint64(round*i) || sp.ptrMask[2] != uint64(round*i*2) {
+				t.Fatalf("round %d: got masks %x, %x of span %d, want the ones set in the last round",
+					round, sp.visitMask[1], sp.ptrMask[2], i)
+			}
+			sp.visitMask[1], sp.ptrMask[2] = uint64(i), uint64(i*2)
+			s.unpinMasks(sp)
+		}
+	}
+	var resident int
+	for _, sp := range spans {
+		if sp.visitMask != nil {
+			resident++
+		}
+	}
+	if resident != 1 {
+		t.Fatalf("got %d spans with the masks in memory, want 1", resident)
+	}
+	if got := s.masks.ram.masks.Load(); got != 256 {
+		t.Fatalf("got %d bytes of the masks accounted, want 256", got)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// ScanStats are the statistics of a scanning to tell where the time goes, see WithScanStats.
+type ScanStats struct {
+	// Phases are the durations of the scanning phases in order, like "read heap" and "globals".
+	Phases []PhaseStat
+	// Reads and BytesRead are the number and the bytes of the reads of the target memory,
+	// including the copies of the freeze and the snapshot, but not the reads of a forked child.
+	Reads, BytesRead int64
+}
+
+// PhaseStat is the duration of a scanning phase.
+type PhaseStat struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// WithScanStats collects the statistics of the scanning to stats, like the duration of every phase
+// and the bytes read from the target.
+func WithScanStats(stats *ScanStats) Option {
+	return func(o *options) {
+		o.scanStats = stats
+	}
+}
+
+// countingMemory counts the reads of the target memory.
+type countingMemory struct {
+	proc.MemoryReadWriter
+	reads, bytes atomic.Int64
+}
+
+func (m *countingMemory) ReadMemory(data []byte, addr uint64) (int, error) {
+	m.count(len(data))
+	return m.MemoryReadWriter.ReadMemory(data, addr)
+}
+
+// count counts a read of n bytes, which may bypass the memory, e.g. directly from the target process.
+func (m *countingMemory) count(n int) {
+	m.reads.Add(1)
+	m.bytes.Add(int64(n))
+}
+
+// countedMemory returns the countingMemory under mem, nil if the reads of mem are not counted.
+func countedMemory(mem proc.MemoryReadWriter) *countingMemory {
+	for {
+		switch m := mem.(type) {
+		case *countingMemory:
+			return m
+		case *pageCache:
+			mem = m.mem
+		default:
+			return nil
+		}
+	}
+}
+
+// scanStatsRecorder records the phases of the scanning to the stats. A nil scanStatsRecorder records nothing.
+type scanStatsRecorder struct {
+	stats *ScanStats
+	mem   *countingMemory
+	phase string
+	start time.Time
+}
+
+// newScanStatsRecorder returns the recorder of the stats and the memory to read the target through,
+// which is mem itself if the stats are not collected.
+func newScanStatsRecorder(stats *ScanStats, mem proc.MemoryReadWriter) (*scanStatsRecorder, proc.MemoryReadWriter) {
+	if stats == nil {
+		return nil, mem
+	}
+	r := &scanStatsRecorder{stats: stats, mem: &countingMemory{MemoryReadWriter: mem}}
+	return r, r.mem
+}
+
+// begin ends the current phase, and begins the next one.
+func (r *scanStatsRecorder) begin(phase string) {
+	if r == nil {
+		return
+	}
+	r.end()
+	r.phase, r.start = phase, time.Now()
+}
+
+// end ends the current phase, and updates the reads of the target.
+func (r *scanStatsRecorder) end() {
+	if r == nil {
+		return
+	}
+	if r.phase != "" {
+		r.stats.Phases = append(r.stats.Phases, PhaseStat{Phase: r.phase, Duration: time.Since(r.start)})
+		r.phase = ""
+	}
+	r.stats.Reads, r.stats.BytesRead = r.mem.reads.Load(), r.mem.bytes.Load()
+}
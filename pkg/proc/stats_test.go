@@ -0,0 +1,46 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"testing"
+)
+
+func TestScanStats(t *testing.T) {
+	var stats ScanStats
+	r, mem := newScanStatsRecorder(&stats, &fakeMemory{base: 0x1000, data: make([]byte, 64)})
+	r.begin("read heap")
+	buf := make([]byte, 16)
+	mem.ReadMemory(buf, 0x1000)
+	mem.ReadMemory(buf, 0x1010)
+	r.begin("globals")
+	mem.ReadMemory(buf[:8], 0x1020)
+	r.end()
+	if len(stats.Phases) != 2 || stats.Phases[0].Phase != "read heap" || stats.Phases[1].Phase != "globals" {
+		t.Errorf("got the phases %+v, want read heap and globals", stats.Phases)
+	}
+	if stats.Reads != 3 || stats.BytesRead != 40 {
+		t.Errorf("got %d reads of %d bytes, want 3 reads of 40 bytes", stats.Reads, stats.BytesRead)
+	}
+
+	// nothing is recorded without the stats
+	src := &fakeMemory{}
+	r, mem = newScanStatsRecorder(nil, src)
+	r.begin("read heap")
+	r.end()
+	if mem != src {
+		t.Errorf("got the memory %T without the stats, want the source", mem)
+	}
+}
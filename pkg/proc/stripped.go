@@ -0,0 +1,311 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"debug/buildinfo"
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/goversion"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// the go1 minor versions whose runtime layouts are known without DWARF, i.e. the ones with the allocation
+// headers, which name the objects by their types without DWARF
+const (
+	minStrippedVersion = 22
+	maxStrippedVersion = 23
+)
+
+// strippedArchs are the architectures whose runtime layouts are known without DWARF.
+var strippedArchs = []string{"amd64", "arm64"}
+
+// strippedVariables are the runtime variables read without DWARF, and the names of their types.
+var strippedVariables = map[string]string{
+	"runtime.mheap_":          "runtime.mheap",
+	"runtime.firstmoduledata": "runtime.moduledata",
+	"runtime.allgs":           "[]*runtime.g",
+}
+
+// runtime.g.atomicstatus of the goroutines, see runtime/runtime2.go
+const (
+	gIdle    = 0
+	gRunning = 2
+	gSyscall = 3
+	gDead    = 6
+	gScan    = 0x1000
+)
+
+// strippedRuntime describes the runtime of a target whose executable has no DWARF, i.e. the runtime structs
+// by the known layouts of its go version, the runtime constants, and the variables by the ELF symbol table,
+// so the heap is read by HeapScope like the DWARF one.
+type strippedRuntime struct {
+	version string
+	layout  *runtimeLayout
+	// the runtime structs with the fields read by the scanning only, keyed by their names
+	types     map[string]godwarf.Type
+	constants map[string]int64
+	// the addresses of the variables, and the global variables in the data and bss segments
+	variables map[string]Address
+	globals   []strippedSymbol
+}
+
+// strippedSymbol is a global variable of the ELF symbol table.
+type strippedSymbol struct {
+	name string
+	addr Address
+	size int64
+}
+
+// newStrippedRuntime returns the runtime of the executable without DWARF, or an error why it can't be read,
+// e.g. an unsupported go version, or the symbol table is stripped too.
+func newStrippedRuntime(bi *proc.BinaryInfo) (*strippedRuntime, error) {
+	image := bi.Images[0]
+	if !slices.Contains(strippedArchs, bi.Arch.Name) {
+		return nil, fmt.Errorf("%s is not supported, the runtime layouts are known on %s only", bi.Arch.Name, strings.Join(strippedArchs, " and "))
+	}
+	info, err := buildinfo.ReadFile(image.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read the go version: %w", err)
+	}
+	v, ok := goversion.Parse(info.GoVersion)
+	if !ok || v.Major != 1 || v.Minor < minStrippedVersion || v.Minor > maxStrippedVersion {
+		return nil, fmt.Errorf("%s is not supported, the runtime layouts are known for go1.%d to go1.%d only",
+			info.GoVersion, minStrippedVersion, maxStrippedVersion)
+	}
+	for _, s := range info.Settings {
+		if s.Key == "GOEXPERIMENT" && slices.Contains(strings.Split(s.Value, ","), "noallocheaders") {
+			return nil, errors.New("GOEXPERIMENT=noallocheaders is not supported, the objects are named by the allocation headers")
+		}
+	}
+	layout, err := newRuntimeLayout("Go cmd/compile "+info.GoVersion, bi.Arch.PtrSize())
+	if err != nil {
+		return nil, err
+	}
+	r := &strippedRuntime{
+		version:   info.GoVersion,
+		layout:    layout,
+		types:     strippedTypes(v.Minor, bi.Arch.Name),
+		constants: strippedConstants(bi.Arch.Name),
+	}
+	if err = r.readSymbols(image); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readSymbols reads the addresses of the runtime variables and the global variables from the ELF symbol table,
+// which is kept by -ldflags=-w but removed by -s.
+func (r *strippedRuntime) readSymbols(image *proc.Image) error {
+	f, err := elf.Open(image.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		return fmt.Errorf("the symbol table is stripped too, so the runtime variables are not found: %w", err)
+	}
+	r.variables = make(map[string]Address)
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_OBJECT || sym.Value == 0 {
+			continue
+		}
+		// the executable is relocated if position independent
+		addr := Address(sym.Value + image.StaticBase)
+		if _, ok := strippedVariables[sym.Name]; ok {
+			r.variables[sym.Name] = addr
+		}
+		if sym.Size > 0 {
+			r.globals = append(r.globals, strippedSymbol{name: sym.Name, addr: addr, size: int64(sym.Size)})
+		}
+	}
+	for name := range strippedVariables {
+		if r.variables[name] == 0 {
+			return fmt.Errorf("%s is not found in the symbol table", name)
+		}
+	}
+	slices.SortFunc(r.globals, func(a, b strippedSymbol) int { return cmp.Compare(a.addr, b.addr) })
+	return nil
+}
+
+// variable returns the runtime variable like "runtime.mheap_".
+func (r *strippedRuntime) variable(mem proc.MemoryReadWriter, bi *proc.BinaryInfo, name string) (*region, error) {
+	addr, ok := r.variables[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not read without DWARF", name)
+	}
+	return &region{mem: mem, bi: bi, a: addr, typ: r.types[strippedVariables[name]]}, nil
+}
+
+// strippedConstants returns the runtime constants read by the scanning of the 64-bit linux targets.
+func strippedConstants(arch string) map[string]int64 {
+	c := map[string]int64{
+		"_PageSize":              8192,
+		"mSpanInUse":             1,
+		"heapArenaBytes":         64 << 20,
+		"_KindSpecialFinalizer":  1,
+		"arenaL1Bits":            0,
+		"arenaL2Bits":            22,
+		"minSizeForMallocHeader": 512,
+		// -arenaBaseOffsetUintptr like getArenaBaseOffset
+		"arenaBaseOffset": 0,
+	}
+	if arch == "amd64" {
+		c["arenaBaseOffset"] = 1 << 47
+	}
+	return c
+}
+
+// strippedTypes returns the runtime structs of the go version and the architecture, with the fields read by the
+// scanning only, so their sizes are not the real ones. The offsets are the ones in the DWARF of go1.22 and go1.23.
+func strippedTypes(minor int, arch string) map[string]godwarf.Type {
+	var b strippedTypeBuilder
+	uintptr, u32, u16, u8 := b.uint("uintptr", 8), b.uint("uint32", 4), b.uint("uint16", 2), b.uint("uint8", 1)
+	ptr := b.ptr(b.uint("uint8", 1))
+
+	// the offsets changed by the versions and the architectures
+	mheapArenas, gStatus, gGoid, gStartPC, mdNext := int64(66008), int64(144), int64(152), int64(296), int64(584)
+	if arch == "arm64" {
+		// the cache line pad before arenas is 128 bytes on arm64
+		mheapArenas += 64
+	}
+	if minor >= 23 {
+		// runtime.g.syscallbp is added before them, and runtime.moduledata.bad is moved before next
+		gStatus, gGoid, gStartPC = gStatus+8, gGoid+8, gStartPC+8
+		mdNext -= 8
+	}
+
+	special := b.structOf("runtime.special", 16)
+	special.Field = []*godwarf.StructField{
+		b.field("next", 0, b.ptr(special)),
+		b.field("offset", 8, u16),
+		b.field("kind", 10, u8),
+	}
+	specialfinalizer := b.structOf("runtime.specialfinalizer", 24,
+		b.field("special", 0, special),
+		b.field("fn", 16, ptr),
+	)
+	mspan := b.structOf("runtime.mspan", 160,
+		b.field("startAddr", 24, uintptr),
+		b.field("npages", 32, uintptr),
+		b.field("freeindex", 48, u16),
+		b.field("nelems", 50, u16),
+		b.field("freeIndexForScan", 52, u16),
+		b.field("allocBits", 64, ptr),
+		b.field("gcmarkBits", 72, ptr),
+		b.field("sweepgen", 88, u32),
+		b.field("spanclass", 98, u8),
+		b.field("state", 99, u8),
+		b.field("isUserArenaChunk", 101, b.bool()),
+		b.field("elemsize", 104, uintptr),
+		b.field("specials", 128, b.ptr(special)),
+		b.field("largeType", 152, ptr),
+	)
+	// without the bitmap, which is replaced by the allocation headers
+	heapArena := b.structOf("runtime.heapArena", 0)
+	mheap := b.structOf("runtime.mheap", mheapArenas+8,
+		b.field("sweepgen", 65856, u32),
+		b.field("allspans", 65864, b.slice(b.ptr(mspan))),
+		b.field("arenas", mheapArenas, b.array(b.ptr(b.array(b.ptr(heapArena), 1<<22)), 1)),
+	)
+	bitvector := b.structOf("runtime.bitvector", 16,
+		b.field("n", 0, b.int("int32", 4)),
+		b.field("bytedata", 8, ptr),
+	)
+	moduledata := b.structOf("runtime.moduledata", mdNext+8,
+		b.field("text", 176, uintptr),
+		b.field("etext", 184, uintptr),
+		b.field("data", 208, uintptr),
+		b.field("edata", 216, uintptr),
+		b.field("bss", 224, uintptr),
+		b.field("ebss", 232, uintptr),
+		b.field("types", 296, uintptr),
+		b.field("etypes", 304, uintptr),
+		b.field("gcdatamask", 536, bitvector),
+		b.field("gcbssmask", 552, bitvector),
+	)
+	moduledata.Field = append(moduledata.Field, b.field("next", mdNext, b.ptr(moduledata)))
+	g := b.structOf("runtime.g", gStartPC+8,
+		b.field("stack", 0, b.structOf("runtime.stack", 16, b.field("lo", 0, uintptr), b.field("hi", 8, uintptr))),
+		b.field("sched", 56, b.structOf("runtime.gobuf", 56, b.field("sp", 0, uintptr))),
+		b.field("syscallsp", 112, uintptr),
+		b.field("atomicstatus", gStatus, u32),
+		b.field("goid", gGoid, b.uint("uint64", 8)),
+		b.field("startpc", gStartPC, uintptr),
+	)
+	return map[string]godwarf.Type{
+		"runtime.special":          special,
+		"runtime.specialfinalizer": specialfinalizer,
+		"runtime.mspan":            mspan,
+		"runtime.mheap":            mheap,
+		"runtime.moduledata":       moduledata,
+		"runtime.g":                g,
+		"[]*runtime.g":             b.slice(b.ptr(g)),
+	}
+}
+
+// strippedTypeBuilder builds the godwarf types of the runtime structs. Every type gets its own fake offset,
+// which godwarf checks the recursive types by.
+type strippedTypeBuilder struct {
+	off dwarf.Offset
+}
+
+func (b *strippedTypeBuilder) common(name string, size int64, kind reflect.Kind) godwarf.CommonType {
+	b.off++
+	return godwarf.CommonType{ByteSize: size, Name: name, ReflectKind: kind, Offset: b.off}
+}
+
+func (b *strippedTypeBuilder) uint(name string, size int64) godwarf.Type {
+	return &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: b.common(name, size, reflect.Uint)}}
+}
+
+func (b *strippedTypeBuilder) int(name string, size int64) godwarf.Type {
+	return &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: b.common(name, size, reflect.Int)}}
+}
+
+func (b *strippedTypeBuilder) bool() godwarf.Type {
+	return &godwarf.BoolType{BasicType: godwarf.BasicType{CommonType: b.common("bool", 1, reflect.Bool)}}
+}
+
+func (b *strippedTypeBuilder) ptr(elem godwarf.Type) godwarf.Type {
+	return &godwarf.PtrType{CommonType: b.common("*"+elem.Common().Name, 8, reflect.Ptr), Type: elem}
+}
+
+func (b *strippedTypeBuilder) array(elem godwarf.Type, n int64) godwarf.Type {
+	name := fmt.Sprintf("[%d]%s", n, elem.Common().Name)
+	return &godwarf.ArrayType{CommonType: b.common(name, n*elem.Size(), reflect.Array), Type: elem, StrideBitSize: elem.Size() * 8, Count: n}
+}
+
+func (b *strippedTypeBuilder) slice(elem godwarf.Type) godwarf.Type {
+	name := "[]" + elem.Common().Name
+	return &godwarf.SliceType{StructType: godwarf.StructType{CommonType: b.common(name, 24, reflect.Slice), StructName: name, Kind: "struct"}, ElemType: elem}
+}
+
+func (b *strippedTypeBuilder) structOf(name string, size int64, fields ...*godwarf.StructField) *godwarf.StructType {
+	return &godwarf.StructType{CommonType: b.common(name, size, reflect.Struct), StructName: name, Kind: "struct", Field: fields}
+}
+
+func (b *strippedTypeBuilder) field(name string, off int64, typ godwarf.Type) *godwarf.StructField {
+	return &godwarf.StructField{Name: name, Type: typ, ByteOffset: off, ByteSize: typ.Size()}
+}
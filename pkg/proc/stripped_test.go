@@ -0,0 +1,111 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+// skipUnlessStripped skips the test unless the test programs are built by a go version scanned without DWARF.
+func skipUnlessStripped(t *testing.T) int {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	minor := testGoMinorVersion(t)
+	if minor < minStrippedVersion || minor > maxStrippedVersion {
+		t.Skipf("go1.%d is not scanned without DWARF", minor)
+	}
+	return minor
+}
+
+// TestStrippedTypes checks the known runtime layouts against the DWARF of the toolchain building the test programs.
+func TestStrippedTypes(t *testing.T) {
+	minor := skipUnlessStripped(t)
+	f, err := elf.Open(createTestProgram(t, "alltypes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	structs := make(map[string]*dwarf.StructType)
+	for r := d.Reader(); ; {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if e.Tag != dwarf.TagStructType || !strings.HasPrefix(name, "runtime.") || structs[name] != nil {
+			continue
+		}
+		if typ, err := d.Type(e.Offset); err == nil {
+			structs[name] = typ.(*dwarf.StructType)
+		}
+	}
+	var check func(typ *godwarf.StructType)
+	check = func(typ *godwarf.StructType) {
+		want := structs[typ.Name]
+		if want == nil {
+			t.Errorf("%s is not found in DWARF", typ.Name)
+			return
+		}
+		for _, f := range typ.Field {
+			i := 0
+			for i < len(want.Field) && want.Field[i].Name != f.Name {
+				i++
+			}
+			if i == len(want.Field) {
+				t.Errorf("%s.%s is not found in DWARF", typ.Name, f.Name)
+				continue
+			}
+			if wf := want.Field[i]; wf.ByteOffset != f.ByteOffset || wf.Type.Size() != f.ByteSize {
+				t.Errorf("%s.%s is at %d of %d bytes, want %d of %d bytes", typ.Name, f.Name, f.ByteOffset, f.ByteSize, wf.ByteOffset, wf.Type.Size())
+			}
+			if st, ok := f.Type.(*godwarf.StructType); ok {
+				check(st)
+			}
+		}
+	}
+	for name, typ := range strippedTypes(minor, runtime.GOARCH) {
+		if st, ok := typ.(*godwarf.StructType); ok {
+			check(st)
+		} else if !strings.HasPrefix(name, "[]") {
+			t.Errorf("%s is not a struct", name)
+		}
+	}
+}
+
+func TestScanStripped(t *testing.T) {
+	skipUnlessStripped(t)
+	cmd := startTestProgram(t, createTestProgram(t, "alltypes", "-ldflags=-w"))
+	out := scanTestProgram(t, cmd.Process.Pid, WithFormat(FormatFolded))
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the backing array of main.globalCC, named by its allocation header
+	if !strings.Contains(string(b), "main.globalCC;[]string ") {
+		t.Errorf("main.globalCC is not found in the profile:\n%s", b)
+	}
+}
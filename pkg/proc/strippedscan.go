@@ -0,0 +1,321 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// strippedRoot is a root scanned without DWARF, i.e. a global variable by its symbol, the rest of a data or bss
+// segment, or the stack of a goroutine named by its start function, whose pointers are found by it.
+type strippedRoot struct {
+	name string
+	it   *gcMaskBitIterator
+}
+
+// scanStripped scans the target whose executable has no DWARF, which is reported by debugErr, by the runtime
+// structures only. The heap is read by the known runtime layouts, the global variables are found by the symbol
+// table with their pointers by the GC masks of the segments, and the goroutine stacks are scanned conservatively.
+// Without the types of the variables, every object reached is attributed to the root and its allocation type,
+// like "main.cache -> main.Item", so the profile is degraded but still shows what retains the memory.
+func scanStripped(ctx context.Context, t *proc.Target, w io.Writer, o *options, debugErr error) (*Result, error) {
+	if o.referrers != nil || o.goroutineStats != nil || o.channelStats != nil || o.timerStats != nil || o.garbageStats != nil {
+		return nil, fmt.Errorf("%w, which the requested stats require", debugErr)
+	}
+	rt, err := newStrippedRuntime(t.BinInfo())
+	if err != nil {
+		return nil, fmt.Errorf("%w, and it can't be scanned without DWARF: %v", debugErr, err)
+	}
+	o.logger.Printf("%v; scan it without DWARF by the runtime of %s, the objects are attributed to the roots by their allocation types only\n", debugErr, rt.version)
+	if o.freezeDuration > 0 || o.snapshot || o.forkGroup != nil || len(o.runtimeRoots) > 0 || o.validation != nil {
+		o.logger.Warnf("the freeze, the snapshots, the runtime roots and the validation are not supported without DWARF")
+	}
+
+	mem, stats, release := targetMemory(t, o)
+	defer release()
+	defer stats.end()
+	stats.begin("read heap")
+	ram := newRAMBudget(o.maxRAM)
+	heapScope := &HeapScope{
+		mem: mem, bi: t.BinInfo(), stripped: rt, funcExtraMap: make(map[*proc.Function]funcExtra),
+		guard: newMemoryGuard(o.selfMemoryLimit, o.logger), logger: o.logger, progress: newProgressReporter(o.progress),
+		parallelism: o.parallelism, masks: newMaskSpill(ram, o.logger),
+	}
+	defer heapScope.masks.close()
+	if err = heapScope.readHeap(ctx); err != nil {
+		return nil, err
+	}
+	s := &ObjRefScope{
+		HeapScope: heapScope,
+		pb:        newProfileBuilder(w, o.format, o.groupBy, o.sampleTypes),
+		maxDepth:  o.maxDepth,
+		typeRegex: o.typeRegex,
+		canceler:  canceler{ctx: ctx},
+
+		sampleEvery:   o.sampleEvery,
+		sampleMaxSize: o.sampleMaxSize,
+	}
+	if o.largeObjects != nil {
+		s.largeObjects = newLargeObjectHeap(o.largeObjectsN)
+	}
+	s.pb.minSpace, s.pb.minObjects = o.minBytes, o.minObjects
+	s.pb.deterministic = o.deterministic
+	s.pb.limitRAM(ram, o)
+	if o.format == FormatPprof {
+		s.pb.text = readTextMapping(t.BinInfo())
+	}
+	if o.format == FormatPprof || o.format == FormatJSON {
+		s.pb.setProfileLabels(o.labels)
+	}
+	s.pb.comments = append(s.pb.comments, "scanned without DWARF: the objects are grouped by the roots and their allocation types")
+	s.pb.comments = append(s.pb.comments, o.samplingComments()...)
+	if s.mds, err = s.strippedModuleData(); err != nil {
+		return nil, err
+	}
+
+	workers := o.parallelism
+	if o.deterministic {
+		workers = 1
+	}
+	stats.begin("globals")
+	globals := s.strippedGlobals(o)
+	s.parallel(workers, "globals", len(globals), func(w *ObjRefScope, i int) {
+		w.markStripped(globals[i])
+	})
+	stats.begin("goroutines")
+	goroutines := s.strippedGoroutines(o)
+	s.parallel(workers, "goroutines", len(goroutines), func(w *ObjRefScope, i int) {
+		w.markStripped(goroutines[i])
+	})
+	// the finalized objects are only reachable from the specials
+	stats.begin("finalizers")
+	finalized := make([]uint64, len(heapScope.finalizers))
+	for i, fin := range heapScope.finalizers {
+		finalized[i] = uint64(fin.p)
+	}
+	if len(finalized) > 0 {
+		s.safely("finalizers", func() { s.markStrippedValues("finalized", finalized) })
+	}
+	if o.largeObjects != nil {
+		*o.largeObjects = s.largeObjectList()
+	}
+
+	stats.begin("output")
+	heapScope.masks.close()
+	if err = s.pb.flush(); err != nil {
+		return nil, err
+	}
+	res := &Result{Objects: s.reached.objects, Space: s.reached.space, Panics: s.panics.Load()}
+	if s.canceled {
+		return res, fmt.Errorf("scanning is interrupted, the profile is partial: %w", ctx.Err())
+	}
+	if res.Panics > 0 {
+		return res, fmt.Errorf("%d parts of the scanning are skipped on panics, the profile is partial", res.Panics)
+	}
+	return res, nil
+}
+
+// strippedModuleData returns the types sections of the modules, which the allocation types are named by.
+func (s *ObjRefScope) strippedModuleData() ([]proc.ModuleData, error) {
+	firstmoduledata, err := s.runtimeVariable("runtime.firstmoduledata")
+	if err != nil {
+		return nil, err
+	}
+	var mds []proc.ModuleData
+	for md := firstmoduledata; md.a != 0; md = md.Field("next").Deref() {
+		mds = append(mds, newModuleData(md.Field("types").Uintptr(), md.Field("etypes").Uintptr()))
+	}
+	return mds, nil
+}
+
+// strippedGlobals returns the global variables in the data and bss segments by the symbol table, followed by
+// the segments themselves for the pointers not covered by any symbol, unless the roots are restricted to some packages.
+func (s *ObjRefScope) strippedGlobals(o *options) []strippedRoot {
+	var roots []strippedRoot
+	segs := append(append(segments(nil), s.data...), s.bss...)
+	for _, sym := range s.stripped.globals {
+		if !o.includes(sym.name) {
+			continue
+		}
+		end := sym.addr.Add(sym.size)
+		for _, seg := range segs {
+			if sym.addr < seg.base || end > seg.end {
+				continue
+			}
+			it := newGCBitsIterator(sym.addr, end, seg.maskBase, seg.mask)
+			if it.nextPtr(false) != 0 {
+				roots = append(roots, strippedRoot{name: sym.name, it: it})
+			}
+			break
+		}
+	}
+	if len(o.includePackages) > 0 {
+		return roots
+	}
+	for i, seg := range s.bss {
+		roots = append(roots, strippedRoot{name: fmt.Sprintf("bss segment[%d]", i), it: newGCBitsIterator(seg.base, seg.end, seg.maskBase, seg.mask)})
+	}
+	for i, seg := range s.data {
+		roots = append(roots, strippedRoot{name: fmt.Sprintf("data segment[%d]", i), it: newGCBitsIterator(seg.base, seg.end, seg.maskBase, seg.mask)})
+	}
+	return roots
+}
+
+// strippedGoroutines returns the stacks of the live goroutines by runtime.allgs, named by their start functions
+// from the pclntab. A stack is scanned conservatively from its saved stack pointer, or as a whole if the goroutine
+// is running, whose stack pointer is in the registers of its thread.
+func (s *ObjRefScope) strippedGoroutines(o *options) []strippedRoot {
+	allgs, err := s.runtimeVariable("runtime.allgs")
+	if err != nil {
+		s.logger.Errorf("read goroutines error: %v", err)
+		return nil
+	}
+	var roots []strippedRoot
+	n := allgs.SliceLen()
+	for i := int64(0); i < n; i++ {
+		g := allgs.SliceIndex(i).Deref()
+		status := g.Field("atomicstatus").Uint32() &^ gScan
+		if status == gIdle || status == gDead {
+			continue
+		}
+		stack := g.Field("stack")
+		lo, hi := Address(stack.Field("lo").Uintptr()), Address(stack.Field("hi").Uintptr())
+		sp := Address(g.Field("sched").Field("sp").Uintptr())
+		switch status {
+		case gRunning:
+			sp = lo
+		case gSyscall:
+			sp = Address(g.Field("syscallsp").Uintptr())
+		}
+		if sp < lo || sp >= hi {
+			sp = lo
+		}
+		name := "goroutine"
+		if fn := s.bi.PCToFunc(g.Field("startpc").Uintptr()); fn != nil {
+			name = fn.Name
+		}
+		if sp >= hi || !o.includes(name) {
+			continue
+		}
+		ptrMask := make([]uint64, CeilDivide(hi.Sub(sp)/8, 64))
+		for i := range ptrMask {
+			ptrMask[i] = ^uint64(0)
+		}
+		roots = append(roots, strippedRoot{name: name, it: newGCBitsIterator(sp, hi, sp, ptrMask)})
+	}
+	return roots
+}
+
+// strippedType is the objects of an allocation type reached from a root.
+type strippedType struct {
+	idx    *pprofIndex
+	values sampleValues
+}
+
+// markStripped marks the objects reached from the pointers of the root, and records them by their allocation types
+// below the root. The objects are traversed by their GC bits with an explicit stack rather than recursively.
+func (s *ObjRefScope) markStripped(root strippedRoot) {
+	types := make(map[string]*strippedType)
+	idx := (*pprofIndex)(nil).pushHead(s.pb, root.name)
+	s.markStrippedFrom(idx, types, root.it, nil)
+	s.recordStrippedTypes(types)
+}
+
+// markStrippedValues marks the objects at the addresses like markStripped.
+func (s *ObjRefScope) markStrippedValues(name string, addrs []uint64) {
+	types := make(map[string]*strippedType)
+	idx := (*pprofIndex)(nil).pushHead(s.pb, name)
+	s.markStrippedFrom(idx, types, nil, addrs)
+	s.recordStrippedTypes(types)
+}
+
+func (s *ObjRefScope) markStrippedFrom(idx *pprofIndex, types map[string]*strippedType, root *gcMaskBitIterator, addrs []uint64) {
+	type frame struct {
+		it       *gcMaskBitIterator
+		mem      proc.MemoryReadWriter
+		weight   int64
+		sampling bool
+	}
+	var stack []frame
+	visit := func(addr Address) {
+		sp, base := s.findSpanAndBase(addr)
+		if sp == nil || sp.userArena {
+			return
+		}
+		weight := s.sampleWeight(base, sp.elemSize)
+		if weight == 0 {
+			return
+		}
+		hb := s.visitObject(sp, base)
+		if hb == nil {
+			return
+		}
+		s.guard.tick()
+		s.reached.objects += weight
+		s.reached.space += sp.elemSize * weight
+		name := s.allocTypeName(sp, base)
+		typ := types[name]
+		if typ == nil {
+			typ = &strippedType{idx: idx.pushHead(s.pb, name)}
+			if s.typeRegex != nil && !typ.idx.matched {
+				typ.idx.matched = s.typeRegex.MatchString(name)
+			}
+			types[name] = typ
+		}
+		typ.values[SampleObjects] += weight
+		typ.values[SampleSpace] += sp.elemSize * weight
+		typ.values[SampleSelfObjects] += weight
+		typ.values[SampleSelfSpace] += sp.elemSize * weight
+		s.pb.addObjects(name, sp.elemSize*weight, weight)
+		s.addLargeObject(base, sp.elemSize, nil, typ.idx)
+		if hb.nextPtr(false) != 0 {
+			stack = append(stack, frame{it: hb, mem: s.guard.cacheMemory(s.mem, uint64(hb.base), int(hb.end.Sub(hb.base))),
+				weight: weight, sampling: s.samplesBelow(weight, sp.elemSize)})
+		}
+	}
+	defer s.enterSampled(0, false)()
+	for _, addr := range addrs {
+		visit(Address(addr))
+	}
+	if root != nil {
+		stack = append(stack, frame{it: root, mem: s.guard.cacheMemory(s.mem, uint64(root.base), int(root.end.Sub(root.base))),
+			sampling: s.samplesBelow(0, root.end.Sub(root.base))})
+	}
+	for len(stack) > 0 && !s.done() {
+		top := stack[len(stack)-1]
+		ptr := top.it.nextPtr(true)
+		if ptr == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		val, err := readUintRaw(top.mem, uint64(ptr), int64(s.bi.Arch.PtrSize()))
+		if err != nil {
+			continue
+		}
+		s.weight, s.sampling = top.weight, top.sampling
+		visit(Address(val))
+	}
+}
+
+// recordStrippedTypes records the objects of the allocation types reached from a root.
+func (s *ObjRefScope) recordStrippedTypes(types map[string]*strippedType) {
+	for _, typ := range types {
+		s.recordValues(typ.idx, &typ.values)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// chanElemName is the name of the value pending in a blocked channel operation, under the blocked function.
+const chanElemName = "$chanelem"
+
+// findSudogRefs scans the values pending in the blocked channel operations of the goroutines, i.e. the
+// elems of the sudogs in the g.waiting lists, like the values to send or the slots to receive into of
+// the select cases. They are attributed to the functions blocked on the operations, rather than to
+// runtime.allgs or the channels referencing the sudogs, so it's done before the global variables.
+// The elems pointing to the stacks are scanned with the stack frames.
+func (s *ObjRefScope) findSudogRefs(t *proc.Target, grs []*goroutineRoot, o *options) {
+	gs, err := s.readGs()
+	if err != nil {
+		s.logger.Warnf("read runtime.allgs err: %v", err)
+		return
+	}
+	runtimeType, err := findType(s.bi, runtimeTypeTypename(s.bi))
+	if err != nil {
+		s.logger.Warnf("find the runtime type err: %v", err)
+		return
+	}
+	defer s.setGoroutineLabels(nil, nil)
+	for _, gr := range grs {
+		g := gs[gr.g.ID]
+		if g == nil || !g.HasField("waiting") {
+			continue
+		}
+		fn := blockedFunc(gr.frames)
+		if fn == "" || !o.includes(fn) {
+			continue
+		}
+		var root *pprofIndex
+		if o.groupBy.byGoroutine() {
+			root = root.pushHead(s.pb, goroutineRootName(gr, o.groupBy))
+		}
+		s.setGoroutineLabels(gr, root)
+		for sg := g.Field("waiting").Deref(); sg.a != 0 && !s.done(); sg = sg.Field("waitlink").Deref() {
+			elem := sg.Field("elem")
+			c := sg.Field("c").Deref()
+			if elem.Address() == 0 || c.a == 0 {
+				continue
+			}
+			typ := s.chanElemType(newVariable("", uint64(c.Field("elemtype").Address()), runtimeType, s.bi, c.mem))
+			name := fn + "." + chanElemName + ". (" + typ.String() + ")"
+			s.findRef(newReferenceVariable(elem.a, name, pointerTo(typ, s.bi.Arch), elem.mem, nil), root)
+		}
+	}
+}
+
+// chanElemType returns the DWARF type of the runtime type _type of the channel elements, void if not found.
+func (s *ObjRefScope) chanElemType(_type *proc.Variable) godwarf.Type {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	if rtyp, _, err := proc.RuntimeTypeToDIE(_type, 0, s.mds); err == nil {
+		return resolveTypedef(rtyp)
+	}
+	return new(godwarf.VoidType)
+}
+
+// blockedFunc returns the innermost function of the frames out of the runtime, like main.worker
+// blocked on a channel operation in runtime.chanrecv, empty if not found.
+func blockedFunc(frames []proc.Stackframe) string {
+	for i := range frames {
+		if fn := frames[i].Current.Fn; fn != nil && !strings.HasPrefix(fn.Name, "runtime.") {
+			return fn.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// TimerStat is the timers in the runtime timer heaps with the same callback.
+type TimerStat struct {
+	// Callback is the function called when the timers fire, like "main.main.func1" passed to time.AfterFunc,
+	// or "time.sendTime" of the channel timers and tickers.
+	Callback string
+	// Count is the number of the timers, and Periodic is the number of them firing periodically, i.e. the tickers.
+	Count, Periodic int64
+	// Objects and Space are the heap objects retained by the timers, which are not reached by other roots before.
+	Objects, Space int64
+}
+
+// WithTimerStats collects the timers in the runtime timer heaps by their callbacks to stats.
+// The timers root is scanned even if it's not in the runtime roots.
+func WithTimerStats(stats *[]TimerStat) Option {
+	return func(o *options) {
+		o.timerStats = stats
+	}
+}
+
+// timerCallbackFunc is the callback of the timers created by time.AfterFunc, whose arg is the function to call.
+const timerCallbackFunc = "time.goFunc"
+
+// findTimerRoots scans the timers in the timer heaps of all Ps, which are attributed to their callbacks.
+// The heap of a P is a []*timer before go1.23, and a timers struct with a []timerWhen since then.
+func (s *ObjRefScope) findTimerRoots(name string) error {
+	tmp, err := s.scope.EvalExpression("runtime.allp", loadSingleValue)
+	if err != nil {
+		return err
+	}
+	if s.timers == nil {
+		s.timers = make(map[string]*TimerStat)
+	}
+	root := (*pprofIndex)(nil).pushHead(s.pb, name)
+	timerPtrTypes := make(map[godwarf.Type]godwarf.Type)
+	allp := toRegion(tmp, s.bi)
+	for i, n := int64(0), allp.SliceLen(); i < n; i++ {
+		p := allp.SliceIndex(i).Deref()
+		if p.a == 0 {
+			continue
+		}
+		ts := p.Field("timers")
+		if ts.IsStruct() {
+			ts = ts.Field("heap")
+		}
+		for j, m := int64(0), ts.SliceLen(); j < m; j++ {
+			t := ts.SliceIndex(j)
+			if t.IsStruct() {
+				// timerWhen
+				t = t.Field("timer")
+			}
+			s.findTimerRef(t, root, timerPtrTypes)
+		}
+	}
+	return nil
+}
+
+// timerBackRefs are the fields of the timer pointing back to the timers of its P, ts since go1.23 and
+// pp before, which are not scanned, otherwise the other timers and the whole P would be attributed to
+// the first timer. The P is reached by runtime.allp anyway.
+var timerBackRefs = []string{"ts", "pp"}
+
+// findTimerRef scans the timer pointed by t under the path of its callback.
+func (s *ObjRefScope) findTimerRef(t *region, root *pprofIndex, timerPtrTypes map[godwarf.Type]godwarf.Type) {
+	tr := t.Deref()
+	if tr.a == 0 {
+		return
+	}
+	ptrType, ok := timerPtrTypes[t.typ]
+	if !ok {
+		ptrType = t.typ
+		if st, ok := tr.typ.(*godwarf.StructType); ok {
+			nst := *st
+			nst.Field = slices.DeleteFunc(slices.Clone(st.Field), func(f *godwarf.StructField) bool {
+				return slices.Contains(timerBackRefs, f.Name)
+			})
+			ptrType = pointerTo(&nst, s.bi.Arch)
+		}
+		timerPtrTypes[t.typ] = ptrType
+	}
+	callback := s.timerCallback(tr)
+	st := s.timers[callback]
+	if st == nil {
+		st = &TimerStat{Callback: callback}
+		s.timers[callback] = st
+	}
+	st.Count++
+	if tr.Field("period").Int() > 0 {
+		st.Periodic++
+	}
+	objects, space, marks := s.reached.objects, s.reached.space, len(s.finalMarks)
+	s.findRef(newReferenceVariable(t.a, callback, ptrType, s.mem, nil), root)
+	// mark the rest of the timer now, e.g. the closures only known by the GC bits, to count them
+	for _, m := range s.finalMarks[marks:] {
+		for _, f := range timerBackRefs {
+			if tr.HasField(f) {
+				_ = m.hb.resetGCMask(tr.Field(f).a)
+			}
+		}
+		s.finalMark(m.idx, m.hb, m.direct, m.weight)
+	}
+	s.finalMarks = s.finalMarks[:marks]
+	st.Objects += s.reached.objects - objects
+	st.Space += s.reached.space - space
+}
+
+// timerCallback returns the function name of the callback of the timer tr,
+// or the function passed to time.AfterFunc, which is the arg of time.goFunc.
+func (s *ObjRefScope) timerCallback(tr *region) string {
+	name := s.funcValueName(tr.mem, tr.Field("f").a)
+	if name == timerCallbackFunc {
+		// the data word of the interface is the func value
+		if fn := s.funcValueName(tr.mem, tr.Field("arg").a.Add(int64(s.bi.Arch.PtrSize()))); fn != "" {
+			return fn
+		}
+	}
+	if name == "" {
+		return unknownTypeName
+	}
+	return name
+}
+
+// funcValueName returns the function name of the func value at addr, empty if unknown.
+func (s *ObjRefScope) funcValueName(mem proc.MemoryReadWriter, addr Address) string {
+	ptrSize := int64(s.bi.Arch.PtrSize())
+	fv, err := readUintRaw(mem, uint64(addr), ptrSize)
+	if err != nil || fv == 0 {
+		return ""
+	}
+	pc, err := readUintRaw(proc.DereferenceMemory(mem), fv, ptrSize)
+	if err != nil {
+		return ""
+	}
+	if fn := s.bi.PCToFunc(pc); fn != nil {
+		return fn.Name
+	}
+	return ""
+}
+
+// timerStats returns the timers by their callbacks, sorted by the counts.
+func (s *ObjRefScope) timerStats() []TimerStat {
+	stats := make([]TimerStat, 0, len(s.timers))
+	for _, st := range s.timers {
+		stats = append(stats, *st)
+	}
+	slices.SortFunc(stats, func(a, b TimerStat) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Callback, b.Callback)
+	})
+	return stats
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "testing"
+
+func TestTimerStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip scenario tests in short mode")
+	}
+	var stats []TimerStat
+	runTestScenario(t, testScenario{name: "timers", minGoMinor: minTestGoMinor}, WithTimerStats(&stats))
+	for _, st := range stats {
+		if st.Callback != "main.schedule.func1" {
+			continue
+		}
+		if st.Count != 100 || st.Periodic != 0 {
+			t.Errorf("got %d timers and %d periodic, want 100 and 0", st.Count, st.Periodic)
+		}
+		// the payloads and their 4KB buffers
+		if st.Space < 100*4096 {
+			t.Errorf("got %d bytes reached by the timers, want at least %d", st.Space, 100*4096)
+		}
+		return
+	}
+	t.Errorf("no timer of main.schedule.func1 in %+v", stats)
+}
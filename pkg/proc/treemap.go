@@ -0,0 +1,227 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+const (
+	// the size of the treemap in pixels
+	treemapWidth, treemapHeight = 1600, 1000
+	// the height of the label of a node, and the padding around its children
+	treemapLabelHeight, treemapPadding = 14, 2
+	// the nodes smaller than treemapMinSide pixels are not drawn
+	treemapMinSide = 3
+)
+
+// treemapEncoder writes the reference tree as a squarified treemap in SVG, where the area of a node is its
+// retained space if carried by the profile, otherwise its space, and the children are nested in their parent.
+type treemapEncoder struct{}
+
+// rect is a rectangle of the treemap.
+type rect struct {
+	x, y, w, h float64
+}
+
+func (treemapEncoder) encode(out io.Writer, b *profileBuilder) error {
+	vi := 0
+	for i, t := range b.sampleTypes {
+		if t == SampleRetained {
+			vi = i
+			break
+		}
+		if t == SampleSpace {
+			vi = i
+		}
+	}
+	info := sampleTypeInfos[b.sampleTypes[vi]]
+	root := b.flameTree()
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n",
+		treemapWidth, treemapHeight)
+	fmt.Fprintf(w, "<title>goref treemap of %s: %s</title>\n", info.typ, formatValue(root.Values[vi], info.unit))
+	t := &treemap{w: w, vi: vi, unit: info.unit, total: root.Values[vi]}
+	if t.total > 0 {
+		t.drawChildren(root, rect{0, 0, treemapWidth, treemapHeight}, "")
+	}
+	w.WriteString("</svg>\n")
+	return w.Flush()
+}
+
+// treemap draws the nodes of the reference tree.
+type treemap struct {
+	w     *bufio.Writer
+	vi    int
+	unit  string
+	total int64
+}
+
+// drawChildren lays out the children of n in r, the rest of r is the value recorded at n itself.
+func (t *treemap) drawChildren(n *flameNode, r rect, path string) {
+	total := n.Values[t.vi]
+	if total <= 0 || r.w < treemapMinSide || r.h < treemapMinSide {
+		return
+	}
+	// the value recorded at n itself is laid out as an area without node, which is not drawn
+	var sum int64
+	nodes := make([]*flameNode, 0, len(n.Children)+1)
+	for _, c := range n.Children {
+		if v := c.Values[t.vi]; v > 0 {
+			nodes = append(nodes, c)
+			sum += v
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	value := func(c *flameNode) int64 {
+		if c == nil {
+			return total - sum
+		}
+		return c.Values[t.vi]
+	}
+	if total > sum {
+		nodes = append(nodes, nil)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return value(nodes[i]) > value(nodes[j]) })
+	scale := r.w * r.h / float64(max(total, sum))
+	areas := make([]float64, len(nodes))
+	for i, c := range nodes {
+		areas[i] = float64(value(c)) * scale
+	}
+	for i, rc := range squarify(areas, r) {
+		if nodes[i] != nil {
+			t.draw(nodes[i], rc, path)
+		}
+	}
+}
+
+// draw draws the node n in r, and its children nested in r under the label.
+func (t *treemap) draw(n *flameNode, r rect, path string) {
+	if r.w < treemapMinSide || r.h < treemapMinSide {
+		return
+	}
+	if path != "" {
+		path += " -> "
+	}
+	path += n.Name
+	field, typ := profile.SplitNodeName(n.Name)
+	label := field
+	if typ != "" {
+		label += " (" + typ + ")"
+	}
+	v := n.Values[t.vi]
+	fmt.Fprintf(t.w, `<g><title>%s: %s (%.2f%%)</title>`, html.EscapeString(path), formatValue(v, t.unit), float64(v)*100/float64(t.total))
+	fmt.Fprintf(t.w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="#fff"/>`, r.x, r.y, r.w, r.h, treemapColor(typ, field))
+	// about 6 pixels per character
+	if chars := int((r.w - 2*treemapPadding) / 6); chars > 0 && r.h >= treemapLabelHeight {
+		if len(label) > chars {
+			label = label[:max(chars-1, 0)] + "…"
+		}
+		fmt.Fprintf(t.w, `<text x="%.1f" y="%.1f">%s</text>`, r.x+treemapPadding, r.y+treemapLabelHeight-3, html.EscapeString(label))
+	}
+	t.w.WriteString("</g>\n")
+	inner := rect{r.x + treemapPadding, r.y + treemapLabelHeight, r.w - 2*treemapPadding, r.h - treemapLabelHeight - treemapPadding}
+	t.drawChildren(n, inner, path)
+}
+
+// treemapColor returns the color of the nodes of the type, or of the field if the type is unknown, like the roots.
+func treemapColor(typ, field string) string {
+	name := typ
+	if name == "" {
+		name = field
+	}
+	var h uint32
+	for i := 0; i < len(name); i++ {
+		h = h*31 + uint32(name[i])
+	}
+	return fmt.Sprintf("hsl(%d,60%%,%d%%)", h%360, 70+h%15)
+}
+
+// formatValue formats the value of the unit, like "1.00MB" of bytes.
+func formatValue(v int64, unit string) string {
+	if unit == "bytes" {
+		return FormatBytes(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// squarify lays out the areas, sorted in decreasing order and summing to the area of r, in r by the squarified
+// treemap algorithm of Bruls et al., which fills r by rows along its shorter side, and adds an area to the
+// current row as long as it doesn't make the worst aspect ratio of the row worse.
+func squarify(areas []float64, r rect) []rect {
+	rects := make([]rect, 0, len(areas))
+	for i := 0; i < len(areas); {
+		side := math.Min(r.w, r.h)
+		sum, j := areas[i], i+1
+		for j < len(areas) && worstRatio(areas[i:j+1], sum+areas[j], side) <= worstRatio(areas[i:j], sum, side) {
+			sum += areas[j]
+			j++
+		}
+		if r.w >= r.h {
+			// a column at the left
+			w := 0.0
+			if r.h > 0 {
+				w = sum / r.h
+			}
+			y := r.y
+			for _, a := range areas[i:j] {
+				h := 0.0
+				if w > 0 {
+					h = a / w
+				}
+				rects = append(rects, rect{r.x, y, w, h})
+				y += h
+			}
+			r.x, r.w = r.x+w, math.Max(r.w-w, 0)
+		} else {
+			// a row at the top
+			h := 0.0
+			if r.w > 0 {
+				h = sum / r.w
+			}
+			x := r.x
+			for _, a := range areas[i:j] {
+				w := 0.0
+				if h > 0 {
+					w = a / h
+				}
+				rects = append(rects, rect{x, r.y, w, h})
+				x += w
+			}
+			r.y, r.h = r.y+h, math.Max(r.h-h, 0)
+		}
+		i = j
+	}
+	return rects
+}
+
+// worstRatio returns the worst aspect ratio of the row of the areas sorted in decreasing order,
+// laid out along the side.
+func worstRatio(row []float64, sum, side float64) float64 {
+	if sum <= 0 || side <= 0 {
+		return math.Inf(1)
+	}
+	s2, w2 := sum*sum, side*side
+	return math.Max(w2*row[0]/s2, s2/(w2*row[len(row)-1]))
+}
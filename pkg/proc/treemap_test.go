@@ -0,0 +1,67 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSquarify(t *testing.T) {
+	r := rect{10, 20, 600, 400}
+	areas := []float64{60000, 60000, 40000, 30000, 20000, 20000, 10000}
+	rects := squarify(areas, r)
+	if len(rects) != len(areas) {
+		t.Fatalf("got %d rects, want %d", len(rects), len(areas))
+	}
+	const eps = 1e-6
+	for i, rc := range rects {
+		if math.Abs(rc.w*rc.h-areas[i]) > eps*areas[i] {
+			t.Errorf("rect %d has area %f, want %f", i, rc.w*rc.h, areas[i])
+		}
+		if rc.x < r.x-eps || rc.y < r.y-eps || rc.x+rc.w > r.x+r.w+eps || rc.y+rc.h > r.y+r.h+eps {
+			t.Errorf("rect %d %+v is out of %+v", i, rc, r)
+		}
+	}
+}
+
+func TestFlushTreemap(t *testing.T) {
+	var buf bytes.Buffer
+	pb := newProfileBuilder(&buf, FormatTreemap, GroupByPath, []SampleType{SampleObjects, SampleSpace, SampleRetained})
+	root := (*pprofIndex)(nil).pushHead(pb, "main.root")
+	child := root.pushHead(pb, "next. (*main.T)")
+	pb.addReference(root.indexes(), 0, &sampleValues{SampleObjects: 1, SampleSpace: 16, SampleRetained: 1024})
+	pb.addReference(child.indexes(), 0, &sampleValues{SampleObjects: 2, SampleSpace: 64, SampleRetained: 3072})
+	if err := pb.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		"<title>goref treemap of retained_space: 4.00kB</title>",
+		"<title>main.root: 4.00kB (100.00%)</title>",
+		"<title>main.root -&gt; next. (*main.T): 3.00kB (75.00%)</title>",
+		"<text x=",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("treemap misses %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "<rect "); n != 2 {
+		t.Errorf("got %d rects, want 2", n)
+	}
+}
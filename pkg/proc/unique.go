@@ -0,0 +1,258 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// hashTrieMapRegex matches the concurrent hash-trie map of go1.23, which backs the intern table of
+// the unique package, i.e. unique.uniqueMaps and a uniqueMap[T] for every type T passed to unique.Make.
+var hashTrieMapRegex = regexp.MustCompile(`^internal/concurrent\.HashTrieMap\[(.*)\]$`)
+
+// uniqueMapRegex matches the runtime type of the per-type intern table of the unique package, like
+// "*unique.uniqueMap[main.Key]", which has no DIE since only the shapes of the generic types are in DWARF.
+var uniqueMapRegex = regexp.MustCompile(`^\*unique\.uniqueMap\[(.*)\]$`)
+
+const shapePrefix = "go.shape."
+
+const (
+	// internTableName is the path of the indirect and entry nodes of a hash-trie map, i.e. the table overhead.
+	internTableName = "$interntable"
+	// internKeyName and internValName are the paths of the keys and values stored in the entries,
+	// e.g. the interned values and the weak pointers to their canonical copies.
+	internKeyName = "$internkey"
+	internValName = "$internval"
+)
+
+// hashTrieTypes are the types of the nodes of a hash-trie map with the same type arguments.
+type hashTrieTypes struct {
+	indirect, entry *godwarf.StructType
+}
+
+func (s *ObjRefScope) findHashTrieTypes(args string) (*hashTrieTypes, bool) {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	var types [2]*godwarf.StructType
+	for i, name := range []string{"internal/concurrent.indirect[" + args + "]", "internal/concurrent.entry[" + args + "]"} {
+		typ, err := findType(s.bi, name)
+		if err != nil {
+			return nil, false
+		}
+		st, ok := resolveTypedef(typ).(*godwarf.StructType)
+		if !ok {
+			return nil, false
+		}
+		types[i] = st
+	}
+	return &hashTrieTypes{indirect: types[0], entry: types[1]}, true
+}
+
+// findHashTrieMapRef scans the hash-trie map x. The trie is walked by the node kinds rather than
+// as the nested fields, since a child is declared as *node[K,V] and converted to *indirect[K,V] or
+// *entry[K,V] by its isEntry flag, and the depth of the trie would exceed the max reference depth.
+// The nodes are recorded under internTableName, and the keys and values under internKeyName and
+// internValName of x, so the interned values are attributed to their types and the overhead is separated.
+// It returns false if the node types are not found, e.g. only the shapes are in DWARF.
+func (s *ObjRefScope) findHashTrieMapRef(x *ReferenceVariable, typ *godwarf.StructType, args string, idx *pprofIndex) bool {
+	if s.depthExceeded(idx) {
+		return false
+	}
+	types, ok := s.findHashTrieTypes(args)
+	if !ok {
+		return false
+	}
+	var root uint64
+	for _, field := range typ.Field {
+		if field.Name == "root" {
+			var err error
+			if root, err = x.readPointer(x.Addr.Add(field.ByteOffset)); err != nil {
+				return true
+			}
+		}
+	}
+	mem := proc.DereferenceMemory(x.mem)
+	tableIdx := idx.pushHead(s.pb, internTableName)
+	var size, count int64
+	var nodes []*ReferenceVariable
+	find := func(addr uint64, mem proc.MemoryReadWriter) {
+		if addr == 0 {
+			return
+		}
+		isEntry, err := readUintRaw(mem, addr, 1)
+		if err != nil {
+			return
+		}
+		typ := types.indirect
+		if isEntry != 0 {
+			typ = types.entry
+		}
+		if y := s.findObject(Address(addr), typ, mem); y != nil {
+			size += y.size
+			count += y.count
+			nodes = append(nodes, y)
+		}
+	}
+	find(root, mem)
+	for len(nodes) > 0 && !s.done() {
+		y := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		s.findHashTrieNodeRef(y, types, tableIdx, idx, find)
+	}
+	s.record(tableIdx, size, count)
+	return true
+}
+
+// findHashTrieNodeRef scans the node y of a hash-trie map, the child nodes are passed to find.
+func (s *ObjRefScope) findHashTrieNodeRef(y *ReferenceVariable, types *hashTrieTypes, tableIdx, idx *pprofIndex,
+	find func(addr uint64, mem proc.MemoryReadWriter),
+) {
+	if s.retained != nil {
+		defer s.enterRetained(y.Addr, tableIdx)()
+	}
+	defer func() {
+		if y.hb.nextPtr(false) != 0 {
+			// still has pointer, add to the finalMarks
+			s.finalMarks = append(s.finalMarks, finalMarkParam{tableIdx, y.hb, false, max(y.weight, s.weight)})
+		}
+	}()
+	st := y.RealType.(*godwarf.StructType)
+	for _, field := range st.Field {
+		addr := y.Addr.Add(field.ByteOffset)
+		switch field.Name {
+		case "parent":
+			// found before the node
+			_, _ = y.readPointer(addr)
+		case "children":
+			arr, ok := resolveTypedef(field.Type).(*godwarf.ArrayType)
+			if !ok {
+				continue
+			}
+			off, ok := atomicPointerOffset(arr.Type)
+			if !ok {
+				continue
+			}
+			for i := int64(0); i < arr.Count; i++ {
+				ptr, err := y.readPointer(addr.Add(i*arr.Type.Size() + off))
+				if err == nil {
+					find(ptr, proc.DereferenceMemory(y.mem))
+				}
+			}
+		case "overflow":
+			if off, ok := atomicPointerOffset(field.Type); ok {
+				if ptr, err := y.readPointer(addr.Add(off)); err == nil {
+					find(ptr, proc.DereferenceMemory(y.mem))
+				}
+			}
+		case "key", "value":
+			name := internKeyName
+			if field.Name == "value" {
+				name = internValName
+			}
+			ftyp := resolveTypedef(field.Type)
+			tname := strings.TrimPrefix(ftyp.String(), shapePrefix)
+			_ = s.findRef(newReferenceVariable(addr, name+". ("+tname+")", ftyp, y.mem, y.hb), idx)
+		}
+	}
+}
+
+// uniqueMapType returns the shape type of unique.uniqueMap[elem], nil if not found.
+func (s *ObjRefScope) uniqueMapType(elem string) godwarf.Type {
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	if typ, ok := s.uniqueMapTypes[elem]; ok {
+		return typ
+	}
+	if s.uniqueMapTypes == nil {
+		s.uniqueMapTypes = make(map[string]godwarf.Type)
+	}
+	typ := s.findUniqueMapType(elem)
+	s.uniqueMapTypes[elem] = typ
+	return typ
+}
+
+func (s *ObjRefScope) findUniqueMapType(elem string) godwarf.Type {
+	const prefix = "unique.uniqueMap[" + shapePrefix
+	// e.g. unique.uniqueMap[go.shape.string]
+	if typ, err := findType(s.bi, prefix+elem+"]"); err == nil {
+		return resolveTypedef(typ)
+	}
+	etyp, err := findType(s.bi, elem)
+	if err != nil {
+		return nil
+	}
+	etyp = resolveTypedef(etyp)
+	names, _ := s.bi.Types()
+	for _, name := range names {
+		shape, ok := strings.CutPrefix(name, prefix)
+		if !ok || !strings.HasSuffix(shape, "]") {
+			continue
+		}
+		styp, err := findType(s.bi, shapePrefix+strings.TrimSuffix(shape, "]"))
+		if err != nil || !sameShape(etyp, resolveTypedef(styp)) {
+			continue
+		}
+		if typ, err := findType(s.bi, name); err == nil {
+			return resolveTypedef(typ)
+		}
+	}
+	return nil
+}
+
+// sameShape reports whether typ has the shape, like main.Key of
+// "go.shape.struct { main.a string; main.b string }", whose fields are named with the package.
+func sameShape(typ, shape godwarf.Type) bool {
+	if typ.Size() != shape.Size() {
+		return false
+	}
+	st, ok1 := typ.(*godwarf.StructType)
+	sst, ok2 := shape.(*godwarf.StructType)
+	if ok1 != ok2 {
+		return false
+	}
+	if !ok1 {
+		return typ.Common().ReflectKind == shape.Common().ReflectKind && typ.Common().ReflectKind != reflect.Invalid
+	}
+	if len(st.Field) != len(sst.Field) {
+		return false
+	}
+	for i, f := range st.Field {
+		sf := sst.Field[i]
+		if f.ByteOffset != sf.ByteOffset || f.Type.String() != sf.Type.String() ||
+			sf.Name != f.Name && !strings.HasSuffix(sf.Name, "."+f.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// atomicPointerOffset returns the offset of the pointer in the sync/atomic.Pointer[T] typ.
+func atomicPointerOffset(typ godwarf.Type) (int64, bool) {
+	st, ok := resolveTypedef(typ).(*godwarf.StructType)
+	if !ok || !atomicPointerRegex.MatchString(st.StructName) {
+		return 0, false
+	}
+	for _, field := range st.Field {
+		if field.Name == "v" {
+			return field.ByteOffset, true
+		}
+	}
+	return 0, false
+}
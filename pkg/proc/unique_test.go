@@ -0,0 +1,51 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+func TestSameShape(t *testing.T) {
+	str := &godwarf.StringType{StructType: godwarf.StructType{CommonType: godwarf.CommonType{ByteSize: 16, Name: "string", ReflectKind: reflect.String}}}
+	structOf := func(name string, fields ...string) *godwarf.StructType {
+		st := &godwarf.StructType{CommonType: godwarf.CommonType{Name: name, ReflectKind: reflect.Struct}, StructName: name, Kind: "struct"}
+		for _, f := range fields {
+			st.Field = append(st.Field, &godwarf.StructField{Name: f, Type: str, ByteOffset: st.ByteSize})
+			st.ByteSize += str.Size()
+		}
+		return st
+	}
+	key := structOf("main.Key", "a", "b")
+	for _, tt := range []struct {
+		shape godwarf.Type
+		want  bool
+	}{
+		{structOf("go.shape.struct { main.a string; main.b string }", "main.a", "main.b"), true},
+		{structOf("go.shape.struct { main.a string; main.c string }", "main.a", "main.c"), false},
+		{structOf("go.shape.struct { main.a string }", "main.a"), false},
+		{str, false},
+	} {
+		if got := sameShape(key, tt.shape); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.shape.String(), got, tt.want)
+		}
+	}
+	if !sameShape(str, str) {
+		t.Errorf("string: got false, want true")
+	}
+}
@@ -34,6 +34,9 @@ var (
 	stackLoField reflect2.StructField
 	stackHiField reflect2.StructField
 	offsetField  reflect2.StructField
+
+	mdTypesField  reflect2.StructField
+	mdEtypesField reflect2.StructField
 )
 
 func init() {
@@ -49,6 +52,10 @@ func init() {
 
 	ft := reflect2.TypeOf(proc.Function{}).(reflect2.StructType)
 	offsetField = ft.FieldByName("offset")
+
+	mt := reflect2.TypeOf(proc.ModuleData{}).(reflect2.StructType)
+	mdTypesField = mt.FieldByName("types")
+	mdEtypesField = mt.FieldByName("etypes")
 }
 
 func getVariableMem(v *proc.Variable) proc.MemoryReadWriter {
@@ -66,6 +73,16 @@ func getFunctionOffset(f *proc.Function) (offset dwarf.Offset) {
 	return *offsetField.Get(f).(*dwarf.Offset)
 }
 
+func getModuleDataTypes(md *proc.ModuleData) (types, etypes uint64) {
+	return *mdTypesField.Get(md).(*uint64), *mdEtypesField.Get(md).(*uint64)
+}
+
+func newModuleData(types, etypes uint64) (md proc.ModuleData) {
+	mdTypesField.Set(&md, &types)
+	mdEtypesField.Set(&md, &etypes)
+	return
+}
+
 //go:linkname image github.com/go-delve/delve/pkg/proc.(*EvalScope).image
 func image(scope *proc.EvalScope) *proc.Image
 
@@ -92,7 +109,10 @@ func uint64s2str(us []uint64) string {
 	return unsafe.String((*byte)(p), len(us)*8)
 }
 
-func str2uint64s(s string) []uint64 {
-	p := unsafe.Pointer(unsafe.StringData(s))
-	return unsafe.Slice((*uint64)(p), len(s)/8)
+// uint64sBytes returns the memory of the words as bytes.
+func uint64sBytes(words []uint64) []byte {
+	if len(words) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*8)
 }
@@ -0,0 +1,104 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "github.com/go-delve/delve/pkg/dwarf/godwarf"
+
+// Validation cross-checks the totals of the scanning against the heap of the target, see WithValidation.
+type Validation struct {
+	// HeapInuse is the bytes in the in-use spans, read from the runtime like runtime.MemStats.HeapInuse,
+	// or summed from the spans if the runtime stat is not found.
+	HeapInuse int64
+	// HeapAlloc and HeapObjects are the bytes and the count of the allocated heap objects, counted by
+	// the allocation bits of the spans like runtime.MemStats.HeapAlloc and HeapObjects.
+	HeapAlloc, HeapObjects int64
+	// Space and Objects are the bytes and the count of the heap objects reached by the scanning.
+	Space, Objects int64
+}
+
+// SpaceRatio returns the fraction of the allocated heap bytes attributed by the scanning.
+func (v *Validation) SpaceRatio() float64 {
+	return ratio(v.Space, v.HeapAlloc)
+}
+
+// ObjectsRatio returns the fraction of the allocated heap objects attributed by the scanning.
+func (v *Validation) ObjectsRatio() float64 {
+	return ratio(v.Objects, v.HeapObjects)
+}
+
+func isUint(typ godwarf.Type) bool {
+	_, ok := typ.(*godwarf.UintType)
+	return ok
+}
+
+func ratio(a, b int64) float64 {
+	if b <= 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// WithValidation cross-checks the totals of the scanning against the heap of the target to v, which
+// tells how much of the heap is attributed. The allocated objects not reached are either garbage not
+// collected yet, or referenced in a way the scanning misses, e.g. a bug of the attribution.
+func WithValidation(v *Validation) Option {
+	return func(o *options) {
+		o.validation = v
+	}
+}
+
+// allocated returns the count of the allocated objects in the span.
+func (sp *spanInfo) allocated() int64 {
+	if sp.nelems <= 0 {
+		// large object span, or the allocation state is unknown
+		return 1
+	}
+	if sp.allocBits == nil {
+		return sp.nelems
+	}
+	// objects before freeIndex or with alloc bit set are allocated
+	n := sp.freeIndex
+	for idx := sp.freeIndex; idx < sp.nelems; idx++ {
+		if sp.allocBits[idx/8]&(1<<(idx%8)) != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// validate fills v with the heap stats of the target and the totals of the scanning.
+func (s *ObjRefScope) validate(v *Validation) {
+	v.Space, v.Objects = s.reached.space, s.reached.objects
+	var inuse int64
+	for _, sp := range s.spans {
+		inuse += sp.spanSize
+		n := sp.allocated()
+		v.HeapObjects += n
+		v.HeapAlloc += n * sp.elemSize
+	}
+	// gcController.heapInUse since go1.20, memstats.heap_inuse before
+	for _, expr := range []string{"runtime.gcController.heapInUse", "runtime.memstats.heap_inuse"} {
+		tmp, err := s.scope.EvalExpression(expr, loadSingleValue)
+		if err != nil {
+			continue
+		}
+		if r := toRegion(tmp, s.bi); isUint(r.typ) {
+			v.HeapInuse = int64(r.Uint())
+			return
+		}
+	}
+	s.logger.Warnf("the heap in use is not found in the runtime, sum the in-use spans instead")
+	v.HeapInuse = inuse
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "testing"
+
+func TestSpanAllocated(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		sp   *spanInfo
+		want int64
+	}{
+		{"large", &spanInfo{elemSize: 8192, spanSize: 8192}, 1},
+		{"full", &spanInfo{elemSize: 16, spanSize: 8192, nelems: 512, freeIndex: 512}, 512},
+		// 0-3 before the free index, 5 and 9 by the alloc bits
+		{"partial", &spanInfo{elemSize: 16, spanSize: 8192, nelems: 12, freeIndex: 4, allocBits: []uint8{0b00100001, 0b10}}, 6},
+	} {
+		if got := tt.sp.allocated(); got != tt.want {
+			t.Errorf("%s: got %d allocated objects, want %d", tt.name, got, tt.want)
+		}
+	}
+}
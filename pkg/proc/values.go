@@ -0,0 +1,82 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strconv"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// WithShowValues shows the values of the scalar map keys, i.e. the strings truncated to maxLen bytes,
+// the integers and the booleans, in the names of the map entries like `$mapval["user:123"]`, so the
+// entries retaining much can be identified. It reads the payloads of the target, which may be
+// sensitive, and every distinct key adds a path to the profile. 0 disables it.
+func WithShowValues(maxLen int) Option {
+	return func(o *options) {
+		o.showValues = maxLen
+	}
+}
+
+// keyPreview returns the value of the scalar map key in brackets, like `["user:123"]` or `[42]`,
+// empty if the key is not scalar or not readable. The strings longer than maxLen are truncated.
+func keyPreview(key *ReferenceVariable, maxLen int) string {
+	var v string
+	switch typ := key.RealType.(type) {
+	case *godwarf.StringType:
+		ptr, err := readUintRaw(key.mem, uint64(key.Addr), 8)
+		if err != nil {
+			return ""
+		}
+		n, err := readUintRaw(key.mem, uint64(key.Addr.Add(8)), 8)
+		if err != nil {
+			return ""
+		}
+		b := make([]byte, min(n, uint64(maxLen)))
+		if len(b) > 0 {
+			if _, err := proc.DereferenceMemory(key.mem).ReadMemory(b, ptr); err != nil {
+				return ""
+			}
+		}
+		v = strconv.Quote(string(b))
+		if n > uint64(len(b)) {
+			v += "..."
+		}
+	case *godwarf.IntType:
+		i, err := readUintRaw(key.mem, uint64(key.Addr), typ.Size())
+		if err != nil {
+			return ""
+		}
+		// sign extended
+		shift := 64 - 8*typ.Size()
+		v = strconv.FormatInt(int64(i<<shift)>>shift, 10)
+	case *godwarf.UintType:
+		i, err := readUintRaw(key.mem, uint64(key.Addr), typ.Size())
+		if err != nil {
+			return ""
+		}
+		v = strconv.FormatUint(i, 10)
+	case *godwarf.BoolType:
+		i, err := readUintRaw(key.mem, uint64(key.Addr), typ.Size())
+		if err != nil {
+			return ""
+		}
+		v = strconv.FormatBool(i != 0)
+	default:
+		return ""
+	}
+	return "[" + v + "]"
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+func TestKeyPreview(t *testing.T) {
+	const base = 0x1000
+	mem := &fakeMemory{base: base, data: make([]byte, 64)}
+	// a string header pointing to its bytes at base+32
+	binary.LittleEndian.PutUint64(mem.data[0:], base+32)
+	binary.LittleEndian.PutUint64(mem.data[8:], 8)
+	copy(mem.data[32:], "user:123")
+	mem.data[16] = 0xfd
+	mem.data[24] = 1
+
+	str := &godwarf.StringType{StructType: godwarf.StructType{CommonType: godwarf.CommonType{ByteSize: 16, Name: "string", ReflectKind: reflect.String}}}
+	int8Type := &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "int8", ReflectKind: reflect.Int8}}}
+	uint8Type := &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "uint8", ReflectKind: reflect.Uint8}}}
+	boolType := &godwarf.BoolType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "bool", ReflectKind: reflect.Bool}}}
+	ptrType := &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*int", ReflectKind: reflect.Ptr}, Type: int8Type}
+	for _, tc := range []struct {
+		addr   Address
+		typ    godwarf.Type
+		maxLen int
+		want   string
+	}{
+		{base, str, 32, `["user:123"]`},
+		{base, str, 4, `["user"...]`},
+		{base + 16, int8Type, 32, "[-3]"},
+		{base + 16, uint8Type, 32, "[253]"},
+		{base + 24, boolType, 32, "[true]"},
+		{base, ptrType, 32, ""},
+	} {
+		if got := keyPreview(newReferenceVariable(tc.addr, "", tc.typ, mem, nil), tc.maxLen); got != tc.want {
+			t.Errorf("keyPreview(%s, %d) = %s, want %s", tc.typ, tc.maxLen, got, tc.want)
+		}
+	}
+}
@@ -23,7 +23,6 @@ import (
 
 	"github.com/go-delve/delve/pkg/dwarf/godwarf"
 	"github.com/go-delve/delve/pkg/goversion"
-	"github.com/go-delve/delve/pkg/logflags"
 	"github.com/go-delve/delve/pkg/proc"
 )
 
@@ -64,6 +63,16 @@ type ReferenceVariable struct {
 	size int64
 	// node count
 	count int64
+	// unused bytes beyond the length of the slice, which are newly attributed to the node
+	waste int64
+	// the declaration line of the local variable, 0 if unknown
+	declLine int64
+	// self size and count, the objects referenced by the node directly, or the object itself
+	// if it's found in the heap, excluding the objects folded into the node, see flatten
+	selfSize, selfCount int64
+	// the number of the objects the heap object stands for in the sampling mode, see sampleWeight,
+	// 0 if it's not a heap object
+	weight int64
 }
 
 func newReferenceVariable(addr Address, name string, typ godwarf.Type, mem proc.MemoryReadWriter, hb *gcMaskBitIterator) *ReferenceVariable {
@@ -73,9 +82,27 @@ func newReferenceVariable(addr Address, name string, typ godwarf.Type, mem proc.
 func newReferenceVariableWithSizeAndCount(addr Address, name string, typ godwarf.Type, mem proc.MemoryReadWriter, hb *gcMaskBitIterator, size, count int64) *ReferenceVariable {
 	rv := newReferenceVariable(addr, name, typ, mem, hb)
 	rv.size, rv.count = size, count
+	rv.selfSize, rv.selfCount = size, count
 	return rv
 }
 
+// flatten folds the objects found through y, an object referenced by v directly, into v.
+func (v *ReferenceVariable) flatten(y *ReferenceVariable) {
+	v.fold(y.size, y.count, y.selfSize, y.selfCount)
+}
+
+// fold adds size and count of the objects found through v, selfSize and selfCount of which are
+// referenced by v directly. Only a node of the profile counts the self values, while a heap object
+// without its own node keeps itself as the self values, like the objects folded into it.
+func (v *ReferenceVariable) fold(size, count, selfSize, selfCount int64) {
+	v.size += size
+	v.count += count
+	if v.Name != "" {
+		v.selfSize += selfSize
+		v.selfCount += selfCount
+	}
+}
+
 func (v *ReferenceVariable) readPointer(addr Address) (uint64, error) {
 	if err := v.hb.resetGCMask(addr); err != nil {
 		return 0, err
@@ -276,7 +303,7 @@ func (s *ObjRefScope) nextBucket(it *mapIterator) bool {
 		case "overflow":
 			ptr, err := it.b.readPointer(field.Addr)
 			if err != nil {
-				// logflags.DebuggerLogger().Errorf("could not load overflow variable: %v", err)
+				// s.logger.Errorf("could not load overflow variable: %v", err)
 				return false
 			}
 			if it.overflow = s.findObject(Address(ptr), field.RealType.(*godwarf.PtrType).Type, proc.DereferenceMemory(it.b.mem)); it.overflow != nil {
@@ -289,7 +316,7 @@ func (s *ObjRefScope) nextBucket(it *mapIterator) bool {
 
 	// sanity checks
 	if it.tophashes == nil || it.keys == nil || it.values == nil {
-		logflags.DebuggerLogger().Errorf("malformed map type")
+		s.logger.Errorf("malformed map type")
 		return false
 	}
 
@@ -297,25 +324,25 @@ func (s *ObjRefScope) nextBucket(it *mapIterator) bool {
 	keysType, ok2 := it.keys.RealType.(*godwarf.ArrayType)
 	valuesType, ok3 := it.values.RealType.(*godwarf.ArrayType)
 	if !ok1 || !ok2 || !ok3 {
-		logflags.DebuggerLogger().Errorf("%v", errMapBucketContentsNotArray)
+		s.logger.Errorf("%v", errMapBucketContentsNotArray)
 		return false
 	}
 
 	if tophashesType.Count != keysType.Count {
-		logflags.DebuggerLogger().Errorf("%v", errMapBucketContentsInconsistentLen)
+		s.logger.Errorf("%v", errMapBucketContentsInconsistentLen)
 		return false
 	}
 
 	if valuesType.Type.Size() > 0 && tophashesType.Count != valuesType.Count {
 		// if the type of the value is zero-sized (i.e. struct{}) then the values
 		// array's length is zero.
-		logflags.DebuggerLogger().Errorf("%v", errMapBucketContentsInconsistentLen)
+		s.logger.Errorf("%v", errMapBucketContentsInconsistentLen)
 		return false
 	}
 
 	if it.overflow != nil {
 		if _, ok := it.overflow.RealType.(*godwarf.StructType); !ok {
-			logflags.DebuggerLogger().Errorf("%v", errMapBucketsNotStruct)
+			s.logger.Errorf("%v", errMapBucketsNotStruct)
 			return false
 		}
 	}
@@ -346,7 +373,7 @@ func (s *ObjRefScope) next(it *mapIterator) bool {
 
 		h, err := readUintRaw(tophash.mem, uint64(tophash.Addr), 1)
 		if err != nil {
-			logflags.DebuggerLogger().Errorf("unreadable tophash: %v", err)
+			s.logger.Errorf("unreadable tophash: %v", err)
 			return false
 		}
 		it.idx++
@@ -448,7 +475,7 @@ func (s *ObjRefScope) readInterface(v *ReferenceVariable) (_type *proc.Variable,
 					}
 				}
 				if _type == nil {
-					logflags.DebuggerLogger().Errorf("invalid interface type")
+					s.logger.Errorf("invalid interface type")
 				}
 			}
 		case "_type": // for runtime.eface
@@ -470,6 +497,11 @@ type finalizePtrType struct {
 	godwarf.Type
 }
 
+// Size returns the size of the pointer, since the type of the finalized object is unknown.
+func (*finalizePtrType) Size() int64 {
+	return 8
+}
+
 func readIntRaw(mem proc.MemoryReadWriter, addr uint64, size int64) (int64, error) {
 	var n int64
 
@@ -0,0 +1,105 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// fakeClassicMap lays out a map[int64]*int64 of 1<<b full buckets in a fake heap span, and returns the scope
+// with the span and the hmap variable, which is out of the heap.
+func fakeClassicMap(b uint8) (*ObjRefScope, *ReferenceVariable) {
+	const hmapAddr, bucketsAddr = 0x800000, 0x1000000
+	intType := &godwarf.IntType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "int64", ReflectKind: reflect.Int64}}}
+	uint8Type := &godwarf.UintType{BasicType: godwarf.BasicType{CommonType: godwarf.CommonType{ByteSize: 1, Name: "uint8", ReflectKind: reflect.Uint8}}}
+	ptrType := &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*int64", ReflectKind: reflect.Ptr}, Type: intType}
+	bucketType := &godwarf.StructType{
+		CommonType: godwarf.CommonType{ByteSize: 144, Name: "bucket<int64,*int64>", ReflectKind: reflect.Struct},
+		StructName: "bucket<int64,*int64>",
+		Kind:       "struct",
+		Field: []*godwarf.StructField{
+			{Name: "tophash", Type: fakeArrayType(8, uint8Type), ByteOffset: 0},
+			{Name: "keys", Type: fakeArrayType(8, intType), ByteOffset: 8},
+			{Name: "values", Type: fakeArrayType(8, ptrType), ByteOffset: 72},
+		},
+	}
+	bucketPtrType := &godwarf.PtrType{CommonType: godwarf.CommonType{ByteSize: 8, Name: "*bucket<int64,*int64>", ReflectKind: reflect.Ptr}, Type: bucketType}
+	bucketType.Field = append(bucketType.Field, &godwarf.StructField{Name: "overflow", Type: bucketPtrType, ByteOffset: 136})
+	hmapType := &godwarf.StructType{
+		CommonType: godwarf.CommonType{ByteSize: 48, Name: "hash<int64,*int64>", ReflectKind: reflect.Struct},
+		StructName: "hash<int64,*int64>",
+		Kind:       "struct",
+		Field: []*godwarf.StructField{
+			{Name: "count", Type: intType, ByteOffset: 0},
+			{Name: "B", Type: uint8Type, ByteOffset: 9},
+			{Name: "buckets", Type: bucketPtrType, ByteOffset: 16},
+			{Name: "oldbuckets", Type: bucketPtrType, ByteOffset: 24},
+		},
+	}
+
+	nb := int64(1) << b
+	bucketsSize := CeilDivide(nb*bucketType.Size(), 8192) * 8192
+	mem := &fakeMemory{base: hmapAddr, data: make([]byte, bucketsAddr-hmapAddr+bucketsSize)}
+	binary.LittleEndian.PutUint64(mem.data[0:], uint64(nb*8))
+	mem.data[9] = b
+	binary.LittleEndian.PutUint64(mem.data[16:], bucketsAddr)
+	for i := int64(0); i < nb; i++ {
+		bucket := mem.data[bucketsAddr-hmapAddr+i*bucketType.Size():]
+		for j := int64(0); j < 8; j++ {
+			bucket[j] = hashMinTopHashGo112
+			binary.LittleEndian.PutUint64(bucket[8+j*8:], uint64(i*8+j))
+		}
+	}
+
+	s := newTestObjRefScope()
+	s.mem = mem
+	s.bi = &proc.BinaryInfo{Images: []*proc.Image{{}}}
+	s.allocSpan(bucketsAddr, &spanInfo{
+		base: bucketsAddr, elemSize: nb * bucketType.Size(), spanSize: bucketsSize,
+		visitMask: make([]uint64, bucketsSize/8/64), ptrMask: make([]uint64, bucketsSize/8/64),
+	})
+	return s, newReferenceVariable(hmapAddr, "m", hmapType, mem, nil)
+}
+
+func BenchmarkMapIterator(b *testing.B) {
+	// the swiss maps are not supported yet
+	b.Run("classic", func(b *testing.B) {
+		s, hmap := fakeClassicMap(10)
+		sp := s.spanOf(0x1000000)
+		var entries int
+		for i := 0; i < b.N; i++ {
+			clear(sp.visitMask)
+			it, err := s.toMapIterator(hmap)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for s.next(it) {
+				if it.key() == nil || it.value() == nil {
+					b.Fatal("no key or value of the entry")
+				}
+				entries++
+			}
+		}
+		if want := b.N * 8 << 10; entries != want {
+			b.Fatalf("got %d entries, want %d", entries, want)
+		}
+		b.ReportMetric(float64(entries)/float64(b.N), "entries/op")
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64)
+
+package proc
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// max iovecs of a process_vm_readv call, i.e. IOV_MAX
+const maxIovecs = 1024
+
+// remoteIovec is an iovec of the target process, whose base must not be dereferenced locally.
+type remoteIovec struct {
+	base uintptr
+	len  uint
+}
+
+// readProcessRegions reads the regions from the process by process_vm_readv, many regions per syscall.
+// It returns the number of the regions read completely, the reading stops at the first region failed.
+func readProcessRegions(pid int, regions []snapshotRegion) (done int) {
+	if pid <= 0 {
+		return 0
+	}
+	local := make([]syscall.Iovec, 0, maxIovecs)
+	remote := make([]remoteIovec, 0, maxIovecs)
+	for done < len(regions) {
+		batch := regions[done:min(done+maxIovecs, len(regions))]
+		local, remote = local[:0], remote[:0]
+		for i := range batch {
+			iov := syscall.Iovec{Base: &batch[i].data[0]}
+			iov.SetLen(len(batch[i].data))
+			local = append(local, iov)
+			remote = append(remote, remoteIovec{base: uintptr(batch[i].addr), len: uint(len(batch[i].data))})
+		}
+		n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+			uintptr(unsafe.Pointer(&local[0])), uintptr(len(local)),
+			uintptr(unsafe.Pointer(&remote[0])), uintptr(len(remote)), 0)
+		runtime.KeepAlive(batch)
+		if errno != 0 {
+			return done
+		}
+		// the regions are read in order, until the first one failed partially
+		for _, r := range batch {
+			if n < uintptr(len(r.data)) {
+				return done
+			}
+			n -= uintptr(len(r.data))
+			done++
+		}
+	}
+	return done
+}
+
+// readProcessMemory reads the memory of the process at addr by a process_vm_readv call.
+func readProcessMemory(pid int, data []byte, addr uint64) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	local := syscall.Iovec{Base: &data[0]}
+	local.SetLen(len(data))
+	remote := remoteIovec{base: uintptr(addr), len: uint(len(data))}
+	n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+		uintptr(unsafe.Pointer(&local)), 1, uintptr(unsafe.Pointer(&remote)), 1, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	if int(n) < len(data) {
+		return int(n), io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+// tracedBySelf returns whether the process is traced by goref, i.e. it's a live local process attached.
+func tracedBySelf(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "TracerPid:"); ok {
+			return strings.TrimSpace(v) == strconv.Itoa(os.Getpid())
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux || !(amd64 || arm64)
+
+package proc
+
+import "errors"
+
+// readProcessRegions reads the regions from the process in batches, which is only supported on linux/amd64 and linux/arm64.
+func readProcessRegions(pid int, regions []snapshotRegion) (done int) {
+	return 0
+}
+
+// readProcessMemory is only supported on linux/amd64 and linux/arm64.
+func readProcessMemory(pid int, data []byte, addr uint64) (int, error) {
+	return 0, errors.New("reading the process directly is not supported")
+}
+
+// tracedBySelf returns false since the processes are read through the debugger on the platform.
+func tracedBySelf(pid int) bool {
+	return false
+}
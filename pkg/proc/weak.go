@@ -0,0 +1,54 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/proc"
+)
+
+// weakPointerRegex matches weak.Pointer since go1.24, internal/weak.Pointer used by unique in go1.23,
+// and their shapes in the generic code, like "go.shape.struct { internal/weak.u unsafe.Pointer }".
+var weakPointerRegex = regexp.MustCompile(`^(internal/)?weak\.Pointer\[.*\]$|^go\.shape\.struct \{ (.*; )?(internal/)?weak\.u unsafe\.Pointer \}$`)
+
+// findWeakRef scans the weak pointer x. The field u points to the weak handle, an 8-byte heap object
+// holding the address of the object as an uintptr, which the GC clears once the object is unreachable.
+// The handle is referenced by x, but the object is not, it's only recorded as SampleWeak of the path.
+func (s *ObjRefScope) findWeakRef(x *ReferenceVariable, typ *godwarf.StructType, idx *pprofIndex) {
+	for _, field := range typ.Field {
+		if field.Name != "u" && !strings.HasSuffix(field.Name, "weak.u") {
+			continue
+		}
+		handle, err := x.readPointer(x.Addr.Add(field.ByteOffset))
+		if err != nil || handle == 0 {
+			return
+		}
+		mem := proc.DereferenceMemory(x.mem)
+		size, count, selfSize, selfCount := s.markObject(Address(handle), mem, idx)
+		x.fold(size, count, selfSize, selfCount)
+		target, err := readUintRaw(mem, handle, 8)
+		if err != nil || target == 0 {
+			// the object is collected
+			return
+		}
+		if sp, _ := s.findSpanAndBase(Address(target)); sp != nil {
+			s.recordValues(idx, &sampleValues{SampleWeak: sp.elemSize})
+		}
+		return
+	}
+}
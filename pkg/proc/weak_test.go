@@ -0,0 +1,58 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"testing"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+)
+
+func TestWeakPointerRegex(t *testing.T) {
+	for name, want := range map[string]bool{
+		"weak.Pointer[main.T]":                                               true,
+		"internal/weak.Pointer[string]":                                      true,
+		"go.shape.struct { internal/weak.u unsafe.Pointer }":                 true,
+		"go.shape.struct { weak._ [0]*go.shape.int; weak.u unsafe.Pointer }": true,
+		"sync/atomic.Pointer[main.T]":                                        false,
+		"go.shape.struct { internal/concurrent.isEntry bool }":               false,
+		"github.com/x/weak.Pointer[main.T]":                                  false,
+	} {
+		if got := weakPointerRegex.MatchString(name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestClassifyStruct(t *testing.T) {
+	s := newTestObjRefScope()
+	weak := &godwarf.StructType{StructName: "weak.Pointer[main.T]"}
+	plain := &godwarf.StructType{StructName: "main.T"}
+	table := &godwarf.StructType{StructName: "internal/concurrent.HashTrieMap[string,internal/weak.Pointer[string]]"}
+	for i := 0; i < 2; i++ {
+		if c := s.classifyStruct(weak); !c.weak {
+			t.Errorf("%s is not classified as weak", weak.StructName)
+		}
+		if c := s.classifyStruct(plain); c.weak || c.typ != plain {
+			t.Errorf("%s is classified as %+v", plain.StructName, c)
+		}
+		if c := s.classifyStruct(table); c.hashTrieMap != "string,internal/weak.Pointer[string]" {
+			t.Errorf("got type arguments %q of %s", c.hashTrieMap, table.StructName)
+		}
+	}
+	if len(s.structClasses) != 3 {
+		t.Errorf("got %d classes cached, want 3", len(s.structClasses))
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// refTreeNode is a node of the reference tree served by the web UI, the name of the node is split into
+// the field or the variable, and the type of the referenced value, like "buf" and "[]uint8" of "buf. ([]uint8)".
+type refTreeNode struct {
+	Field string `json:"f"`
+	Type  string `json:"t,omitempty"`
+	// the cumulative values, and the values recorded at the node itself, of the sample types
+	Cum      []int64        `json:"c"`
+	Flat     []int64        `json:"v"`
+	Children []*refTreeNode `json:"ch,omitempty"`
+}
+
+// newRefTree converts the reference tree of a profile, the children are sorted by their first values.
+func newRefTree(n *profile.Node) *refTreeNode {
+	rn := &refTreeNode{Cum: n.Cum, Flat: n.Flat}
+	rn.Field, rn.Type = profile.SplitNodeName(n.Name)
+	for _, child := range n.Children {
+		rn.Children = append(rn.Children, newRefTree(child))
+	}
+	// the children of the profile tree are sorted by their names, which stay in order of the same values
+	sort.SliceStable(rn.Children, func(i, j int) bool {
+		ci, cj := rn.Children[i], rn.Children[j]
+		return len(ci.Cum) > 0 && ci.Cum[0] > cj.Cum[0]
+	})
+	return rn
+}
+
+// NewWebHandler returns the handler serving the web UI of the profile, which shows the reference tree,
+// a flame graph, and the top nodes by their paths, types or fields, with the names of the nodes split into
+// the fields and the types. The page is served at "/", and the data of the profile at "/profile.json".
+func NewWebHandler(p *profile.RefProfile) (http.Handler, error) {
+	type sampleType struct {
+		Type string `json:"type"`
+		Unit string `json:"unit"`
+	}
+	doc := struct {
+		SampleTypes []sampleType `json:"sampleTypes"`
+		Comments    []string     `json:"comments"`
+		Root        *refTreeNode `json:"root"`
+	}{Comments: p.Comments, Root: newRefTree(p.Tree())}
+	for _, vt := range p.SampleTypes {
+		doc.SampleTypes = append(doc.SampleTypes, sampleType{vt.Type, vt.Unit})
+	}
+	data, err := json.Marshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(webUIHTML))
+	})
+	mux.HandleFunc("/profile.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+	return mux, nil
+}
+
+const webUIHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goref</title>
+<style>
+body { font: 12px sans-serif; margin: 8px; }
+#bar { margin-bottom: 8px; }
+#bar button.active { font-weight: bold; }
+#comments { margin: 0 0 8px; color: #555; }
+.type { color: #777; }
+table { border-collapse: collapse; }
+th, td { padding: 1px 8px; text-align: right; white-space: nowrap; }
+th { cursor: pointer; }
+td.name { text-align: left; font-family: monospace; }
+#tree { font-family: monospace; }
+#tree .row { white-space: nowrap; cursor: pointer; }
+#tree .row .vals { display: inline-block; width: 230px; text-align: right; margin-right: 8px; }
+#tree .row .vals span { display: inline-block; width: 70px; }
+#flame { position: relative; }
+.node { position: absolute; height: 17px; overflow: hidden; white-space: nowrap; box-sizing: border-box;
+  border: 1px solid #fff; padding: 1px 3px; cursor: pointer; }
+.node:hover { border-color: #000; }
+#info { margin-top: 8px; height: 16px; font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="bar">
+<button data-view="top">Top</button>
+<button data-view="tree">Tree</button>
+<button data-view="flame">Flame graph</button>
+<select id="type"></select>
+<select id="group">
+<option value="path">by reference path</option>
+<option value="type">by type</option>
+<option value="field">by field or variable</option>
+</select>
+<input id="search" placeholder="Search">
+<button id="reset">Reset zoom</button>
+</div>
+<pre id="comments"></pre>
+<div id="views">
+<table id="top"><thead><tr><th data-sort="flat">flat</th><th>flat%</th><th data-sort="cum">cum</th><th>cum%</th><th></th></tr></thead><tbody></tbody></table>
+<div id="tree"></div>
+<div><div id="info"></div><div id="flame"></div></div>
+</div>
+<script>
+const $ = id => document.getElementById(id);
+let data, view = "top", sortBy = "flat", focus, parents = new Map();
+const typeSel = $("type"), groupSel = $("group"), search = $("search");
+
+fetch("profile.json").then(r => r.json()).then(d => {
+  data = d;
+  focus = data.root;
+  (function link(n) { (n.ch || []).forEach(c => { parents.set(c, n); link(c); }); })(data.root);
+  data.sampleTypes.forEach((t, i) => typeSel.add(new Option(t.type, i)));
+  $("comments").textContent = (data.comments || []).join("\n");
+  render();
+});
+
+function format(v) {
+  if (data.sampleTypes[+typeSel.value].unit !== "bytes") return v.toString();
+  const units = ["B", "kB", "MB", "GB", "TB"];
+  let i = 0, x = Math.abs(v);
+  while (x >= 1024 && i < units.length - 1) { x /= 1024; i++; }
+  return (v < 0 ? "-" : "") + (i ? x.toFixed(2) : x) + units[i];
+}
+
+function percent(v) {
+  const total = data.root.c[+typeSel.value];
+  return total ? (100 * v / total).toFixed(2) + "%" : "";
+}
+
+// name appends the field and the type of the node to el, the type in gray.
+function name(el, n) {
+  el.append(n.f);
+  if (n.t) {
+    const t = document.createElement("span");
+    t.className = "type";
+    t.textContent = " (" + n.t + ")";
+    el.append(t);
+  }
+}
+
+function matches(n) {
+  const term = search.value;
+  return !term || n.f.includes(term) || (n.t || "").includes(term);
+}
+
+function render() {
+  if (!data) return;
+  document.querySelectorAll("#bar button[data-view]").forEach(b => b.classList.toggle("active", b.dataset.view === view));
+  ["top", "tree", "flame"].forEach(v => { ($(v).closest("#views > *") || $(v)).style.display = v === view ? "" : "none"; });
+  groupSel.style.display = view === "top" ? "" : "none";
+  $("reset").style.display = view === "flame" ? "" : "none";
+  ({ top: renderTop, tree: renderTree, flame: renderFlame })[view]();
+}
+
+// topEntries aggregates the nodes by their paths, types or fields. The cumulative value of a type or a field
+// only counts its outermost nodes in a path, so the nested nodes of the same type are not counted twice.
+function topEntries(t) {
+  const group = groupSel.value, entries = new Map(), active = new Map();
+  (function walk(n, path) {
+    if (n !== data.root) {
+      const key = group === "path" ? path : group === "type" ? (n.t || n.f) : n.f;
+      let e = entries.get(key);
+      if (!e) entries.set(key, e = { key: key, flat: 0, cum: 0 });
+      e.flat += n.v[t];
+      if (!active.get(key)) e.cum += n.c[t];
+      active.set(key, (active.get(key) || 0) + 1);
+    }
+    (n.ch || []).forEach(c => walk(c, path ? path + " -> " + c.f + (c.t ? " (" + c.t + ")" : "") : c.f + (c.t ? " (" + c.t + ")" : "")));
+    if (n !== data.root) {
+      const key = group === "path" ? path : group === "type" ? (n.t || n.f) : n.f;
+      active.set(key, active.get(key) - 1);
+    }
+  })(data.root, "");
+  return [...entries.values()];
+}
+
+function renderTop() {
+  const t = +typeSel.value, term = search.value;
+  const entries = topEntries(t).filter(e => (sortBy === "cum" ? e.cum : e.flat) !== 0 && (!term || e.key.includes(term)));
+  entries.sort((a, b) => (b[sortBy] - a[sortBy]) || (a.key < b.key ? -1 : 1));
+  const body = document.createElement("tbody");
+  entries.slice(0, 1000).forEach(e => {
+    const tr = body.insertRow();
+    [format(e.flat), percent(e.flat), format(e.cum), percent(e.cum)].forEach(v => { tr.insertCell().textContent = v; });
+    const td = tr.insertCell();
+    td.className = "name";
+    td.textContent = e.key;
+  });
+  $("top").tBodies[0].replaceWith(body);
+}
+
+function renderTree() {
+  const t = +typeSel.value, tree = $("tree");
+  tree.replaceChildren();
+  (data.root.ch || []).forEach(c => tree.append(treeRow(c, 0, t)));
+}
+
+// treeRow returns the row of the node, the children are rendered when expanded.
+function treeRow(n, depth, t) {
+  const el = document.createElement("div"), row = document.createElement("div");
+  row.className = "row";
+  const vals = document.createElement("span");
+  vals.className = "vals";
+  [format(n.c[t]), percent(n.c[t]), format(n.v[t])].forEach(v => {
+    const s = document.createElement("span");
+    s.textContent = v;
+    vals.append(s);
+  });
+  row.append(vals, "\u00a0".repeat(2 * depth) + (n.ch ? "+ " : "  "));
+  name(row, n);
+  if (!matches(n)) row.style.opacity = 0.5;
+  el.append(row);
+  let children;
+  row.onclick = () => {
+    if (!n.ch) return;
+    if (children) {
+      children.remove();
+      children = null;
+      return;
+    }
+    children = document.createElement("div");
+    n.ch.filter(c => c.c[t] !== 0).forEach(c => children.append(treeRow(c, depth + 1, t)));
+    el.append(children);
+  };
+  return el;
+}
+
+function color(name) {
+  let h = 0;
+  for (let i = 0; i < name.length; i++) h = (h * 31 + name.charCodeAt(i)) >>> 0;
+  return "hsl(" + (h % 60 + 10) + ",80%," + (60 + h % 15) + "%)";
+}
+
+function renderFlame() {
+  const t = +typeSel.value, graph = $("flame"), info = $("info");
+  const width = graph.clientWidth, total = focus.c[t], term = search.value;
+  const frag = document.createDocumentFragment();
+  let depth = 0, maxDepth = 0;
+  // the ancestors of the focus are drawn in full width
+  const path = [];
+  for (let n = parents.get(focus); n; n = parents.get(n)) path.unshift(n);
+  path.forEach(n => draw(n, 0, width, depth++));
+  (function walk(n, x, w, d) {
+    if (w < 1 || total <= 0) return;
+    draw(n, x, w, d);
+    let cx = x;
+    (n.ch || []).forEach(c => {
+      const cw = w * c.c[t] / n.c[t];
+      if (c.c[t] > 0) { walk(c, cx, cw, d + 1); cx += cw; }
+    });
+  })(focus, 0, width, depth);
+  graph.replaceChildren(frag);
+  graph.style.height = (maxDepth + 1) * 17 + "px";
+
+  function draw(n, x, w, d) {
+    maxDepth = Math.max(maxDepth, d);
+    const el = document.createElement("div");
+    el.className = "node";
+    el.style.left = x + "px";
+    el.style.width = w + "px";
+    el.style.top = d * 17 + "px";
+    el.style.background = term && matches(n) ? "#e0f" : color(n.t || n.f);
+    name(el, n);
+    const desc = n.f + (n.t ? " (" + n.t + ")" : "") + ": " + format(n.c[t]) + " (" + percent(n.c[t]) + ")";
+    el.title = desc;
+    el.onmouseover = () => { info.textContent = desc; };
+    el.onclick = () => { focus = n; renderFlame(); };
+    frag.appendChild(el);
+  }
+}
+
+document.querySelectorAll("#bar button[data-view]").forEach(b => { b.onclick = () => { view = b.dataset.view; render(); }; });
+document.querySelectorAll("#top th[data-sort]").forEach(th => { th.onclick = () => { sortBy = th.dataset.sort; render(); }; });
+typeSel.onchange = render;
+groupSel.onchange = render;
+search.oninput = render;
+$("reset").onclick = () => { focus = data.root; render(); };
+window.onresize = () => { if (view === "flame") render(); };
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,59 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebHandler(t *testing.T) {
+	p := writeTestProfile(t, map[string]sampleValues{
+		"main.root":                 {SampleObjects: 1, SampleSpace: 16},
+		"main.root;next. (*main.T)": {SampleObjects: 2, SampleSpace: 64},
+		"main.root;next. (*main.T);buf. ([]uint8)": {SampleObjects: 1, SampleSpace: 128},
+	})
+	h, err := NewWebHandler(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "profile.json") {
+		t.Fatalf("unexpected page: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profile.json", nil))
+	var data struct {
+		Root *refTreeNode `json:"root"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	root := data.Root
+	if len(root.Children) != 1 || root.Cum[1] != 208 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	next := root.Children[0].Children[0]
+	if next.Field != "next" || next.Type != "*main.T" || next.Flat[1] != 64 || next.Cum[1] != 192 {
+		t.Fatalf("unexpected node: %+v", next)
+	}
+	if buf := next.Children[0]; buf.Field != "buf" || buf.Type != "[]uint8" || buf.Flat[0] != 1 {
+		t.Fatalf("unexpected leaf: %+v", buf)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Referrers are the references to a heap object, see WithReferrers.
+type Referrers struct {
+	// Base and Size are the address and the size of the heap object containing the queried address.
+	Base Address
+	Size int64
+	// Reached is whether the object is reached by the scanning, i.e. it is not garbage.
+	Reached bool
+	// Paths are the reference paths to the object, root first. Every path ends with the variable,
+	// field or element pointing to the object, and leads to it through the path where the referencing
+	// object is first found by the scanning, so there is one path for every referencing variable.
+	Paths [][]string
+}
+
+// WithReferrers finds out the references to the heap object containing addr to r, like a reverse
+// reference query. The scanning is sequential, and records the object graph which takes extra memory.
+func WithReferrers(addr Address, r *Referrers) Option {
+	return func(o *options) {
+		o.referrersAddr, o.referrers = addr, r
+	}
+}
+
+// setReferrersTarget sets the heap object containing addr as the target of the reverse reference query.
+func (s *HeapScope) setReferrersTarget(addr Address, r *Referrers) error {
+	sp, base := s.findSpanAndBase(addr)
+	if sp == nil {
+		return fmt.Errorf("%#x is not in an allocated heap object", uint64(addr))
+	}
+	r.Base, r.Size = base, sp.elemSize
+	s.retained.target = base
+	return nil
+}
+
+// addReferrer records the current source as a referrer if base is the target of the query.
+func (g *retainedGraph) addReferrer(base Address) {
+	if g.target != 0 && base == g.target {
+		g.referrers = append(g.referrers, g.idx)
+	}
+}
+
+// referrers fills r with the reference paths to the target of the query, sorted and deduplicated.
+func (s *ObjRefScope) referrers(r *Referrers) {
+	g := s.retained
+	_, r.Reached = g.objects[g.target]
+	seen := make(map[string]bool)
+	for _, idx := range g.referrers {
+		if idx == nil {
+			continue
+		}
+		path := s.pb.pathNames(idx)
+		key := strings.Join(path, "\x00")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		r.Paths = append(r.Paths, path)
+	}
+	slices.SortFunc(r.Paths, func(a, b []string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return slices.Compare(a, b)
+	})
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the fields of the messages of perftools.profiles.Profile read by Parse, see pkg/proc/protobuf.go for the others
+const (
+	tagProfile_SampleType  = 1  // repeated ValueType
+	tagProfile_Sample      = 2  // repeated Sample
+	tagProfile_Location    = 4  // repeated Location
+	tagProfile_Function    = 5  // repeated Function
+	tagProfile_StringTable = 6  // repeated string
+	tagProfile_Comment     = 13 // repeated int64
+
+	tagValueType_Type = 1 // int64 (string table index)
+	tagValueType_Unit = 2 // int64 (string table index)
+
+	tagSample_Location = 1 // repeated uint64
+	tagSample_Value    = 2 // repeated int64
+	tagSample_Label    = 3 // repeated Label
+
+	tagLabel_Key = 1 // int64 (string table index)
+	tagLabel_Str = 2 // int64 (string table index)
+
+	tagLocation_ID   = 1 // uint64
+	tagLocation_Line = 4 // repeated Line
+
+	tagLine_FunctionID = 1 // uint64
+
+	tagFunction_ID        = 1 // uint64
+	tagFunction_Name      = 2 // int64 (string table index)
+	tagFunction_Filename  = 4 // int64 (string table index)
+	tagFunction_StartLine = 5 // int64
+)
+
+var errMalformedProfile = errors.New("malformed profile")
+
+type rawSample struct {
+	locations []uint64
+	values    []int64
+	// the string indexes of the label keys and values in pairs
+	labels []int64
+}
+
+type rawValueType struct {
+	typ, unit int64
+}
+
+type rawFunction struct {
+	name, file, line int64
+}
+
+// parseProfile decodes the messages of perftools.profiles.Profile used by goref.
+func parse(data []byte) (*RefProfile, error) {
+	var (
+		strs        []string
+		sampleTypes []rawValueType
+		samples     []rawSample
+		comments    []uint64
+		locFuncs    = make(map[uint64][]uint64) // location id -> function ids of lines
+		funcs       = make(map[uint64]rawFunction)
+	)
+	err := decodeMessage(data, func(tag int, d *protoDecoder) error {
+		switch tag {
+		case tagProfile_SampleType:
+			var vt rawValueType
+			return d.message(func(tag int, d *protoDecoder) error {
+				switch tag {
+				case tagValueType_Type:
+					vt.typ = int64(d.u64)
+				case tagValueType_Unit:
+					vt.unit = int64(d.u64)
+				}
+				return nil
+			}, func() { sampleTypes = append(sampleTypes, vt) })
+		case tagProfile_Sample:
+			var s rawSample
+			return d.message(func(tag int, d *protoDecoder) error {
+				switch tag {
+				case tagSample_Location:
+					return d.uint64s(&s.locations)
+				case tagSample_Value:
+					var vs []uint64
+					if err := d.uint64s(&vs); err != nil {
+						return err
+					}
+					for _, v := range vs {
+						s.values = append(s.values, int64(v))
+					}
+				case tagSample_Label:
+					var key, str int64
+					return d.message(func(tag int, d *protoDecoder) error {
+						switch tag {
+						case tagLabel_Key:
+							key = int64(d.u64)
+						case tagLabel_Str:
+							str = int64(d.u64)
+						}
+						return nil
+					}, func() { s.labels = append(s.labels, key, str) })
+				}
+				return nil
+			}, func() { samples = append(samples, s) })
+		case tagProfile_Location:
+			var id uint64
+			var funcs []uint64
+			return d.message(func(tag int, d *protoDecoder) error {
+				switch tag {
+				case tagLocation_ID:
+					id = d.u64
+				case tagLocation_Line:
+					return d.message(func(tag int, d *protoDecoder) error {
+						if tag == tagLine_FunctionID {
+							funcs = append(funcs, d.u64)
+						}
+						return nil
+					}, nil)
+				}
+				return nil
+			}, func() { locFuncs[id] = funcs })
+		case tagProfile_Function:
+			var id uint64
+			var fn rawFunction
+			return d.message(func(tag int, d *protoDecoder) error {
+				switch tag {
+				case tagFunction_ID:
+					id = d.u64
+				case tagFunction_Name:
+					fn.name = int64(d.u64)
+				case tagFunction_Filename:
+					fn.file = int64(d.u64)
+				case tagFunction_StartLine:
+					fn.line = int64(d.u64)
+				}
+				return nil
+			}, func() { funcs[id] = fn })
+		case tagProfile_Comment:
+			return d.uint64s(&comments)
+		case tagProfile_StringTable:
+			strs = append(strs, string(d.bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) (string, error) {
+		if i < 0 || i >= int64(len(strs)) {
+			return "", fmt.Errorf("%w: string index %d out of range", errMalformedProfile, i)
+		}
+		return strs[i], nil
+	}
+	p := &RefProfile{}
+	for _, vt := range sampleTypes {
+		typ, err := str(vt.typ)
+		if err != nil {
+			return nil, err
+		}
+		unit, err := str(vt.unit)
+		if err != nil {
+			return nil, err
+		}
+		p.SampleTypes = append(p.SampleTypes, ValueType{Type: typ, Unit: unit})
+	}
+	for _, c := range comments {
+		comment, err := str(int64(c))
+		if err != nil {
+			return nil, err
+		}
+		p.Comments = append(p.Comments, comment)
+	}
+	for _, rs := range samples {
+		if len(rs.values) != len(p.SampleTypes) {
+			return nil, fmt.Errorf("%w: %d values for %d sample types", errMalformedProfile, len(rs.values), len(p.SampleTypes))
+		}
+		s := &Sample{Values: rs.values}
+		for _, loc := range rs.locations {
+			for _, fn := range locFuncs[loc] {
+				name, err := str(funcs[fn].name)
+				if err != nil {
+					return nil, err
+				}
+				s.Path = append(s.Path, name)
+			}
+		}
+		for i := 0; i < len(rs.labels); i += 2 {
+			key, err := str(rs.labels[i])
+			if err != nil {
+				return nil, err
+			}
+			val, err := str(rs.labels[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if s.Labels == nil {
+				s.Labels = make(map[string]string)
+			}
+			s.Labels[key] = val
+		}
+		p.Samples = append(p.Samples, s)
+	}
+	for _, fn := range funcs {
+		if fn.file == 0 {
+			continue
+		}
+		name, err := str(fn.name)
+		if err != nil {
+			return nil, err
+		}
+		file, err := str(fn.file)
+		if err != nil {
+			return nil, err
+		}
+		if p.Sources == nil {
+			p.Sources = make(map[string]SourceLine)
+		}
+		p.Sources[name] = SourceLine{File: file, Line: fn.line}
+	}
+	return p, nil
+}
+
+// protoDecoder holds the current field of a message being decoded.
+type protoDecoder struct {
+	wire  int
+	u64   uint64 // for varint and fixed fields
+	bytes []byte // for length-delimited fields
+}
+
+// decodeMessage calls fn for every field of the message.
+func decodeMessage(data []byte, fn func(tag int, d *protoDecoder) error) error {
+	var d protoDecoder
+	for len(data) > 0 {
+		key, n := decodeVarint(data)
+		if n == 0 {
+			return errMalformedProfile
+		}
+		data = data[n:]
+		tag, wire := int(key>>3), int(key&7)
+		d = protoDecoder{wire: wire}
+		switch wire {
+		case 0: // varint
+			if d.u64, n = decodeVarint(data); n == 0 {
+				return errMalformedProfile
+			}
+			data = data[n:]
+		case 1: // fixed64
+			if len(data) < 8 {
+				return errMalformedProfile
+			}
+			for i := 7; i >= 0; i-- {
+				d.u64 = d.u64<<8 | uint64(data[i])
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			l, n := decodeVarint(data)
+			if n == 0 || uint64(len(data)-n) < l {
+				return errMalformedProfile
+			}
+			d.bytes = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case 5: // fixed32
+			if len(data) < 4 {
+				return errMalformedProfile
+			}
+			for i := 3; i >= 0; i-- {
+				d.u64 = d.u64<<8 | uint64(data[i])
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("%w: unknown wire type %d", errMalformedProfile, wire)
+		}
+		if err := fn(tag, &d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// message decodes the field as an embedded message, and calls done after all its fields.
+func (d *protoDecoder) message(fn func(tag int, d *protoDecoder) error, done func()) error {
+	if d.wire != 2 {
+		return errMalformedProfile
+	}
+	if err := decodeMessage(d.bytes, fn); err != nil {
+		return err
+	}
+	if done != nil {
+		done()
+	}
+	return nil
+}
+
+// uint64s decodes a repeated varint field, which may be packed.
+func (d *protoDecoder) uint64s(dst *[]uint64) error {
+	if d.wire == 0 {
+		*dst = append(*dst, d.u64)
+		return nil
+	}
+	data := d.bytes
+	for len(data) > 0 {
+		v, n := decodeVarint(data)
+		if n == 0 {
+			return errMalformedProfile
+		}
+		*dst = append(*dst, v)
+		data = data[n:]
+	}
+	return nil
+}
+
+func decodeVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
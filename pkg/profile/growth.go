@@ -0,0 +1,111 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GrowthEntry is a reference chain with its growth over a series of profiles.
+type GrowthEntry struct {
+	// Path is the names of the nodes from the root to the leaf.
+	Path []string
+	// Rate is the growth per minute, fitted by the least squares over the profiles.
+	Rate float64
+	// First and Last are the values in the first and the last profiles, 0 if the chain is not in them.
+	First, Last int64
+}
+
+// Growth returns the n reference chains growing the fastest in the profiles taken at the times, by their flat
+// values of the sample type, or their cumulative values if cum is set, see Top. Only the growing chains are
+// returned, and all of them if n <= 0. The growth rate is fitted over all the profiles rather than the first
+// and the last ones, so a chain fluctuating with the load doesn't stand out.
+func Growth(profiles []*RefProfile, times []time.Time, sampleType string, n int, cum bool) ([]GrowthEntry, error) {
+	if len(profiles) != len(times) {
+		return nil, fmt.Errorf("%d profiles taken at %d times", len(profiles), len(times))
+	}
+	if len(profiles) < 2 {
+		return nil, errors.New("at least 2 profiles are required")
+	}
+	// x is the minutes since the first profile
+	xs := make([]float64, len(times))
+	var mx float64
+	for i, t := range times {
+		xs[i] = t.Sub(times[0]).Minutes()
+		mx += xs[i]
+	}
+	mx /= float64(len(xs))
+	var sxx float64
+	for _, x := range xs {
+		sxx += (x - mx) * (x - mx)
+	}
+	if sxx == 0 {
+		return nil, errors.New("the profiles are taken at the same time")
+	}
+
+	type series struct {
+		path   []string
+		values []int64
+	}
+	chains := make(map[string]*series)
+	for i, p := range profiles {
+		entries, _, err := p.Top(sampleType, 0, cum)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			k := strings.Join(e.Path, "\x00")
+			s := chains[k]
+			if s == nil {
+				s = &series{path: e.Path, values: make([]int64, len(profiles))}
+				chains[k] = s
+			}
+			s.values[i] = e.Flat
+			if cum {
+				s.values[i] = e.Cum
+			}
+		}
+	}
+
+	var growth []GrowthEntry
+	for _, s := range chains {
+		var my float64
+		for _, v := range s.values {
+			my += float64(v)
+		}
+		my /= float64(len(s.values))
+		var sxy float64
+		for i, v := range s.values {
+			sxy += (xs[i] - mx) * (float64(v) - my)
+		}
+		if rate := sxy / sxx; rate > 0 {
+			growth = append(growth, GrowthEntry{Path: s.path, Rate: rate, First: s.values[0], Last: s.values[len(s.values)-1]})
+		}
+	}
+	sort.Slice(growth, func(i, j int) bool {
+		if growth[i].Rate != growth[j].Rate {
+			return growth[i].Rate > growth[j].Rate
+		}
+		return strings.Join(growth[i].Path, "\x00") < strings.Join(growth[j].Path, "\x00")
+	})
+	if n > 0 && len(growth) > n {
+		growth = growth[:n]
+	}
+	return growth, nil
+}
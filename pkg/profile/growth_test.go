@@ -0,0 +1,60 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGrowth(t *testing.T) {
+	types := []ValueType{{"inuse_objects", "count"}, {"inuse_space", "bytes"}}
+	snapshot := func(leak, steady, noise int64) *RefProfile {
+		return &RefProfile{SampleTypes: types, Samples: []*Sample{
+			{Path: []string{"items. ([]*main.T)", "main.cache"}, Values: []int64{leak / 64, leak}},
+			{Path: []string{"main.config"}, Values: []int64{1, steady}},
+			{Path: []string{"main.buf"}, Values: []int64{1, noise}},
+		}}
+	}
+	start := time.Unix(1700000000, 0)
+	profiles := []*RefProfile{snapshot(1024, 512, 4096), snapshot(2048, 512, 0), snapshot(3072, 512, 8192), snapshot(4096, 512, 0)}
+	times := []time.Time{start, start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)}
+
+	growth, err := Growth(profiles, times, "inuse_space", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GrowthEntry{{Path: []string{"main.cache", "items. ([]*main.T)"}, Rate: 1024, First: 1024, Last: 4096}}
+	if !reflect.DeepEqual(growth, want) {
+		t.Fatalf("unexpected growth: %+v", growth)
+	}
+
+	// the root grows with the chain it references
+	growth, err = Growth(profiles, times, "inuse_space", 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(growth) != 1 || !reflect.DeepEqual(growth[0].Path, []string{"main.cache"}) || growth[0].Rate != 1024 {
+		t.Fatalf("unexpected cumulative growth: %+v", growth)
+	}
+
+	if _, err = Growth(profiles[:1], times[:1], "inuse_space", 0, false); err == nil {
+		t.Fatal("expect an error for a single profile")
+	}
+	if _, err = Growth(profiles[:2], []time.Time{start, start}, "inuse_space", 0, false); err == nil {
+		t.Fatal("expect an error for the profiles taken at the same time")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profile reads the reference profiles written by goref in pprof format back into a typed tree,
+// so the tools consuming them, like grf diff, top and tui, don't decode the protobuf messages themselves.
+package profile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RefProfile is a reference profile read from a file in pprof format written by goref.
+type RefProfile struct {
+	SampleTypes []ValueType
+	Samples     []*Sample
+	// Comments are the free-form lines of the profile, like the memory breakdown of the target.
+	Comments []string
+	// Sources are the source locations of the nodes by their names, like the declarations of the local variables.
+	Sources map[string]SourceLine
+}
+
+// SourceLine is a line in a source file.
+type SourceLine struct {
+	File string
+	Line int64
+}
+
+// ValueType describes the semantics and measurement units of a sample value.
+type ValueType struct {
+	Type, Unit string
+}
+
+// Sample is the values recorded at a reference path.
+type Sample struct {
+	// Path is the names of the nodes from the leaf to the root, like a pprof stack.
+	Path []string
+	// Values are indexed like the SampleTypes of the profile.
+	Values []int64
+	// Labels are the pprof labels of the goroutines referencing the path, nil if not labeled.
+	Labels map[string]string
+}
+
+// Parse reads a profile in pprof format, which is optionally gzipped.
+func Parse(r io.Reader) (*RefProfile, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	} else {
+		r = br
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data)
+}
+
+// SampleIndex returns the index of the sample type in the values of the samples, like "inuse_space", or -1 if not carried.
+func (p *RefProfile) SampleIndex(typ string) int {
+	for i, vt := range p.SampleTypes {
+		if vt.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+// SplitNodeName splits the name of a node in the profile into the field or the variable, and the type
+// of the referenced value, like "next" and "*main.T" of "next. (*main.T)". The type is empty if unknown,
+// like the roots named by the variables and the goroutines.
+func SplitNodeName(name string) (field, typ string) {
+	if field, typ, ok := strings.Cut(name, ". ("); ok && strings.HasSuffix(typ, ")") {
+		return field, typ[:len(typ)-1]
+	}
+	return name, ""
+}
+
+// Node is a node of the reference tree of a profile.
+type Node struct {
+	// Name is the name of the node in the profile, like "next. (*main.T)", see SplitNodeName.
+	Name string
+	// Flat are the values recorded at the node itself, and Cum include the values referenced through it,
+	// indexed like the SampleTypes of the profile.
+	Flat, Cum []int64
+	// Children are sorted by their names.
+	Children []*Node
+}
+
+// Tree returns the reference tree of the profile, i.e. the samples merged by their paths. The returned
+// node is a virtual root named "root" above the roots of the profile, which sums up all the samples.
+func (p *RefProfile) Tree() *Node {
+	n := len(p.SampleTypes)
+	root := &Node{Name: "root", Flat: make([]int64, n), Cum: make([]int64, n)}
+	children := make(map[*Node]map[string]*Node)
+	for _, s := range p.Samples {
+		node := root
+		addValues(node.Cum, s.Values)
+		// the path is from the leaf to the root
+		for i := len(s.Path) - 1; i >= 0; i-- {
+			m := children[node]
+			if m == nil {
+				m = make(map[string]*Node)
+				children[node] = m
+			}
+			child := m[s.Path[i]]
+			if child == nil {
+				child = &Node{Name: s.Path[i], Flat: make([]int64, n), Cum: make([]int64, n)}
+				m[s.Path[i]] = child
+				node.Children = append(node.Children, child)
+			}
+			node = child
+			addValues(node.Cum, s.Values)
+		}
+		addValues(node.Flat, s.Values)
+	}
+	for node := range children {
+		sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	}
+	return root
+}
+
+func addValues(dst, values []int64) {
+	for i, v := range values {
+		dst[i] += v
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// protoBuffer encodes the fields of a protobuf message.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) uint64(tag int, v uint64) {
+	b.varint(uint64(tag)<<3 | 0)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bytes(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(tag int, fn func(b *protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	b.bytes(tag, m.data)
+}
+
+// testProfileData encodes a profile of the paths "main.root" and "main.root;next. (*main.T)" like goref.
+func testProfileData() []byte {
+	strs := []string{"", "inuse_objects", "count", "inuse_space", "bytes", "main.root", "next. (*main.T)",
+		"/src/main.go", "rss: 1.00MB", "tenant", "a"}
+	var b protoBuffer
+	for _, vt := range [][2]uint64{{1, 2}, {3, 4}} {
+		b.message(tagProfile_SampleType, func(b *protoBuffer) {
+			b.uint64(tagValueType_Type, vt[0])
+			b.uint64(tagValueType_Unit, vt[1])
+		})
+	}
+	b.message(tagProfile_Sample, func(b *protoBuffer) {
+		b.uint64(tagSample_Location, 1)
+		b.uint64(tagSample_Value, 1)
+		b.uint64(tagSample_Value, 16)
+	})
+	b.message(tagProfile_Sample, func(b *protoBuffer) {
+		// packed
+		b.bytes(tagSample_Location, []byte{2, 1})
+		b.bytes(tagSample_Value, []byte{2, 64})
+		b.message(tagSample_Label, func(b *protoBuffer) {
+			b.uint64(tagLabel_Key, 9)
+			b.uint64(tagLabel_Str, 10)
+		})
+	})
+	for id, name := range []uint64{5, 6} {
+		b.message(tagProfile_Location, func(b *protoBuffer) {
+			b.uint64(tagLocation_ID, uint64(id+1))
+			b.message(tagLocation_Line, func(b *protoBuffer) {
+				b.uint64(tagLine_FunctionID, uint64(id+1))
+			})
+		})
+		b.message(tagProfile_Function, func(b *protoBuffer) {
+			b.uint64(tagFunction_ID, uint64(id+1))
+			b.uint64(tagFunction_Name, name)
+			if id == 0 {
+				b.uint64(tagFunction_Filename, 7)
+				b.uint64(tagFunction_StartLine, 12)
+			}
+		})
+	}
+	b.uint64(tagProfile_Comment, 8)
+	for _, s := range strs {
+		b.bytes(tagProfile_StringTable, []byte(s))
+	}
+	return b.data
+}
+
+func TestParse(t *testing.T) {
+	data := testProfileData()
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+
+	want := &RefProfile{
+		SampleTypes: []ValueType{{"inuse_objects", "count"}, {"inuse_space", "bytes"}},
+		Samples: []*Sample{
+			{Path: []string{"main.root"}, Values: []int64{1, 16}},
+			{Path: []string{"next. (*main.T)", "main.root"}, Values: []int64{2, 64}, Labels: map[string]string{"tenant": "a"}},
+		},
+		Comments: []string{"rss: 1.00MB"},
+		Sources:  map[string]SourceLine{"main.root": {File: "/src/main.go", Line: 12}},
+	}
+	for name, r := range map[string]*bytes.Reader{"plain": bytes.NewReader(data), "gzipped": bytes.NewReader(zbuf.Bytes())} {
+		p, err := Parse(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Fatalf("%s: got %+v, want %+v", name, p, want)
+		}
+	}
+
+	// truncated in the string table
+	if _, err := Parse(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, errMalformedProfile) {
+		t.Fatalf("got err %v for a truncated profile, want %v", err, errMalformedProfile)
+	}
+}
+
+func TestTree(t *testing.T) {
+	p := &RefProfile{
+		SampleTypes: []ValueType{{"inuse_objects", "count"}, {"inuse_space", "bytes"}},
+		Samples: []*Sample{
+			{Path: []string{"next. (*main.T)", "main.root"}, Values: []int64{2, 64}},
+			{Path: []string{"main.root"}, Values: []int64{1, 16}},
+			{Path: []string{"main.other"}, Values: []int64{1, 32}},
+			{Path: []string{"next. (*main.T)", "main.root"}, Values: []int64{1, 8}, Labels: map[string]string{"tenant": "a"}},
+		},
+	}
+	var lines []string
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		lines = append(lines, fmt.Sprintf("%s%s %v %v", strings.Repeat("  ", depth), n.Name, n.Flat, n.Cum))
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(p.Tree(), 0)
+	want := `root [0 0] [5 120]
+  main.other [1 32] [1 32]
+  main.root [1 16] [4 88]
+    next. (*main.T) [3 72] [3 72]`
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected tree:\n%s\nwant:\n%s", got, want)
+	}
+	if got := p.SampleIndex("inuse_space"); got != 1 {
+		t.Fatalf("got sample index %d, want 1", got)
+	}
+	if got := p.SampleIndex("retained_space"); got != -1 {
+		t.Fatalf("got sample index %d for the missing sample type, want -1", got)
+	}
+}
+
+func TestSplitNodeName(t *testing.T) {
+	tests := []struct {
+		name, field, typ string
+	}{
+		{"next. (*main.T)", "next", "*main.T"},
+		{"$mapval. (map[string]int)", "$mapval", "map[string]int"},
+		{"main.worker.buf", "main.worker.buf", ""},
+		{"goroutine 18: main.worker created by main.main", "goroutine 18: main.worker created by main.main", ""},
+	}
+	for _, tt := range tests {
+		if field, typ := SplitNodeName(tt.name); field != tt.field || typ != tt.typ {
+			t.Errorf("SplitNodeName(%q) = %q, %q, want %q, %q", tt.name, field, typ, tt.field, tt.typ)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TopEntry is a reference chain of a profile with its values of a sample type.
+type TopEntry struct {
+	// Path is the names of the nodes from the root to the leaf.
+	Path []string
+	// Flat is the value recorded at the chain itself, and Cum includes the values referenced through it.
+	Flat, Cum int64
+}
+
+// Top returns the n reference chains with the most flat values of the sample type, or the most
+// cumulative values if cum is set, and the total value of the sample type. All chains are returned if n <= 0.
+func (p *RefProfile) Top(sampleType string, n int, cum bool) ([]TopEntry, int64, error) {
+	vi := p.SampleIndex(sampleType)
+	if vi < 0 {
+		return nil, 0, fmt.Errorf("sample type %q not found in the profile", sampleType)
+	}
+
+	var total int64
+	entries := make(map[string]*TopEntry)
+	for _, s := range p.Samples {
+		v := s.Values[vi]
+		if v == 0 {
+			continue
+		}
+		total += v
+		// every prefix of the path references the value
+		path := make([]string, len(s.Path))
+		for i, name := range s.Path {
+			path[len(path)-1-i] = name
+		}
+		for i := 1; i <= len(path); i++ {
+			k := strings.Join(path[:i], "\x00")
+			e := entries[k]
+			if e == nil {
+				e = &TopEntry{Path: path[:i]}
+				entries[k] = e
+			}
+			e.Cum += v
+			if i == len(path) {
+				e.Flat += v
+			}
+		}
+	}
+
+	top := make([]TopEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Flat != 0 || cum {
+			top = append(top, *e)
+		}
+	}
+	key := func(e *TopEntry) int64 {
+		if cum {
+			return e.Cum
+		}
+		return e.Flat
+	}
+	sort.Slice(top, func(i, j int) bool {
+		ki, kj := key(&top[i]), key(&top[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return strings.Join(top[i].Path, "\x00") < strings.Join(top[j].Path, "\x00")
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top, total, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTop(t *testing.T) {
+	p := &RefProfile{
+		SampleTypes: []ValueType{{"inuse_objects", "count"}, {"inuse_space", "bytes"}},
+		Samples: []*Sample{
+			{Path: []string{"main.root"}, Values: []int64{1, 16}},
+			{Path: []string{"next. (*main.T)", "main.root"}, Values: []int64{2, 64}},
+			{Path: []string{"main.other"}, Values: []int64{1, 32}},
+		},
+	}
+	top, total, err := p.Top("inuse_space", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 112 {
+		t.Fatalf("unexpected total %d", total)
+	}
+	want := []TopEntry{
+		{Path: []string{"main.root", "next. (*main.T)"}, Flat: 64, Cum: 64},
+		{Path: []string{"main.other"}, Flat: 32, Cum: 32},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("unexpected top: %+v", top)
+	}
+
+	top, _, err = p.Top("inuse_space", 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []TopEntry{{Path: []string{"main.root"}, Flat: 16, Cum: 80}}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("unexpected cumulative top: %+v", top)
+	}
+
+	if _, _, err = p.Top("retained_space", 1, false); err == nil {
+		t.Fatal("expect an error for the missing sample type")
+	}
+}
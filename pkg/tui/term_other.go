@@ -0,0 +1,39 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+type terminal struct {
+	in, out *os.File
+}
+
+// openTerminal is only supported on linux, where the terminal is switched to the raw mode by ioctl.
+func openTerminal(in, out *os.File) (*terminal, error) {
+	return nil, errors.New("the terminal UI is only supported on linux")
+}
+
+func (t *terminal) size() (width, height int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+func (t *terminal) restore() error {
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tui is a terminal UI exploring the reference tree of a profile written by goref, like ncdu
+// for the heap: the reference chains are expanded and collapsed interactively, the children are sorted
+// by a sample type like the retained size, and the nodes are searched by their type names.
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+// Node is a node of the reference tree.
+type Node struct {
+	// Field is the field or the variable of the node, and Type is the type of the referenced value,
+	// empty if unknown, see profile.SplitNodeName.
+	Field, Type string
+	// Flat are the values recorded at the node itself, and Cum include the values referenced through it,
+	// indexed like the sample types of the tree.
+	Flat, Cum []int64
+	Parent    *Node
+	Children  []*Node
+	// Expanded is whether the children are shown.
+	Expanded bool
+	// Depth is the depth of the node, the roots of the profile are at 0.
+	Depth int
+}
+
+// Tree is the reference tree of a profile.
+type Tree struct {
+	SampleTypes []profile.ValueType
+	// Root is the virtual root above the roots of the profile, which is always expanded.
+	Root *Node
+	// SortIndex is the index of the sample type the children are sorted by.
+	SortIndex int
+}
+
+// NewTree builds the reference tree of the profile, the children are sorted by the retained space if
+// the profile carries it, otherwise by the first sample type of bytes.
+func NewTree(p *profile.RefProfile) *Tree {
+	t := &Tree{SampleTypes: p.SampleTypes, Root: newNode(p.Tree(), nil)}
+	t.Root.Field, t.Root.Expanded = "root", true
+	t.SortIndex = defaultSortIndex(p.SampleTypes)
+	t.Sort(t.SortIndex)
+	return t
+}
+
+// newNode converts the node of the profile tree and its descendants.
+func newNode(pn *profile.Node, parent *Node) *Node {
+	n := &Node{Flat: pn.Flat, Cum: pn.Cum, Parent: parent, Depth: -1}
+	if parent != nil {
+		n.Field, n.Type = profile.SplitNodeName(pn.Name)
+		n.Depth = parent.Depth + 1
+	}
+	for _, c := range pn.Children {
+		n.Children = append(n.Children, newNode(c, n))
+	}
+	return n
+}
+
+// defaultSortIndex returns the index of the retained space, or the first sample type of bytes, or 0.
+func defaultSortIndex(types []profile.ValueType) int {
+	for i, vt := range types {
+		if vt.Type == "retained_space" {
+			return i
+		}
+	}
+	for i, vt := range types {
+		if vt.Unit == "bytes" {
+			return i
+		}
+	}
+	return 0
+}
+
+// Sort sorts the children of every node by their cumulative values of the sample type, the most first.
+func (t *Tree) Sort(index int) {
+	if index < 0 || index >= len(t.SampleTypes) {
+		return
+	}
+	t.SortIndex = index
+	var sortChildren func(n *Node)
+	sortChildren = func(n *Node) {
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			ci, cj := n.Children[i], n.Children[j]
+			if ci.Cum[index] != cj.Cum[index] {
+				return ci.Cum[index] > cj.Cum[index]
+			}
+			return ci.Name() < cj.Name()
+		})
+		for _, c := range n.Children {
+			sortChildren(c)
+		}
+	}
+	sortChildren(t.Root)
+}
+
+// Name returns the name of the node in the profile, like "next. (*main.T)".
+func (n *Node) Name() string {
+	if n.Type == "" {
+		return n.Field
+	}
+	return n.Field + ". (" + n.Type + ")"
+}
+
+// Visible returns the nodes shown in order, i.e. the descendants of the root through the expanded nodes.
+func (t *Tree) Visible() []*Node {
+	var nodes []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, c := range n.Children {
+			nodes = append(nodes, c)
+			if c.Expanded {
+				walk(c)
+			}
+		}
+	}
+	walk(t.Root)
+	return nodes
+}
+
+// Search returns the next node after from in the depth-first order whose type contains the term, wrapping
+// around, and expands its ancestors so it's visible. It returns nil if no node matches. The search starts
+// from the first node if from is nil.
+func (t *Tree) Search(term string, from *Node) *Node {
+	if term == "" {
+		return nil
+	}
+	var nodes []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, c := range n.Children {
+			nodes = append(nodes, c)
+			walk(c)
+		}
+	}
+	walk(t.Root)
+	start := 0
+	for i, n := range nodes {
+		if n == from {
+			start = i + 1
+			break
+		}
+	}
+	for i := range nodes {
+		n := nodes[(start+i)%len(nodes)]
+		if strings.Contains(n.Type, term) {
+			for p := n.Parent; p != nil; p = p.Parent {
+				p.Expanded = true
+			}
+			return n
+		}
+	}
+	return nil
+}
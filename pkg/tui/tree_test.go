@@ -0,0 +1,103 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+func testProfile() *profile.RefProfile {
+	return &profile.RefProfile{
+		SampleTypes: []profile.ValueType{{Type: "inuse_objects", Unit: "count"}, {Type: "inuse_space", Unit: "bytes"}},
+		Samples: []*profile.Sample{
+			{Path: []string{"main.small"}, Values: []int64{1, 32}},
+			{Path: []string{"main.root"}, Values: []int64{1, 16}},
+			{Path: []string{"next. (*main.T)", "main.root"}, Values: []int64{2, 64}},
+			{Path: []string{"buf. ([]uint8)", "next. (*main.T)", "main.root"}, Values: []int64{1, 128}},
+			{Path: []string{"$mapval. (*main.Item)", "main.root"}, Values: []int64{3, 24}},
+		},
+	}
+}
+
+func names(nodes []*Node) string {
+	var s []string
+	for _, n := range nodes {
+		s = append(s, n.Name())
+	}
+	return strings.Join(s, ",")
+}
+
+func TestTree(t *testing.T) {
+	tree := NewTree(testProfile())
+	if tree.SortIndex != 1 {
+		t.Fatalf("sorted by %d, want the space", tree.SortIndex)
+	}
+	root := tree.Root.Children[0]
+	if root.Field != "main.root" || root.Cum[1] != 232 || root.Flat[1] != 16 {
+		t.Fatalf("unexpected root %+v", root)
+	}
+	if got, want := names(root.Children), "next. (*main.T),$mapval. (*main.Item)"; got != want {
+		t.Fatalf("got children %s, want %s", got, want)
+	}
+	tree.Sort(0)
+	if got, want := names(root.Children), "$mapval. (*main.Item),next. (*main.T)"; got != want {
+		t.Fatalf("got children sorted by objects %s, want %s", got, want)
+	}
+	if got, want := names(tree.Visible()), "main.root,main.small"; got != want {
+		t.Fatalf("got visible %s, want %s", got, want)
+	}
+
+	n := tree.Search("[]uint8", nil)
+	if n == nil || n.Field != "buf" || n.Depth != 2 {
+		t.Fatalf("unexpected match %+v", n)
+	}
+	if got, want := names(tree.Visible()), "main.root,$mapval. (*main.Item),next. (*main.T),buf. ([]uint8),main.small"; got != want {
+		t.Fatalf("got visible %s, want %s", got, want)
+	}
+	// wraps around
+	if m := tree.Search("main.", n); m == nil || m.Field != "$mapval" {
+		t.Fatalf("unexpected match %+v", m)
+	}
+	if m := tree.Search("main.Unknown", nil); m != nil {
+		t.Fatalf("unexpected match %+v", m)
+	}
+}
+
+func TestUI(t *testing.T) {
+	u := &ui{tree: NewTree(testProfile()), title: "grf.out"}
+	for _, key := range []string{"right", "right", "right", "right"} {
+		u.handle(key)
+	}
+	if u.selected == nil || u.selected.Field != "buf" {
+		t.Fatalf("unexpected selected %+v", u.selected)
+	}
+	screen := u.render(80, 10)
+	if !strings.Contains(screen, "total: 264B") || !strings.Contains(screen, "- next") || !strings.Contains(screen, "buf") {
+		t.Fatalf("unexpected screen:\n%s", screen)
+	}
+	for _, key := range []string{"left", "left", "/", "I", "t", "e", "m", "enter"} {
+		u.handle(key)
+	}
+	if u.selected == nil || u.selected.Field != "$mapval" {
+		t.Fatalf("unexpected selected %+v", u.selected)
+	}
+	u.handle("q")
+	if !u.quit {
+		t.Fatal("not quit")
+	}
+}
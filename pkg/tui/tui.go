@@ -0,0 +1,351 @@
+// Copyright 2024 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	myproc "github.com/cloudwego/goref/pkg/proc"
+	"github.com/cloudwego/goref/pkg/profile"
+)
+
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l" // the alternate screen, and hide the cursor
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	reverse     = "\x1b[7m"
+	dim         = "\x1b[2m"
+	reset       = "\x1b[0m"
+	helpLine    = "up/down move  right/left expand/collapse  s sort  / search type  n next  q quit"
+	barWidth    = 10
+)
+
+// Run runs the terminal UI of the profile on the terminal of stdin and stdout until the user quits,
+// the title is shown in the header, like the file name of the profile.
+func Run(p *profile.RefProfile, title string) error {
+	if len(p.SampleTypes) == 0 || len(p.Samples) == 0 {
+		return fmt.Errorf("no samples in %s", title)
+	}
+	term, err := openTerminal(os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+	io.WriteString(term.out, enterScreen)
+	defer io.WriteString(term.out, leaveScreen)
+
+	u := &ui{tree: NewTree(p), title: title}
+	r := bufio.NewReader(term.in)
+	for !u.quit {
+		width, height, err := term.size()
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		if _, err = io.WriteString(term.out, u.render(width, height)); err != nil {
+			return err
+		}
+		key, err := readKey(r)
+		if err != nil {
+			return err
+		}
+		u.handle(key)
+	}
+	return nil
+}
+
+// readKey reads a key pressed, the special keys are named like "up" or "enter", and the others are the runes.
+func readKey(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case 0x1b:
+		if r.Buffered() == 0 {
+			return "esc", nil
+		}
+		return readEscape(r)
+	case '\r', '\n':
+		return "enter", nil
+	case 0x7f, 0x08:
+		return "backspace", nil
+	case 0x03:
+		return "ctrl-c", nil
+	}
+	if b < utf8.RuneSelf {
+		return string(rune(b)), nil
+	}
+	if err = r.UnreadByte(); err != nil {
+		return "", err
+	}
+	c, _, err := r.ReadRune()
+	return string(c), err
+}
+
+// readEscape reads the escape sequence of a special key after ESC, like "[A" of the up arrow.
+func readEscape(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil || b != '[' && b != 'O' {
+		return "esc", err
+	}
+	var seq []byte
+	for {
+		if b, err = r.ReadByte(); err != nil {
+			return "", err
+		}
+		seq = append(seq, b)
+		if b < '0' || b > '9' && b != ';' {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return "up", nil
+	case "B":
+		return "down", nil
+	case "C":
+		return "right", nil
+	case "D":
+		return "left", nil
+	case "H", "1~", "7~":
+		return "home", nil
+	case "F", "4~", "8~":
+		return "end", nil
+	case "5~":
+		return "pgup", nil
+	case "6~":
+		return "pgdn", nil
+	}
+	return "", nil
+}
+
+// ui is the state of the terminal UI.
+type ui struct {
+	tree  *Tree
+	title string
+	// selected is the node under the cursor, and offset is the index of the first row shown
+	selected *Node
+	offset   int
+	// the rows of the nodes shown by the last rendering
+	pageSize int
+	// searching is whether the search term is being typed, and term is the last term searched
+	searching bool
+	input     string
+	term      string
+	status    string
+	quit      bool
+}
+
+// cursor returns the visible nodes and the index of the selected one, which is the first node if
+// nothing is selected, or the nearest visible ancestor if the selected one is collapsed.
+func (u *ui) cursor() ([]*Node, int) {
+	rows := u.tree.Visible()
+	for n := u.selected; n != nil; n = n.Parent {
+		for i, row := range rows {
+			if row == n {
+				u.selected = n
+				return rows, i
+			}
+		}
+	}
+	if len(rows) > 0 {
+		u.selected = rows[0]
+	}
+	return rows, 0
+}
+
+func (u *ui) handle(key string) {
+	u.status = ""
+	if u.searching {
+		switch key {
+		case "enter":
+			u.searching = false
+			u.term = u.input
+			u.search()
+		case "esc", "ctrl-c":
+			u.searching = false
+		case "backspace":
+			if _, size := utf8.DecodeLastRuneInString(u.input); size > 0 {
+				u.input = u.input[:len(u.input)-size]
+			}
+		default:
+			if r, _ := utf8.DecodeRuneInString(key); utf8.RuneCountInString(key) == 1 && unicode.IsPrint(r) {
+				u.input += key
+			}
+		}
+		return
+	}
+	rows, i := u.cursor()
+	if len(rows) == 0 {
+		if key == "q" || key == "ctrl-c" {
+			u.quit = true
+		}
+		return
+	}
+	n := rows[i]
+	move := func(j int) {
+		u.selected = rows[max(0, min(j, len(rows)-1))]
+	}
+	switch key {
+	case "q", "ctrl-c":
+		u.quit = true
+	case "up", "k":
+		move(i - 1)
+	case "down", "j":
+		move(i + 1)
+	case "pgup":
+		move(i - max(u.pageSize, 1))
+	case "pgdn":
+		move(i + max(u.pageSize, 1))
+	case "home", "g":
+		move(0)
+	case "end", "G":
+		move(len(rows) - 1)
+	case "right", "l", "enter":
+		switch {
+		case len(n.Children) == 0:
+		case !n.Expanded:
+			n.Expanded = true
+		default:
+			u.selected = n.Children[0]
+		}
+	case "left", "h":
+		if n.Expanded {
+			n.Expanded = false
+		} else if n.Parent != u.tree.Root {
+			u.selected = n.Parent
+		}
+	case " ":
+		n.Expanded = !n.Expanded && len(n.Children) > 0
+	case "s":
+		u.tree.Sort((u.tree.SortIndex + 1) % len(u.tree.SampleTypes))
+		u.status = "sorted by " + u.tree.SampleTypes[u.tree.SortIndex].Type
+	case "/":
+		u.searching, u.input = true, ""
+	case "n":
+		u.search()
+	}
+}
+
+// search selects the next node whose type contains the term.
+func (u *ui) search() {
+	if u.term == "" {
+		return
+	}
+	if n := u.tree.Search(u.term, u.selected); n != nil {
+		u.selected = n
+	} else {
+		u.status = "no type matches " + strconv.Quote(u.term)
+	}
+}
+
+// render returns the screen of the width and the height.
+func (u *ui) render(width, height int) string {
+	rows, i := u.cursor()
+	u.pageSize = max(height-2, 1)
+	if i < u.offset {
+		u.offset = i
+	} else if i >= u.offset+u.pageSize {
+		u.offset = i - u.pageSize + 1
+	}
+	u.offset = max(0, min(u.offset, len(rows)-u.pageSize))
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	line := func(s string) {
+		sb.WriteString(s)
+		sb.WriteString("\x1b[K\r\n")
+	}
+	t := u.tree.SortIndex
+	vt := u.tree.SampleTypes[t]
+	total := u.tree.Root.Cum[t]
+	line(reverse + truncate(fmt.Sprintf(" goref %s  sort: %s  total: %s", u.title, vt.Type, formatValue(total, vt.Unit)), width) + reset)
+	for j := u.offset; j < u.offset+u.pageSize; j++ {
+		if j >= len(rows) {
+			line("")
+			continue
+		}
+		line(u.renderRow(rows[j], rows[j] == u.selected, width, vt.Unit, total))
+	}
+	switch {
+	case u.searching:
+		sb.WriteString(truncate("search type: "+u.input, width))
+	case u.status != "":
+		sb.WriteString(truncate(u.status, width))
+	default:
+		sb.WriteString(dim + truncate(helpLine, width) + reset)
+	}
+	sb.WriteString("\x1b[K\x1b[J")
+	return sb.String()
+}
+
+// renderRow renders the node like "  1.00MB  50.0% [#####     ] - buf ([]uint8)", the bar is relative to the parent.
+func (u *ui) renderRow(n *Node, selected bool, width int, unit string, total int64) string {
+	t := u.tree.SortIndex
+	var pct float64
+	if total != 0 {
+		pct = float64(n.Cum[t]) * 100 / float64(total)
+	}
+	var filled int
+	if p := n.Parent.Cum[t]; p > 0 && n.Cum[t] > 0 {
+		filled = int(float64(n.Cum[t]) * barWidth / float64(p))
+	}
+	filled = max(0, min(filled, barWidth))
+	marker := " "
+	if len(n.Children) > 0 {
+		marker = "+"
+		if n.Expanded {
+			marker = "-"
+		}
+	}
+	prefix := fmt.Sprintf("%10s %6.1f%% [%s%s] %s%s ", formatValue(n.Cum[t], unit), pct,
+		strings.Repeat("#", filled), strings.Repeat(" ", barWidth-filled), strings.Repeat("  ", n.Depth), marker)
+	text := truncate(prefix+n.Field, width)
+	var typ string
+	if n.Type != "" {
+		typ = truncate(" ("+n.Type+")", width-utf8.RuneCountInString(text))
+	}
+	if selected {
+		return reverse + text + typ + reset
+	}
+	if typ != "" {
+		typ = dim + typ + reset
+	}
+	return text + typ
+}
+
+func formatValue(v int64, unit string) string {
+	if unit == "bytes" {
+		return myproc.FormatBytes(v)
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// truncate truncates s to at most width runes.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+var (
+	// grew to 1MB, then was reset
+	resetBuf bytes.Buffer
+	// half of the 1MB is unread
+	halfReadBuf = &bytes.Buffer{}
+	writer      = bufio.NewWriterSize(io.Discard, 64*1024)
+)
+
+func main() {
+	resetBuf.Write(make([]byte, 1<<20))
+	resetBuf.Reset()
+
+	halfReadBuf.Write(make([]byte, 1<<20))
+	halfReadBuf.Next(1 << 19)
+
+	writer.WriteString("hello")
+	time.Sleep(100 * time.Second)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+var block = make(chan struct{})
+
+func use(b []byte) {
+	if len(b) == 0 {
+		panic("empty")
+	}
+}
+
+// deferrer defers the closures capturing the 1MB buffers in a loop, which are allocated on heap.
+func deferrer() {
+	for i := 0; i < 3; i++ {
+		buf := make([]byte, 1<<20)
+		defer func() { use(buf) }()
+	}
+	<-block
+}
+
+type Err struct {
+	data []byte
+}
+
+// panicker panics with the 1MB value, and blocks in the deferred recovery.
+func panicker() {
+	defer func() {
+		<-block
+		_ = recover()
+	}()
+	panic(&Err{data: make([]byte, 1<<20)})
+}
+
+func main() {
+	go deferrer()
+	go panicker()
+	time.Sleep(100 * time.Second)
+}
@@ -0,0 +1,25 @@
+package main
+
+import "time"
+
+type Msg struct {
+	data []byte
+}
+
+var escaped []*Msg
+
+// sender blocks on sending the 1MB message, which is moved to heap since its address escapes.
+func sender(ch chan Msg) {
+	m := Msg{data: make([]byte, 1<<20)}
+	escaped = append(escaped, &m)
+	escaped = escaped[:0]
+	ch <- m
+}
+
+func main() {
+	ch := make(chan Msg)
+	for i := 0; i < 3; i++ {
+		go sender(ch)
+	}
+	time.Sleep(100 * time.Second)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+type node struct {
+	id          int
+	payload     [4]int64
+	left, right *node
+}
+
+var (
+	slice []*node
+	m     map[int]*node
+	list  *node
+	tree  *node
+)
+
+// build builds a balanced tree of the nodes in [lo, hi).
+func build(lo, hi int) *node {
+	if lo >= hi {
+		return nil
+	}
+	mid := (lo + hi) / 2
+	return &node{id: mid, left: build(lo, mid), right: build(mid+1, hi)}
+}
+
+// usage: synthheap <slice|map|list|tree> <n>
+func main() {
+	n, _ := strconv.Atoi(os.Args[2])
+	switch os.Args[1] {
+	case "slice":
+		slice = make([]*node, n)
+		for i := range slice {
+			slice[i] = &node{id: i}
+		}
+	case "map":
+		m = make(map[int]*node, n)
+		for i := 0; i < n; i++ {
+			m[i] = &node{id: i}
+		}
+	case "list":
+		for i := 0; i < n; i++ {
+			list = &node{id: i, right: list}
+		}
+	case "tree":
+		tree = build(0, n)
+	}
+	time.Sleep(100 * time.Second)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type payload struct {
+	buf []byte
+}
+
+// schedule leaks a 4KB payload in every timer which never fires.
+func schedule(i int) {
+	p := &payload{buf: make([]byte, 4096)}
+	time.AfterFunc(time.Hour, func() { fmt.Println(len(p.buf), i) })
+}
+
+func main() {
+	for i := 0; i < 100; i++ {
+		schedule(i)
+	}
+	time.Sleep(100 * time.Second)
+}